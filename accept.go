@@ -0,0 +1,63 @@
+package toxiproxy
+
+import (
+	"context"
+	"net"
+	"sync"
+
+	"github.com/Shopify/toxiproxy/v2/toxics"
+)
+
+// acceptClient runs the connection toxics of the proxy on a new client, before
+// its upstream is dialed. It returns false when a toxic stopped the client, after
+// closing it.
+func (proxy *Proxy) acceptClient(
+	ctx context.Context,
+	client net.Conn,
+	name string,
+	id string,
+) (net.Conn, bool) {
+	stub := toxics.NewConnectionStub(client, ctx.Done())
+	for _, toxic := range proxy.Toxics.GetConnectionToxics() {
+		if toxic.FlappedOff() {
+			continue
+		}
+		stub.Rand = toxicRand(proxy.seed(), toxic, proxy.connectionKey(id))
+		if stub.Rand.Float32() >= toxic.Toxicity {
+			continue
+		}
+
+		action := toxic.Toxic.(toxics.ConnectionToxic).Accept(stub)
+		if action != toxics.ConnectionContinue {
+			proxy.Logger.
+				Debug().
+				Str("client", name).
+				Str("toxic", toxic.Name).
+				Str("action", string(action)).
+				Msg("Connection toxic stopped client")
+			stub.Close()
+			proxy.failClient(ctx, client, string(action))
+			return nil, false
+		}
+	}
+
+	return &closeHookConn{Conn: client, onClose: stub.Close}, true
+}
+
+// closeHookConn calls onClose once when the connection is closed, so connection
+// toxics know when the clients they let through are gone.
+type closeHookConn struct {
+	net.Conn
+	onClose func()
+	once    sync.Once
+}
+
+func (c *closeHookConn) Close() error {
+	c.once.Do(c.onClose)
+	return c.Conn.Close()
+}
+
+// NetConn returns the underlying connection, see setLinger.
+func (c *closeHookConn) NetConn() net.Conn {
+	return c.Conn
+}
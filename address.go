@@ -0,0 +1,79 @@
+package toxiproxy
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// UnixScheme prefixes proxy addresses that refer to Unix domain sockets,
+// e.g. unix:///var/run/postgresql/.s.PGSQL.5432.
+const UnixScheme = "unix://"
+
+var errLingerNotSupported = errors.New("connection does not support SO_LINGER")
+
+// splitAddress returns the network and address to listen on or dial for a proxy
+// address. Unix socket addresses use the unix network regardless of the proxy
+// protocol.
+func splitAddress(protocol, address string) (string, string) {
+	if strings.HasPrefix(address, UnixScheme) {
+		return "unix", strings.TrimPrefix(address, UnixScheme)
+	}
+	return protocol, address
+}
+
+// isUnixAddress reports whether the address refers to a Unix domain socket.
+func isUnixAddress(address string) bool {
+	return strings.HasPrefix(address, UnixScheme)
+}
+
+// listenUnix listens on a Unix domain socket. A socket file left behind by a
+// previous process is removed first, and the file is removed again when the
+// listener is closed.
+func listenUnix(path string) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	listener.(*net.UnixListener).SetUnlinkOnClose(true)
+	return listener, nil
+}
+
+// clientName returns a unique name for an accepted client. Clients of a Unix
+// socket have no address, so they are numbered instead.
+func (proxy *Proxy) clientName(client net.Conn) string {
+	addr := client.RemoteAddr()
+	if addr == nil || addr.String() == "" || addr.String() == "@" {
+		return fmt.Sprintf("unix#%d", atomic.AddUint64(&proxy.clients, 1))
+	}
+	return addr.String()
+}
+
+// setLinger sets SO_LINGER on connections that support it, so closing them
+// sends a TCP RST. Wrapped connections, like TLS, are unwrapped to reach the
+// underlying socket. Other connections return errLingerNotSupported.
+func setLinger(conn interface{}, sec int) error {
+	for {
+		wrapped, ok := conn.(interface{ NetConn() net.Conn })
+		if !ok {
+			break
+		}
+		conn = wrapped.NetConn()
+	}
+	if lingerer, ok := conn.(interface{ SetLinger(int) error }); ok {
+		return lingerer.SetLinger(sec)
+	}
+	return errLingerNotSupported
+}
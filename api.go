@@ -34,7 +34,10 @@ type ApiServer struct {
 	Collection *ProxyCollection
 	Metrics    *metricsContainer
 	Logger     *zerolog.Logger
-	http       *http.Server
+	// The random numbers of every proxy and toxic derive from the seed, so a
+	// run can be replayed by starting the server with the same seed.
+	Seed int64
+	http *http.Server
 }
 
 const (
@@ -47,6 +50,7 @@ func NewServer(m *metricsContainer, logger zerolog.Logger) *ApiServer {
 		Collection: NewProxyCollection(),
 		Metrics:    m,
 		Logger:     &logger,
+		Seed:       time.Now().UTC().UnixNano(),
 	}
 }
 
@@ -123,6 +127,14 @@ func (server *ApiServer) Routes() *mux.Router {
 		Name("ProxyUpdate")
 	r.HandleFunc("/proxies/{proxy}", server.ProxyDelete).Methods("DELETE").
 		Name("ProxyDelete")
+	r.HandleFunc("/proxies/{proxy}/upstreams", server.UpstreamIndex).Methods("GET").
+		Name("UpstreamIndex")
+	r.HandleFunc("/proxies/{proxy}/upstreams", server.UpstreamUpdate).Methods("POST", "PATCH").
+		Name("UpstreamUpdate")
+	r.HandleFunc("/proxies/{proxy}/connections", server.ConnectionIndex).Methods("GET").
+		Name("ConnectionIndex")
+	r.HandleFunc("/proxies/{proxy}/connections/{id}", server.ConnectionDelete).Methods("DELETE").
+		Name("ConnectionDelete")
 	r.HandleFunc("/proxies/{proxy}/toxics", server.ToxicIndex).Methods("GET").
 		Name("ToxicIndex")
 	r.HandleFunc("/proxies/{proxy}/toxics", server.ToxicCreate).Methods("POST").
@@ -133,8 +145,11 @@ func (server *ApiServer) Routes() *mux.Router {
 		Name("ToxicUpdate")
 	r.HandleFunc("/proxies/{proxy}/toxics/{toxic}", server.ToxicDelete).Methods("DELETE").
 		Name("ToxicDelete")
+	r.HandleFunc("/proxies/{proxy}/toxics/{toxic}/ramp", server.ToxicRamp).Methods("POST").
+		Name("ToxicRamp")
 
 	r.HandleFunc("/version", server.Version).Methods("GET").Name("Version")
+	r.HandleFunc("/status", server.Status).Methods("GET").Name("Status")
 
 	if server.Metrics.anyMetricsEnabled() {
 		r.Handle("/metrics", server.Metrics.handler()).Name("Metrics")
@@ -214,12 +229,15 @@ func (server *ApiServer) ProxyCreate(response http.ResponseWriter, request *http
 		server.apiError(response, joinError(fmt.Errorf("name"), ErrMissingField))
 		return
 	}
-	if len(input.Upstream) < 1 {
+	if len(input.Upstream) < 1 && len(input.Upstreams) < 1 {
 		server.apiError(response, joinError(fmt.Errorf("upstream"), ErrMissingField))
 		return
 	}
 
-	proxy := NewProxy(server, input.Name, input.Listen, input.Upstream)
+	proxy, err := newProxyFromInput(server, &input)
+	if server.apiError(response, err) {
+		return
+	}
 
 	err = server.Collection.Add(proxy, input.Enabled)
 	if server.apiError(response, err) {
@@ -308,7 +326,16 @@ func (server *ApiServer) ProxyUpdate(response http.ResponseWriter, request *http
 	}
 
 	// Default fields are the same as existing proxy
-	input := Proxy{Listen: proxy.Listen, Upstream: proxy.Upstream, Enabled: proxy.Enabled}
+	input := Proxy{
+		Listen:      proxy.Listen,
+		Upstream:    proxy.Upstream,
+		Upstreams:   append([]string(nil), proxy.Upstreams...),
+		Balance:     proxy.Balance,
+		Protocol:    proxy.Protocol,
+		TLS:         proxy.TLS.clone(),
+		Enabled:     proxy.Enabled,
+		DialOptions: proxy.DialOptions,
+	}
 	err = json.NewDecoder(request.Body).Decode(&input)
 	if server.apiError(response, joinError(err, ErrBadRequestBody)) {
 		return
@@ -347,6 +374,122 @@ func (server *ApiServer) ProxyDelete(response http.ResponseWriter, request *http
 	}
 }
 
+func (server *ApiServer) UpstreamIndex(response http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+
+	proxy, err := server.Collection.Get(vars["proxy"])
+	if server.apiError(response, err) {
+		return
+	}
+
+	data, err := json.Marshal(proxy.UpstreamPool().Members())
+	if server.apiError(response, err) {
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	_, err = response.Write(data)
+	if err != nil {
+		log := zerolog.Ctx(request.Context())
+		log.Warn().Err(err).Msg("UpstreamIndex: Failed to write response to client")
+	}
+}
+
+func (server *ApiServer) UpstreamUpdate(response http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+
+	proxy, err := server.Collection.Get(vars["proxy"])
+	if server.apiError(response, err) {
+		return
+	}
+
+	input := struct {
+		Address string `json:"address"`
+		Enabled *bool  `json:"enabled"`
+	}{}
+	err = json.NewDecoder(request.Body).Decode(&input)
+	if server.apiError(response, joinError(err, ErrBadRequestBody)) {
+		return
+	}
+	if len(input.Address) < 1 {
+		server.apiError(response, joinError(fmt.Errorf("address"), ErrMissingField))
+		return
+	}
+	if input.Enabled == nil {
+		server.apiError(response, joinError(fmt.Errorf("enabled"), ErrMissingField))
+		return
+	}
+
+	member, err := proxy.UpstreamPool().SetEnabled(input.Address, *input.Enabled)
+	if server.apiError(response, err) {
+		return
+	}
+
+	data, err := json.Marshal(member)
+	if server.apiError(response, err) {
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	_, err = response.Write(data)
+	if err != nil {
+		log := zerolog.Ctx(request.Context())
+		log.Warn().Err(err).Msg("UpstreamUpdate: Failed to write response to client")
+	}
+}
+
+func (server *ApiServer) ConnectionIndex(response http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+
+	proxy, err := server.Collection.Get(vars["proxy"])
+	if server.apiError(response, err) {
+		return
+	}
+
+	data, err := json.Marshal(proxy.Connections())
+	if server.apiError(response, err) {
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	_, err = response.Write(data)
+	if err != nil {
+		log := zerolog.Ctx(request.Context())
+		log.Warn().Err(err).Msg("ConnectionIndex: Failed to write response to client")
+	}
+}
+
+func (server *ApiServer) ConnectionDelete(response http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+	log := zerolog.Ctx(request.Context())
+
+	proxy, err := server.Collection.Get(vars["proxy"])
+	if server.apiError(response, err) {
+		return
+	}
+
+	var reset bool
+	switch strings.ToLower(request.URL.Query().Get("mode")) {
+	case "", "close":
+	case "reset":
+		reset = true
+	default:
+		server.apiError(response, ErrInvalidCloseMode)
+		return
+	}
+
+	err = proxy.CloseConnection(vars["id"], reset)
+	if server.apiError(response, err) {
+		return
+	}
+
+	response.WriteHeader(http.StatusNoContent)
+	_, err = response.Write(nil)
+	if err != nil {
+		log.Warn().Err(err).Msg("ConnectionDelete: Failed to write headers to client")
+	}
+}
+
 func (server *ApiServer) ToxicIndex(response http.ResponseWriter, request *http.Request) {
 	vars := mux.Vars(request)
 
@@ -452,6 +595,32 @@ func (server *ApiServer) ToxicUpdate(response http.ResponseWriter, request *http
 	}
 }
 
+func (server *ApiServer) ToxicRamp(response http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+
+	proxy, err := server.Collection.Get(vars["proxy"])
+	if server.apiError(response, err) {
+		return
+	}
+
+	toxic, err := proxy.Toxics.RampToxicJson(vars["toxic"], request.Body)
+	if server.apiError(response, err) {
+		return
+	}
+
+	data, err := json.Marshal(toxic)
+	if server.apiError(response, err) {
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	_, err = response.Write(data)
+	if err != nil {
+		log := zerolog.Ctx(request.Context())
+		log.Warn().Err(err).Msg("ToxicRamp: Failed to write response to client")
+	}
+}
+
 func (server *ApiServer) ToxicDelete(response http.ResponseWriter, request *http.Request) {
 	vars := mux.Vars(request)
 	ctx := request.Context()
@@ -485,6 +654,23 @@ func (server *ApiServer) Version(response http.ResponseWriter, request *http.Req
 	}
 }
 
+func (server *ApiServer) Status(response http.ResponseWriter, request *http.Request) {
+	data, err := json.Marshal(map[string]interface{}{
+		"version": Version,
+		"seed":    server.Seed,
+	})
+	if server.apiError(response, err) {
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	_, err = response.Write(data)
+	if err != nil {
+		log := zerolog.Ctx(request.Context())
+		log.Warn().Err(err).Msg("Status: Failed to write response to client")
+	}
+}
+
 type ApiError struct {
 	Message    string `json:"error"`
 	StatusCode int    `json:"status"`
@@ -514,6 +700,63 @@ var (
 		"stream was invalid, can be either upstream or downstream",
 		http.StatusBadRequest,
 	)
+	ErrInvalidProtocol = newError(
+		"protocol was invalid, can be either tcp or udp",
+		http.StatusBadRequest,
+	)
+	ErrUnixDatagram = newError(
+		"unix socket addresses are only supported by tcp proxies",
+		http.StatusBadRequest,
+	)
+	ErrTLSDatagram = newError(
+		"tls is only supported by tcp proxies",
+		http.StatusBadRequest,
+	)
+	ErrInvalidTLS     = newError("invalid tls configuration", http.StatusBadRequest)
+	ErrInvalidBalance = newError(
+		"balance was invalid, can be round_robin, random, least_connections or failover",
+		http.StatusBadRequest,
+	)
+	ErrUpstreamNotFound   = newError("upstream not found", http.StatusNotFound)
+	ErrInvalidDialOptions = newError(
+		"dial_timeout, dial_retries and dial_retry_delay can't be negative",
+		http.StatusBadRequest,
+	)
+	ErrInvalidDialFailure = newError(
+		"dial_failure was invalid, can be close, reset or hang",
+		http.StatusBadRequest,
+	)
+	ErrConnectionNotFound = newError("connection not found", http.StatusNotFound)
+	ErrInvalidCloseMode   = newError(
+		"mode was invalid, can be close or reset",
+		http.StatusBadRequest,
+	)
+	ErrInvalidSampling = newError(
+		"sampling was invalid, can be restart, connection or chunk",
+		http.StatusBadRequest,
+	)
+	ErrInvalidFlap = newError(
+		"flap was invalid, on and off must be positive, or period and a duty between 0 and 1",
+		http.StatusBadRequest,
+	)
+	ErrInvalidMatch = newError(
+		"match was invalid, the protocol must be known and its fields valid",
+		http.StatusBadRequest,
+	)
+	ErrInvalidRamp = newError(
+		"ramp was invalid, attributes must be numeric, duration positive "+
+			"and curve linear or exponential",
+		http.StatusBadRequest,
+	)
+	ErrInvalidSchedule = newError(
+		"schedule was invalid, start_after and duration can't be negative and "+
+			"expires_at must be after the start",
+		http.StatusBadRequest,
+	)
+	ErrInvalidAttributes = newError(
+		"attributes were invalid for the toxic type",
+		http.StatusBadRequest,
+	)
 	ErrInvalidToxicType   = newError("invalid toxic type", http.StatusBadRequest)
 	ErrToxicAlreadyExists = newError("toxic already exists", http.StatusConflict)
 	ErrToxicNotFound      = newError("toxic not found", http.StatusNotFound)
@@ -1,16 +1,29 @@
 package toxiproxy_test
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
 	"flag"
 	"io"
+	"net"
 	"net/http"
+	"net/http/httptest"
 	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/rs/zerolog"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 
 	"github.com/Shopify/toxiproxy/v2"
 	tclient "github.com/Shopify/toxiproxy/v2/client"
@@ -533,6 +546,54 @@ func TestCreateProxyWithSave(t *testing.T) {
 	})
 }
 
+func TestCreateUDPProxy(t *testing.T) {
+	WithServer(t, func(addr string) {
+		testProxy := client.NewProxy()
+		testProxy.Name = "statsd"
+		testProxy.Listen = "localhost:3310"
+		testProxy.Upstream = "localhost:20001"
+		testProxy.Protocol = "udp"
+		testProxy.Enabled = true
+
+		err := testProxy.Save()
+		if err != nil {
+			t.Fatal("Unable to create proxy:", err)
+		}
+
+		proxy, err := client.Proxy("statsd")
+		if err != nil {
+			t.Fatal("Unable to retriecve proxy:", err)
+		}
+
+		if proxy.Protocol != "udp" || proxy.Listen != "127.0.0.1:3310" || !proxy.Enabled {
+			t.Fatalf(
+				"Unexpected proxy metadata: %s, %s, %v",
+				proxy.Protocol,
+				proxy.Listen,
+				proxy.Enabled,
+			)
+		}
+	})
+}
+
+func TestCreateProxyInvalidProtocol(t *testing.T) {
+	WithServer(t, func(addr string) {
+		testProxy := client.NewProxy()
+		testProxy.Name = "sctp"
+		testProxy.Listen = "localhost:3310"
+		testProxy.Upstream = "localhost:20001"
+		testProxy.Protocol = "sctp"
+
+		err := testProxy.Save()
+		expected := "HTTP 400: protocol was invalid, can be either tcp or udp"
+		if err == nil {
+			t.Error("Expected error creating proxy, got nil")
+		} else if err.Error() != expected {
+			t.Errorf("Expected error `%s',\n\tgot: `%s'", expected, err)
+		}
+	})
+}
+
 func TestCreateDisabledProxy(t *testing.T) {
 	WithServer(t, func(addr string) {
 		disabledProxy := client.NewProxy()
@@ -969,6 +1030,296 @@ func TestAddToxicWithToxicity(t *testing.T) {
 	})
 }
 
+func TestAddToxicWithSampling(t *testing.T) {
+	WithServer(t, func(addr string) {
+		testProxy, err := client.CreateProxy("mysql_master", "localhost:3310", "localhost:20001")
+		if err != nil {
+			t.Fatal("Unable to create proxy:", err)
+		}
+
+		latency, err := testProxy.AddToxic("", "latency", "downstream", 0.5, nil)
+		if err != nil {
+			t.Fatal("Error setting toxic:", err)
+		}
+		if latency.Sampling != "restart" {
+			t.Fatal("Expected toxic to default to restart sampling, got", latency.Sampling)
+		}
+
+		latency, err = client.UpdateToxic(&tclient.ToxicOptions{
+			ProxyName: "mysql_master",
+			ToxicName: "latency_downstream",
+			Toxicity:  -1,
+			Sampling:  "connection",
+		})
+		if err != nil {
+			t.Fatal("Error updating toxic:", err)
+		}
+		if latency.Sampling != "connection" || latency.Toxicity != 0.5 {
+			t.Fatal("Toxic was not updated correctly:", latency)
+		}
+
+		options := &tclient.ToxicOptions{
+			ProxyName: "mysql_master",
+			ToxicType: "timeout",
+			Stream:    "upstream",
+			Toxicity:  0.1,
+			Sampling:  "sometimes",
+		}
+		_, err = client.AddToxic(options)
+		expected := "failed to add toxic to proxy mysql_master: " +
+			"AddToxic: HTTP 400: sampling was invalid, can be restart, connection or chunk"
+		if err == nil || err.Error() != expected {
+			t.Errorf("Expected error `%s',\n\tgot: `%v'", expected, err)
+		}
+
+		options.Sampling = "chunk"
+		toxic, err := client.AddToxic(options)
+		if err != nil {
+			t.Fatal("Error setting toxic:", err)
+		}
+		if toxic.Sampling != "chunk" {
+			t.Fatal("Expected toxic to sample chunks, got", toxic.Sampling)
+		}
+	})
+}
+
+func TestScheduledToxics(t *testing.T) {
+	WithServer(t, func(addr string) {
+		testProxy, err := client.CreateProxy("mysql_master", "localhost:3310", "localhost:20001")
+		if err != nil {
+			t.Fatal("Unable to create proxy:", err)
+		}
+
+		toxic, err := client.AddToxic(&tclient.ToxicOptions{
+			ProxyName:  testProxy.Name,
+			ToxicType:  "latency",
+			Stream:     "downstream",
+			Toxicity:   1,
+			StartAfter: 100,
+			Duration:   200,
+		})
+		if err != nil {
+			t.Fatal("Error setting toxic:", err)
+		}
+		if toxic.StartsAt == nil || toxic.StartsIn <= 0 || toxic.StartsIn > 100 {
+			t.Fatalf("Expected toxic to start in 100ms, got %+v", toxic)
+		}
+		if toxic.ExpiresAt == nil || toxic.ExpiresIn <= 200 || toxic.ExpiresIn > 300 {
+			t.Fatalf("Expected toxic to expire in 300ms, got %+v", toxic)
+		}
+
+		time.Sleep(150 * time.Millisecond)
+		toxics, err := testProxy.Toxics()
+		if err != nil {
+			t.Fatal("Error returning toxics:", err)
+		}
+		if len(toxics) != 1 || toxics[0].StartsIn != 0 || toxics[0].ExpiresIn <= 0 {
+			t.Fatalf("Expected toxic to have started, got %+v", toxics)
+		}
+
+		time.Sleep(200 * time.Millisecond)
+		toxics, err = testProxy.Toxics()
+		if err != nil {
+			t.Fatal("Error returning toxics:", err)
+		}
+		if len(toxics) != 0 {
+			t.Fatalf("Expected toxic to have expired, got %+v", toxics)
+		}
+
+		// A toxic removed before it started is never added.
+		_, err = client.AddToxic(&tclient.ToxicOptions{
+			ProxyName:  testProxy.Name,
+			ToxicName:  "pending",
+			ToxicType:  "timeout",
+			Toxicity:   1,
+			StartAfter: 50,
+		})
+		if err != nil {
+			t.Fatal("Error setting toxic:", err)
+		}
+		err = testProxy.RemoveToxic("pending")
+		if err != nil {
+			t.Fatal("Error removing toxic:", err)
+		}
+		time.Sleep(100 * time.Millisecond)
+		toxics, err = testProxy.Toxics()
+		if err != nil {
+			t.Fatal("Error returning toxics:", err)
+		}
+		if len(toxics) != 0 {
+			t.Fatalf("Expected removed toxic not to start, got %+v", toxics)
+		}
+
+		past := time.Now().Add(-time.Second)
+		for _, invalid := range []tclient.ToxicOptions{
+			{ToxicType: "latency", Duration: -1},
+			{ToxicType: "latency", StartAfter: -1},
+			{ToxicType: "latency", ExpiresAt: &past},
+		} {
+			invalid.ProxyName = testProxy.Name
+			_, err = client.AddToxic(&invalid)
+			expected := "failed to add toxic to proxy mysql_master: AddToxic: HTTP 400: " +
+				"schedule was invalid, start_after and duration can't be negative and " +
+				"expires_at must be after the start"
+			if err == nil || err.Error() != expected {
+				t.Errorf("Expected error `%s',\n\tgot: `%v'", expected, err)
+			}
+		}
+	})
+}
+
+func TestFlappingToxics(t *testing.T) {
+	WithServer(t, func(addr string) {
+		WithEchoServer(t, func(upstream string) {
+			testProxy, err := client.CreateProxy("mysql_master", "localhost:3310", upstream)
+			if err != nil {
+				t.Fatal("Unable to create proxy:", err)
+			}
+
+			conn := AssertProxyUp(t, testProxy.Listen, true)
+			defer conn.Close()
+
+			_, err = client.AddToxic(&tclient.ToxicOptions{
+				ProxyName: testProxy.Name,
+				ToxicName: "flapping",
+				ToxicType: "latency",
+				Stream:    "upstream",
+				Toxicity:  1,
+				Flap:      &tclient.Flap{Period: 200, Duty: 0.5},
+			})
+			if err != nil {
+				t.Fatal("Error setting toxic:", err)
+			}
+
+			// flapped waits for the toxic to be flapped off or on, on the toxic and
+			// on the connection.
+			flapped := func(off bool) {
+				for i := 0; i < 100; i++ {
+					toxics, err := testProxy.Toxics()
+					if err != nil || len(toxics) != 1 {
+						t.Fatal("Error returning toxics:", toxics, err)
+					}
+					connections, err := testProxy.Connections()
+					if err != nil {
+						t.Fatal("Unable to list connections:", err)
+					}
+					active := len(connections) == 1 && len(connections[0].Toxics["upstream"]) == 1
+					if toxics[0].FlappedOff == off && active == !off {
+						return
+					}
+					time.Sleep(10 * time.Millisecond)
+				}
+				t.Fatalf("Expected toxic to be flapped off: %v", off)
+			}
+			flapped(false)
+			flapped(true)
+			flapped(false)
+
+			_, err = client.AddToxic(&tclient.ToxicOptions{
+				ProxyName: testProxy.Name,
+				ToxicType: "timeout",
+				Flap:      &tclient.Flap{Period: 200, Duty: 1},
+			})
+			expected := "failed to add toxic to proxy mysql_master: AddToxic: HTTP 400: " +
+				"flap was invalid, on and off must be positive, or period and a duty between 0 and 1"
+			if err == nil || err.Error() != expected {
+				t.Errorf("Expected error `%s',\n\tgot: `%v'", expected, err)
+			}
+		})
+	})
+}
+
+func TestRampToxic(t *testing.T) {
+	WithServer(t, func(addr string) {
+		WithEchoServer(t, func(upstream string) {
+			testProxy, err := client.CreateProxy("mysql_master", "localhost:3310", upstream)
+			if err != nil {
+				t.Fatal("Unable to create proxy:", err)
+			}
+
+			// The ramp updates the toxic on running links.
+			conn := AssertProxyUp(t, testProxy.Listen, true)
+			defer conn.Close()
+
+			_, err = testProxy.AddToxic("ramping", "latency", "upstream", 1, tclient.Attributes{
+				"latency": 0,
+			})
+			if err != nil {
+				t.Fatal("Error setting toxic:", err)
+			}
+
+			toxic, err := testProxy.RampToxic("ramping", tclient.Ramp{
+				Attributes: tclient.Attributes{"latency": 1000},
+				Duration:   300,
+				Interval:   50,
+			})
+			if err != nil {
+				t.Fatal("Error ramping toxic:", err)
+			}
+			if toxic.Ramp == nil || toxic.Ramp.Curve != "linear" || toxic.Ramp.From["latency"] != 0.0 {
+				t.Fatalf("Expected toxic to ramp from 0, got %+v", toxic.Ramp)
+			}
+
+			// latency returns the latency of the toxic and whether it is ramping.
+			latency := func() (float64, bool) {
+				toxics, err := testProxy.Toxics()
+				if err != nil || len(toxics) != 1 {
+					t.Fatal("Error returning toxics:", toxics, err)
+				}
+				return toxics[0].Attributes["latency"].(float64), toxics[0].Ramp != nil
+			}
+
+			time.Sleep(150 * time.Millisecond)
+			value, ramping := latency()
+			if value <= 0 || value >= 1000 || !ramping {
+				t.Errorf("Expected toxic to be ramping, got a latency of %v", value)
+			}
+
+			time.Sleep(250 * time.Millisecond)
+			value, ramping = latency()
+			if value != 1000 || ramping {
+				t.Errorf("Expected toxic to have ramped to 1000, got %v", value)
+			}
+
+			// Updating the toxic ends its ramp.
+			_, err = testProxy.RampToxic("ramping", tclient.Ramp{
+				Attributes: tclient.Attributes{"latency": 0},
+				Duration:   100,
+			})
+			if err != nil {
+				t.Fatal("Error ramping toxic:", err)
+			}
+			_, err = testProxy.UpdateToxic("ramping", 1, tclient.Attributes{"latency": 500})
+			if err != nil {
+				t.Fatal("Error updating toxic:", err)
+			}
+			time.Sleep(150 * time.Millisecond)
+			value, ramping = latency()
+			if value != 500 || ramping {
+				t.Errorf("Expected update to end the ramp, got a latency of %v", value)
+			}
+
+			_, err = testProxy.RampToxic("ramping", tclient.Ramp{
+				Attributes: tclient.Attributes{"latency": 100},
+			})
+			expected := "RampToxic: HTTP 400: ramp was invalid, attributes must be numeric, " +
+				"duration positive and curve linear or exponential"
+			if err == nil || err.Error() != expected {
+				t.Errorf("Expected error `%s',\n\tgot: `%v'", expected, err)
+			}
+
+			_, err = testProxy.RampToxic("missing", tclient.Ramp{
+				Attributes: tclient.Attributes{"latency": 100},
+				Duration:   100,
+			})
+			expected = "RampToxic: HTTP 404: toxic not found"
+			if err == nil || err.Error() != expected {
+				t.Errorf("Expected error `%s',\n\tgot: `%v'", expected, err)
+			}
+		})
+	})
+}
+
 func TestAddNoop(t *testing.T) {
 	WithServer(t, func(addr string) {
 		testProxy, err := client.CreateProxy("mysql_master", "localhost:3310", "localhost:20001")
@@ -1054,6 +1405,62 @@ func TestUpdateToxics(t *testing.T) {
 	})
 }
 
+func TestToxicAttributesAreValidated(t *testing.T) {
+	WithServer(t, func(addr string) {
+		testProxy, err := client.CreateProxy("mysql_master", "localhost:3310", "localhost:20001")
+		if err != nil {
+			t.Fatal("Unable to create proxy:", err)
+		}
+
+		expected := "AddToxic: HTTP 400: attributes were invalid for the toxic type"
+		_, err = testProxy.AddToxic("", "latency", "downstream", 1, tclient.Attributes{
+			"distribution": "gaussian",
+		})
+		if err == nil || err.Error() != expected {
+			t.Errorf("Expected error `%s',\n\tgot: `%v'", expected, err)
+		}
+
+		latency, err := testProxy.AddToxic("", "latency", "downstream", 1, tclient.Attributes{
+			"distribution": "pareto-normal",
+		})
+		if err != nil {
+			t.Fatal("Error setting toxic:", err)
+		}
+		if latency.Attributes["distribution"] != "pareto_normal" {
+			t.Error("Expected the distribution to be normalized, got", latency.Attributes)
+		}
+
+		_, err = testProxy.UpdateToxic("latency_downstream", -1, tclient.Attributes{
+			"latency":     100,
+			"correlation": 1.5,
+		})
+		expected = "HTTP 400: attributes were invalid for the toxic type"
+		if err == nil || err.Error() != expected {
+			t.Errorf("Expected error `%s',\n\tgot: `%v'", expected, err)
+		}
+
+		toxics, err := testProxy.Toxics()
+		if err != nil {
+			t.Fatal("Error returning toxics:", err)
+		}
+		toxic := AssertToxicExists(t, toxics, "latency_downstream", "latency", "downstream", true)
+		if toxic.Attributes["latency"] != 0.0 || toxic.Attributes["correlation"] != 0.0 {
+			t.Error("Expected the invalid update to be rejected, got", toxic.Attributes)
+		}
+
+		// The latency toxics of protocols draw their delays the same way.
+		for _, kind := range []string{"http_latency", "http2_latency", "kafka_latency", "dns_latency"} {
+			_, err = testProxy.AddToxic("", kind, "downstream", 1, tclient.Attributes{
+				"distribution": "gaussian",
+			})
+			expected = "AddToxic: HTTP 400: attributes were invalid for the toxic type"
+			if err == nil || err.Error() != expected {
+				t.Errorf("Expected error `%s' for %s,\n\tgot: `%v'", expected, kind, err)
+			}
+		}
+	})
+}
+
 func TestRemoveToxic(t *testing.T) {
 	WithServer(t, func(addr string) {
 		testProxy, err := client.CreateProxy("mysql_master", "localhost:3310", "localhost:20001")
@@ -1090,6 +1497,19 @@ func TestRemoveToxic(t *testing.T) {
 	})
 }
 
+func TestStatusEndpointReturnsSeed(t *testing.T) {
+	WithServer(t, func(addr string) {
+		status, err := client.Status()
+		if err != nil {
+			t.Fatal("Failed to get status", err)
+		}
+
+		if status.Version != "git" || status.Seed != testServer.Seed {
+			t.Fatalf("Expected version git and seed %d, got %+v", testServer.Seed, status)
+		}
+	})
+}
+
 func TestVersionEndpointReturnsVersion(t *testing.T) {
 	WithServer(t, func(addr string) {
 		resp, err := http.Get(addr + "/version")
@@ -1156,3 +1576,694 @@ func AssertToxicExists(
 	}
 	return toxic
 }
+
+func TestCreateProxyWithUpstreamPool(t *testing.T) {
+	WithServer(t, func(addr string) {
+		testProxy := client.NewProxy()
+		testProxy.Name = "replicas"
+		testProxy.Listen = "localhost:3310"
+		testProxy.Upstreams = []string{"localhost:20001", "localhost:20002"}
+		testProxy.Balance = "failover"
+		testProxy.Enabled = true
+
+		err := testProxy.Save()
+		if err != nil {
+			t.Fatal("Unable to create proxy:", err)
+		}
+
+		if testProxy.Upstream != "localhost:20001" || testProxy.Balance != "failover" {
+			t.Fatalf("Unexpected proxy metadata: %s, %s", testProxy.Upstream, testProxy.Balance)
+		}
+
+		members, err := testProxy.PoolMembers()
+		if err != nil {
+			t.Fatal("Unable to retrieve upstreams:", err)
+		}
+		if len(members) != 2 || !members[0].Enabled || !members[1].Enabled {
+			t.Fatalf("Unexpected upstreams: %+v", members)
+		}
+
+		member, err := testProxy.DisableUpstream("localhost:20002")
+		if err != nil {
+			t.Fatal("Unable to disable upstream:", err)
+		}
+		if member.Address != "localhost:20002" || member.Enabled {
+			t.Fatalf("Unexpected upstream: %+v", member)
+		}
+
+		members, err = testProxy.PoolMembers()
+		if err != nil {
+			t.Fatal("Unable to retrieve upstreams:", err)
+		}
+		if !members[0].Enabled || members[1].Enabled {
+			t.Fatalf("Unexpected upstreams after disabling: %+v", members)
+		}
+
+		_, err = testProxy.EnableUpstream("localhost:20003")
+		expected := "HTTP 404: upstream not found"
+		if err == nil {
+			t.Error("Expected error enabling missing upstream, got nil")
+		} else if err.Error() != expected {
+			t.Errorf("Expected error `%s',\n\tgot: `%s'", expected, err)
+		}
+	})
+}
+
+func TestCreateProxyInvalidBalance(t *testing.T) {
+	WithServer(t, func(addr string) {
+		testProxy := client.NewProxy()
+		testProxy.Name = "replicas"
+		testProxy.Listen = "localhost:3310"
+		testProxy.Upstreams = []string{"localhost:20001", "localhost:20002"}
+		testProxy.Balance = "weighted"
+
+		err := testProxy.Save()
+		expected := "HTTP 400: balance was invalid, " +
+			"can be round_robin, random, least_connections or failover"
+		if err == nil {
+			t.Error("Expected error creating proxy, got nil")
+		} else if err.Error() != expected {
+			t.Errorf("Expected error `%s',\n\tgot: `%s'", expected, err)
+		}
+	})
+}
+
+func TestCreateProxyDialOptions(t *testing.T) {
+	WithServer(t, func(addr string) {
+		testProxy := client.NewProxy()
+		testProxy.Name = "flaky"
+		testProxy.Listen = "localhost:3310"
+		testProxy.Upstream = "localhost:20001"
+		testProxy.DialTimeout = 500
+		testProxy.DialRetries = 3
+		testProxy.DialFailure = "hang"
+		testProxy.Enabled = true
+
+		err := testProxy.Save()
+		if err != nil {
+			t.Fatal("Unable to create proxy:", err)
+		}
+		if testProxy.DialTimeout != 500 || testProxy.DialRetries != 3 ||
+			testProxy.DialFailure != "hang" {
+			t.Fatalf("Unexpected dial options: %+v", testProxy)
+		}
+
+		testProxy.DialFailure = "explode"
+		err = testProxy.Save()
+		expected := "HTTP 400: dial_failure was invalid, can be close, reset or hang"
+		if err == nil {
+			t.Error("Expected error updating proxy, got nil")
+		} else if err.Error() != expected {
+			t.Errorf("Expected error `%s',\n\tgot: `%s'", expected, err)
+		}
+	})
+}
+
+func TestListAndCloseConnections(t *testing.T) {
+	WithServer(t, func(addr string) {
+		WithEchoServer(t, func(upstream string) {
+			testProxy, err := client.CreateProxy("mysql_master", "localhost:3310", upstream)
+			if err != nil {
+				t.Fatal("Unable to create proxy:", err)
+			}
+
+			conn := AssertProxyUp(t, testProxy.Listen, true)
+			defer conn.Close()
+
+			var connections []tclient.Connection
+			for i := 0; i < 100 && len(connections) == 0; i++ {
+				time.Sleep(10 * time.Millisecond)
+				connections, err = testProxy.Connections()
+				if err != nil {
+					t.Fatal("Unable to list connections:", err)
+				}
+			}
+			if len(connections) != 1 || connections[0].Client != conn.LocalAddr().String() {
+				t.Fatalf("Expected connection from %s, got %+v", conn.LocalAddr(), connections)
+			}
+
+			err = testProxy.CloseConnection(connections[0].ID, true)
+			if err != nil {
+				t.Fatal("Unable to close connection:", err)
+			}
+
+			conn.SetReadDeadline(time.Now().Add(time.Second))
+			_, err = conn.Read(make([]byte, 1))
+			if !errors.Is(err, syscall.ECONNRESET) {
+				t.Error("Expected connection to be reset, got", err)
+			}
+
+			err = testProxy.CloseConnection(connections[0].ID, false)
+			expected := "HTTP 404: connection not found"
+			if err == nil || err.Error() != expected {
+				t.Errorf("Expected error `%s',\n\tgot: `%v'", expected, err)
+			}
+		})
+	})
+}
+
+func TestHttpToxics(t *testing.T) {
+	WithServer(t, func(addr string) {
+		upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("hello " + r.URL.Path))
+		}))
+		defer upstream.Close()
+
+		testProxy, err := client.CreateProxy("web", "localhost:3310", upstream.Listener.Addr().String())
+		if err != nil {
+			t.Fatal("Unable to create proxy:", err)
+		}
+
+		_, err = testProxy.AddToxic("", "http_status", "upstream", 1, tclient.Attributes{
+			"path":        "^/checkout",
+			"status":      503,
+			"set_headers": map[string]string{"Retry-After": "1"},
+		})
+		if err != nil {
+			t.Fatal("Error setting toxic:", err)
+		}
+		_, err = testProxy.AddToxic("", "http_headers", "downstream", 1, tclient.Attributes{
+			"set_headers": map[string]string{"X-Toxic": "1"},
+		})
+		if err != nil {
+			t.Fatal("Error setting toxic:", err)
+		}
+
+		web := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+		resp, err := web.Get("http://localhost:3310/checkout")
+		if err != nil {
+			t.Fatal("Error sending request:", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != 503 || resp.Header.Get("Retry-After") != "1" {
+			t.Errorf("Expected a 503 from the toxic, got %d %v", resp.StatusCode, resp.Header)
+		}
+
+		resp, err = web.Get("http://localhost:3310/products")
+		if err != nil {
+			t.Fatal("Error sending request:", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != 200 || string(body) != "hello /products" {
+			t.Errorf("Expected the request to pass, got %d %q", resp.StatusCode, body)
+		}
+		if resp.Header.Get("X-Toxic") != "1" {
+			t.Errorf("Expected the response header to be set, got %v", resp.Header)
+		}
+	})
+}
+
+func TestHttpStatusToxicKeepsOrder(t *testing.T) {
+	WithServer(t, func(addr string) {
+		upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(50 * time.Millisecond)
+			w.Write([]byte("hello " + r.URL.Path))
+		}))
+		defer upstream.Close()
+
+		testProxy, err := client.CreateProxy("web", "localhost:3310", upstream.Listener.Addr().String())
+		if err != nil {
+			t.Fatal("Unable to create proxy:", err)
+		}
+		_, err = testProxy.AddToxic("", "http_status", "upstream", 1, tclient.Attributes{
+			"path":   "^/checkout",
+			"status": 100, // Not a final status, so it replies with a 503
+		})
+		if err != nil {
+			t.Fatal("Error setting toxic:", err)
+		}
+
+		conn, err := net.Dial("tcp", "localhost:3310")
+		if err != nil {
+			t.Fatal("Unable to dial proxy:", err)
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+		// The requests are pipelined, so the reply waits for the response to
+		// the request before it.
+		var requests string
+		for _, path := range []string{"/a", "/checkout", "/c"} {
+			requests += "GET " + path + " HTTP/1.1\r\nHost: localhost\r\n\r\n"
+		}
+		conn.Write([]byte(requests))
+
+		r := bufio.NewReader(conn)
+		var responses []string
+		for i := 0; i < 3; i++ {
+			resp, err := http.ReadResponse(r, nil)
+			if err != nil {
+				t.Fatal("Error reading response:", err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			responses = append(responses, strconv.Itoa(resp.StatusCode)+" "+string(body))
+		}
+		expected := []string{"200 hello /a", "503 ", "200 hello /c"}
+		if strings.Join(responses, ", ") != strings.Join(expected, ", ") {
+			t.Errorf("Expected the responses %q, got %q", expected, responses)
+		}
+	})
+}
+
+func TestHttp2Toxics(t *testing.T) {
+	WithServer(t, func(addr string) {
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Grpc-Status", "0")
+			w.Write([]byte("hello " + r.URL.Path))
+		})
+		var conns atomic.Int32
+		upstream := httptest.NewUnstartedServer(h2c.NewHandler(handler, &http2.Server{}))
+		upstream.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+			if state == http.StateNew {
+				conns.Add(1)
+			}
+		}
+		upstream.Start()
+		defer upstream.Close()
+
+		testProxy, err := client.CreateProxy("grpc", "localhost:3310", upstream.Listener.Addr().String())
+		if err != nil {
+			t.Fatal("Unable to create proxy:", err)
+		}
+
+		_, err = testProxy.AddToxic("", "grpc_status", "upstream", 1, tclient.Attributes{
+			"path":    "^/shop.Cart/Checkout$",
+			"status":  14,
+			"message": "try again",
+		})
+		if err != nil {
+			t.Fatal("Error setting toxic:", err)
+		}
+
+		// Both requests are sent on the same connection.
+		dial := func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, addr)
+		}
+		web := &http.Client{Transport: &http2.Transport{AllowHTTP: true, DialTLSContext: dial}}
+		resp, err := web.Post("http://localhost:3310/shop.Cart/Checkout", "application/grpc", nil)
+		if err != nil {
+			t.Fatal("Error sending request:", err)
+		}
+		resp.Body.Close()
+		if resp.Header.Get("Grpc-Status") != "14" || resp.Header.Get("Grpc-Message") != "try again" {
+			t.Errorf("Expected an UNAVAILABLE status from the toxic, got %v", resp.Header)
+		}
+
+		resp, err = web.Post("http://localhost:3310/shop.Cart/Get", "application/grpc", nil)
+		if err != nil {
+			t.Fatal("Error sending request:", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.Header.Get("Grpc-Status") != "0" || string(body) != "hello /shop.Cart/Get" {
+			t.Errorf("Expected the request to pass, got %v %q", resp.Header, body)
+		}
+		if n := conns.Load(); n != 1 {
+			t.Errorf("Expected the connection to be kept, the server got %d", n)
+		}
+	})
+}
+
+func TestKafkaToxics(t *testing.T) {
+	WithServer(t, func(addr string) {
+		// The broker answers every request with the Metadata v1 response of a topic.
+		broker := "\x00\x00\x00\x01\x00\x00\x00\x01\x00\x09localhost\x00\x00\x23\x84\xff\xff"
+		topic := "\x00\x00\x00\x01\x00\x00\x00\x01t\x00\x00\x00\x00\x00"
+		upstream, err := net.Listen("tcp", "localhost:0")
+		if err != nil {
+			t.Fatal("Unable to listen:", err)
+		}
+		defer upstream.Close()
+		go func() {
+			conn, err := upstream.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			for {
+				request := make([]byte, 4)
+				_, err := io.ReadFull(conn, request)
+				if err != nil {
+					return
+				}
+				request = make([]byte, binary.BigEndian.Uint32(request))
+				_, err = io.ReadFull(conn, request)
+				if err != nil {
+					return
+				}
+				body := string(request[4:8]) + broker + "\x00\x00\x00\x01" + topic
+				conn.Write(append(binary.BigEndian.AppendUint32(nil, uint32(len(body))), body...))
+			}
+		}()
+
+		testProxy, err := client.CreateProxy("kafka", "localhost:3310", upstream.Addr().String())
+		if err != nil {
+			t.Fatal("Unable to create proxy:", err)
+		}
+		_, err = testProxy.AddToxic("", "kafka_error", "downstream", 1, tclient.Attributes{
+			"api_keys": []string{"metadata"},
+			"error":    "leader_not_available",
+		})
+		if err != nil {
+			t.Fatal("Error setting toxic:", err)
+		}
+
+		conn, err := net.Dial("tcp", "localhost:3310")
+		if err != nil {
+			t.Fatal("Unable to dial proxy:", err)
+		}
+		defer conn.Close()
+
+		request := "\x00\x00\x00\x0e\x00\x03\x00\x01\x00\x00\x00\x07\xff\xff\x00\x00\x00\x00"
+		for _, id := range []byte{7, 8} {
+			request = request[:11] + string(id) + request[12:]
+			_, err = conn.Write([]byte(request))
+			if err != nil {
+				t.Fatal("Error sending request:", err)
+			}
+			response := make([]byte, 4+4+len(broker)+4+len(topic))
+			conn.SetReadDeadline(time.Now().Add(time.Second))
+			_, err = io.ReadFull(conn, response)
+			if err != nil {
+				t.Fatal("Error reading response:", err)
+			}
+			code := response[len(response)-len(topic)+4 : len(response)-len(topic)+6]
+			if response[7] != id || string(code) != "\x00\x05" {
+				t.Errorf("Expected LEADER_NOT_AVAILABLE for request %d, got %q", id, response)
+			}
+		}
+	})
+}
+
+// WithRedisServer runs a server that replies to every command with its key, a
+// while after it. It follows CLIENT REPLY SKIP, so it doesn't reply to the SKIP
+// and the command after it.
+func WithRedisServer(t *testing.T, f func(string)) {
+	upstream, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal("Unable to listen:", err)
+	}
+	defer upstream.Close()
+	go func() {
+		conn, err := upstream.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		skip := 0
+		for {
+			var args []string
+			line, err := r.ReadString('\n')
+			n, _ := strconv.Atoi(strings.TrimSpace(line[min(1, len(line)):]))
+			for i := 0; err == nil && i < 2*n; i++ {
+				line, err = r.ReadString('\n')
+				args = append(args, strings.TrimSpace(line))
+			}
+			if err != nil || len(args) < 4 {
+				return
+			}
+			if len(args) == 6 && args[5] == "SKIP" {
+				skip = 2
+			}
+			if skip > 0 {
+				skip--
+				continue
+			}
+			time.Sleep(50 * time.Millisecond)
+			conn.Write([]byte(args[2] + "\r\n" + args[3] + "\r\n"))
+		}
+	}()
+
+	f(upstream.Addr().String())
+}
+
+// AssertRedisReplies sends the commands through the proxy at once, and checks
+// the replies.
+func AssertRedisReplies(t *testing.T, commands [][]string, expected string) {
+	conn, err := net.Dial("tcp", "localhost:3310")
+	if err != nil {
+		t.Fatal("Unable to dial proxy:", err)
+	}
+	defer conn.Close()
+
+	var data string
+	for _, args := range commands {
+		data += "*" + strconv.Itoa(len(args)) + "\r\n"
+		for _, arg := range args {
+			data += "$" + strconv.Itoa(len(arg)) + "\r\n" + arg + "\r\n"
+		}
+	}
+	_, err = conn.Write([]byte(data))
+	if err != nil {
+		t.Fatal("Error sending commands:", err)
+	}
+	replies := make([]byte, len(expected))
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = io.ReadFull(conn, replies)
+	if err != nil {
+		t.Fatalf("Error reading replies %q: %v", replies, err)
+	}
+	if string(replies) != expected {
+		t.Errorf("Expected the replies in order %q, got %q", expected, replies)
+	}
+}
+
+func TestRedisErrorToxicKeepsOrder(t *testing.T) {
+	readonly := "-READONLY You can't write against a read only replica.\r\n"
+	WithServer(t, func(addr string) {
+		WithRedisServer(t, func(upstream string) {
+			testProxy, err := client.CreateProxy("redis", "localhost:3310", upstream)
+			if err != nil {
+				t.Fatal("Unable to create proxy:", err)
+			}
+			_, err = client.AddToxic(&tclient.ToxicOptions{
+				ProxyName:  testProxy.Name,
+				ToxicType:  "redis_error",
+				Stream:     "upstream",
+				Toxicity:   1,
+				Attributes: tclient.Attributes{"error": "readonly"},
+				Match:      tclient.Attributes{"protocol": "redis", "commands": []string{"SET"}},
+			})
+			if err != nil {
+				t.Fatal("Error setting toxic:", err)
+			}
+
+			// The commands are pipelined, so the error waits for the reply to
+			// GET a.
+			AssertRedisReplies(t, [][]string{{"GET", "a"}, {"SET", "b", "x"}, {"GET", "c"}},
+				"$1\r\na\r\n"+readonly+"$1\r\nc\r\n")
+		})
+	})
+}
+
+func TestRedisErrorToxicSkippedReplies(t *testing.T) {
+	readonly := "-READONLY You can't write against a read only replica.\r\n"
+	WithServer(t, func(addr string) {
+		WithRedisServer(t, func(upstream string) {
+			testProxy, err := client.CreateProxy("redis", "localhost:3310", upstream)
+			if err != nil {
+				t.Fatal("Unable to create proxy:", err)
+			}
+			_, err = client.AddToxic(&tclient.ToxicOptions{
+				ProxyName:  testProxy.Name,
+				ToxicType:  "redis_error",
+				Stream:     "upstream",
+				Toxicity:   1,
+				Attributes: tclient.Attributes{"error": "readonly"},
+				Match:      tclient.Attributes{"protocol": "redis", "commands": []string{"SET"}},
+			})
+			if err != nil {
+				t.Fatal("Error setting toxic:", err)
+			}
+
+			// The server doesn't reply to GET a after CLIENT REPLY SKIP.
+			AssertRedisReplies(t, [][]string{
+				{"CLIENT", "REPLY", "SKIP"}, {"GET", "a"}, {"SET", "b", "x"}, {"GET", "c"},
+			}, readonly+"$1\r\nc\r\n")
+		})
+
+		// The toxic after it makes the server skip the reply to GET a.
+		WithRedisServer(t, func(upstream string) {
+			testProxy, err := client.Proxy("redis")
+			if err != nil {
+				t.Fatal("Unable to get proxy:", err)
+			}
+			testProxy.Upstream = upstream
+			err = testProxy.Save()
+			if err != nil {
+				t.Fatal("Unable to update proxy:", err)
+			}
+			_, err = client.AddToxic(&tclient.ToxicOptions{
+				ProxyName: testProxy.Name,
+				ToxicType: "redis_drop_reply",
+				Stream:    "upstream",
+				Toxicity:  1,
+				Match:     tclient.Attributes{"protocol": "redis", "key": "^a$"},
+			})
+			if err != nil {
+				t.Fatal("Error setting toxic:", err)
+			}
+
+			AssertRedisReplies(t, [][]string{{"GET", "a"}, {"SET", "b", "x"}, {"GET", "c"}},
+				readonly+"$1\r\nc\r\n")
+		})
+	})
+}
+
+func TestPostgresErrorToxicKeepsOrder(t *testing.T) {
+	message := func(kind byte, payload string) string {
+		return string(kind) + string(binary.BigEndian.AppendUint32(nil, uint32(4+len(payload)))) +
+			payload
+	}
+	ready, complete := message('Z', "I"), message('C', "SELECT 1\x00")
+
+	WithServer(t, func(addr string) {
+		// The server replies to every message, a while after it.
+		upstream, err := net.Listen("tcp", "localhost:0")
+		if err != nil {
+			t.Fatal("Unable to listen:", err)
+		}
+		defer upstream.Close()
+		go func() {
+			conn, err := upstream.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			replies := map[byte]string{
+				0:   message('R', "\x00\x00\x00\x00") + ready, // The startup message
+				'P': message('1', ""),
+				'B': message('2', ""),
+				'E': complete,
+				'S': ready,
+				'Q': complete + ready,
+			}
+			kind := byte(0) // The startup message has no type
+			for {
+				header := make([]byte, 4)
+				_, err = io.ReadFull(conn, header)
+				if err != nil {
+					return
+				}
+				payload := make([]byte, binary.BigEndian.Uint32(header)-4)
+				_, err = io.ReadFull(conn, payload)
+				if err != nil {
+					return
+				}
+				time.Sleep(20 * time.Millisecond)
+				conn.Write([]byte(replies[kind]))
+
+				_, err = io.ReadFull(conn, header[:1])
+				if err != nil {
+					return
+				}
+				kind = header[0]
+			}
+		}()
+
+		testProxy, err := client.CreateProxy("postgres", "localhost:3310", upstream.Addr().String())
+		if err != nil {
+			t.Fatal("Unable to create proxy:", err)
+		}
+		_, err = testProxy.AddToxic("", "postgres_error", "upstream", 1, tclient.Attributes{
+			"statement": "UPDATE",
+		})
+		if err != nil {
+			t.Fatal("Error setting toxic:", err)
+		}
+
+		conn, err := net.Dial("tcp", "localhost:3310")
+		if err != nil {
+			t.Fatal("Unable to dial proxy:", err)
+		}
+		defer conn.Close()
+
+		// The statements are pipelined, so the error waits for the results of
+		// SELECT 1, and the results of SELECT 2 wait for the end of the batch.
+		startup := "\x00\x00\x00\x12\x00\x03\x00\x00user\x00app\x00\x00"
+		bind := message('B', "\x00\x00\x00\x00\x00\x00\x00\x00")
+		execute := message('E', "\x00\x00\x00\x00\x00")
+		_, err = conn.Write([]byte(startup +
+			message('P', "\x00SELECT 1\x00\x00\x00") + bind + execute +
+			message('P', "\x00UPDATE t SET a = 1\x00\x00\x00") + bind + execute +
+			message('S', "") + message('Q', "SELECT 2\x00")))
+		if err != nil {
+			t.Fatal("Error sending messages:", err)
+		}
+		failure := "SERROR\x00VERROR\x00C40001\x00" +
+			"Mcould not serialize access due to concurrent update\x00\x00"
+		expected := message('R', "\x00\x00\x00\x00") + ready +
+			message('1', "") + message('2', "") + complete +
+			message('E', failure) + ready +
+			complete + ready
+		replies := make([]byte, len(expected))
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		_, err = io.ReadFull(conn, replies)
+		if err != nil {
+			t.Fatal("Error reading replies:", err)
+		}
+		if string(replies) != expected {
+			t.Errorf("Expected the replies in order %q, got %q", expected, replies)
+		}
+	})
+}
+
+func TestToxicMatch(t *testing.T) {
+	WithServer(t, func(addr string) {
+		upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("hello " + r.URL.Path))
+		}))
+		defer upstream.Close()
+
+		testProxy, err := client.CreateProxy("web", "localhost:3310", upstream.Listener.Addr().String())
+		if err != nil {
+			t.Fatal("Unable to create proxy:", err)
+		}
+
+		_, err = client.AddToxic(&tclient.ToxicOptions{
+			ProxyName:  testProxy.Name,
+			ToxicType:  "latency",
+			Stream:     "upstream",
+			Toxicity:   1,
+			Attributes: tclient.Attributes{"latency": 300},
+			Match:      tclient.Attributes{"protocol": "http", "path": "^/checkout$"},
+		})
+		if err != nil {
+			t.Fatal("Error setting toxic:", err)
+		}
+
+		toxics, err := testProxy.Toxics()
+		if err != nil || len(toxics) != 1 || toxics[0].Match["path"] != "^/checkout$" {
+			t.Fatalf("Expected the toxic to have a match, got %+v: %v", toxics, err)
+		}
+
+		web := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+		for path, slow := range map[string]bool{"/checkout": true, "/products": false} {
+			start := time.Now()
+			resp, err := web.Get("http://localhost:3310" + path)
+			if err != nil {
+				t.Fatal("Error sending request:", err)
+			}
+			resp.Body.Close()
+			elapsed := time.Since(start)
+			if slow != (elapsed >= 300*time.Millisecond) {
+				t.Errorf("Expected %s to be slow: %v, it took %s", path, slow, elapsed)
+			}
+		}
+
+		_, err = client.AddToxic(&tclient.ToxicOptions{
+			ProxyName: testProxy.Name,
+			ToxicType: "latency",
+			Toxicity:  1,
+			Match:     tclient.Attributes{"protocol": "redis", "path": "/"},
+		})
+		expected := "failed to add toxic to proxy web: AddToxic: HTTP 400: " +
+			"match was invalid, the protocol must be known and its fields valid"
+		if err == nil || err.Error() != expected {
+			t.Errorf("Expected an invalid match error, got %v", err)
+		}
+	})
+}
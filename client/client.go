@@ -46,6 +46,28 @@ func (client *Client) Version() ([]byte, error) {
 	return client.get("/version")
 }
 
+// Status is the state of a running Toxiproxy server.
+type Status struct {
+	Version string `json:"version"`
+	Seed    int64  `json:"seed"` // Start the server with this seed to replay a run
+}
+
+// Status returns the version and the random seed of the server.
+func (client *Client) Status() (*Status, error) {
+	resp, err := client.get("/status")
+	if err != nil {
+		return nil, err
+	}
+
+	status := &Status{}
+	err = json.Unmarshal(resp, status)
+	if err != nil {
+		return nil, err
+	}
+
+	return status, nil
+}
+
 // Proxies returns a map with all the proxies and their toxics.
 func (client *Client) Proxies() (map[string]*Proxy, error) {
 	resp, err := client.get("/proxies")
@@ -149,13 +171,20 @@ func (client *Client) AddToxic(options *ToxicOptions) (*Toxic, error) {
 		return nil, fmt.Errorf("failed to retrieve proxy with name `%s`: %v", options.ProxyName, err)
 	}
 
-	toxic, err := proxy.AddToxic(
-		options.ToxicName,
-		options.ToxicType,
-		options.Stream,
-		options.Toxicity,
-		options.Attributes,
-	)
+	toxic, err := proxy.createToxic(Toxic{
+		Name:       options.ToxicName,
+		Type:       options.ToxicType,
+		Stream:     options.Stream,
+		Toxicity:   options.Toxicity,
+		Sampling:   options.Sampling,
+		Seed:       options.Seed,
+		Attributes: options.Attributes,
+		StartAfter: options.StartAfter,
+		Duration:   options.Duration,
+		ExpiresAt:  options.ExpiresAt,
+		Flap:       options.Flap,
+		Match:      options.Match,
+	})
 
 	if err != nil {
 		return nil, fmt.Errorf("failed to add toxic to proxy %s: %v", options.ProxyName, err)
@@ -171,11 +200,16 @@ func (client *Client) UpdateToxic(options *ToxicOptions) (*Toxic, error) {
 		return nil, fmt.Errorf("failed to retrieve proxy with name `%s`: %v", options.ProxyName, err)
 	}
 
-	toxic, err := proxy.UpdateToxic(
-		options.ToxicName,
-		options.Toxicity,
-		options.Attributes,
-	)
+	fields := map[string]interface{}{
+		"attributes": options.Attributes,
+	}
+	if options.Toxicity != -1 {
+		fields["toxicity"] = options.Toxicity
+	}
+	if options.Sampling != "" {
+		fields["sampling"] = options.Sampling
+	}
+	toxic, err := proxy.updateToxic(options.ToxicName, fields)
 
 	if err != nil {
 		return nil,
@@ -8,13 +8,23 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"time"
 )
 
 type Proxy struct {
-	Name     string `json:"name"`     // The name of the proxy
-	Listen   string `json:"listen"`   // The address the proxy listens on
-	Upstream string `json:"upstream"` // The upstream address to proxy to
-	Enabled  bool   `json:"enabled"`  // Whether the proxy is enabled
+	Name      string    `json:"name"`                // The name of the proxy
+	Listen    string    `json:"listen"`              // The address the proxy listens on
+	Upstream  string    `json:"upstream"`            // The upstream address to proxy to
+	Upstreams []string  `json:"upstreams,omitempty"` // A pool of upstreams to balance across
+	Balance   string    `json:"balance,omitempty"`   // How connections are spread over the pool
+	Protocol  string    `json:"protocol,omitempty"`  // The transport protocol, tcp or udp
+	TLS       *ProxyTLS `json:"tls,omitempty"`       // TLS settings for the listener and upstream
+	Enabled   bool      `json:"enabled"`             // Whether the proxy is enabled
+
+	DialTimeout    int64  `json:"dial_timeout,omitempty"`     // Upstream dial timeout in ms
+	DialRetries    int    `json:"dial_retries,omitempty"`     // Dial attempts after the first
+	DialRetryDelay int64  `json:"dial_retry_delay,omitempty"` // Delay between dials in ms
+	DialFailure    string `json:"dial_failure,omitempty"`     // close, reset or hang on failure
 
 	// The toxics active on this proxy. Note: you cannot set this
 	// when passing Proxy into Populate()
@@ -24,6 +34,38 @@ type Proxy struct {
 	created bool // True if this proxy exists on the server
 }
 
+// ProxyTLS terminates TLS from clients with the given certificate and key, and
+// originates TLS to the upstream when Upstream is set.
+type ProxyTLS struct {
+	CertFile string       `json:"cert_file,omitempty"` // PEM certificate for the listener
+	KeyFile  string       `json:"key_file,omitempty"`  // PEM private key for the listener
+	Upstream *UpstreamTLS `json:"upstream,omitempty"`  // Connect to the upstream over TLS
+}
+
+type UpstreamTLS struct {
+	CAFile             string `json:"ca_file,omitempty"`              // PEM CA certificates
+	ServerName         string `json:"server_name,omitempty"`          // SNI and verified name
+	InsecureSkipVerify bool   `json:"insecure_skip_verify,omitempty"` // Skip verification
+}
+
+// PoolMember is the state of one upstream in the pool of a proxy.
+type PoolMember struct {
+	Address     string `json:"address"`
+	Enabled     bool   `json:"enabled"`
+	Connections int    `json:"connections"` // Open connections to this upstream
+}
+
+// Connection is a client currently connected through a proxy.
+type Connection struct {
+	ID              string              `json:"id"`               // Used to close the connection
+	Client          string              `json:"client"`           // The address of the client
+	Upstream        string              `json:"upstream"`         // The upstream it is connected to
+	StartedAt       time.Time           `json:"started_at"`       // When the upstream was dialed
+	BytesUpstream   int64               `json:"bytes_upstream"`   // Bytes read from the client
+	BytesDownstream int64               `json:"bytes_downstream"` // Bytes read from the upstream
+	Toxics          map[string][]string `json:"toxics"`           // Active toxics by stream
+}
+
 // Save saves changes to a proxy such as its enabled status or upstream port.
 func (proxy *Proxy) Save() error {
 	request, err := json.Marshal(proxy)
@@ -102,7 +144,18 @@ func (proxy *Proxy) AddToxic(
 	toxicity float32,
 	attrs Attributes,
 ) (*Toxic, error) {
-	toxic := Toxic{name, typeName, stream, toxicity, attrs}
+	return proxy.createToxic(Toxic{
+		Name:       name,
+		Type:       typeName,
+		Stream:     stream,
+		Toxicity:   toxicity,
+		Attributes: attrs,
+	})
+}
+
+// createToxic adds a toxic with all of its fields, including the ones AddToxic
+// leaves to their defaults like Seed.
+func (proxy *Proxy) createToxic(toxic Toxic) (*Toxic, error) {
 	if toxic.Toxicity == -1 {
 		toxic.Toxicity = 1 // Just to be consistent with a toxicity of -1 using the default
 	}
@@ -138,6 +191,10 @@ func (proxy *Proxy) UpdateToxic(name string, toxicity float32, attrs Attributes)
 	if toxicity != -1 {
 		toxic["toxicity"] = toxicity
 	}
+	return proxy.updateToxic(name, toxic)
+}
+
+func (proxy *Proxy) updateToxic(name string, toxic map[string]interface{}) (*Toxic, error) {
 	request, err := json.Marshal(&toxic)
 	if err != nil {
 		return nil, err
@@ -160,7 +217,113 @@ func (proxy *Proxy) UpdateToxic(name string, toxicity float32, attrs Attributes)
 	return result, nil
 }
 
+// RampToxic changes numeric attributes of an existing toxic gradually, to the
+// target attributes of the ramp.
+func (proxy *Proxy) RampToxic(name string, ramp Ramp) (*Toxic, error) {
+	request, err := json.Marshal(&ramp)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := proxy.client.post(
+		"/proxies/"+proxy.Name+"/toxics/"+name+"/ramp",
+		bytes.NewReader(request),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("RampToxic: %w", err)
+	}
+
+	result := &Toxic{}
+	err = json.Unmarshal(resp, result)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
 // RemoveToxic renives the toxic with the given name.
 func (proxy *Proxy) RemoveToxic(name string) error {
 	return proxy.client.delete("/proxies/" + proxy.Name + "/toxics/" + name)
 }
+
+// PoolMembers returns the state of every upstream of the proxy.
+func (proxy *Proxy) PoolMembers() ([]PoolMember, error) {
+	resp, err := proxy.client.get("/proxies/" + proxy.Name + "/upstreams")
+	if err != nil {
+		return nil, err
+	}
+
+	members := make([]PoolMember, 0)
+	err = json.Unmarshal(resp, &members)
+	if err != nil {
+		return nil, err
+	}
+
+	return members, nil
+}
+
+// EnableUpstream sends new connections to an upstream of the pool again after it
+// has been disabled.
+func (proxy *Proxy) EnableUpstream(address string) (*PoolMember, error) {
+	return proxy.setUpstreamEnabled(address, true)
+}
+
+// DisableUpstream marks an upstream of the pool as down. Its connections are
+// closed and new connections go to the other upstreams.
+func (proxy *Proxy) DisableUpstream(address string) (*PoolMember, error) {
+	return proxy.setUpstreamEnabled(address, false)
+}
+
+func (proxy *Proxy) setUpstreamEnabled(address string, enabled bool) (*PoolMember, error) {
+	request, err := json.Marshal(map[string]interface{}{
+		"address": address,
+		"enabled": enabled,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := proxy.client.patch(
+		"/proxies/"+proxy.Name+"/upstreams",
+		bytes.NewReader(request),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &PoolMember{}
+	err = json.Unmarshal(resp, result)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// Connections returns the clients currently connected through the proxy, oldest
+// first.
+func (proxy *Proxy) Connections() ([]Connection, error) {
+	resp, err := proxy.client.get("/proxies/" + proxy.Name + "/connections")
+	if err != nil {
+		return nil, err
+	}
+
+	connections := make([]Connection, 0)
+	err = json.Unmarshal(resp, &connections)
+	if err != nil {
+		return nil, err
+	}
+
+	return connections, nil
+}
+
+// CloseConnection closes a connection of the proxy by its ID, on both the client
+// and upstream side. With reset, the sockets are closed with a TCP RST.
+func (proxy *Proxy) CloseConnection(id string, reset bool) error {
+	path := "/proxies/" + proxy.Name + "/connections/" + id
+	if reset {
+		path += "?mode=reset"
+	}
+	return proxy.client.delete(path)
+}
@@ -4,6 +4,8 @@
 // For use with Toxiproxy 2.x
 package toxiproxy
 
+import "time"
+
 type Attributes map[string]interface{}
 
 type Toxic struct {
@@ -11,7 +13,54 @@ type Toxic struct {
 	Type       string     `json:"type"`
 	Stream     string     `json:"stream,omitempty"`
 	Toxicity   float32    `json:"toxicity"`
+	Sampling   string     `json:"sampling,omitempty"` // restart, connection or chunk
+	Seed       *int64     `json:"seed,omitempty"`     // Replaces the server seed for this toxic
+	Attributes Attributes `json:"attributes"`
+
+	// The toxic starts after StartAfter and is removed after its Duration, or
+	// at ExpiresAt. The times left are filled in by the server.
+	StartAfter int64      `json:"start_after,omitempty"` // Time in milliseconds
+	Duration   int64      `json:"duration,omitempty"`    // Time in milliseconds
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	StartsAt   *time.Time `json:"starts_at,omitempty"`
+	StartsIn   int64      `json:"starts_in,omitempty"`  // Time in milliseconds
+	ExpiresIn  int64      `json:"expires_in,omitempty"` // Time in milliseconds
+
+	// The toxic is turned off and on again with a Flap. FlappedOff is filled in
+	// by the server.
+	Flap       *Flap `json:"flap,omitempty"`
+	FlappedOff bool  `json:"flapped_off,omitempty"`
+
+	// The toxic only applies to the messages of a protocol that Match, like
+	// {"protocol": "http", "path": "^/checkout"}.
+	Match Attributes `json:"match,omitempty"`
+
+	// Ramp is filled in by the server while the toxic is ramping.
+	Ramp *Ramp `json:"ramp,omitempty"`
+}
+
+// Ramp changes numeric attributes of a toxic gradually over its Duration, from
+// their current values to the target Attributes. The Curve is linear or
+// exponential, and the attributes are updated every Interval. From and EndsAt
+// are filled in by the server.
+type Ramp struct {
 	Attributes Attributes `json:"attributes"`
+	Duration   int64      `json:"duration"`           // Time in milliseconds
+	Interval   int64      `json:"interval,omitempty"` // Time in milliseconds
+	Curve      string     `json:"curve,omitempty"`
+	From       Attributes `json:"from,omitempty"`
+	EndsAt     *time.Time `json:"ends_at,omitempty"`
+}
+
+// Flap turns a toxic off and on again, either with On and Off times or with a
+// Period and the Duty part of it the toxic is on. With Random, the times are
+// the means of exponentially distributed times.
+type Flap struct {
+	On     int64   `json:"on,omitempty"`     // Time in milliseconds
+	Off    int64   `json:"off,omitempty"`    // Time in milliseconds
+	Period int64   `json:"period,omitempty"` // Time in milliseconds
+	Duty   float64 `json:"duty,omitempty"`   // Between 0 and 1
+	Random bool    `json:"random,omitempty"`
 }
 
 type Toxics []Toxic
@@ -20,7 +69,14 @@ type ToxicOptions struct {
 	ProxyName,
 	ToxicName,
 	ToxicType,
-	Stream string
+	Stream,
+	Sampling string
 	Toxicity   float32
+	Seed       *int64
 	Attributes Attributes
+	StartAfter int64 // Time in milliseconds
+	Duration   int64 // Time in milliseconds
+	ExpiresAt  *time.Time
+	Flap       *Flap
+	Match      Attributes
 }
@@ -1,12 +1,14 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 	"runtime"
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/urfave/cli/v2"
 	terminal "golang.org/x/term"
@@ -35,10 +37,11 @@ func color(color string) string {
 var toxicDescription = `
   Default Toxics:
   latency:    delay all data +/- jitter
-              latency=<ms>,jitter=<ms>
+              latency=<ms>,jitter=<ms>,correlation=<0-1>,
+              distribution=<uniform|normal|pareto|pareto_normal|log_normal>
 
-  bandwidth:  limit to max kb/s
-              rate=<KB/s>
+  bandwidth:  limit to max kb/s, per connection or shared by a proxy or group
+              rate=<KB/s>,burst=<KB>,scope=<connection|proxy|group>,group=<name>
 
   slow_close: delay from closing
               delay=<ms>
@@ -53,17 +56,108 @@ var toxicDescription = `
   slicer:     slice data into bits with optional delay
               average_size=<bytes>,size_variation=<bytes>,delay=<microseconds>
 
+  drop:       drop chunks of data with a probability, optionally in bursts
+              probability=<0-1>,burst_start=<0-1>,burst_end=<0-1>,burst_keep=<0-1>
+
+  corrupt:    damage bytes of the data by flipping bits, replacing, zeroing or truncating
+              mode=<bit_flip|replace|zero|truncate>,unit=<byte|chunk>,probability=<0-1>,
+              byte=<0-255>
+
+  reorder:    pass chunks of data on out of order, optionally duplicating them
+              window=<chunks>,delay=<ms>,duplicate=<0-1>
+
+  HTTP Toxics, applied to the requests (upstream) or responses (downstream) that match
+  method=<method>,path=<regexp>,headers=<json object of regexps>:
+  http_status:   reply with a status instead of forwarding requests, or replace responses
+                 status=<code>,body=<text>,set_headers=<json object>
+
+  http_latency:  delay matching messages +/- jitter
+                 latency=<ms>,jitter=<ms>
+
+  http_headers:  set or remove headers of matching messages
+                 set_headers=<json object>,remove_headers=<json list>
+
+  http_truncate: cut the body of a matching message and close the connection
+                 bytes=<bytes>
+
+  Redis Toxics, applied to the commands of the upstream, scoped with a redis match:
+  redis_error:      reply with an error instead of forwarding commands
+                    error=<loading|readonly|moved|ask|oom>,message=<text>,address=<host:port>
+
+  redis_drop_reply: forward commands, but make the server skip their replies
+
+  PostgreSQL Toxics:
+  postgres_error:     reply to matching queries (upstream) with an error
+                      statement=<prefix>,code=<sqlstate>,message=<text>,severity=<ERROR|FATAL>
+
+  postgres_terminate: end connections after the startup handshake (downstream)
+                      delay=<ms>,code=<sqlstate>,message=<text>
+
+  MySQL Toxics:
+  mysql_error:     reply to matching statements (upstream) with an error
+                   statement=<prefix>,code=<code>,message=<text>
+
+  mysql_handshake: fail new connections (downstream) with an error, closing them
+                   phase=<greeting|auth>,code=<code>,message=<text>
+
+  HTTP/2 Toxics, applied to the upstream requests that match
+  path=<regexp>,headers=<json object of regexps>:
+  grpc_status:   reply with a gRPC status instead of forwarding requests
+                 status=<code>,message=<text>
+
+  http2_reset:   reset the streams of matching requests
+                 error_code=<name>
+
+  http2_goaway:  send a GOAWAY on the first matching request
+                 error_code=<name>
+
+  http2_latency: delay the data of matching requests +/- jitter
+                 latency=<ms>,jitter=<ms>
+
+  Kafka Toxics, applied to the downstream responses to the requests that match
+  api_keys=<json list of names>:
+  kafka_error:   set an error in the responses to produce, fetch and metadata requests
+                 error=<name|code>
+
+  kafka_latency: delay matching responses +/- jitter
+                 latency=<ms>,jitter=<ms>
+
+  DNS Toxics, applied to the messages of UDP proxies that match
+  names=<json list>,types=<json list of names>:
+  dns_error:     reply to matching queries (upstream) with an error
+                 rcode=<name|code>
+
+  dns_rewrite:   rewrite the A and AAAA records of matching responses (downstream)
+                 address=<ipv4>,address6=<ipv6>
+
+  dns_truncate:  truncate matching responses (downstream), forcing a TCP retry
+
+  dns_latency:   delay matching messages +/- jitter
+                 latency=<ms>,jitter=<ms>
+
+  Connection Toxics, applied to new clients before the upstream is dialed:
+  refuse:          reset new connections, like a refused connection
+
+  accept_delay:    delay new connections +/- jitter
+                   delay=<ms>,jitter=<ms>
+
+  accept_hang:     never dial the upstream, close after timeout (0 waits for the client)
+                   timeout=<ms>
+
+  max_connections: close or reset connections over the limit
+                   limit=<count>,mode=<close|reset>
+
   toxic add:
     usage: toxiproxy-cli toxic add --type <toxicType> [--downstream|--upstream] \
-            --toxicName <toxicName> [--toxicity <float>] \
-            --attribute <key=value> [--attribute <key2=value2>] <proxyName>
+            --toxicName <toxicName> [--toxicity <float>] [--sampling <mode>] \
+            [--seed <int>] --attribute <key=value> [--attribute <key2=value2>] <proxyName>
 
 
     example: toxiproxy-cli toxic add -t latency -n myToxic -a latency=100 -a jitter=50 myProxy
 
   toxic update:
     usage: toxiproxy-cli toxic update --toxicName <toxicName> [--toxicity <float>] \
-            --attribute <key1=value1> [--attribute <key2=value2>] <proxyName>
+            [--sampling <mode>] --attribute <key1=value1> [--attribute <key2=value2>] <proxyName>
 
     example: toxiproxy-cli toxic update -n myToxic -a jitter=25 myProxy
 
@@ -129,10 +223,63 @@ func cliCommands() []*cli.Command {
 					Aliases: []string{"l"},
 					Usage:   "proxy will listen on this address",
 				},
-				&cli.StringFlag{
+				&cli.StringSliceFlag{
 					Name:    "upstream",
 					Aliases: []string{"u"},
-					Usage:   "proxy will forward to this address",
+					Usage:   "proxy will forward to this address, repeat for a pool of upstreams",
+				},
+				&cli.StringFlag{
+					Name:        "balance",
+					Aliases:     []string{"b"},
+					Usage:       "round_robin, random, least_connections or failover",
+					DefaultText: "round_robin",
+				},
+				&cli.StringFlag{
+					Name:        "protocol",
+					Aliases:     []string{"p"},
+					Usage:       "transport protocol of the proxy, tcp or udp",
+					DefaultText: "tcp",
+				},
+				&cli.Int64Flag{
+					Name:  "dial-timeout",
+					Usage: "upstream dial timeout in milliseconds, 0 waits for the OS",
+				},
+				&cli.IntFlag{
+					Name:  "dial-retries",
+					Usage: "number of times to retry dialing the upstream",
+				},
+				&cli.Int64Flag{
+					Name:  "dial-retry-delay",
+					Usage: "milliseconds to wait between dial retries",
+				},
+				&cli.StringFlag{
+					Name:        "dial-failure",
+					Usage:       "what clients see when the upstream can't be dialed: close, reset or hang",
+					DefaultText: "close",
+				},
+				&cli.StringFlag{
+					Name:  "tls-cert",
+					Usage: "terminate TLS from clients with this PEM certificate file",
+				},
+				&cli.StringFlag{
+					Name:  "tls-key",
+					Usage: "PEM private key file for --tls-cert",
+				},
+				&cli.BoolFlag{
+					Name:  "upstream-tls",
+					Usage: "connect to the upstream over TLS",
+				},
+				&cli.StringFlag{
+					Name:  "upstream-ca",
+					Usage: "verify the upstream with the CA certificates in this PEM file",
+				},
+				&cli.StringFlag{
+					Name:  "upstream-server-name",
+					Usage: "server name to send and verify for the upstream",
+				},
+				&cli.BoolFlag{
+					Name:  "upstream-insecure",
+					Usage: "skip verification of the upstream certificate",
 				},
 			},
 			Action: withToxi(createProxy),
@@ -150,6 +297,18 @@ func cliCommands() []*cli.Command {
 			Aliases: []string{"d"},
 			Action:  withToxi(deleteProxy),
 		},
+		{
+			Name:        "upstreams",
+			Aliases:     []string{"u"},
+			Usage:       "\tlist, enable or disable upstreams\n\t\tusage: see 'toxiproxy-cli upstreams'\n",
+			Subcommands: cliUpstreamsSubCommands(),
+		},
+		{
+			Name:        "connections",
+			Aliases:     []string{"conn"},
+			Usage:       "\tlist or kill live connections\n\t\tusage: see 'toxiproxy-cli connections'\n",
+			Subcommands: cliConnectionsSubCommands(),
+		},
 		{
 			Name:        "toxic",
 			Aliases:     []string{"t"},
@@ -164,6 +323,7 @@ func cliToxiSubCommands() []*cli.Command {
 	return []*cli.Command{
 		cliToxiAddSubCommand(),
 		cliToxiUpdateSubCommand(),
+		cliToxiRampSubCommand(),
 		cliToxiRemoveSubCommand(),
 	}
 }
@@ -191,6 +351,45 @@ func cliToxiAddSubCommand() *cli.Command {
 				Usage:       "toxicity of toxic should be a float between 0 and 1",
 				DefaultText: "1.0",
 			},
+			&cli.StringFlag{
+				Name:        "sampling",
+				Usage:       "when toxicity is rolled: restart, connection or chunk",
+				DefaultText: "restart",
+			},
+			&cli.Int64Flag{
+				Name:        "seed",
+				Usage:       "seed for the randomness of the toxic, to replay a run",
+				DefaultText: "derived from the server seed",
+			},
+			&cli.DurationFlag{
+				Name:        "start-after",
+				Usage:       "wait before starting the toxic, like 5s",
+				DefaultText: "start now",
+			},
+			&cli.DurationFlag{
+				Name:        "duration",
+				Usage:       "remove the toxic after it ran for this long, like 1m",
+				DefaultText: "keep it",
+			},
+			&cli.StringFlag{
+				Name:        "expires-at",
+				Usage:       "remove the toxic at this RFC 3339 time",
+				DefaultText: "keep it",
+			},
+			&cli.StringFlag{
+				Name:        "flap",
+				Usage:       "turn the toxic on and off, like 5s/25s for 5s on every 30s",
+				DefaultText: "always on",
+			},
+			&cli.BoolFlag{
+				Name:  "flap-random",
+				Usage: "flap with random times, using the flap times as their means",
+			},
+			&cli.StringSliceFlag{
+				Name:    "match",
+				Aliases: []string{"m"},
+				Usage:   "only apply to matching messages, like -m protocol=http -m path=^/checkout",
+			},
 			&cli.StringSliceFlag{
 				Name:    "attribute",
 				Aliases: []string{"a"},
@@ -231,6 +430,11 @@ func cliToxiUpdateSubCommand() *cli.Command {
 				Usage:       "toxicity of toxic should be a float between 0 and 1",
 				DefaultText: "1.0",
 			},
+			&cli.StringFlag{
+				Name:        "sampling",
+				Usage:       "when toxicity is rolled: restart, connection or chunk",
+				DefaultText: "restart",
+			},
 			&cli.StringSliceFlag{
 				Name:    "attribute",
 				Aliases: []string{"a"},
@@ -241,6 +445,41 @@ func cliToxiUpdateSubCommand() *cli.Command {
 	}
 }
 
+func cliToxiRampSubCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "ramp",
+		Usage:     "change numeric attributes of an enabled toxic gradually",
+		ArgsUsage: "<proxyName>",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "toxicName",
+				Aliases: []string{"n"},
+				Usage:   "name of the toxic",
+			},
+			&cli.DurationFlag{
+				Name:  "duration",
+				Usage: "time to reach the target attributes over, like 1m",
+			},
+			&cli.DurationFlag{
+				Name:        "interval",
+				Usage:       "time between updates of the attributes",
+				DefaultText: "1s",
+			},
+			&cli.StringFlag{
+				Name:        "curve",
+				Usage:       "how attributes change: linear or exponential",
+				DefaultText: "linear",
+			},
+			&cli.StringSliceFlag{
+				Name:    "attribute",
+				Aliases: []string{"a"},
+				Usage:   "target toxic attribute in key=value format",
+			},
+		},
+		Action: withToxi(rampToxic),
+	}
+}
+
 func cliToxiRemoveSubCommand() *cli.Command {
 	return &cli.Command{
 		Name:      "remove",
@@ -258,6 +497,58 @@ func cliToxiRemoveSubCommand() *cli.Command {
 	}
 }
 
+func cliUpstreamsSubCommands() []*cli.Command {
+	return []*cli.Command{
+		{
+			Name:      "list",
+			Aliases:   []string{"l", "ls"},
+			Usage:     "list the upstreams of a proxy",
+			ArgsUsage: "<proxyName>",
+			Action:    withToxi(listUpstreams),
+		},
+		{
+			Name:      "enable",
+			Aliases:   []string{"e"},
+			Usage:     "send new connections to an upstream again",
+			ArgsUsage: "<proxyName> <address>",
+			Action:    withToxi(enableUpstream),
+		},
+		{
+			Name:      "disable",
+			Aliases:   []string{"d"},
+			Usage:     "mark an upstream as down, closing its connections",
+			ArgsUsage: "<proxyName> <address>",
+			Action:    withToxi(disableUpstream),
+		},
+	}
+}
+
+func cliConnectionsSubCommands() []*cli.Command {
+	return []*cli.Command{
+		{
+			Name:      "list",
+			Aliases:   []string{"l", "ls"},
+			Usage:     "list the connections open through a proxy",
+			ArgsUsage: "<proxyName>",
+			Action:    withToxi(listConnections),
+		},
+		{
+			Name:      "kill",
+			Aliases:   []string{"k"},
+			Usage:     "close a connection on both the client and upstream side",
+			ArgsUsage: "<proxyName> <id>",
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:    "reset",
+					Aliases: []string{"r"},
+					Usage:   "close the connection with a TCP RST",
+				},
+			},
+			Action: withToxi(killConnection),
+		},
+	}
+}
+
 type toxiAction func(*cli.Context, *toxiproxy.Client) error
 
 func withToxi(f toxiAction) func(*cli.Context) error {
@@ -414,11 +705,27 @@ func createProxy(c *cli.Context, t *toxiproxy.Client) error {
 	if err != nil {
 		return err
 	}
-	upstream, err := getArgOrFail(c, "upstream")
-	if err != nil {
-		return err
+	upstreams := c.StringSlice("upstream")
+	if len(upstreams) == 0 {
+		cli.ShowSubcommandHelp(c)
+		return errorf("Required argument 'upstream' was empty.\n")
+	}
+	proxy := t.NewProxy()
+	proxy.Name = proxyName
+	proxy.Listen = listen
+	proxy.Upstream = upstreams[0]
+	if len(upstreams) > 1 {
+		proxy.Upstreams = upstreams
 	}
-	_, err = t.CreateProxy(proxyName, listen, upstream)
+	proxy.Balance = c.String("balance")
+	proxy.DialTimeout = c.Int64("dial-timeout")
+	proxy.DialRetries = c.Int("dial-retries")
+	proxy.DialRetryDelay = c.Int64("dial-retry-delay")
+	proxy.DialFailure = c.String("dial-failure")
+	proxy.Protocol = c.String("protocol")
+	proxy.TLS = parseProxyTLS(c)
+	proxy.Enabled = true
+	err = proxy.Save()
 	if err != nil {
 		return errorf("Failed to create proxy: %s\n", err.Error())
 	}
@@ -426,6 +733,176 @@ func createProxy(c *cli.Context, t *toxiproxy.Client) error {
 	return nil
 }
 
+func parseProxyTLS(c *cli.Context) *toxiproxy.ProxyTLS {
+	var upstream *toxiproxy.UpstreamTLS
+	if c.Bool("upstream-tls") || c.String("upstream-ca") != "" ||
+		c.String("upstream-server-name") != "" || c.Bool("upstream-insecure") {
+		upstream = &toxiproxy.UpstreamTLS{
+			CAFile:             c.String("upstream-ca"),
+			ServerName:         c.String("upstream-server-name"),
+			InsecureSkipVerify: c.Bool("upstream-insecure"),
+		}
+	}
+
+	if upstream == nil && c.String("tls-cert") == "" && c.String("tls-key") == "" {
+		return nil
+	}
+
+	return &toxiproxy.ProxyTLS{
+		CertFile: c.String("tls-cert"),
+		KeyFile:  c.String("tls-key"),
+		Upstream: upstream,
+	}
+}
+
+func listUpstreams(c *cli.Context, t *toxiproxy.Client) error {
+	proxyName := c.Args().First()
+	if proxyName == "" {
+		cli.ShowSubcommandHelp(c)
+		return errorf("Proxy name is required as the first argument.\n")
+	}
+
+	proxy, err := t.Proxy(proxyName)
+	if err != nil {
+		return errorf("Failed to retrieve proxy %s: %s\n", proxyName, err.Error())
+	}
+
+	members, err := proxy.PoolMembers()
+	if err != nil {
+		return errorf("Failed to retrieve upstreams of %s: %s\n", proxyName, err.Error())
+	}
+
+	if isTTY {
+		fmt.Printf(
+			"%sUpstream\t\t\t%sEnabled\t\t%sConnections\n%s",
+			color(YELLOW),
+			color(PURPLE),
+			color(BLUE),
+			color(NONE),
+		)
+		fmt.Printf(
+			"%s======================================================================\n",
+			color(NONE),
+		)
+	}
+
+	for _, member := range members {
+		printWidth(color(colorEnabled(member.Enabled)), member.Address, 3)
+		printWidth(PURPLE, enabledText(member.Enabled), 2)
+		fmt.Printf("%s%d%s\n", color(BLUE), member.Connections, color(NONE))
+	}
+	return nil
+}
+
+func enableUpstream(c *cli.Context, t *toxiproxy.Client) error {
+	return setUpstreamEnabled(c, t, true)
+}
+
+func disableUpstream(c *cli.Context, t *toxiproxy.Client) error {
+	return setUpstreamEnabled(c, t, false)
+}
+
+func setUpstreamEnabled(c *cli.Context, t *toxiproxy.Client, enabled bool) error {
+	proxyName := c.Args().Get(0)
+	address := c.Args().Get(1)
+	if proxyName == "" || address == "" {
+		cli.ShowSubcommandHelp(c)
+		return errorf("Proxy name and upstream address are required arguments.\n")
+	}
+
+	proxy, err := t.Proxy(proxyName)
+	if err != nil {
+		return errorf("Failed to retrieve proxy %s: %s\n", proxyName, err.Error())
+	}
+
+	if enabled {
+		_, err = proxy.EnableUpstream(address)
+	} else {
+		_, err = proxy.DisableUpstream(address)
+	}
+	if err != nil {
+		return errorf("Failed to update upstream %s: %s\n", address, err.Error())
+	}
+
+	fmt.Printf(
+		"Upstream %s%s%s of proxy %s is now %s%s%s\n",
+		colorEnabled(enabled),
+		address,
+		color(NONE),
+		proxyName,
+		colorEnabled(enabled),
+		enabledText(enabled),
+		color(NONE),
+	)
+	return nil
+}
+
+func listConnections(c *cli.Context, t *toxiproxy.Client) error {
+	proxyName := c.Args().First()
+	if proxyName == "" {
+		cli.ShowSubcommandHelp(c)
+		return errorf("Proxy name is required as the first argument.\n")
+	}
+
+	proxy, err := t.Proxy(proxyName)
+	if err != nil {
+		return errorf("Failed to retrieve proxy %s: %s\n", proxyName, err.Error())
+	}
+
+	connections, err := proxy.Connections()
+	if err != nil {
+		return errorf("Failed to retrieve connections of %s: %s\n", proxyName, err.Error())
+	}
+
+	if isTTY {
+		fmt.Printf(
+			"%sID\t%sClient\t\t\t%sUpstream\t\t%sBytes up/down\t%sToxics\n%s",
+			color(NONE),
+			color(YELLOW),
+			color(YELLOW),
+			color(BLUE),
+			color(PURPLE),
+			color(NONE),
+		)
+		fmt.Printf(
+			"%s======================================================================\n",
+			color(NONE),
+		)
+	}
+
+	for _, conn := range connections {
+		printWidth(NONE, conn.ID, 1)
+		printWidth(YELLOW, conn.Client, 3)
+		printWidth(YELLOW, conn.Upstream, 2)
+		printWidth(BLUE, fmt.Sprintf("%d/%d", conn.BytesUpstream, conn.BytesDownstream), 2)
+		toxics := append(conn.Toxics["upstream"], conn.Toxics["downstream"]...)
+		fmt.Printf("%s%s%s\n", color(PURPLE), strings.Join(toxics, ","), color(NONE))
+	}
+	return nil
+}
+
+func killConnection(c *cli.Context, t *toxiproxy.Client) error {
+	proxyName := c.Args().Get(0)
+	id := c.Args().Get(1)
+	if proxyName == "" || id == "" {
+		cli.ShowSubcommandHelp(c)
+		return errorf("Proxy name and connection id are required arguments.\n")
+	}
+
+	proxy, err := t.Proxy(proxyName)
+	if err != nil {
+		return errorf("Failed to retrieve proxy %s: %s\n", proxyName, err.Error())
+	}
+
+	err = proxy.CloseConnection(id, c.Bool("reset"))
+	if err != nil {
+		return errorf("Failed to close connection %s: %s\n", id, err.Error())
+	}
+
+	fmt.Printf("Closed connection %s%s%s of proxy %s\n", color(GREEN), id, color(NONE), proxyName)
+	return nil
+}
+
 func deleteProxy(c *cli.Context, t *toxiproxy.Client) error {
 	proxyName := c.Args().First()
 	if proxyName == "" {
@@ -499,6 +976,41 @@ func updateToxic(c *cli.Context, t *toxiproxy.Client) error {
 	return nil
 }
 
+func rampToxic(c *cli.Context, t *toxiproxy.Client) error {
+	toxicParams, err := parseToxicCommonParams(c)
+	if err != nil {
+		return err
+	}
+
+	proxy, err := t.Proxy(toxicParams.ProxyName)
+	if err != nil {
+		return errorf("Failed to retrieve proxy %s: %s\n", toxicParams.ProxyName, err.Error())
+	}
+
+	toxic, err := proxy.RampToxic(toxicParams.ToxicName, toxiproxy.Ramp{
+		Attributes: parseAttributes(c, "attribute"),
+		Duration:   c.Duration("duration").Milliseconds(),
+		Interval:   c.Duration("interval").Milliseconds(),
+		Curve:      c.String("curve"),
+	})
+	if err != nil {
+		return errorf("Failed to ramp toxic: %v\n", err)
+	}
+
+	if toxic.Ramp == nil || toxic.Ramp.EndsAt == nil {
+		// The ramp was so short it already ended.
+		fmt.Printf("Ramped toxic '%s' on proxy '%s'\n", toxic.Name, toxicParams.ProxyName)
+		return nil
+	}
+	fmt.Printf(
+		"Ramping toxic '%s' on proxy '%s' until %s\n",
+		toxic.Name,
+		toxicParams.ProxyName,
+		toxic.Ramp.EndsAt.Format(time.RFC3339),
+	)
+	return nil
+}
+
 func removeToxic(c *cli.Context, t *toxiproxy.Client) error {
 	toxicParams, err := parseToxicCommonParams(c)
 	if err != nil {
@@ -540,6 +1052,7 @@ func parseUpdateToxicParams(c *cli.Context) (*toxiproxy.ToxicOptions, error) {
 		return nil, err
 	}
 
+	result.Sampling = c.String("sampling")
 	result.Attributes = parseAttributes(c, "attribute")
 
 	return result, nil
@@ -573,11 +1086,53 @@ func parseAddToxicParams(c *cli.Context) (*toxiproxy.ToxicOptions, error) {
 		return nil, err
 	}
 
+	result.Sampling = c.String("sampling")
+	if c.IsSet("seed") {
+		seed := c.Int64("seed")
+		result.Seed = &seed
+	}
+	result.StartAfter = c.Duration("start-after").Milliseconds()
+	result.Duration = c.Duration("duration").Milliseconds()
+	if c.IsSet("expires-at") {
+		expiresAt, err := time.Parse(time.RFC3339, c.String("expires-at"))
+		if err != nil {
+			return nil, errorf("expires-at should be an RFC 3339 time: %v\n", err)
+		}
+		result.ExpiresAt = &expiresAt
+	}
+	if c.IsSet("flap") {
+		result.Flap, err = parseFlap(c.String("flap"), c.Bool("flap-random"))
+		if err != nil {
+			return nil, err
+		}
+	}
+	result.Match = parseMatch(c)
 	result.Attributes = parseAttributes(c, "attribute")
 
 	return result, nil
 }
 
+// parseFlap parses the on and off times of a flap, like 5s/25s.
+func parseFlap(raw string, random bool) (*toxiproxy.Flap, error) {
+	times := strings.SplitN(raw, "/", 2)
+	if len(times) < 2 {
+		return nil, errorf("flap should be on and off times, like 5s/25s\n")
+	}
+	on, err := time.ParseDuration(times[0])
+	if err != nil {
+		return nil, errorf("flap on time is invalid: %v\n", err)
+	}
+	off, err := time.ParseDuration(times[1])
+	if err != nil {
+		return nil, errorf("flap off time is invalid: %v\n", err)
+	}
+	return &toxiproxy.Flap{
+		On:     on.Milliseconds(),
+		Off:    off.Milliseconds(),
+		Random: random,
+	}, nil
+}
+
 func parseAttributes(c *cli.Context, name string) toxiproxy.Attributes {
 	parsed := map[string]interface{}{}
 	args := c.StringSlice(name)
@@ -587,8 +1142,12 @@ func parseAttributes(c *cli.Context, name string) toxiproxy.Attributes {
 		if len(kv) < 2 {
 			continue
 		}
+		var object interface{}
 		if float, err := strconv.ParseFloat(kv[1], 64); err == nil {
 			parsed[kv[0]] = float
+		} else if strings.IndexAny(kv[1], "{[") == 0 &&
+			json.Unmarshal([]byte(kv[1]), &object) == nil {
+			parsed[kv[0]] = object // Maps and lists, like headers, are given as JSON
 		} else {
 			parsed[kv[0]] = kv[1]
 		}
@@ -596,6 +1155,22 @@ func parseAttributes(c *cli.Context, name string) toxiproxy.Attributes {
 	return parsed
 }
 
+// parseMatch parses the fields of a match like attributes, except that they are
+// never numbers.
+func parseMatch(c *cli.Context) toxiproxy.Attributes {
+	match := parseAttributes(c, "match")
+	if len(match) == 0 {
+		return nil
+	}
+	for _, raw := range c.StringSlice("match") {
+		kv := strings.SplitN(raw, "=", 2)
+		if _, ok := match[kv[0]].(float64); ok {
+			match[kv[0]] = kv[1]
+		}
+	}
+	return match
+}
+
 func colorEnabled(enabled bool) string {
 	if enabled {
 		return color(GREEN)
@@ -648,6 +1223,38 @@ func listToxics(toxics toxiproxy.Toxics, stream string) {
 		fmt.Printf("type=%s\t", t.Type)
 		fmt.Printf("stream=%s\t", t.Stream)
 		fmt.Printf("toxicity=%.2f\t", t.Toxicity)
+		if t.Sampling != "" && t.Sampling != "restart" {
+			fmt.Printf("sampling=%s\t", t.Sampling)
+		}
+		if t.Seed != nil {
+			fmt.Printf("seed=%d\t", *t.Seed)
+		}
+		if t.StartsIn > 0 {
+			fmt.Printf("starts_in=%v\t", time.Duration(t.StartsIn)*time.Millisecond)
+		}
+		if t.ExpiresIn > 0 {
+			fmt.Printf("expires_in=%v\t", time.Duration(t.ExpiresIn)*time.Millisecond)
+		}
+		if t.Flap != nil {
+			fmt.Printf(
+				"flap=%v/%v\t",
+				time.Duration(t.Flap.On)*time.Millisecond,
+				time.Duration(t.Flap.Off)*time.Millisecond,
+			)
+			if t.FlappedOff {
+				fmt.Printf("flapped_off\t")
+			}
+		}
+		if t.Match != nil {
+			var fields []string
+			for _, a := range sortedAttributes(t.Match) {
+				fields = append(fields, fmt.Sprintf("%s=%v", a.key, a.value))
+			}
+			fmt.Printf("match=[%s]\t", strings.Join(fields, " "))
+		}
+		if t.Ramp != nil && t.Ramp.EndsAt != nil {
+			fmt.Printf("ramping_until=%s\t", t.Ramp.EndsAt.Format(time.RFC3339))
+		}
 		fmt.Printf("attributes=[")
 		sorted := sortedAttributes(t.Attributes)
 		for _, a := range sorted {
@@ -3,7 +3,6 @@ package main
 import (
 	"flag"
 	"fmt"
-	"math/rand"
 	"net"
 	"os"
 	"os/signal"
@@ -67,18 +66,18 @@ func run() error {
 		return nil
 	}
 
-	rand.New(rand.NewSource(cli.seed)) // #nosec G404 -- ignoring this rule
-
 	logger := setupLogger()
 	log.Logger = logger
 
 	logger.
 		Info().
 		Str("version", toxiproxy.Version).
+		Int64("seed", cli.seed).
 		Msg("Starting Toxiproxy")
 
 	metrics := toxiproxy.NewMetricsContainer(prometheus.NewRegistry())
 	server := toxiproxy.NewServer(metrics, logger)
+	server.Seed = cli.seed
 	if cli.proxyMetrics {
 		server.Metrics.ProxyMetrics = collectors.NewProxyMetricCollectors()
 	}
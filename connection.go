@@ -0,0 +1,353 @@
+package toxiproxy
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Shopify/toxiproxy/v2/stream"
+	"github.com/Shopify/toxiproxy/v2/toxics"
+)
+
+// Connection describes a client connected through a proxy to its upstream.
+// Bytes are counted as they are read from each side, before toxics apply.
+type Connection struct {
+	ID              string              `json:"id"`
+	Client          string              `json:"client"`
+	Upstream        string              `json:"upstream"`
+	StartedAt       time.Time           `json:"started_at"`
+	BytesUpstream   int64               `json:"bytes_upstream"`
+	BytesDownstream int64               `json:"bytes_downstream"`
+	Toxics          map[string][]string `json:"toxics"`
+}
+
+// connection is the record of a client and upstream pair. It is stored in the
+// ConnectionList under the names of both of its links.
+type connection struct {
+	id       string
+	name     string
+	client   net.Conn
+	upstream net.Conn
+	started  time.Time
+}
+
+// close closes both sides of the connection, with a TCP RST if reset is true.
+func (c *connection) close(reset bool) {
+	if reset {
+		setLinger(c.client, 0)   // #nosec G104 -- not every connection supports it
+		setLinger(c.upstream, 0) // #nosec G104
+	}
+	c.client.Close()
+	c.upstream.Close()
+}
+
+// nextConnectionID numbers the connections of the proxy in the order their
+// clients were accepted.
+func (proxy *Proxy) nextConnectionID() string {
+	return strconv.FormatUint(atomic.AddUint64(&proxy.connectionIDs, 1), 10)
+}
+
+// startConnection registers a client and its upstream, and starts the links
+// between them.
+func (proxy *Proxy) startConnection(id, name string, client, upstream net.Conn) {
+	conn := &connection{
+		id:       id,
+		name:     name,
+		client:   client,
+		upstream: upstream,
+		started:  time.Now(),
+	}
+
+	proxy.connections.Lock()
+	proxy.connections.list[name+"upstream"] = conn
+	proxy.connections.list[name+"downstream"] = conn
+	proxy.connections.Unlock()
+	proxy.Toxics.StartLink(proxy.apiServer, name+"upstream", client, upstream, stream.Upstream)
+	proxy.Toxics.StartLink(proxy.apiServer, name+"downstream", upstream, client, stream.Downstream)
+
+	// The toxics of both links set their framers and observers before either
+	// side is written to.
+	for _, side := range []net.Conn{client, upstream} {
+		if c, ok := side.(*replyConn); ok {
+			c.start()
+		}
+	}
+}
+
+// Connections returns the connections open through the proxy, oldest first.
+func (proxy *Proxy) Connections() []Connection {
+	proxy.connections.Lock()
+	conns := make([]*connection, 0, len(proxy.connections.list)/2)
+	seen := make(map[*connection]bool, len(proxy.connections.list)/2)
+	for _, conn := range proxy.connections.list {
+		if !seen[conn] {
+			seen[conn] = true
+			conns = append(conns, conn)
+		}
+	}
+	proxy.connections.Unlock()
+
+	sort.Slice(conns, func(i, j int) bool {
+		return conns[i].started.Before(conns[j].started)
+	})
+
+	result := make([]Connection, len(conns))
+	for i, conn := range conns {
+		bytesUp, toxicsUp := proxy.Toxics.linkStats(conn.name + "upstream")
+		bytesDown, toxicsDown := proxy.Toxics.linkStats(conn.name + "downstream")
+		result[i] = Connection{
+			ID:              conn.id,
+			Client:          conn.name,
+			Upstream:        conn.upstream.RemoteAddr().String(),
+			StartedAt:       conn.started,
+			BytesUpstream:   bytesUp,
+			BytesDownstream: bytesDown,
+			Toxics: map[string][]string{
+				"upstream":   toxicsUp,
+				"downstream": toxicsDown,
+			},
+		}
+	}
+	return result
+}
+
+// CloseConnection closes the connection with the given id, with a TCP RST if
+// reset is true.
+func (proxy *Proxy) CloseConnection(id string, reset bool) error {
+	var found *connection
+	proxy.connections.Lock()
+	for _, conn := range proxy.connections.list {
+		if conn.id == id {
+			found = conn
+			break
+		}
+	}
+	proxy.connections.Unlock()
+
+	if found == nil {
+		return ErrConnectionNotFound
+	}
+
+	proxy.Logger.
+		Info().
+		Str("client", found.name).
+		Bool("reset", reset).
+		Msg("Closing connection")
+	found.close(reset)
+	return nil
+}
+
+// connectionID returns the id of the connection a link belongs to.
+func (proxy *Proxy) connectionID(link string) string {
+	proxy.connections.Lock()
+	defer proxy.connections.Unlock()
+
+	if conn, ok := proxy.connections.list[link]; ok {
+		return conn.id
+	}
+	return ""
+}
+
+func (proxy *Proxy) RemoveConnection(name string) {
+	proxy.connections.Lock()
+	defer proxy.connections.Unlock()
+	delete(proxy.connections.list, name)
+}
+
+// replyConn is a side of a connection. It is written to by the link towards it,
+// and by the toxics of the other link replying to it. Once a toxic sets a
+// framer, replies wait for the end of the message the link is writing.
+type replyConn struct {
+	net.Conn
+	ready     chan struct{} // Closed once the links of the connection started
+	mu        sync.Mutex
+	framer    toxics.Framer
+	observers []io.Writer // Get a copy of the data the link writes
+	written   bool
+	pending   []byte   // Replies waiting for the end of a message
+	queue     []queued // Replies waiting for their turn, in order
+	released  bool     // Replies don't wait for their turn anymore
+}
+
+// queued is a reply waiting for the framer to reach its position.
+type queued struct {
+	data     []byte
+	position int
+}
+
+func newReplyConn(conn net.Conn) *replyConn {
+	return &replyConn{Conn: conn, ready: make(chan struct{})}
+}
+
+// start lets the links write to the side.
+func (c *replyConn) start() {
+	if c.ready != nil {
+		close(c.ready)
+	}
+}
+
+func (c *replyConn) Write(p []byte) (int, error) {
+	if c.ready != nil {
+		<-c.ready
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, observer := range c.observers {
+		observer.Write(p) // #nosec G104 -- observers only follow the data
+	}
+	c.written = c.written || len(p) > 0
+	if c.framer == nil {
+		return c.Conn.Write(p)
+	}
+
+	written := 0
+	for {
+		if c.framer.Boundary() {
+			err := c.flush()
+			if err != nil {
+				return written, err
+			}
+		}
+		if written == len(p) {
+			return written, nil
+		}
+
+		// The framer can stop before a message, to place replies before it.
+		n := c.framer.Next(p[written:])
+		if n == 0 {
+			continue
+		}
+		n, err := c.Conn.Write(p[written : written+n])
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+}
+
+// flush writes the replies waiting for the end of a message, and the ones whose
+// turn came. It is called between messages.
+func (c *replyConn) flush() error {
+	data := c.pending
+	c.pending = nil
+	if seq, ok := c.framer.(toxics.Sequencer); ok {
+		position := seq.Position()
+		for len(c.queue) > 0 && c.queue[0].position <= position {
+			data = append(data, c.queue[0].data...)
+			c.queue = c.queue[1:]
+		}
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	_, err := c.Conn.Write(data)
+	return err
+}
+
+// NetConn returns the underlying connection, see setLinger.
+func (c *replyConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+// replies returns the writer of the replies to this side.
+func (c *replyConn) replies() io.Writer {
+	return &replyWriter{c}
+}
+
+// replyWriter writes replies to a side of a connection, see replyConn.
+type replyWriter struct {
+	conn *replyConn
+}
+
+func (w *replyWriter) Write(p []byte) (int, error) {
+	w.conn.mu.Lock()
+	defer w.conn.mu.Unlock()
+
+	return w.write(p)
+}
+
+// write writes a reply once the message being sent ends.
+func (w *replyWriter) write(p []byte) (int, error) {
+	if w.conn.framer != nil && !w.conn.framer.Boundary() {
+		w.conn.pending = append(w.conn.pending, p...)
+		return len(p), nil
+	}
+	return w.conn.Conn.Write(p)
+}
+
+func (w *replyWriter) WriteAt(p []byte, position int) {
+	w.conn.mu.Lock()
+	defer w.conn.mu.Unlock()
+
+	if _, ok := w.conn.framer.(toxics.Sequencer); !ok || w.conn.released {
+		w.write(p) // #nosec G104 -- replies to a closed connection are dropped
+		return
+	}
+	i := len(w.conn.queue)
+	for i > 0 && w.conn.queue[i-1].position > position {
+		i--
+	}
+	w.conn.queue = slices.Insert(w.conn.queue, i, queued{bytes.Clone(p), position})
+	if w.conn.framer.Boundary() {
+		w.conn.flush() // #nosec G104
+	}
+}
+
+func (w *replyWriter) Release() {
+	w.conn.mu.Lock()
+	defer w.conn.mu.Unlock()
+
+	w.conn.released = true
+	for _, reply := range w.conn.queue {
+		w.conn.pending = append(w.conn.pending, reply.data...)
+	}
+	w.conn.queue = nil
+	if w.conn.framer != nil && w.conn.framer.Boundary() {
+		w.conn.flush() // #nosec G104
+	}
+}
+
+func (w *replyWriter) SetFramer(framer toxics.Framer) bool {
+	w.conn.mu.Lock()
+	defer w.conn.mu.Unlock()
+
+	if w.conn.framer != nil {
+		return true
+	} else if w.conn.written {
+		return false
+	}
+	w.conn.framer = framer
+	return true
+}
+
+// observe adds an observer of the data written to the side. It returns false
+// once data was written, as the observer would miss the start of it.
+func (w *replyWriter) observe(observer io.Writer) bool {
+	w.conn.mu.Lock()
+	defer w.conn.mu.Unlock()
+
+	if w.conn.written {
+		return false
+	}
+	w.conn.observers = append(w.conn.observers, observer)
+	return true
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	io.Writer
+	count *atomic.Int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	w.count.Add(int64(n))
+	return n, err
+}
@@ -0,0 +1,165 @@
+package toxiproxy_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/Shopify/toxiproxy/v2"
+)
+
+// WithEchoServer runs a TCP server that echoes everything it reads back to
+// the client.
+func WithEchoServer(t *testing.T, f func(addr string)) {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal("Failed to create TCP server", err)
+	}
+	defer ln.Close()
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	f(ln.Addr().String())
+}
+
+// AssertConnections waits for the proxy to list the given number of
+// connections, and returns them.
+func AssertConnections(t *testing.T, proxy *toxiproxy.Proxy, n int) []toxiproxy.Connection {
+	deadline := time.Now().Add(time.Second)
+	for {
+		conns := proxy.Connections()
+		if len(conns) == n || time.Now().After(deadline) {
+			if len(conns) != n {
+				t.Fatalf("Expected %d connections, got %d", n, len(conns))
+			}
+			return conns
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestConnectionsAreListed(t *testing.T) {
+	WithEchoServer(t, func(upstream string) {
+		proxy := NewTestProxy("test", upstream)
+		err := proxy.Start()
+		if err != nil {
+			t.Fatal("Proxy failed to start", err)
+		}
+		defer proxy.Stop()
+
+		_, err = proxy.Toxics.AddToxicJson(bytes.NewReader(
+			[]byte(`{"name": "lag", "type": "latency", "stream": "upstream"}`),
+		))
+		if err != nil {
+			t.Fatal("Failed to add toxic", err)
+		}
+
+		conn := AssertProxyUp(t, proxy.Listen, true)
+		defer conn.Close()
+
+		_, err = conn.Write([]byte("ping"))
+		if err != nil {
+			t.Fatal("Failed to write to proxy", err)
+		}
+		_, err = io.ReadFull(conn, make([]byte, 4))
+		if err != nil {
+			t.Fatal("Failed to read echo", err)
+		}
+		// The downstream byte count is updated right after the echo is handed
+		// to the toxics.
+		time.Sleep(10 * time.Millisecond)
+
+		conns := AssertConnections(t, proxy, 1)
+		if conns[0].Client != conn.LocalAddr().String() {
+			t.Errorf("Expected client %s, got %s", conn.LocalAddr(), conns[0].Client)
+		}
+		if conns[0].Upstream != upstream {
+			t.Errorf("Expected upstream %s, got %s", upstream, conns[0].Upstream)
+		}
+		if conns[0].BytesUpstream != 4 || conns[0].BytesDownstream != 4 {
+			t.Errorf("Expected 4 bytes each way, got %d/%d",
+				conns[0].BytesUpstream, conns[0].BytesDownstream)
+		}
+		up, down := conns[0].Toxics["upstream"], conns[0].Toxics["downstream"]
+		if len(up) != 1 || up[0] != "lag" || len(down) != 0 {
+			t.Errorf("Expected only the lag toxic to be active, got %v", conns[0].Toxics)
+		}
+
+		conn.Close()
+		AssertConnections(t, proxy, 0)
+	})
+}
+
+func TestCloseConnection(t *testing.T) {
+	WithEchoServer(t, func(upstream string) {
+		proxy := NewTestProxy("test", upstream)
+		err := proxy.Start()
+		if err != nil {
+			t.Fatal("Proxy failed to start", err)
+		}
+		defer proxy.Stop()
+
+		for _, reset := range []bool{false, true} {
+			conn := AssertProxyUp(t, proxy.Listen, true)
+			defer conn.Close()
+
+			conns := AssertConnections(t, proxy, 1)
+			err = proxy.CloseConnection(conns[0].ID, reset)
+			if err != nil {
+				t.Fatal("Failed to close connection", err)
+			}
+
+			expected := io.EOF
+			if reset {
+				expected = syscall.ECONNRESET
+			}
+			conn.SetReadDeadline(time.Now().Add(time.Second))
+			_, err = conn.Read(make([]byte, 1))
+			if !errors.Is(err, expected) {
+				t.Errorf("Expected connection to close with %v, got %v", expected, err)
+			}
+			AssertConnections(t, proxy, 0)
+		}
+
+		err = proxy.CloseConnection("unknown", false)
+		if err != toxiproxy.ErrConnectionNotFound {
+			t.Error("Expected connection not found error, got", err)
+		}
+	})
+}
+
+func TestConnectionsClosedOnStop(t *testing.T) {
+	WithEchoServer(t, func(upstream string) {
+		proxy := NewTestProxy("test", upstream)
+		err := proxy.Start()
+		if err != nil {
+			t.Fatal("Proxy failed to start", err)
+		}
+
+		conn := AssertProxyUp(t, proxy.Listen, true)
+		defer conn.Close()
+		AssertConnections(t, proxy, 1)
+
+		proxy.Stop()
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		_, err = conn.Read(make([]byte, 1))
+		if err != io.EOF {
+			t.Error("Expected connection to close when the proxy stops, got", err)
+		}
+	})
+}
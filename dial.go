@@ -0,0 +1,172 @@
+package toxiproxy
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// What clients see when the upstream of their connection can't be dialed.
+const (
+	DialFailureClose = "close"
+	DialFailureReset = "reset"
+	DialFailureHang  = "hang"
+)
+
+// DialOptions configures how a TCP proxy connects to its upstream for every
+// accepted client. Timeouts and delays are in milliseconds.
+type DialOptions struct {
+	DialTimeout    int64  `json:"dial_timeout"`
+	DialRetries    int    `json:"dial_retries"`
+	DialRetryDelay int64  `json:"dial_retry_delay"`
+	DialFailure    string `json:"dial_failure"`
+}
+
+// normalize validates the dial options and fills in their defaults.
+func (options *DialOptions) normalize() error {
+	if options.DialTimeout < 0 || options.DialRetries < 0 || options.DialRetryDelay < 0 {
+		return ErrInvalidDialOptions
+	}
+
+	switch strings.ToLower(options.DialFailure) {
+	case "", DialFailureClose:
+		options.DialFailure = DialFailureClose
+	case DialFailureReset, DialFailureHang:
+		options.DialFailure = strings.ToLower(options.DialFailure)
+	default:
+		return ErrInvalidDialFailure
+	}
+	return nil
+}
+
+// handle runs the connection toxics on an accepted client, then dials its
+// upstream and links them together. It runs in its own goroutine, so a slow or
+// blackholed upstream doesn't stop the proxy from accepting other clients.
+func (proxy *Proxy) handle(
+	ctx context.Context,
+	client net.Conn,
+	pool *UpstreamPool,
+	name, id string,
+) {
+	defer proxy.dialing.Done()
+
+	client, ok := proxy.acceptClient(ctx, client, name, id)
+	if !ok {
+		return
+	}
+
+	options := proxy.dialOptions.Load()
+	upstream, err := proxy.dialUpstream(ctx, pool, options)
+	if err != nil {
+		proxy.Logger.
+			Err(err).
+			Str("client", name).
+			Str("dial_failure", options.DialFailure).
+			Msg("Unable to open connection to upstream")
+		proxy.failClient(ctx, client, options.DialFailure)
+		return
+	}
+
+	// Replies of the toxics of one link are written between the messages of the
+	// other.
+	proxy.startConnection(id, name, newReplyConn(client), newReplyConn(upstream))
+}
+
+// failClient closes a client that won't reach its upstream, either because the
+// dial failed or a connection toxic stopped it. Failure is one of the
+// DialFailure modes, which match the toxics.ConnectionAction values.
+func (proxy *Proxy) failClient(ctx context.Context, client net.Conn, failure string) {
+	switch failure {
+	case DialFailureReset:
+		err := setLinger(client, 0)
+		if err != nil {
+			proxy.Logger.Debug().Err(err).Msg("Unable to reset client, closing it instead")
+		}
+	case DialFailureHang:
+		// Keep the client open without ever answering, until it gives up or the
+		// proxy is stopped.
+		closed := make(chan struct{})
+		go func() {
+			io.Copy(io.Discard, client) // #nosec G104 -- errors mean the client is gone
+			close(closed)
+		}()
+		select {
+		case <-closed:
+		case <-ctx.Done():
+		}
+	}
+	client.Close()
+}
+
+// dialUpstream connects to an upstream of the pool, retrying with the delay
+// from the dial options when every upstream fails.
+func (proxy *Proxy) dialUpstream(
+	ctx context.Context,
+	pool *UpstreamPool,
+	options *DialOptions,
+) (net.Conn, error) {
+	delay := time.Duration(options.DialRetryDelay) * time.Millisecond
+
+	var err error
+	for retry := 0; retry <= options.DialRetries; retry++ {
+		if retry > 0 {
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+
+		var conn net.Conn
+		conn, err = proxy.dialPool(ctx, pool, options)
+		if err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
+
+// dialPool connects to an upstream picked from the pool. With the failover
+// strategy, the next upstream is tried when one can't be dialed.
+func (proxy *Proxy) dialPool(
+	ctx context.Context,
+	pool *UpstreamPool,
+	options *DialOptions,
+) (net.Conn, error) {
+	dialer := &net.Dialer{
+		Timeout: time.Duration(options.DialTimeout) * time.Millisecond,
+	}
+
+	var err error
+	attempts := pool.attempts()
+	for attempt := 0; attempt < attempts; attempt++ {
+		member := pool.pick(attempt)
+		if member == nil {
+			return nil, ErrNoUpstreams
+		}
+
+		network, address := splitAddress(proxy.Protocol, member.Address)
+		var conn net.Conn
+		conn, err = dialer.DialContext(ctx, network, address)
+		if err != nil {
+			proxy.Logger.
+				Debug().
+				Err(err).
+				Str("upstream", member.Address).
+				Msg("Unable to dial upstream")
+			continue
+		}
+
+		conn = pool.track(member, conn)
+		if proxy.upstreamTLS != nil {
+			// The handshake happens on the first read or write, so it doesn't
+			// hold up the links of the connection.
+			conn = tls.Client(conn, upstreamTLSConfig(proxy.upstreamTLS, member.Address))
+		}
+		return conn, nil
+	}
+	return nil, err
+}
@@ -0,0 +1,127 @@
+package toxiproxy_test
+
+import (
+	"errors"
+	"io"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/Shopify/toxiproxy/v2"
+)
+
+// ReserveAddress returns a local address that refuses connections.
+func ReserveAddress(t *testing.T) string {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal("Failed to reserve an address", err)
+	}
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+func TestDialFailureReset(t *testing.T) {
+	proxy := NewTestProxy("test", ReserveAddress(t))
+	proxy.DialFailure = toxiproxy.DialFailureReset
+	err := proxy.Start()
+	if err != nil {
+		t.Fatal("Proxy failed to start", err)
+	}
+	defer proxy.Stop()
+
+	conn := AssertProxyUp(t, proxy.Listen, true)
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = conn.Read(make([]byte, 1))
+	if !errors.Is(err, syscall.ECONNRESET) {
+		t.Error("Expected connection to be reset when upstream is down, got", err)
+	}
+}
+
+func TestDialFailureHang(t *testing.T) {
+	proxy := NewTestProxy("test", ReserveAddress(t))
+	proxy.DialFailure = toxiproxy.DialFailureHang
+	err := proxy.Start()
+	if err != nil {
+		t.Fatal("Proxy failed to start", err)
+	}
+
+	conn := AssertProxyUp(t, proxy.Listen, true)
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	_, err = conn.Read(make([]byte, 1))
+	if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
+		t.Error("Expected connection to hang when upstream is down, got", err)
+	}
+
+	proxy.Stop()
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = conn.Read(make([]byte, 1))
+	if err != io.EOF {
+		t.Error("Expected connection to close when the proxy stops, got", err)
+	}
+}
+
+func TestDialRetries(t *testing.T) {
+	upstream := ReserveAddress(t)
+
+	proxy := NewTestProxy("test", upstream)
+	proxy.DialRetries = 20
+	proxy.DialRetryDelay = 20
+	err := proxy.Start()
+	if err != nil {
+		t.Fatal("Proxy failed to start", err)
+	}
+	defer proxy.Stop()
+
+	conn := AssertProxyUp(t, proxy.Listen, true)
+	defer conn.Close()
+
+	// The upstream comes up while the proxy is retrying.
+	time.Sleep(100 * time.Millisecond)
+	ln, err := net.Listen("tcp", upstream)
+	if err != nil {
+		t.Fatal("Failed to start upstream", err)
+	}
+	defer ln.Close()
+	go ServeName(ln, "up")
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 2)
+	_, err = io.ReadFull(conn, buf)
+	if err != nil || string(buf) != "up" {
+		t.Errorf("Expected to reach the upstream after retrying, got %q %v", buf, err)
+	}
+}
+
+func TestDialDoesNotBlockAccept(t *testing.T) {
+	proxy := NewTestProxy("test", ReserveAddress(t))
+	proxy.DialRetries = 4
+	proxy.DialRetryDelay = 100
+	err := proxy.Start()
+	if err != nil {
+		t.Fatal("Proxy failed to start", err)
+	}
+	defer proxy.Stop()
+
+	// Each client retries for 400ms. If dialing blocked the accept loop, the
+	// second client would only be closed after 800ms.
+	start := time.Now()
+	first := AssertProxyUp(t, proxy.Listen, true)
+	defer first.Close()
+	second := AssertProxyUp(t, proxy.Listen, true)
+	defer second.Close()
+
+	second.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, err = second.Read(make([]byte, 1))
+	if err != io.EOF {
+		t.Fatal("Expected connection to close when upstream is down, got", err)
+	}
+	if elapsed := time.Since(start); elapsed > 700*time.Millisecond {
+		t.Error("Second client waited for the first client's dial:", elapsed)
+	}
+}
@@ -4,7 +4,7 @@ import (
 	"context"
 	"fmt"
 	"io"
-	"net"
+	"sync/atomic"
 	"time"
 
 	"github.com/rs/zerolog"
@@ -28,7 +28,13 @@ type ToxicLink struct {
 	input     *stream.ChanWriter
 	output    *stream.ChanReader
 	direction stream.Direction
+	reply     io.Writer // The source of the link, for toxics to reply to
+	bytes     atomic.Int64
 	Logger    *zerolog.Logger
+
+	// The seeds of the toxics on the link derive from these, see toxicRand.
+	seed       int64
+	connection string
 }
 
 func NewToxicLink(
@@ -87,31 +93,82 @@ func (link *ToxicLink) Start(
 
 	go link.read(labels, server, source)
 
+	if conn, ok := source.(*replyConn); ok {
+		link.reply = conn.replies()
+	} else if w, ok := source.(io.Writer); ok {
+		link.reply = w
+	}
+
 	for i, toxic := range link.toxics.chain[link.direction] {
 		if stateful, ok := toxic.Toxic.(toxics.StatefulToxic); ok {
 			link.stubs[i].State = stateful.NewState()
 		}
 
 		if _, ok := toxic.Toxic.(*toxics.ResetToxic); ok {
-			if err := source.(*net.TCPConn).SetLinger(0); err != nil {
-				logger.Err(err).
-					Str("toxic", toxic.Type).
-					Msg("source: Unable to setLinger(ms)")
-			}
-
-			if err := dest.(*net.TCPConn).SetLinger(0); err != nil {
-				logger.Err(err).
-					Str("toxic", toxic.Type).
-					Msg("dest: Unable to setLinger(ms)")
-			}
+			link.setLinger("source", source, toxic)
+			link.setLinger("dest", dest, toxic)
 		}
 
+		link.stubs[i].Rand = toxicRand(link.seed, toxic, link.connection)
+		link.stubs[i].Reply = link.replies(toxic, true)
+		link.stubs[i].Observer = link.observer(toxic)
+		link.stubs[i].Datagrams = link.proxy.Protocol == ProtocolUDP
 		go link.stubs[i].Run(toxic)
 	}
 
 	go link.write(labels, name, server, dest)
 }
 
+// replies returns the writer of the replies of a toxic. A framing toxic sets
+// its framer on the source, and places its replies only if it sees the data of
+// the link from the start, and the framer sees the data sent the other way from
+// the start. Otherwise its replies are written right away.
+func (link *ToxicLink) replies(toxic *toxics.ToxicWrapper, start bool) io.Writer {
+	framing, ok := toxic.Toxic.(toxics.FramingToxic)
+	if !ok {
+		return link.reply
+	}
+	w, ok := link.reply.(toxics.FramedWriter)
+	if ok && start && w.SetFramer(framing.NewFramer()) {
+		return w
+	}
+	return struct{ io.Writer }{link.reply}
+}
+
+// observer returns the observer of an observing toxic, set on the side the link
+// reads from. It is nil if that side was already written to, see
+// toxics.ObservingToxic.
+func (link *ToxicLink) observer(toxic *toxics.ToxicWrapper) io.Writer {
+	observing, ok := toxic.Toxic.(toxics.ObservingToxic)
+	if !ok {
+		return nil
+	}
+	w, ok := link.reply.(*replyWriter)
+	if !ok {
+		return nil
+	}
+	observer := observing.NewObserver()
+	if !w.observe(observer) {
+		return nil
+	}
+	return observer
+}
+
+// setLinger makes closing the connection send a TCP RST. Connections without
+// SO_LINGER, like Unix sockets, are closed gracefully instead.
+func (link *ToxicLink) setLinger(side string, conn interface{}, toxic *toxics.ToxicWrapper) {
+	err := setLinger(conn, 0)
+	if err == errLingerNotSupported {
+		link.Logger.Debug().
+			Str("toxic", toxic.Type).
+			Msgf("%s: Connection does not support RST, it will be closed gracefully", side)
+	} else if err != nil {
+		link.Logger.Err(err).
+			Str("toxic", toxic.Type).
+			Msgf("%s: Unable to setLinger(ms)", side)
+	}
+}
+
 // read copies bytes from a source to the link's input channel.
 func (link *ToxicLink) read(
 	metricLabels []string,
@@ -119,7 +176,7 @@ func (link *ToxicLink) read(
 	source io.Reader,
 ) {
 	logger := link.Logger
-	bytes, err := io.Copy(link.input, source)
+	bytes, err := io.Copy(&countingWriter{link.input, &link.bytes}, source)
 	if err != nil {
 		logger.Warn().
 			Int64("bytes", bytes).
@@ -182,6 +239,10 @@ func (link *ToxicLink) AddToxic(toxic *toxics.ToxicWrapper) {
 			link.stubs[i].State = stateful.NewState()
 		}
 
+		link.stubs[i].Rand = toxicRand(link.seed, toxic, link.connection)
+		link.stubs[i].Reply = link.replies(toxic, link.bytes.Load() == 0)
+		link.stubs[i].Observer = link.observer(toxic)
+		link.stubs[i].Datagrams = link.stubs[i-1].Datagrams
 		go link.stubs[i].Run(toxic)
 		go link.stubs[i-1].Run(link.toxics.chain[link.direction][i-1])
 	} else {
@@ -277,6 +338,18 @@ func (link *ToxicLink) RemoveToxic(ctx context.Context, toxic *toxics.ToxicWrapp
 	}
 }
 
+// activeToxics returns the names of the toxics that are applied to the data of
+// the link, as opposed to the ones skipped because of their toxicity.
+func (link *ToxicLink) activeToxics() []string {
+	names := []string{}
+	for i, toxic := range link.toxics.chain[link.direction] {
+		if i > 0 && i < len(link.stubs) && link.stubs[i].Active() {
+			names = append(names, toxic.Name)
+		}
+	}
+	return names
+}
+
 // Direction returns the direction of the link (upstream or downstream).
 func (link *ToxicLink) Direction() string {
 	return link.direction.String()
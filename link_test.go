@@ -1,10 +1,12 @@
 package toxiproxy
 
 import (
+	"bytes"
 	"context"
 	"encoding/binary"
 	"flag"
 	"io"
+	"net"
 	"os"
 	"testing"
 	"time"
@@ -323,3 +325,166 @@ func TestRemoveToxicWithBrokenConnection(t *testing.T) {
 	collection.chainRemoveToxic(ctx, toxics[0])
 	collection.chainRemoveToxic(ctx, toxics[1])
 }
+
+// lineFramer frames lines, for TestRepliesWaitForFramer.
+type lineFramer struct {
+	partial bool
+}
+
+func (f *lineFramer) Next(data []byte) int {
+	for i, b := range data {
+		if b == '\n' {
+			f.partial = false
+			return i + 1
+		}
+	}
+	f.partial = len(data) > 0 || f.partial
+	return len(data)
+}
+
+func (f *lineFramer) Boundary() bool {
+	return !f.partial
+}
+
+// bufferConn is a connection that only keeps what is written to it.
+type bufferConn struct {
+	net.Conn
+	buf bytes.Buffer
+}
+
+func (c *bufferConn) Write(p []byte) (int, error) {
+	return c.buf.Write(p)
+}
+
+func TestRepliesWaitForFramer(t *testing.T) {
+	side := &bufferConn{}
+	conn := &replyConn{Conn: side}
+	replies := conn.replies().(toxics.FramedWriter)
+	if !replies.SetFramer(new(lineFramer)) {
+		t.Fatal("Expected the framer to be set before any data")
+	}
+
+	conn.Write([]byte("first\nsec"))
+	replies.Write([]byte("reply\n"))
+	conn.Write([]byte("ond\nthird"))
+	if expected := "first\nsecond\nreply\nthird"; side.buf.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, side.buf.String())
+	}
+
+	replies.Write([]byte("late\n"))
+	conn.Write([]byte("\n"))
+	if expected := "first\nsecond\nreply\nthird\nlate\n"; side.buf.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, side.buf.String())
+	}
+
+	written := &replyConn{Conn: &bufferConn{}}
+	written.Write([]byte("data"))
+	if written.replies().(toxics.FramedWriter).SetFramer(new(lineFramer)) {
+		t.Error("Expected no framer to be set once data was written")
+	}
+}
+
+// lineSequencer numbers the lines, for TestRepliesWaitForTheirTurn.
+type lineSequencer struct {
+	lineFramer
+	lines int
+}
+
+func (f *lineSequencer) Next(data []byte) int {
+	n := f.lineFramer.Next(data)
+	if n > 0 && data[n-1] == '\n' {
+		f.lines++
+	}
+	return n
+}
+
+func (f *lineSequencer) Position() int {
+	return f.lines
+}
+
+// framingToxic places its replies between lines, for TestRepliesWaitForTheirTurn.
+type framingToxic struct {
+	toxics.NoopToxic
+}
+
+func (t *framingToxic) NewFramer() toxics.Framer {
+	return new(lineSequencer)
+}
+
+func TestRepliesWaitForTheirTurn(t *testing.T) {
+	side := &bufferConn{}
+	conn := &replyConn{Conn: side}
+	link := &ToxicLink{reply: conn.replies()}
+	toxic := &toxics.ToxicWrapper{Toxic: new(framingToxic)}
+	if _, ok := link.replies(toxic, false).(toxics.FramedWriter); ok {
+		t.Error("Expected no framed replies for a toxic added once the link carried data")
+	}
+	replies, ok := link.replies(toxic, true).(toxics.FramedWriter)
+	if !ok {
+		t.Fatal("Expected framed replies for a toxic added before any data")
+	}
+
+	replies.WriteAt([]byte("e2\n"), 2)
+	replies.WriteAt([]byte("e0\n"), 0)
+	conn.Write([]byte("r0\nr"))
+	replies.WriteAt([]byte("e1\n"), 1)
+	if expected := "e0\nr0\nr"; side.buf.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, side.buf.String())
+	}
+	conn.Write([]byte("1\nr2\n"))
+	if expected := "e0\nr0\nr1\ne1\ne2\nr2\n"; side.buf.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, side.buf.String())
+	}
+
+	// Released replies wait for the end of a message, but not for their turn.
+	replies.WriteAt([]byte("e9\n"), 9)
+	conn.Write([]byte("r"))
+	replies.Release()
+	replies.WriteAt([]byte("e8\n"), 8)
+	conn.Write([]byte("3\n"))
+	if expected := "e0\nr0\nr1\ne1\ne2\nr2\nr3\ne9\ne8\n"; side.buf.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, side.buf.String())
+	}
+}
+
+// observingToxic keeps the data sent the other way, for TestObserversSeeTheOtherWay.
+type observingToxic struct {
+	toxics.NoopToxic
+}
+
+func (t *observingToxic) NewObserver() io.Writer {
+	return new(bytes.Buffer)
+}
+
+func TestObserversSeeTheOtherWay(t *testing.T) {
+	side := &bufferConn{}
+	conn := newReplyConn(side)
+	link := &ToxicLink{reply: conn.replies()}
+	toxic := &toxics.ToxicWrapper{Toxic: new(observingToxic)}
+
+	written := make(chan struct{})
+	go func() {
+		conn.Write([]byte("data"))
+		close(written)
+	}()
+	select {
+	case <-written:
+		t.Fatal("Expected writes to wait for the links to start")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	observer := link.observer(toxic)
+	if observer == nil {
+		t.Fatal("Expected an observer before any data was written")
+	}
+	conn.start()
+	<-written
+	if observer.(*bytes.Buffer).String() != "data" || side.buf.String() != "data" {
+		t.Errorf("Expected the observer and the side to get %q, got %q and %q",
+			"data", observer, side.buf.String())
+	}
+
+	if link.observer(toxic) != nil {
+		t.Error("Expected no observer once data was written")
+	}
+}
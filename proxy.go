@@ -1,14 +1,16 @@
 package toxiproxy
 
 import (
+	"context"
+	"crypto/tls"
 	"errors"
 	"net"
+	"strings"
 	"sync"
+	"sync/atomic"
 
 	"github.com/rs/zerolog"
 	tomb "gopkg.in/tomb.v1"
-
-	"github.com/Shopify/toxiproxy/v2/stream"
 )
 
 // Proxy represents the proxy in its entirety with all its links. The main
@@ -19,13 +21,26 @@ import (
 type Proxy struct {
 	sync.Mutex
 
-	Name     string `json:"name"`
-	Listen   string `json:"listen"`
-	Upstream string `json:"upstream"`
-	Enabled  bool   `json:"enabled"`
-
-	listener net.Listener
-	started  chan error
+	Name      string    `json:"name"`
+	Listen    string    `json:"listen"`
+	Upstream  string    `json:"upstream"`
+	Upstreams []string  `json:"upstreams,omitempty"`
+	Balance   string    `json:"balance"`
+	Protocol  string    `json:"protocol"`
+	TLS       *ProxyTLS `json:"tls,omitempty"`
+	Enabled   bool      `json:"enabled"`
+	DialOptions
+
+	listener    net.Listener
+	packetConn  net.PacketConn
+	pool        *UpstreamPool
+	upstreamTLS *tls.Config
+	dialOptions atomic.Pointer[DialOptions]
+	dialing     sync.WaitGroup
+	started     chan error
+	clients     uint64
+
+	connectionIDs uint64
 
 	tomb        tomb.Tomb
 	connections ConnectionList
@@ -34,8 +49,10 @@ type Proxy struct {
 	Logger      *zerolog.Logger
 }
 
+// ConnectionList holds the open connections of a proxy by the names of their
+// links, so each connection is stored twice.
 type ConnectionList struct {
-	list map[string]net.Conn
+	list map[string]*connection
 	lock sync.Mutex
 }
 
@@ -47,7 +64,26 @@ func (c *ConnectionList) Unlock() {
 	c.lock.Unlock()
 }
 
-var ErrProxyAlreadyStarted = errors.New("Proxy already started")
+var (
+	ErrProxyAlreadyStarted = errors.New("Proxy already started")
+	ErrNoUpstreams         = errors.New("All upstreams are disabled")
+)
+
+const (
+	ProtocolTCP = "tcp"
+	ProtocolUDP = "udp"
+)
+
+// parseProtocol normalizes the protocol of a proxy, defaulting to TCP.
+func parseProtocol(protocol string) (string, error) {
+	switch strings.ToLower(protocol) {
+	case "", ProtocolTCP:
+		return ProtocolTCP, nil
+	case ProtocolUDP:
+		return ProtocolUDP, nil
+	}
+	return "", ErrInvalidProtocol
+}
 
 func NewProxy(server *ApiServer, name, listen, upstream string) *Proxy {
 	l := server.Logger.
@@ -61,15 +97,102 @@ func NewProxy(server *ApiServer, name, listen, upstream string) *Proxy {
 		Name:        name,
 		Listen:      listen,
 		Upstream:    upstream,
+		Balance:     BalanceRoundRobin,
+		Protocol:    ProtocolTCP,
+		DialOptions: DialOptions{DialFailure: DialFailureClose},
 		started:     make(chan error),
-		connections: ConnectionList{list: make(map[string]net.Conn)},
+		connections: ConnectionList{list: make(map[string]*connection)},
 		apiServer:   server,
 		Logger:      &l,
 	}
 	proxy.Toxics = NewToxicCollection(proxy)
+	proxy.syncPool()
+	options := proxy.DialOptions
+	proxy.dialOptions.Store(&options)
 	return proxy
 }
 
+// newProxyFromInput creates a proxy from the fields of a proxy decoded from an
+// API request, validating and normalizing them first.
+func newProxyFromInput(server *ApiServer, input *Proxy) (*Proxy, error) {
+	err := input.normalize()
+	if err != nil {
+		return nil, err
+	}
+
+	proxy := NewProxy(server, input.Name, input.Listen, input.Upstream)
+	proxy.Upstreams = input.Upstreams
+	proxy.Balance = input.Balance
+	proxy.Protocol = input.Protocol
+	proxy.TLS = input.TLS
+	proxy.DialOptions = input.DialOptions
+	proxy.syncPool()
+	return proxy, nil
+}
+
+// normalize validates the fields of a proxy decoded from an API request and
+// fills in their defaults.
+func (proxy *Proxy) normalize() error {
+	var err error
+	proxy.Protocol, err = parseProtocol(proxy.Protocol)
+	if err != nil {
+		return err
+	}
+	proxy.Balance, err = parseBalance(proxy.Balance)
+	if err != nil {
+		return err
+	}
+	err = proxy.DialOptions.normalize()
+	if err != nil {
+		return err
+	}
+	if len(proxy.Upstreams) > 0 {
+		// The first upstream of a pool is its primary.
+		proxy.Upstream = proxy.Upstreams[0]
+	}
+	return nil
+}
+
+// addresses returns the address of every upstream of the proxy.
+func (proxy *Proxy) addresses() []string {
+	if len(proxy.Upstreams) > 0 {
+		return proxy.Upstreams
+	}
+	return []string{proxy.Upstream}
+}
+
+// sameUpstreams reports whether the proxy has the same upstream configuration
+// as the input.
+func (proxy *Proxy) sameUpstreams(input *Proxy) bool {
+	if input.Upstream != proxy.Upstream || input.Balance != proxy.Balance ||
+		len(input.Upstreams) != len(proxy.Upstreams) {
+		return false
+	}
+	for i := range input.Upstreams {
+		if input.Upstreams[i] != proxy.Upstreams[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// syncPool replaces the upstream pool when the upstreams or balance strategy of
+// the proxy changed, so the pool keeps its state across restarts otherwise.
+func (proxy *Proxy) syncPool() {
+	if proxy.pool == nil || !proxy.pool.matches(proxy.Balance, proxy.addresses()) {
+		proxy.pool = NewUpstreamPool(proxy.Balance, proxy.addresses())
+		proxy.pool.random = proxy.poolRand()
+	}
+}
+
+// UpstreamPool returns the pool of upstreams new connections are dialed to.
+func (proxy *Proxy) UpstreamPool() *UpstreamPool {
+	proxy.Lock()
+	defer proxy.Unlock()
+
+	return proxy.pool
+}
+
 func (proxy *Proxy) Start() error {
 	proxy.Lock()
 	defer proxy.Unlock()
@@ -81,12 +204,30 @@ func (proxy *Proxy) Update(input *Proxy) error {
 	proxy.Lock()
 	defer proxy.Unlock()
 
-	if input.Listen != proxy.Listen || input.Upstream != proxy.Upstream {
+	err := input.normalize()
+	if err != nil {
+		return err
+	}
+
+	if input.Listen != proxy.Listen ||
+		!proxy.sameUpstreams(input) ||
+		input.Protocol != proxy.Protocol ||
+		!input.TLS.equal(proxy.TLS) {
 		stop(proxy)
 		proxy.Listen = input.Listen
 		proxy.Upstream = input.Upstream
+		proxy.Upstreams = append([]string(nil), input.Upstreams...)
+		proxy.Balance = input.Balance
+		proxy.Protocol = input.Protocol
+		proxy.TLS = input.TLS.clone()
+		proxy.syncPool()
 	}
 
+	// Dial options apply to new clients without restarting the proxy.
+	proxy.DialOptions = input.DialOptions
+	options := proxy.DialOptions
+	proxy.dialOptions.Store(&options)
+
 	if input.Enabled != proxy.Enabled {
 		if input.Enabled {
 			return start(proxy)
@@ -104,13 +245,11 @@ func (proxy *Proxy) Stop() {
 }
 
 func (proxy *Proxy) listen() error {
-	var err error
-	proxy.listener, err = net.Listen("tcp", proxy.Listen)
+	err := proxy.bind()
 	if err != nil {
 		proxy.started <- err
 		return err
 	}
-	proxy.Listen = proxy.listener.Addr().String()
 	proxy.started <- nil
 
 	proxy.Logger.
@@ -120,9 +259,72 @@ func (proxy *Proxy) listen() error {
 	return nil
 }
 
+// bind opens the socket of the proxy and updates Listen with the bound address.
+func (proxy *Proxy) bind() error {
+	network, address := splitAddress(proxy.Protocol, proxy.Listen)
+
+	if proxy.Protocol == ProtocolUDP {
+		if network == "unix" {
+			return ErrUnixDatagram
+		}
+		for _, upstream := range proxy.addresses() {
+			if isUnixAddress(upstream) {
+				return ErrUnixDatagram
+			}
+		}
+		if proxy.TLS != nil {
+			return ErrTLSDatagram
+		}
+
+		conn, err := net.ListenPacket(network, address)
+		if err != nil {
+			return err
+		}
+		proxy.packetConn = conn
+		proxy.Listen = conn.LocalAddr().String()
+		return nil
+	}
+
+	serverTLS, err := proxy.TLS.serverConfig()
+	if err != nil {
+		return err
+	}
+	proxy.upstreamTLS, err = proxy.TLS.upstreamConfig()
+	if err != nil {
+		return err
+	}
+
+	var listener net.Listener
+	if network == "unix" {
+		listener, err = listenUnix(address)
+	} else {
+		listener, err = net.Listen(network, address)
+	}
+	if err != nil {
+		return err
+	}
+
+	if network == "unix" {
+		proxy.Listen = UnixScheme + listener.Addr().String()
+	} else {
+		proxy.Listen = listener.Addr().String()
+	}
+
+	if serverTLS != nil {
+		listener = tls.NewListener(listener, serverTLS)
+	}
+	proxy.listener = listener
+	return nil
+}
+
 func (proxy *Proxy) close() {
-	// Unblock proxy.listener.Accept()
-	err := proxy.listener.Close()
+	// Unblock proxy.listener.Accept() or proxy.packetConn.ReadFrom()
+	var err error
+	if proxy.Protocol == ProtocolUDP {
+		err = proxy.packetConn.Close()
+	} else {
+		err = proxy.listener.Close()
+	}
 	if err != nil {
 		proxy.Logger.
 			Warn().
@@ -133,7 +335,7 @@ func (proxy *Proxy) close() {
 
 // This channel is to kill the blocking Accept() call below by closing the
 // net.Listener.
-func (proxy *Proxy) freeBlocker(acceptTomb *tomb.Tomb) {
+func (proxy *Proxy) freeBlocker(acceptTomb *tomb.Tomb, cancelDials context.CancelFunc) {
 	<-proxy.tomb.Dying()
 
 	// Notify ln.Accept() that the shutdown was safe
@@ -143,12 +345,17 @@ func (proxy *Proxy) freeBlocker(acceptTomb *tomb.Tomb) {
 
 	// Wait for the accept loop to finish processing
 	acceptTomb.Wait()
+
+	// Wait for clients still dialing their upstream, so stop() closes every
+	// connection that made it.
+	cancelDials()
+	proxy.dialing.Wait()
 	proxy.tomb.Done()
 }
 
 // server runs the Proxy server, accepting new clients and creating Links to
-// connect them to upstreams.
-func (proxy *Proxy) server() {
+// connect them to upstreams picked from the pool.
+func (proxy *Proxy) server(pool *UpstreamPool) {
 	err := proxy.listen()
 	if err != nil {
 		return
@@ -157,9 +364,17 @@ func (proxy *Proxy) server() {
 	acceptTomb := &tomb.Tomb{}
 	defer acceptTomb.Done()
 
+	// Cancelled on shutdown to abort the dials of clients in flight.
+	ctx, cancel := context.WithCancel(context.Background())
+
 	// This channel is to kill the blocking Accept() call below by closing the
 	// net.Listener.
-	go proxy.freeBlocker(acceptTomb)
+	go proxy.freeBlocker(acceptTomb, cancel)
+
+	if proxy.Protocol == ProtocolUDP {
+		proxy.serveUDP(acceptTomb, pool)
+		return
+	}
 
 	for {
 		client, err := proxy.listener.Accept()
@@ -180,37 +395,17 @@ func (proxy *Proxy) server() {
 			return
 		}
 
+		name := proxy.clientName(client)
 		proxy.Logger.
 			Info().
-			Str("client", client.RemoteAddr().String()).
+			Str("client", name).
 			Msg("Accepted client")
 
-		upstream, err := net.Dial("tcp", proxy.Upstream)
-		if err != nil {
-			proxy.Logger.
-				Err(err).
-				Str("client", client.RemoteAddr().String()).
-				Msg("Unable to open connection to upstream")
-			client.Close()
-			continue
-		}
-
-		name := client.RemoteAddr().String()
-		proxy.connections.Lock()
-		proxy.connections.list[name+"upstream"] = upstream
-		proxy.connections.list[name+"downstream"] = client
-		proxy.connections.Unlock()
-		proxy.Toxics.StartLink(proxy.apiServer, name+"upstream", client, upstream, stream.Upstream)
-		proxy.Toxics.StartLink(proxy.apiServer, name+"downstream", upstream, client, stream.Downstream)
+		proxy.dialing.Add(1)
+		go proxy.handle(ctx, client, pool, name, proxy.nextConnectionID())
 	}
 }
 
-func (proxy *Proxy) RemoveConnection(name string) {
-	proxy.connections.Lock()
-	defer proxy.connections.Unlock()
-	delete(proxy.connections.list, name)
-}
-
 // Starts a proxy, assumes the lock has already been taken.
 func start(proxy *Proxy) error {
 	if proxy.Enabled {
@@ -218,7 +413,11 @@ func start(proxy *Proxy) error {
 	}
 
 	proxy.tomb = tomb.Tomb{} // Reset tomb, from previous starts/stops
-	go proxy.server()
+	proxy.syncPool()
+	options := proxy.DialOptions
+	proxy.dialOptions.Store(&options)
+	// The server gets the pool, as stop holds the lock while waiting for it.
+	go proxy.server(proxy.pool)
 	err := <-proxy.started
 	// Only enable the proxy if it successfully started
 	proxy.Enabled = err == nil
@@ -238,7 +437,7 @@ func stop(proxy *Proxy) {
 	proxy.connections.Lock()
 	defer proxy.connections.Unlock()
 	for _, conn := range proxy.connections.list {
-		conn.Close()
+		conn.close(false)
 	}
 
 	proxy.Logger.
@@ -48,7 +48,11 @@ func (collection *ProxyCollection) AddOrReplace(proxy *Proxy, start bool) error
 	defer collection.Unlock()
 
 	if existing, exists := collection.proxies[proxy.Name]; exists {
-		if existing.Listen == proxy.Listen && existing.Upstream == proxy.Upstream {
+		if existing.Listen == proxy.Listen &&
+			existing.sameUpstreams(proxy) &&
+			existing.Protocol == proxy.Protocol &&
+			existing.DialOptions == proxy.DialOptions &&
+			existing.TLS.equal(proxy.TLS) {
 			return nil
 		}
 		existing.Stop()
@@ -87,9 +91,23 @@ func (collection *ProxyCollection) PopulateJson(
 		if len(input[i].Name) < 1 {
 			return nil, joinError(fmt.Errorf("name at proxy %d", i+1), ErrMissingField)
 		}
-		if len(input[i].Upstream) < 1 {
+		if len(input[i].Upstream) < 1 && len(input[i].Upstreams) < 1 {
 			return nil, joinError(fmt.Errorf("upstream at proxy %d", i+1), ErrMissingField)
 		}
+		protocol, err := parseProtocol(input[i].Protocol)
+		if err != nil {
+			return nil, joinError(fmt.Errorf("protocol at proxy %d", i+1), ErrInvalidProtocol)
+		}
+		input[i].Protocol = protocol
+		balance, err := parseBalance(input[i].Balance)
+		if err != nil {
+			return nil, joinError(fmt.Errorf("balance at proxy %d", i+1), ErrInvalidBalance)
+		}
+		input[i].Balance = balance
+		err = input[i].DialOptions.normalize()
+		if apiErr, ok := err.(*ApiError); ok {
+			return nil, joinError(fmt.Errorf("at proxy %d", i+1), apiErr)
+		}
 		if input[i].Enabled == nil {
 			input[i].Enabled = &t
 		}
@@ -98,8 +116,8 @@ func (collection *ProxyCollection) PopulateJson(
 	proxies := make([]*Proxy, 0, len(input))
 	tempinputmap := map[string]bool{}
 	//lol soz
-	for _,xx := range input {
-		tempinputmap[xx.Name]=true
+	for i := range input {
+		tempinputmap[input[i].Name]=true
 	}
 
 	if (len(tempinputmap) != len(collection.proxies)){
@@ -112,7 +130,10 @@ func (collection *ProxyCollection) PopulateJson(
 		}
 	}
 	for i := range input {
-		proxy := NewProxy(server, input[i].Name, input[i].Listen, input[i].Upstream)
+		proxy, err := newProxyFromInput(server, &input[i].Proxy)
+		if err != nil {
+			return proxies, err
+		}
 		err = collection.AddOrReplace(proxy, *input[i].Enabled)
 		if err != nil {
 			return proxies, err
@@ -151,6 +172,8 @@ func (collection *ProxyCollection) Remove(name string) error {
 		return err
 	}
 	proxy.Stop()
+	proxy.Toxics.StopTimers()
+	proxy.Toxics.ReleaseToxics()
 
 	delete(collection.proxies, proxy.Name)
 	return nil
@@ -162,6 +185,8 @@ func (collection *ProxyCollection) Clear() error {
 
 	for _, proxy := range collection.proxies {
 		proxy.Stop()
+		proxy.Toxics.StopTimers()
+		proxy.Toxics.ReleaseToxics()
 
 		delete(collection.proxies, proxy.Name)
 	}
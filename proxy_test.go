@@ -5,6 +5,8 @@ import (
 	"encoding/hex"
 	"io"
 	"net"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -207,3 +209,408 @@ func TestRestartFailedToStartProxy(t *testing.T) {
 		AssertProxyUp(t, proxy.Listen, false)
 	})
 }
+
+func WithUDPEchoServer(t *testing.T, f func(upstream string, clients chan net.Addr)) {
+	conn, err := net.ListenPacket("udp", "localhost:0")
+	if err != nil {
+		t.Fatal("Failed to create UDP server", err)
+	}
+	defer conn.Close()
+
+	clients := make(chan net.Addr, 16)
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			select {
+			case clients <- addr:
+			default:
+			}
+			conn.WriteTo(buf[:n], addr)
+		}
+	}()
+
+	f(conn.LocalAddr().String(), clients)
+}
+
+func TestUDPProxyEcho(t *testing.T) {
+	WithUDPEchoServer(t, func(upstream string, clients chan net.Addr) {
+		proxy := NewTestProxy("test", upstream)
+		proxy.Protocol = toxiproxy.ProtocolUDP
+		err := proxy.Start()
+		if err != nil {
+			t.Fatal("Proxy failed to start", err)
+		}
+		defer proxy.Stop()
+
+		conn, err := net.Dial("udp", proxy.Listen)
+		if err != nil {
+			t.Fatal("Unable to dial UDP proxy", err)
+		}
+		defer conn.Close()
+
+		buf := make([]byte, 65535)
+		for _, msg := range [][]byte{[]byte("hello"), []byte("world"), make([]byte, 40000)} {
+			_, err = conn.Write(msg)
+			if err != nil {
+				t.Fatal("Failed writing to UDP proxy", err)
+			}
+
+			conn.SetReadDeadline(time.Now().Add(time.Second))
+			n, err := conn.Read(buf)
+			if err != nil {
+				t.Fatal("Failed reading from UDP proxy", err)
+			}
+			if !bytes.Equal(buf[:n], msg) {
+				t.Errorf("Datagram boundaries were not preserved: got %d bytes, want %d", n, len(msg))
+			}
+		}
+	})
+}
+
+func TestUDPProxySessionExpires(t *testing.T) {
+	defer func(timeout time.Duration) {
+		toxiproxy.UDPSessionTimeout = timeout
+	}(toxiproxy.UDPSessionTimeout)
+	toxiproxy.UDPSessionTimeout = 50 * time.Millisecond
+
+	WithUDPEchoServer(t, func(upstream string, clients chan net.Addr) {
+		proxy := NewTestProxy("test", upstream)
+		proxy.Protocol = toxiproxy.ProtocolUDP
+		err := proxy.Start()
+		if err != nil {
+			t.Fatal("Proxy failed to start", err)
+		}
+		defer proxy.Stop()
+
+		conn, err := net.Dial("udp", proxy.Listen)
+		if err != nil {
+			t.Fatal("Unable to dial UDP proxy", err)
+		}
+		defer conn.Close()
+
+		ping := func() net.Addr {
+			_, err := conn.Write([]byte("ping"))
+			if err != nil {
+				t.Fatal("Failed writing to UDP proxy", err)
+			}
+
+			conn.SetReadDeadline(time.Now().Add(time.Second))
+			_, err = conn.Read(make([]byte, 1024))
+			if err != nil {
+				t.Fatal("Failed reading from UDP proxy", err)
+			}
+			return <-clients
+		}
+
+		first := ping()
+		if second := ping(); second.String() != first.String() {
+			t.Fatalf("Expected the session to be reused, got %s and %s", first, second)
+		}
+
+		// Wait for the session to expire, the next datagram starts a new one.
+		time.Sleep(200 * time.Millisecond)
+		if third := ping(); third.String() == first.String() {
+			t.Fatalf("Expected a new upstream socket after expiry, got %s again", third)
+		}
+	})
+}
+
+// StartUDPProxy starts a UDP proxy with a toxic, and dials it. Both are closed
+// when the test ends.
+func StartUDPProxy(t *testing.T, upstream, toxic string) (*toxiproxy.Proxy, net.Conn) {
+	proxy := NewTestProxy("test", upstream)
+	proxy.Protocol = toxiproxy.ProtocolUDP
+	err := proxy.Start()
+	if err != nil {
+		t.Fatal("Proxy failed to start", err)
+	}
+	t.Cleanup(proxy.Stop)
+
+	_, err = proxy.Toxics.AddToxicJson(bytes.NewReader([]byte(toxic)))
+	if err != nil {
+		t.Fatal("Failed to add toxic", err)
+	}
+
+	conn, err := net.Dial("udp", proxy.Listen)
+	if err != nil {
+		t.Fatal("Unable to dial UDP proxy", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return proxy, conn
+}
+
+func TestUDPProxyLatencyToxic(t *testing.T) {
+	WithUDPEchoServer(t, func(upstream string, clients chan net.Addr) {
+		_, conn := StartUDPProxy(t, upstream,
+			`{"type": "latency", "stream": "upstream", "attributes": {"latency": 100}}`)
+
+		start := time.Now()
+		_, err := conn.Write([]byte("hello"))
+		if err != nil {
+			t.Fatal("Failed writing to UDP proxy", err)
+		}
+
+		buf := make([]byte, 1024)
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatal("Failed reading from UDP proxy", err)
+		}
+		if string(buf[:n]) != "hello" {
+			t.Errorf("Expected the datagram to be echoed, got %q", buf[:n])
+		}
+		if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+			t.Errorf("Expected the datagram to be delayed, it took %s", elapsed)
+		}
+	})
+}
+
+func TestUDPProxyBandwidthToxicKeepsDatagrams(t *testing.T) {
+	WithUDPEchoServer(t, func(upstream string, clients chan net.Addr) {
+		// Data is sent 1000 bytes at a time at this rate, except datagrams.
+		_, conn := StartUDPProxy(t, upstream,
+			`{"type": "bandwidth", "stream": "upstream", "attributes": {"rate": 10}}`)
+
+		msg := bytes.Repeat([]byte("x"), 3000)
+		start := time.Now()
+		_, err := conn.Write(msg)
+		if err != nil {
+			t.Fatal("Failed writing to UDP proxy", err)
+		}
+
+		buf := make([]byte, 65535)
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatal("Failed reading from UDP proxy", err)
+		}
+		if !bytes.Equal(buf[:n], msg) {
+			t.Errorf("Datagram boundaries were not preserved: got %d bytes, want %d", n, len(msg))
+		}
+		if elapsed := time.Since(start); elapsed < 250*time.Millisecond {
+			t.Errorf("Expected the datagram to be sent at 10KB/s, it took %s", elapsed)
+		}
+	})
+}
+
+func TestUDPProxyTimeoutToxic(t *testing.T) {
+	WithUDPEchoServer(t, func(upstream string, clients chan net.Addr) {
+		proxy, conn := StartUDPProxy(t, upstream,
+			`{"type": "timeout", "stream": "upstream", "attributes": {"timeout": 100}}`)
+
+		_, err := conn.Write([]byte("hello"))
+		if err != nil {
+			t.Fatal("Failed writing to UDP proxy", err)
+		}
+
+		conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		n, err := conn.Read(make([]byte, 1024))
+		if err == nil {
+			t.Errorf("Expected no datagram to get through, got %d bytes", n)
+		}
+		if len(clients) != 0 {
+			t.Error("Expected the datagram to be dropped before the server")
+		}
+		// The session is closed after the timeout.
+		AssertConnections(t, proxy, 0)
+	})
+}
+
+func TestUDPProxyDNSErrorToxic(t *testing.T) {
+	WithUDPEchoServer(t, func(upstream string, clients chan net.Addr) {
+		proxy := NewTestProxy("test", upstream)
+		proxy.Protocol = toxiproxy.ProtocolUDP
+		err := proxy.Start()
+		if err != nil {
+			t.Fatal("Proxy failed to start", err)
+		}
+		defer proxy.Stop()
+
+		_, err = proxy.Toxics.AddToxicJson(bytes.NewReader([]byte(`{
+			"type": "dns_error", "stream": "upstream",
+			"attributes": {"names": ["example.com"], "rcode": "nxdomain"}
+		}`)))
+		if err != nil {
+			t.Fatal("Failed to add toxic", err)
+		}
+
+		conn, err := net.Dial("udp", proxy.Listen)
+		if err != nil {
+			t.Fatal("Unable to dial UDP proxy", err)
+		}
+		defer conn.Close()
+
+		// Queries with recursion desired for the A records of a name.
+		header := []byte{0x12, 0x34, 0x01, 0x00, 0, 1, 0, 0, 0, 0, 0, 0}
+		question := []byte("\x03www\x07example\x03com\x00\x00\x01\x00\x01")
+		other := []byte("\x07example\x03org\x00\x00\x01\x00\x01")
+
+		buf := make([]byte, 512)
+		exchange := func(query []byte) []byte {
+			_, err := conn.Write(query)
+			if err != nil {
+				t.Fatal("Failed writing to UDP proxy", err)
+			}
+			conn.SetReadDeadline(time.Now().Add(time.Second))
+			n, err := conn.Read(buf)
+			if err != nil {
+				t.Fatal("Failed reading from UDP proxy", err)
+			}
+			return buf[:n]
+		}
+
+		reply := exchange(append(header, question...))
+		expected := append([]byte{0x12, 0x34, 0x81, 0x83}, header[4:]...)
+		if !bytes.Equal(reply, append(expected, question...)) {
+			t.Errorf("Expected a NXDOMAIN response, got %q", reply)
+		}
+
+		query := append(header, other...)
+		if reply := exchange(query); !bytes.Equal(reply, query) {
+			t.Errorf("Expected the other query to reach the server, got %q", reply)
+		}
+		if len(clients) != 1 {
+			t.Errorf("Expected the server to get 1 query, got %d", len(clients))
+		}
+	})
+}
+
+func TestUnixSocketProxy(t *testing.T) {
+	dir := t.TempDir()
+	upstreamPath := filepath.Join(dir, "upstream.sock")
+	listenPath := filepath.Join(dir, "proxy.sock")
+
+	ln, err := net.Listen("unix", upstreamPath)
+	if err != nil {
+		t.Fatal("Failed to create Unix server", err)
+	}
+	defer ln.Close()
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	// A socket file left behind by a crashed process must not prevent startup.
+	stale, err := net.Listen("unix", listenPath)
+	if err != nil {
+		t.Fatal("Failed to create stale socket", err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	proxy := NewTestProxy("test", toxiproxy.UnixScheme+upstreamPath)
+	proxy.Listen = toxiproxy.UnixScheme + listenPath
+	err = proxy.Start()
+	if err != nil {
+		t.Fatal("Proxy failed to start", err)
+	}
+
+	if proxy.Listen != toxiproxy.UnixScheme+listenPath {
+		t.Errorf("Unexpected listen address: %s", proxy.Listen)
+	}
+
+	for i := 0; i < 2; i++ {
+		conn, err := net.Dial("unix", listenPath)
+		if err != nil {
+			t.Fatal("Unable to dial Unix proxy", err)
+		}
+
+		msg := []byte("hello world")
+		_, err = conn.Write(msg)
+		if err != nil {
+			t.Fatal("Failed writing to Unix proxy", err)
+		}
+
+		resp := make([]byte, len(msg))
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		_, err = io.ReadFull(conn, resp)
+		if err != nil {
+			t.Fatal("Failed reading from Unix proxy", err)
+		}
+		if !bytes.Equal(resp, msg) {
+			t.Error("Proxy didn't echo correct bytes", resp)
+		}
+		conn.Close()
+	}
+
+	proxy.Stop()
+	if _, err := os.Stat(listenPath); !os.IsNotExist(err) {
+		t.Error("Expected socket file to be removed on stop", err)
+	}
+}
+
+func TestUnixSocketResetPeerClosesGracefully(t *testing.T) {
+	dir := t.TempDir()
+	upstreamPath := filepath.Join(dir, "upstream.sock")
+
+	ln, err := net.Listen("unix", upstreamPath)
+	if err != nil {
+		t.Fatal("Failed to create Unix server", err)
+	}
+	defer ln.Close()
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(io.Discard, conn)
+	}()
+
+	proxy := NewTestProxy("test", toxiproxy.UnixScheme+upstreamPath)
+	proxy.Listen = toxiproxy.UnixScheme + filepath.Join(dir, "proxy.sock")
+	err = proxy.Start()
+	if err != nil {
+		t.Fatal("Proxy failed to start", err)
+	}
+	defer proxy.Stop()
+
+	_, err = proxy.Toxics.AddToxicJson(bytes.NewReader(
+		[]byte(`{"type": "reset_peer", "stream": "upstream"}`),
+	))
+	if err != nil {
+		t.Fatal("Failed to add toxic", err)
+	}
+
+	conn, err := net.Dial("unix", filepath.Join(dir, "proxy.sock"))
+	if err != nil {
+		t.Fatal("Unable to dial Unix proxy", err)
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("hello"))
+	if err != nil {
+		t.Fatal("Failed writing to Unix proxy", err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = conn.Read(make([]byte, 1))
+	if err != io.EOF {
+		t.Error("Expected connection to be closed gracefully, got", err)
+	}
+}
+
+func TestUDPProxyRejectsUnixSocket(t *testing.T) {
+	proxy := NewTestProxy("test", "localhost:53")
+	proxy.Protocol = toxiproxy.ProtocolUDP
+	proxy.Listen = toxiproxy.UnixScheme + filepath.Join(t.TempDir(), "proxy.sock")
+
+	err := proxy.Start()
+	if err != toxiproxy.ErrUnixDatagram {
+		t.Error("Expected unix socket to be rejected for udp proxy, got", err)
+	}
+}
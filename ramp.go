@@ -0,0 +1,79 @@
+package toxiproxy
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/Shopify/toxiproxy/v2/toxics"
+)
+
+// RampToxicJson starts changing numeric attributes of a toxic gradually, to
+// the target attributes of the ramp. A new ramp of the toxic replaces the
+// previous one, and setting its attributes ends the ramp.
+func (c *ToxicCollection) RampToxicJson(
+	name string,
+	data io.Reader,
+) (*toxics.ToxicWrapper, error) {
+	c.Lock()
+	defer c.Unlock()
+
+	toxic := c.findToxicByName(name)
+	if toxic == nil {
+		return nil, ErrToxicNotFound
+	}
+
+	ramp := new(toxics.Ramp)
+	err := json.NewDecoder(data).Decode(ramp)
+	if err != nil {
+		return nil, joinError(err, ErrBadRequestBody)
+	}
+
+	now := time.Now()
+	err = ramp.Start(toxic.Toxic, now)
+	if err != nil {
+		return nil, ErrInvalidRamp
+	}
+
+	toxic.Update(func() error { // #nosec G104 -- never fails
+		toxic.Ramp = ramp
+		return nil
+	})
+	c.setTimer(c.ramps, toxic, ramp.Next(now), c.rampToxic)
+	return toxic, nil
+}
+
+// rampToxic updates the attributes of a ramping toxic on its links. This
+// assumes the lock is already grabbed.
+func (c *ToxicCollection) rampToxic(toxic *toxics.ToxicWrapper) {
+	ramp := toxic.Ramp
+	now := time.Now()
+	progress := ramp.Progress(now)
+
+	err := toxic.Update(func() error {
+		if progress >= 1 {
+			toxic.Ramp = nil
+		}
+		data, err := ramp.Step(toxic.Toxic, progress)
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal(data, toxic.Toxic)
+	})
+	if err != nil {
+		c.proxy.Logger.
+			Warn().
+			Err(err).
+			Str("toxic", toxic.Name).
+			Msg("Failed to ramp toxic")
+		return
+	}
+
+	_, connection := toxic.Toxic.(toxics.ConnectionToxic)
+	if !connection && !c.isPending(toxic) {
+		c.chainUpdateToxic(toxic)
+	}
+	if progress < 1 {
+		c.setTimer(c.ramps, toxic, ramp.Next(now), c.rampToxic)
+	}
+}
@@ -0,0 +1,159 @@
+package toxiproxy
+
+import (
+	"context"
+	"time"
+
+	"github.com/Shopify/toxiproxy/v2/toxics"
+)
+
+// Toxics with a start_after wait in the pending list of the collection until
+// they start, and toxics with a duration or expires_at are removed when they
+// expire, so a crashed test doesn't leave a broken proxy behind. Toxics with a
+// flap are turned off and on again while they run, by restarting their stubs.
+// All of the following functions assume the lock is already grabbed.
+
+// scheduleToxic adds a toxic to the collection now, or when it starts.
+func (c *ToxicCollection) scheduleToxic(toxic *toxics.ToxicWrapper) {
+	if toxic.StartsAt != nil {
+		c.pending = append(c.pending, toxic)
+		c.setTimer(c.timers, toxic, *toxic.StartsAt, c.startToxic)
+		return
+	}
+	c.activateToxic(toxic)
+}
+
+// startToxic moves a pending toxic into the collection.
+func (c *ToxicCollection) startToxic(toxic *toxics.ToxicWrapper) {
+	c.removePending(toxic)
+	c.proxy.Logger.
+		Info().
+		Str("toxic", toxic.Name).
+		Msg("Started scheduled toxic")
+	c.activateToxic(toxic)
+}
+
+func (c *ToxicCollection) activateToxic(toxic *toxics.ToxicWrapper) {
+	if _, ok := toxic.Toxic.(toxics.ConnectionToxic); ok {
+		c.connAddToxic(toxic)
+	} else {
+		c.chainAddToxic(toxic)
+	}
+	if toxic.ExpiresAt != nil {
+		c.setTimer(c.timers, toxic, *toxic.ExpiresAt, c.expireToxic)
+	}
+	if toxic.Flap != nil {
+		c.startFlapping(toxic)
+	}
+}
+
+// startFlapping flaps a toxic that just started off and on until it is removed.
+// Random flaps derive from the seed, like the randomness of toxics.
+func (c *ToxicCollection) startFlapping(toxic *toxics.ToxicWrapper) {
+	r := toxicRand(c.proxy.seed(), toxic, c.proxy.Name+"/flap")
+
+	var flap func(*toxics.ToxicWrapper)
+	flap = func(toxic *toxics.ToxicWrapper) {
+		off := !toxic.FlappedOff()
+		toxic.SetFlappedOff(off)
+		if _, ok := toxic.Toxic.(toxics.ConnectionToxic); !ok {
+			c.chainUpdateToxic(toxic)
+		}
+		c.setTimer(c.flaps, toxic, time.Now().Add(toxic.Flap.Next(!off, r)), flap)
+	}
+
+	toxic.SetFlappedOff(false)
+	c.setTimer(c.flaps, toxic, time.Now().Add(toxic.Flap.Next(true, r)), flap)
+}
+
+func (c *ToxicCollection) expireToxic(toxic *toxics.ToxicWrapper) {
+	c.proxy.Logger.
+		Info().
+		Str("toxic", toxic.Name).
+		Msg("Removed expired toxic")
+	c.removeToxic(c.proxy.Logger.WithContext(context.Background()), toxic)
+}
+
+// removeToxic removes a toxic from the collection, whether it started or not.
+func (c *ToxicCollection) removeToxic(ctx context.Context, toxic *toxics.ToxicWrapper) {
+	c.stopTimer(c.timers, toxic)
+	c.stopTimer(c.flaps, toxic)
+	c.stopTimer(c.ramps, toxic)
+	if c.removePending(toxic) {
+		return
+	}
+	if _, ok := toxic.Toxic.(toxics.ConnectionToxic); ok {
+		c.connRemoveToxic(toxic)
+	} else {
+		c.chainRemoveToxic(ctx, toxic)
+	}
+}
+
+// isPending reports whether the toxic is waiting to start.
+func (c *ToxicCollection) isPending(toxic *toxics.ToxicWrapper) bool {
+	for _, pending := range c.pending {
+		if pending == toxic {
+			return true
+		}
+	}
+	return false
+}
+
+// removePending removes a toxic from the pending list, and reports whether it
+// was in it.
+func (c *ToxicCollection) removePending(toxic *toxics.ToxicWrapper) bool {
+	for i, pending := range c.pending {
+		if pending == toxic {
+			c.pending = append(c.pending[:i], c.pending[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// setTimer calls f with the toxic at the given time, replacing the previous
+// timer of the toxic in timers.
+func (c *ToxicCollection) setTimer(
+	timers map[*toxics.ToxicWrapper]*time.Timer,
+	toxic *toxics.ToxicWrapper,
+	at time.Time,
+	f func(*toxics.ToxicWrapper),
+) {
+	c.stopTimer(timers, toxic)
+
+	var timer *time.Timer
+	timer = time.AfterFunc(time.Until(at), func() {
+		c.Lock()
+		defer c.Unlock()
+
+		// The timer may have been replaced or stopped while waiting for the lock.
+		if timers[toxic] != timer {
+			return
+		}
+		delete(timers, toxic)
+		f(toxic)
+	})
+	timers[toxic] = timer
+}
+
+func (c *ToxicCollection) stopTimer(
+	timers map[*toxics.ToxicWrapper]*time.Timer,
+	toxic *toxics.ToxicWrapper,
+) {
+	if timer, ok := timers[toxic]; ok {
+		timer.Stop()
+		delete(timers, toxic)
+	}
+}
+
+func (c *ToxicCollection) stopTimers() {
+	for toxic := range c.timers {
+		c.stopTimer(c.timers, toxic)
+	}
+	for toxic := range c.flaps {
+		c.stopTimer(c.flaps, toxic)
+	}
+	for toxic := range c.ramps {
+		c.stopTimer(c.ramps, toxic)
+	}
+}
@@ -0,0 +1,37 @@
+package toxiproxy
+
+import (
+	"math/rand"
+
+	"github.com/Shopify/toxiproxy/v2/toxics"
+)
+
+// seed returns the seed of the server, which every random number used by the
+// proxy derives from.
+func (proxy *Proxy) seed() int64 {
+	if proxy.apiServer == nil {
+		return 0
+	}
+	return proxy.apiServer.Seed
+}
+
+// poolRand returns the random numbers the pool of the proxy balances with.
+func (proxy *Proxy) poolRand() *rand.Rand {
+	return toxics.NewRand(toxics.DeriveSeed(proxy.seed(), proxy.Name))
+}
+
+// connectionKey identifies a connection of the proxy when deriving seeds. Ids
+// follow the order clients are accepted in, so they are the same when a run
+// is replayed.
+func (proxy *Proxy) connectionKey(id string) string {
+	return proxy.Name + "/" + id
+}
+
+// toxicRand returns the random numbers of a toxic on one connection, derived
+// from the seed of the toxic if it has one, or else from the server seed.
+func toxicRand(seed int64, toxic *toxics.ToxicWrapper, connection string) *rand.Rand {
+	if toxic.Seed != nil {
+		seed = *toxic.Seed
+	}
+	return toxics.NewRand(toxics.DeriveSeed(seed, connection, toxic.Name))
+}
@@ -0,0 +1,75 @@
+package toxiproxy_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog"
+
+	"github.com/Shopify/toxiproxy/v2"
+)
+
+// ToxicPattern opens connections one at a time through a proxy of a server
+// with the given seed, and returns which of them the toxic was applied to.
+func ToxicPattern(t *testing.T, upstream string, seed int64, toxic string) string {
+	srv := toxiproxy.NewServer(
+		toxiproxy.NewMetricsContainer(prometheus.NewRegistry()),
+		zerolog.Nop(),
+	)
+	srv.Seed = seed
+	proxy := toxiproxy.NewProxy(srv, "test", "localhost:0", upstream)
+	err := proxy.Start()
+	if err != nil {
+		t.Fatal("Proxy failed to start", err)
+	}
+	defer proxy.Stop()
+
+	_, err = proxy.Toxics.AddToxicJson(bytes.NewReader([]byte(toxic)))
+	if err != nil {
+		t.Fatal("Failed to add toxic", err)
+	}
+
+	pattern := ""
+	for i := 0; i < 20; i++ {
+		conn := AssertProxyUp(t, proxy.Listen, true)
+		// Toxics roll their toxicity when they start on the link, which is done
+		// once data went through.
+		_, err = conn.Write([]byte("x"))
+		if err == nil {
+			_, err = io.ReadFull(conn, make([]byte, 1))
+		}
+		if err != nil {
+			t.Fatal("Failed to echo through the proxy", err)
+		}
+		conns := AssertConnections(t, proxy, 1)
+		pattern += fmt.Sprint(len(conns[0].Toxics["upstream"]))
+		conn.Close()
+		AssertConnections(t, proxy, 0)
+	}
+	return pattern
+}
+
+func TestServerSeedReplaysToxicity(t *testing.T) {
+	toxic := `{"type": "latency", "stream": "upstream", "toxicity": 0.5}`
+	WithEchoServer(t, func(upstream string) {
+		first := ToxicPattern(t, upstream, 42, toxic)
+		if first != ToxicPattern(t, upstream, 42, toxic) {
+			t.Error("Expected the same seed to apply the toxic to the same connections")
+		}
+		if first == ToxicPattern(t, upstream, 43, toxic) {
+			t.Error("Expected another seed to apply the toxic to other connections")
+		}
+	})
+}
+
+func TestToxicSeedReplacesServerSeed(t *testing.T) {
+	toxic := `{"type": "latency", "stream": "upstream", "toxicity": 0.5, "seed": 7}`
+	WithEchoServer(t, func(upstream string) {
+		if ToxicPattern(t, upstream, 1, toxic) != ToxicPattern(t, upstream, 2, toxic) {
+			t.Error("Expected the toxic seed to apply the toxic to the same connections")
+		}
+	})
+}
@@ -0,0 +1,111 @@
+package testhelper
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Shopify/toxiproxy/v2/stream"
+	"github.com/Shopify/toxiproxy/v2/toxics"
+)
+
+// ToxicRun runs a toxic on a stub like a link does, for the tests of toxics.
+type ToxicRun struct {
+	Match     string // The fields of the match of the toxic, if it has one
+	Observed  string // The data sent the other way, for observing toxics
+	Size      int    // The size the chunks are split in, if it is set
+	Interrupt bool   // Interrupt and run the toxic again between chunks
+}
+
+// NewMatch returns the compiled match with the fields.
+func NewMatch(t testing.TB, fields string) *toxics.Match {
+	t.Helper()
+	match := new(toxics.Match)
+	err := json.Unmarshal([]byte(fields), match)
+	if err == nil {
+		err = match.Compile()
+	}
+	if err != nil {
+		t.Fatalf("Invalid match %s: %v", fields, err)
+	}
+	return match
+}
+
+// Split splits data in chunks of the size, so messages are split between
+// chunks.
+func Split(data string, size int) []string {
+	var chunks []string
+	for len(data) > 0 {
+		n := min(len(data), size)
+		chunks = append(chunks, data[:n])
+		data = data[n:]
+	}
+	return chunks
+}
+
+// Run sends the chunks through the toxic, and returns what came out and what
+// was replied.
+func (r ToxicRun) Run(t testing.TB, toxic toxics.Toxic, chunks ...string) (string, string) {
+	t.Helper()
+	wrapper := &toxics.ToxicWrapper{Toxic: toxic, Toxicity: 1}
+	if r.Match != "" {
+		wrapper.Match = NewMatch(t, r.Match)
+	}
+
+	input := make(chan *stream.StreamChunk)
+	output := make(chan *stream.StreamChunk)
+	stub := toxics.NewToxicStub(input, output)
+	if stateful, ok := toxic.(toxics.StatefulToxic); ok {
+		stub.State = stateful.NewState()
+	}
+	if observing, ok := toxic.(toxics.ObservingToxic); ok {
+		stub.Observer = observing.NewObserver()
+		stub.Observer.Write([]byte(r.Observed))
+	}
+	reply := new(bytes.Buffer)
+	stub.Reply = reply
+
+	if r.Size > 0 {
+		chunks = Split(strings.Join(chunks, ""), r.Size)
+	}
+	// The chunks left once the toxic closed the stub aren't sent.
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		go stub.Run(wrapper)
+		for i, c := range chunks {
+			if i > 0 && r.Interrupt && stub.InterruptToxic() {
+				go stub.Run(wrapper)
+			}
+			select {
+			case input <- &stream.StreamChunk{Data: []byte(c)}:
+			case <-done:
+				return
+			}
+		}
+		close(input)
+	}()
+
+	var out bytes.Buffer
+	for {
+		select {
+		case c, ok := <-output:
+			if !ok {
+				return out.String(), reply.String()
+			}
+			out.Write(c.Data)
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for the toxic to close")
+		}
+	}
+}
+
+// DropMatching sends the chunks through a toxic dropping the messages that
+// match, and returns what came out.
+func (r ToxicRun) DropMatching(t testing.TB, chunks ...string) string {
+	t.Helper()
+	out, _ := r.Run(t, &toxics.DropToxic{Probability: 1}, chunks...)
+	return out
+}
@@ -0,0 +1,31 @@
+package testhelper_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Shopify/toxiproxy/v2/testhelper"
+	"github.com/Shopify/toxiproxy/v2/toxics"
+)
+
+func TestSplit(t *testing.T) {
+	chunks := testhelper.Split("abcdefg", 3)
+	if expected := []string{"abc", "def", "g"}; !reflect.DeepEqual(chunks, expected) {
+		t.Errorf("Expected %q, got %q", expected, chunks)
+	}
+}
+
+func TestToxicRun(t *testing.T) {
+	for _, run := range []testhelper.ToxicRun{{}, {Size: 2}, {Interrupt: true}} {
+		out, reply := run.Run(t, new(toxics.NoopToxic), "hello", " ", "world")
+		if out != "hello world" || reply != "" {
+			t.Errorf("Expected the chunks to pass through, got %q and reply %q", out, reply)
+		}
+	}
+
+	run := testhelper.ToxicRun{Match: `{"protocol": "bytes", "pattern": "^drop"}`}
+	out := run.DropMatching(t, "keep", "drop", "keep")
+	if out != "keepkeep" {
+		t.Errorf("Expected the matching chunk to be dropped, got %q", out)
+	}
+}
@@ -0,0 +1,118 @@
+package toxiproxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+)
+
+// ProxyTLS configures TLS on either side of a proxy. When the listener
+// certificate is set, clients connect to the proxy over TLS. When Upstream is
+// set, the proxy connects to the upstream over TLS. Either way, toxics always
+// operate on the plaintext.
+type ProxyTLS struct {
+	CertFile string       `json:"cert_file,omitempty"`
+	KeyFile  string       `json:"key_file,omitempty"`
+	Upstream *UpstreamTLS `json:"upstream,omitempty"`
+}
+
+// UpstreamTLS configures how the proxy verifies the upstream server.
+type UpstreamTLS struct {
+	// PEM encoded CA certificates, the system pool is used when empty.
+	CAFile string `json:"ca_file,omitempty"`
+	// Server name for SNI and verification, defaults to the upstream host.
+	ServerName         string `json:"server_name,omitempty"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify,omitempty"`
+}
+
+func (t *ProxyTLS) clone() *ProxyTLS {
+	if t == nil {
+		return nil
+	}
+	result := *t
+	if t.Upstream != nil {
+		upstream := *t.Upstream
+		result.Upstream = &upstream
+	}
+	return &result
+}
+
+func (t *ProxyTLS) equal(other *ProxyTLS) bool {
+	if t == nil || other == nil {
+		return t == other
+	}
+	if t.CertFile != other.CertFile || t.KeyFile != other.KeyFile {
+		return false
+	}
+	if t.Upstream == nil || other.Upstream == nil {
+		return t.Upstream == other.Upstream
+	}
+	return *t.Upstream == *other.Upstream
+}
+
+// serverConfig returns the configuration to terminate TLS on the listener, or
+// nil if clients connect in plaintext.
+func (t *ProxyTLS) serverConfig() (*tls.Config, error) {
+	if t == nil || (t.CertFile == "" && t.KeyFile == "") {
+		return nil, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+	if err != nil {
+		return nil, joinError(err, ErrInvalidTLS)
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// upstreamConfig returns the configuration to originate TLS to the upstreams,
+// or nil if they are dialed in plaintext. The server name is filled in for
+// each upstream by upstreamTLSConfig.
+func (t *ProxyTLS) upstreamConfig() (*tls.Config, error) {
+	if t == nil || t.Upstream == nil {
+		return nil, nil
+	}
+
+	config := &tls.Config{
+		ServerName:         t.Upstream.ServerName,
+		InsecureSkipVerify: t.Upstream.InsecureSkipVerify, // #nosec G402 -- opt-in
+		MinVersion:         tls.VersionTLS12,
+	}
+
+	if t.Upstream.CAFile != "" {
+		pem, err := os.ReadFile(t.Upstream.CAFile)
+		if err != nil {
+			return nil, joinError(err, ErrInvalidTLS)
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, joinError(
+				fmt.Errorf("no certificates found in %s", t.Upstream.CAFile),
+				ErrInvalidTLS,
+			)
+		}
+	}
+
+	return config, nil
+}
+
+// upstreamTLSConfig returns the configuration to connect to the given upstream,
+// defaulting the server name to the host of its address.
+func upstreamTLSConfig(config *tls.Config, upstream string) *tls.Config {
+	if config.ServerName != "" || isUnixAddress(upstream) {
+		return config
+	}
+
+	host, _, err := net.SplitHostPort(upstream)
+	if err != nil {
+		host = upstream
+	}
+	config = config.Clone()
+	config.ServerName = host
+	return config
+}
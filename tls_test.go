@@ -0,0 +1,220 @@
+package toxiproxy_test
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Shopify/toxiproxy/v2"
+)
+
+// WriteTestCertificate writes a self-signed certificate for localhost and its
+// key to dir, returning the paths of both files.
+func WriteTestCertificate(t *testing.T, dir string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal("Failed to generate key", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal("Failed to create certificate", err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal("Failed to marshal key", err)
+	}
+
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	if err := os.WriteFile(certFile, certPem, 0o600); err != nil {
+		t.Fatal("Failed to write certificate", err)
+	}
+	if err := os.WriteFile(keyFile, keyPem, 0o600); err != nil {
+		t.Fatal("Failed to write key", err)
+	}
+	return certFile, keyFile
+}
+
+// WithTLSEchoServer runs a TLS server that echoes lines back in upper case.
+func WithTLSEchoServer(t *testing.T, certFile, keyFile string, f func(addr string)) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatal("Failed to load certificate", err)
+	}
+	ln, err := tls.Listen("tcp", "localhost:0", &tls.Config{
+		Certificates: []tls.Certificate{cert},
+	})
+	if err != nil {
+		t.Fatal("Failed to create TLS server", err)
+	}
+	defer ln.Close()
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				line, err := bufio.NewReader(conn).ReadString('\n')
+				if err == nil {
+					conn.Write([]byte(strings.ToUpper(line)))
+				}
+			}()
+		}
+	}()
+
+	f(ln.Addr().String())
+}
+
+func TestTLSTerminationAndOrigination(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := WriteTestCertificate(t, dir)
+
+	WithTLSEchoServer(t, certFile, keyFile, func(upstream string) {
+		proxy := NewTestProxy("test", upstream)
+		proxy.TLS = &toxiproxy.ProxyTLS{
+			CertFile: certFile,
+			KeyFile:  keyFile,
+			Upstream: &toxiproxy.UpstreamTLS{CAFile: certFile},
+		}
+		err := proxy.Start()
+		if err != nil {
+			t.Fatal("Proxy failed to start", err)
+		}
+		defer proxy.Stop()
+
+		pem, _ := os.ReadFile(certFile)
+		roots := x509.NewCertPool()
+		roots.AppendCertsFromPEM(pem)
+
+		conn, err := tls.Dial("tcp", proxy.Listen, &tls.Config{
+			RootCAs:    roots,
+			ServerName: "localhost",
+		})
+		if err != nil {
+			t.Fatal("Unable to dial TLS proxy", err)
+		}
+		defer conn.Close()
+
+		_, err = conn.Write([]byte("hello\n"))
+		if err != nil {
+			t.Fatal("Failed writing to TLS proxy", err)
+		}
+
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		line, err := bufio.NewReader(conn).ReadString('\n')
+		if err != nil {
+			t.Fatal("Failed reading from TLS proxy", err)
+		}
+		if line != "HELLO\n" {
+			t.Errorf("Unexpected response from upstream: %q", line)
+		}
+	})
+}
+
+func TestTLSOriginationToPlaintextClient(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := WriteTestCertificate(t, dir)
+
+	WithTLSEchoServer(t, certFile, keyFile, func(upstream string) {
+		proxy := NewTestProxy("test", upstream)
+		proxy.TLS = &toxiproxy.ProxyTLS{
+			Upstream: &toxiproxy.UpstreamTLS{CAFile: certFile},
+		}
+		err := proxy.Start()
+		if err != nil {
+			t.Fatal("Proxy failed to start", err)
+		}
+		defer proxy.Stop()
+
+		conn := AssertProxyUp(t, proxy.Listen, true)
+		defer conn.Close()
+
+		_, err = conn.Write([]byte("plaintext\n"))
+		if err != nil {
+			t.Fatal("Failed writing to proxy", err)
+		}
+
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		line, err := bufio.NewReader(conn).ReadString('\n')
+		if err != nil {
+			t.Fatal("Failed reading from proxy", err)
+		}
+		if line != "PLAINTEXT\n" {
+			t.Errorf("Unexpected response from upstream: %q", line)
+		}
+	})
+}
+
+func TestTLSUpstreamVerificationFails(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := WriteTestCertificate(t, dir)
+
+	WithTLSEchoServer(t, certFile, keyFile, func(upstream string) {
+		proxy := NewTestProxy("test", upstream)
+		proxy.TLS = &toxiproxy.ProxyTLS{
+			Upstream: &toxiproxy.UpstreamTLS{}, // The test CA is not trusted
+		}
+		err := proxy.Start()
+		if err != nil {
+			t.Fatal("Proxy failed to start", err)
+		}
+		defer proxy.Stop()
+
+		conn := AssertProxyUp(t, proxy.Listen, true)
+		defer conn.Close()
+
+		conn.Write([]byte("hello\n"))
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		_, err = bufio.NewReader(conn).ReadString('\n')
+		if err == nil {
+			t.Error("Expected the connection to close when the upstream can't be verified")
+		}
+	})
+}
+
+func TestTLSInvalidCertificate(t *testing.T) {
+	proxy := NewTestProxy("test", "localhost:20001")
+	proxy.TLS = &toxiproxy.ProxyTLS{
+		CertFile: filepath.Join(t.TempDir(), "missing.pem"),
+		KeyFile:  filepath.Join(t.TempDir(), "missing.pem"),
+	}
+
+	err := proxy.Start()
+	if err == nil {
+		proxy.Stop()
+		t.Fatal("Expected proxy with a missing certificate to fail to start")
+	}
+	if !strings.HasPrefix(err.Error(), "invalid tls configuration") {
+		t.Error("Unexpected error:", err)
+	}
+}
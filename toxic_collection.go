@@ -7,24 +7,39 @@ import (
 	"fmt"
 	"io"
 	"sync"
+	"time"
 
 	"github.com/rs/zerolog"
 
 	"github.com/Shopify/toxiproxy/v2/stream"
 	"github.com/Shopify/toxiproxy/v2/toxics"
+	// The protocol toxics and matchers register themselves.
+	_ "github.com/Shopify/toxiproxy/v2/toxics/dns"
+	_ "github.com/Shopify/toxiproxy/v2/toxics/http"
+	_ "github.com/Shopify/toxiproxy/v2/toxics/http2"
+	_ "github.com/Shopify/toxiproxy/v2/toxics/kafka"
+	_ "github.com/Shopify/toxiproxy/v2/toxics/mysql"
+	_ "github.com/Shopify/toxiproxy/v2/toxics/postgres"
+	_ "github.com/Shopify/toxiproxy/v2/toxics/redis"
 )
 
 // ToxicCollection contains a list of toxics that are chained together. Each proxy
 // has its own collection. A hidden noop toxic is always maintained at the beginning
 // of each chain so toxics have a method of pausing incoming data (by interrupting
-// the preceding toxic).
+// the preceding toxic). Connection toxics are kept in a separate list, since they
+// run on new clients instead of on the links.
 type ToxicCollection struct {
 	sync.Mutex
 
-	noop  *toxics.ToxicWrapper
-	proxy *Proxy
-	chain [][]*toxics.ToxicWrapper
-	links map[string]*ToxicLink
+	noop    *toxics.ToxicWrapper
+	proxy   *Proxy
+	chain   [][]*toxics.ToxicWrapper
+	conns   []*toxics.ToxicWrapper
+	pending []*toxics.ToxicWrapper               // Toxics waiting for their start_after
+	timers  map[*toxics.ToxicWrapper]*time.Timer // Start and expiry of toxics
+	flaps   map[*toxics.ToxicWrapper]*time.Timer
+	ramps   map[*toxics.ToxicWrapper]*time.Timer
+	links   map[string]*ToxicLink
 }
 
 func NewToxicCollection(proxy *Proxy) *ToxicCollection {
@@ -33,9 +48,12 @@ func NewToxicCollection(proxy *Proxy) *ToxicCollection {
 			Toxic: new(toxics.NoopToxic),
 			Type:  "noop",
 		},
-		proxy: proxy,
-		chain: make([][]*toxics.ToxicWrapper, stream.NumDirections),
-		links: make(map[string]*ToxicLink),
+		proxy:  proxy,
+		chain:  make([][]*toxics.ToxicWrapper, stream.NumDirections),
+		timers: make(map[*toxics.ToxicWrapper]*time.Timer),
+		flaps:  make(map[*toxics.ToxicWrapper]*time.Timer),
+		ramps:  make(map[*toxics.ToxicWrapper]*time.Timer),
+		links:  make(map[string]*ToxicLink),
 	}
 	for dir := range collection.chain {
 		collection.chain[dir] = make([]*toxics.ToxicWrapper, 1, toxics.Count()+1)
@@ -54,6 +72,37 @@ func (c *ToxicCollection) ResetToxics(ctx context.Context) {
 			c.chainRemoveToxic(ctx, c.chain[dir][1])
 		}
 	}
+	for len(c.conns) > 0 {
+		c.connRemoveToxic(c.conns[0])
+	}
+	c.pending = nil
+	c.stopTimers()
+}
+
+// StopTimers stops starting, expiring, flapping and ramping the toxics, when the
+// proxy is removed.
+func (c *ToxicCollection) StopTimers() {
+	c.Lock()
+	defer c.Unlock()
+
+	c.stopTimers()
+}
+
+// ReleaseToxics releases what the toxics share with other proxies, when the
+// proxy is removed.
+func (c *ToxicCollection) ReleaseToxics() {
+	c.Lock()
+	defer c.Unlock()
+
+	for dir := range c.chain {
+		// Skip the first noop toxic
+		for _, toxic := range c.chain[dir][1:] {
+			releaseToxic(toxic)
+		}
+	}
+	for _, toxic := range c.conns {
+		releaseToxic(toxic)
+	}
 }
 
 func (c *ToxicCollection) GetToxic(name string) *toxics.ToxicWrapper {
@@ -77,9 +126,23 @@ func (c *ToxicCollection) GetToxicArray() []toxics.Toxic {
 			result = append(result, toxic)
 		}
 	}
+	for _, toxic := range c.conns {
+		result = append(result, toxic)
+	}
+	for _, toxic := range c.pending {
+		result = append(result, toxic)
+	}
 	return result
 }
 
+// GetConnectionToxics returns the toxics to run on new clients of the proxy.
+func (c *ToxicCollection) GetConnectionToxics() []*toxics.ToxicWrapper {
+	c.Lock()
+	defer c.Unlock()
+
+	return append([]*toxics.ToxicWrapper(nil), c.conns...)
+}
+
 func (c *ToxicCollection) AddToxicJson(data io.Reader) (*toxics.ToxicWrapper, error) {
 	c.Lock()
 	defer c.Unlock()
@@ -103,6 +166,11 @@ func (c *ToxicCollection) AddToxicJson(data io.Reader) (*toxics.ToxicWrapper, er
 		return nil, ErrInvalidStream
 	}
 
+	wrapper.Sampling, err = toxics.ParseSampling(wrapper.Sampling)
+	if err != nil {
+		return nil, ErrInvalidSampling
+	}
+
 	if wrapper.Name == "" {
 		wrapper.Name = fmt.Sprintf("%s_%s", wrapper.Type, wrapper.Stream)
 	}
@@ -126,8 +194,24 @@ func (c *ToxicCollection) AddToxicJson(data io.Reader) (*toxics.ToxicWrapper, er
 	if err != nil {
 		return nil, joinError(err, ErrBadRequestBody)
 	}
+	if validated, ok := wrapper.Toxic.(toxics.ValidatedToxic); ok {
+		if validated.Validate() != nil {
+			return nil, ErrInvalidAttributes
+		}
+	}
+
+	err = wrapper.Schedule(time.Now())
+	if err != nil {
+		return nil, ErrInvalidSchedule
+	}
+	if wrapper.Flap != nil && wrapper.Flap.Normalize() != nil {
+		return nil, ErrInvalidFlap
+	}
+	if wrapper.Match != nil && wrapper.Match.Compile() != nil {
+		return nil, ErrInvalidMatch
+	}
 
-	c.chainAddToxic(wrapper)
+	c.scheduleToxic(wrapper)
 	return wrapper, nil
 }
 
@@ -140,20 +224,54 @@ func (c *ToxicCollection) UpdateToxicJson(
 
 	toxic := c.findToxicByName(name)
 	if toxic != nil {
-		attrs := &struct {
-			Attributes interface{} `json:"attributes"`
-			Toxicity   float32     `json:"toxicity"`
-		}{
-			toxic.Toxic,
-			toxic.Toxicity,
-		}
-		err := json.NewDecoder(data).Decode(attrs)
+		// Setting the attributes ends a ramp of the toxic.
+		c.stopTimer(c.ramps, toxic)
+
+		err := toxic.Update(func() error {
+			// Invalid attributes are set back to their values before the update.
+			previous, err := json.Marshal(toxic.Toxic)
+			if err != nil {
+				return err
+			}
+
+			attrs := &struct {
+				Attributes interface{} `json:"attributes"`
+				Toxicity   float32     `json:"toxicity"`
+				Sampling   string      `json:"sampling"`
+			}{
+				toxic.Toxic,
+				toxic.Toxicity,
+				toxic.Sampling,
+			}
+			err = json.NewDecoder(data).Decode(attrs)
+			if err != nil {
+				json.Unmarshal(previous, toxic.Toxic) // #nosec G104 -- encoded above
+				return joinError(err, ErrBadRequestBody)
+			}
+			sampling, err := toxics.ParseSampling(attrs.Sampling)
+			if err != nil {
+				json.Unmarshal(previous, toxic.Toxic) // #nosec G104 -- encoded above
+				return ErrInvalidSampling
+			}
+			if validated, ok := toxic.Toxic.(toxics.ValidatedToxic); ok {
+				if validated.Validate() != nil {
+					json.Unmarshal(previous, toxic.Toxic) // #nosec G104 -- encoded above
+					return ErrInvalidAttributes
+				}
+			}
+			toxic.Toxicity = attrs.Toxicity
+			toxic.Sampling = sampling
+			toxic.Ramp = nil
+			return nil
+		})
 		if err != nil {
-			return nil, joinError(err, ErrBadRequestBody)
+			return nil, err
 		}
-		toxic.Toxicity = attrs.Toxicity
 
-		c.chainUpdateToxic(toxic)
+		_, connection := toxic.Toxic.(toxics.ConnectionToxic)
+		if !connection && !c.isPending(toxic) {
+			c.chainUpdateToxic(toxic)
+		}
 		return toxic, nil
 	}
 	return nil, ErrToxicNotFound
@@ -178,7 +296,7 @@ func (c *ToxicCollection) RemoveToxic(ctx context.Context, name string) error {
 		return ErrToxicNotFound
 	}
 
-	c.chainRemoveToxic(ctx, toxic)
+	c.removeToxic(ctx, toxic)
 	log.Trace().Msg("Finished")
 	return nil
 }
@@ -201,10 +319,25 @@ func (c *ToxicCollection) StartLink(
 	}
 
 	link := NewToxicLink(c.proxy, c, direction, logger)
+	link.seed = c.proxy.seed()
+	link.connection = c.proxy.connectionKey(c.proxy.connectionID(name))
 	link.Start(server, name, input, output)
 	c.links[name] = link
 }
 
+// linkStats returns the bytes read by the link with the given name and the
+// names of its active toxics.
+func (c *ToxicCollection) linkStats(name string) (int64, []string) {
+	c.Lock()
+	defer c.Unlock()
+
+	link, ok := c.links[name]
+	if !ok {
+		return 0, []string{}
+	}
+	return link.bytes.Load(), link.activeToxics()
+}
+
 func (c *ToxicCollection) RemoveLink(name string) {
 	c.Lock()
 	defer c.Unlock()
@@ -221,6 +354,16 @@ func (c *ToxicCollection) findToxicByName(name string) *toxics.ToxicWrapper {
 			}
 		}
 	}
+	for _, toxic := range c.conns {
+		if toxic.Name == name {
+			return toxic
+		}
+	}
+	for _, toxic := range c.pending {
+		if toxic.Name == name {
+			return toxic
+		}
+	}
 	return nil
 }
 
@@ -296,4 +439,26 @@ func (c *ToxicCollection) chainRemoveToxic(ctx context.Context, toxic *toxics.To
 	wg.Wait()
 
 	toxic.Index = -1
+	releaseToxic(toxic)
+}
+
+// Connection toxics take effect on the next client, so no link is updated.
+func (c *ToxicCollection) connAddToxic(toxic *toxics.ToxicWrapper) {
+	toxic.Index = len(c.conns)
+	c.conns = append(c.conns, toxic)
+}
+
+func (c *ToxicCollection) connRemoveToxic(toxic *toxics.ToxicWrapper) {
+	c.conns = append(c.conns[:toxic.Index], c.conns[toxic.Index+1:]...)
+	for i := toxic.Index; i < len(c.conns); i++ {
+		c.conns[i].Index = i
+	}
+	toxic.Index = -1
+	releaseToxic(toxic)
+}
+
+func releaseToxic(toxic *toxics.ToxicWrapper) {
+	if shared, ok := toxic.Toxic.(toxics.SharedToxic); ok {
+		shared.Release()
+	}
 }
@@ -0,0 +1,31 @@
+package toxics
+
+import "time"
+
+// The AcceptDelayToxic waits for delay +/- jitter before dialing the upstream
+// of a new connection, like a slow TCP accept. Data sent by the client in the
+// meantime is buffered by the operating system.
+type AcceptDelayToxic struct {
+	NoopToxic
+	// Times in milliseconds
+	Delay  int64 `json:"delay"`
+	Jitter int64 `json:"jitter"`
+}
+
+func (t *AcceptDelayToxic) Accept(stub *ConnectionStub) ConnectionAction {
+	delay := t.Delay
+	if t.Jitter > 0 {
+		delay += stub.Rand.Int63n(t.Jitter*2) - t.Jitter
+	}
+
+	select {
+	case <-time.After(time.Duration(delay) * time.Millisecond):
+		return ConnectionContinue
+	case <-stub.Done:
+		return ConnectionClose
+	}
+}
+
+func init() {
+	Register("accept_delay", new(AcceptDelayToxic))
+}
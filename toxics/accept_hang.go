@@ -0,0 +1,28 @@
+package toxics
+
+import "time"
+
+// The AcceptHangToxic accepts new connections but never dials the upstream,
+// like a SYN blackhole behind a load balancer. The client is closed after a
+// timeout, or held until it gives up if the timeout is 0.
+type AcceptHangToxic struct {
+	NoopToxic
+	// Times in milliseconds
+	Timeout int64 `json:"timeout"`
+}
+
+func (t *AcceptHangToxic) Accept(stub *ConnectionStub) ConnectionAction {
+	if t.Timeout <= 0 {
+		return ConnectionHang
+	}
+
+	select {
+	case <-time.After(time.Duration(t.Timeout) * time.Millisecond):
+	case <-stub.Done:
+	}
+	return ConnectionClose
+}
+
+func init() {
+	Register("accept_hang", new(AcceptHangToxic))
+}
@@ -2,6 +2,9 @@ package toxics
 
 import (
 	"fmt"
+	"math"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/rs/zerolog/log"
@@ -9,10 +12,148 @@ import (
 	"github.com/Shopify/toxiproxy/v2/stream"
 )
 
-// The BandwidthToxic passes data through at a limited rate.
+// Scopes of the bucket of a BandwidthToxic, which decide what shares the rate.
+const (
+	BandwidthConnection = "connection"
+	BandwidthProxy      = "proxy"
+	BandwidthGroup      = "group"
+)
+
+// The BandwidthToxic passes data through at a limited rate, using a token
+// bucket that allows bursts. By default, every connection has its own bucket.
+// With a scope of proxy, all connections of the toxic share one bucket, and
+// with a scope of group, all bandwidth toxics with the same group share one,
+// across proxies, like a saturated uplink.
 type BandwidthToxic struct {
 	// Rate in KB/s
 	Rate int64 `json:"rate"`
+	// Burst in KB, sent at once after the bucket refilled
+	Burst int64  `json:"burst"`
+	Scope string `json:"scope"` // connection, proxy or group
+	Group string `json:"group"` // Name of the group for a scope of group
+
+	lock   sync.Mutex
+	bucket *tokenBucket // Shared by the connections for a scope of proxy or group
+	joined string       // The group of the bucket, for a scope of group
+}
+
+// bandwidthGroup is the bucket shared by the bandwidth toxics of a group, and
+// the number of toxics that joined it.
+type bandwidthGroup struct {
+	bucket *tokenBucket
+	toxics int
+}
+
+// Groups of bandwidth toxics, by name. A group is dropped once the last of its
+// toxics is released.
+var bandwidthGroups = struct {
+	sync.Mutex
+	groups map[string]*bandwidthGroup
+}{groups: make(map[string]*bandwidthGroup)}
+
+func joinBandwidthGroup(name string) *tokenBucket {
+	bandwidthGroups.Lock()
+	defer bandwidthGroups.Unlock()
+
+	group, ok := bandwidthGroups.groups[name]
+	if !ok {
+		group = &bandwidthGroup{bucket: newTokenBucket()}
+		bandwidthGroups.groups[name] = group
+	}
+	group.toxics++
+	return group.bucket
+}
+
+func leaveBandwidthGroup(name string) {
+	bandwidthGroups.Lock()
+	defer bandwidthGroups.Unlock()
+
+	group, ok := bandwidthGroups.groups[name]
+	if !ok {
+		return
+	}
+	group.toxics--
+	if group.toxics <= 0 {
+		delete(bandwidthGroups.groups, name)
+	}
+}
+
+// Validate checks the scope, and that a scope of group has a group.
+func (t *BandwidthToxic) Validate() error {
+	t.Scope = strings.ToLower(t.Scope)
+	switch t.Scope {
+	case "", BandwidthConnection, BandwidthProxy:
+		return nil
+	case BandwidthGroup:
+		if t.Group != "" {
+			return nil
+		}
+	}
+	return ErrInvalidAttributes
+}
+
+// Release leaves the group of the toxic once it is removed.
+func (t *BandwidthToxic) Release() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.release()
+}
+
+func (t *BandwidthToxic) release() {
+	if t.joined != "" {
+		leaveBandwidthGroup(t.joined)
+		t.joined = ""
+		t.bucket = nil
+	}
+}
+
+func (t *BandwidthToxic) NewState() interface{} {
+	return newTokenBucket()
+}
+
+// tokenBucket returns the token bucket the toxic takes from on the stub. The
+// toxic joins its group the first time it is used, and moves to another one if
+// its group is updated.
+func (t *BandwidthToxic) tokenBucket(stub *ToxicStub) *tokenBucket {
+	switch t.Scope {
+	case BandwidthGroup:
+		t.lock.Lock()
+		defer t.lock.Unlock()
+
+		if t.joined != t.Group {
+			t.release()
+			t.bucket = joinBandwidthGroup(t.Group)
+			t.joined = t.Group
+		}
+		return t.bucket
+	case BandwidthProxy:
+		t.lock.Lock()
+		defer t.lock.Unlock()
+
+		t.release()
+		if t.bucket == nil {
+			t.bucket = newTokenBucket()
+		}
+		return t.bucket
+	}
+
+	if bucket, ok := stub.State.(*tokenBucket); ok {
+		return bucket
+	}
+	// The stub has no state when the toxic is used on its own.
+	stub.State = newTokenBucket()
+	return stub.State.(*tokenBucket)
+}
+
+// chunkSize is the most data sent at once, so slow rates are sent in 100
+// millisecond intervals instead of all at once after a long wait. Datagrams
+// are sent whole.
+func (t *BandwidthToxic) chunkSize(stub *ToxicStub, c *stream.StreamChunk) int {
+	if stub.Datagrams {
+		return len(c.Data)
+	}
+	return min(len(c.Data), int(max(t.Rate*100, t.Burst*1000, 1)))
 }
 
 func (t *BandwidthToxic) Pipe(stub *ToxicStub) {
@@ -22,7 +163,7 @@ func (t *BandwidthToxic) Pipe(stub *ToxicStub) {
 		Str("toxic_type", "bandwidth").
 		Str("addr", fmt.Sprintf("%p", t)).
 		Logger()
-	var sleep time.Duration = 0
+	bucket := t.tokenBucket(stub)
 	for {
 		select {
 		case <-stub.Interrupt:
@@ -34,20 +175,20 @@ func (t *BandwidthToxic) Pipe(stub *ToxicStub) {
 				return
 			}
 			if t.Rate <= 0 {
-				sleep = 0
-			} else {
-				sleep += time.Duration(len(p.Data)) * time.Millisecond / time.Duration(t.Rate)
+				stub.Output <- p
+				continue
 			}
-			// If the rate is low enough, split the packet up and send in 100 millisecond intervals
-			for int64(len(p.Data)) > t.Rate*100 {
+
+			for len(p.Data) > 0 {
+				size := t.chunkSize(stub, p)
+				wait := bucket.take(t.Rate*1000, t.Burst*1000, size, time.Now())
 				select {
-				case <-time.After(100 * time.Millisecond):
+				case <-time.After(wait):
 					stub.Output <- &stream.StreamChunk{
-						Data:      p.Data[:t.Rate*100],
+						Data:      p.Data[:size],
 						Timestamp: p.Timestamp,
 					}
-					p.Data = p.Data[t.Rate*100:]
-					sleep -= 100 * time.Millisecond
+					p.Data = p.Data[size:]
 				case <-stub.Interrupt:
 					logger.Trace().Msg("BandwidthToxic was interrupted during writing data")
 					err := stub.WriteOutput(p, 5*time.Second) // Don't drop any data on the floor
@@ -58,25 +199,48 @@ func (t *BandwidthToxic) Pipe(stub *ToxicStub) {
 					return
 				}
 			}
-			start := time.Now()
-			select {
-			case <-time.After(sleep):
-				// time.After only seems to have ~1ms prevision, so offset the next sleep by the error
-				sleep -= time.Since(start)
-				stub.Output <- p
-			case <-stub.Interrupt:
-				logger.Trace().Msg("BandwidthToxic was interrupted during writing data")
-				err := stub.WriteOutput(p, 5*time.Second) // Don't drop any data on the floor
-				if err != nil {
-					logger.Warn().Err(err).
-						Msg("Could not write last packets after interrupt to Output")
-				}
-				return
-			}
 		}
 	}
 }
 
+// A tokenBucket fills up with a token for every byte of the rate, up to the
+// burst. Sending data takes its size in tokens, and waits for the bucket to
+// refill if there are not enough. Data waiting for the bucket has already taken
+// its tokens, so connections sharing a bucket are served in order.
+type tokenBucket struct {
+	sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket() *tokenBucket {
+	return new(tokenBucket)
+}
+
+// take takes n tokens from the bucket, and returns how long to wait until they
+// are available. The rate is in bytes per second, and the burst in bytes.
+func (b *tokenBucket) take(rate, burst int64, n int, now time.Time) time.Duration {
+	b.Lock()
+	defer b.Unlock()
+
+	if b.last.IsZero() {
+		// The bucket starts full.
+		b.tokens = float64(burst)
+	} else if now.After(b.last) {
+		// The bucket holds 10 milliseconds of the rate more than the burst, so
+		// the time lost when timers fire late is made up for.
+		b.tokens += now.Sub(b.last).Seconds() * float64(rate)
+		b.tokens = math.Min(b.tokens, float64(burst+rate/100))
+	}
+	b.last = now
+
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / float64(rate) * float64(time.Second))
+}
+
 func init() {
 	Register("bandwidth", new(BandwidthToxic))
 }
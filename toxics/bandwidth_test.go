@@ -5,9 +5,11 @@ import (
 	"io"
 	"net"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/Shopify/toxiproxy/v2/stream"
 	"github.com/Shopify/toxiproxy/v2/testhelper"
 	"github.com/Shopify/toxiproxy/v2/toxics"
 )
@@ -58,6 +60,105 @@ func TestBandwidthToxic(t *testing.T) {
 	)
 }
 
+// bandwidthSend sends size bytes through every toxic at the same time, each on
+// its own stub, and returns how long it took for all of the data to get through.
+func bandwidthSend(size int, bandwidth ...*toxics.BandwidthToxic) time.Duration {
+	var wg sync.WaitGroup
+	start := time.Now()
+	for _, toxic := range bandwidth {
+		input := make(chan *stream.StreamChunk)
+		output := make(chan *stream.StreamChunk)
+		stub := toxics.NewToxicStub(input, output)
+		stub.State = toxic.NewState()
+		go toxic.Pipe(stub)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			input <- &stream.StreamChunk{Data: make([]byte, size)}
+			close(input)
+			for range output {
+			}
+		}()
+	}
+	wg.Wait()
+	return time.Since(start)
+}
+
+func TestBandwidthToxicBurst(t *testing.T) {
+	toxic := &toxics.BandwidthToxic{Rate: 100, Burst: 50}
+	AssertDeltaTime(t, "Burst", bandwidthSend(50*1000, toxic), 0, 10*time.Millisecond)
+	AssertDeltaTime(t,
+		"Beyond burst",
+		bandwidthSend(60*1000, toxic),
+		100*time.Millisecond,
+		20*time.Millisecond,
+	)
+}
+
+func TestBandwidthToxicScopes(t *testing.T) {
+	connection := &toxics.BandwidthToxic{Rate: 500}
+	AssertDeltaTime(t,
+		"Connection scope",
+		bandwidthSend(50*1000, connection, connection),
+		100*time.Millisecond,
+		20*time.Millisecond,
+	)
+
+	proxy := &toxics.BandwidthToxic{Rate: 500, Scope: toxics.BandwidthProxy}
+	AssertDeltaTime(t,
+		"Proxy scope",
+		bandwidthSend(50*1000, proxy, proxy),
+		200*time.Millisecond,
+		20*time.Millisecond,
+	)
+
+	// Toxics of different proxies share the bucket of their group.
+	first := &toxics.BandwidthToxic{Rate: 500, Scope: toxics.BandwidthGroup, Group: "uplink"}
+	second := &toxics.BandwidthToxic{Rate: 500, Scope: toxics.BandwidthGroup, Group: "uplink"}
+	other := &toxics.BandwidthToxic{Rate: 500, Scope: toxics.BandwidthGroup, Group: "other"}
+	AssertDeltaTime(t,
+		"Group scope",
+		bandwidthSend(50*1000, first, second, other),
+		200*time.Millisecond,
+		20*time.Millisecond,
+	)
+}
+
+func TestBandwidthToxicGroupIsDroppedOnRelease(t *testing.T) {
+	toxic := func() *toxics.BandwidthToxic {
+		return &toxics.BandwidthToxic{
+			Rate:  100,
+			Burst: 50,
+			Scope: toxics.BandwidthGroup,
+			Group: "released",
+		}
+	}
+	first := toxic()
+	AssertDeltaTime(t, "Burst", bandwidthSend(50*1000, first), 0, 10*time.Millisecond)
+
+	// A toxic joining the group afterwards starts with a full bucket, instead of
+	// the one emptied by the first toxic.
+	first.Release()
+	AssertDeltaTime(t, "New group", bandwidthSend(50*1000, toxic()), 0, 10*time.Millisecond)
+}
+
+func TestBandwidthToxicValidate(t *testing.T) {
+	toxic := &toxics.BandwidthToxic{Scope: "Proxy"}
+	if err := toxic.Validate(); err != nil || toxic.Scope != toxics.BandwidthProxy {
+		t.Errorf("Expected the scope to be normalized, got %q and %v", toxic.Scope, err)
+	}
+
+	for _, toxic := range []*toxics.BandwidthToxic{
+		{Scope: toxics.BandwidthGroup},
+		{Scope: "link"},
+	} {
+		if toxic.Validate() != toxics.ErrInvalidAttributes {
+			t.Errorf("Expected %+v to be invalid", toxic)
+		}
+	}
+}
+
 func BenchmarkBandwidthToxic100MB(b *testing.B) {
 	upstream := testhelper.NewUpstream(b, true)
 	defer upstream.Close()
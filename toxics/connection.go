@@ -0,0 +1,79 @@
+package toxics
+
+import (
+	"math/rand"
+	"net"
+	"sync"
+)
+
+// What the proxy does with a new client after running a ConnectionToxic.
+type ConnectionAction string
+
+const (
+	// Continue with the next toxic, then dial the upstream.
+	ConnectionContinue ConnectionAction = ""
+	// Close the client without dialing the upstream.
+	ConnectionClose ConnectionAction = "close"
+	// Close the client with a TCP RST, like a refused connection.
+	ConnectionReset ConnectionAction = "reset"
+	// Keep the client open without ever dialing the upstream.
+	ConnectionHang ConnectionAction = "hang"
+)
+
+// A ConnectionToxic acts on new client connections, before the upstream is
+// dialed and before any link exists. Connection toxics can refuse, delay or
+// hold clients, which toxics on the data of a link can't simulate.
+//
+// Connection toxics are never added to the chain of a link, so their Pipe
+// should pass data through unchanged.
+type ConnectionToxic interface {
+	// Accept is called for every new client of the proxy. Blocking delays the
+	// connection, and any action other than ConnectionContinue stops the client
+	// from reaching the upstream.
+	Accept(*ConnectionStub) ConnectionAction
+}
+
+// ConnectionStub holds the client of a new connection for connection toxics.
+type ConnectionStub struct {
+	Client net.Conn
+	// Closed when the proxy is stopped, toxics must not block past it.
+	Done <-chan struct{}
+	// Random numbers of the toxic being run, derived from the proxy seed.
+	Rand *rand.Rand
+
+	lock    sync.Mutex
+	onClose []func()
+	closed  bool
+}
+
+func NewConnectionStub(client net.Conn, done <-chan struct{}) *ConnectionStub {
+	return &ConnectionStub{
+		Client: client,
+		Done:   done,
+		Rand:   newTimeRand(),
+	}
+}
+
+// OnClose registers a function to call once the connection is closed, for
+// toxics that track open connections.
+func (s *ConnectionStub) OnClose(f func()) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.onClose = append(s.onClose, f)
+}
+
+// Close runs the functions registered with OnClose, the first time only.
+func (s *ConnectionStub) Close() {
+	s.lock.Lock()
+	if s.closed {
+		s.lock.Unlock()
+		return
+	}
+	s.closed = true
+	funcs := s.onClose
+	s.lock.Unlock()
+
+	for _, f := range funcs {
+		f()
+	}
+}
@@ -0,0 +1,203 @@
+package toxics_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/Shopify/toxiproxy/v2"
+	"github.com/Shopify/toxiproxy/v2/toxics"
+)
+
+// WithConnectionToxic starts a proxy to a server that echoes every connection,
+// with the given connection toxic added before any client connects.
+func WithConnectionToxic(
+	t *testing.T,
+	typeName string,
+	toxic toxics.Toxic,
+	f func(proxy *toxiproxy.Proxy),
+) {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal("Failed to create TCP server", err)
+	}
+	defer ln.Close()
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	proxy := NewTestProxy("test", ln.Addr().String())
+	err = proxy.Start()
+	if err != nil {
+		t.Fatal("Proxy failed to start", err)
+	}
+	defer proxy.Stop()
+
+	_, err = proxy.Toxics.AddToxicJson(ToxicToJson(t, "", typeName, "upstream", toxic))
+	if err != nil {
+		t.Fatal("Failed to add toxic", err)
+	}
+
+	f(proxy)
+}
+
+// AssertEcho checks that the connection reaches the upstream.
+func AssertEcho(t *testing.T, conn net.Conn) {
+	_, err := conn.Write([]byte("ping"))
+	if err != nil {
+		t.Fatal("Failed to write to proxy", err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(conn, buf)
+	if err != nil || string(buf) != "ping" {
+		t.Fatalf("Expected echo from upstream, got %q %v", buf, err)
+	}
+}
+
+// AssertClosed checks that the proxy closes the connection with the given
+// error, without sending any data.
+func AssertClosed(t *testing.T, conn net.Conn, expected error) {
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err := conn.Read(make([]byte, 1))
+	if !errors.Is(err, expected) {
+		t.Fatalf("Expected connection to close with %v, got %v", expected, err)
+	}
+}
+
+func TestRefuseToxic(t *testing.T) {
+	WithConnectionToxic(t, "refuse", new(toxics.RefuseToxic), func(proxy *toxiproxy.Proxy) {
+		conn, err := net.Dial("tcp", proxy.Listen)
+		if err != nil {
+			t.Fatal("Unable to dial proxy", err)
+		}
+		defer conn.Close()
+
+		AssertClosed(t, conn, syscall.ECONNRESET)
+
+		err = proxy.Toxics.RemoveToxic(context.Background(), "refuse_upstream")
+		if err != nil {
+			t.Fatal("Failed to remove toxic", err)
+		}
+
+		conn, err = net.Dial("tcp", proxy.Listen)
+		if err != nil {
+			t.Fatal("Unable to dial proxy", err)
+		}
+		defer conn.Close()
+		AssertEcho(t, conn)
+	})
+}
+
+func TestAcceptDelayToxic(t *testing.T) {
+	toxic := &toxics.AcceptDelayToxic{Delay: 200}
+	WithConnectionToxic(t, "accept_delay", toxic, func(proxy *toxiproxy.Proxy) {
+		start := time.Now()
+		conn, err := net.Dial("tcp", proxy.Listen)
+		if err != nil {
+			t.Fatal("Unable to dial proxy", err)
+		}
+		defer conn.Close()
+
+		AssertEcho(t, conn)
+		AssertDeltaTime(t, "Accept delay", time.Since(start), 200*time.Millisecond, 100*time.Millisecond)
+	})
+}
+
+func TestAcceptHangToxic(t *testing.T) {
+	toxic := new(toxics.AcceptHangToxic)
+	WithConnectionToxic(t, "accept_hang", toxic, func(proxy *toxiproxy.Proxy) {
+		conn, err := net.Dial("tcp", proxy.Listen)
+		if err != nil {
+			t.Fatal("Unable to dial proxy", err)
+		}
+		defer conn.Close()
+
+		conn.Write([]byte("ping"))
+		conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		_, err = conn.Read(make([]byte, 1))
+		if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
+			t.Fatal("Expected connection to hang, got", err)
+		}
+
+		proxy.Stop()
+		AssertClosed(t, conn, io.EOF)
+	})
+}
+
+func TestAcceptHangToxicTimeout(t *testing.T) {
+	toxic := &toxics.AcceptHangToxic{Timeout: 100}
+	WithConnectionToxic(t, "accept_hang", toxic, func(proxy *toxiproxy.Proxy) {
+		start := time.Now()
+		conn, err := net.Dial("tcp", proxy.Listen)
+		if err != nil {
+			t.Fatal("Unable to dial proxy", err)
+		}
+		defer conn.Close()
+
+		AssertClosed(t, conn, io.EOF)
+		AssertDeltaTime(t, "Hang timeout", time.Since(start), 100*time.Millisecond, 100*time.Millisecond)
+	})
+}
+
+func TestMaxConnectionsToxic(t *testing.T) {
+	toxic := &toxics.MaxConnectionsToxic{Limit: 1, Mode: "reset"}
+	WithConnectionToxic(t, "max_connections", toxic, func(proxy *toxiproxy.Proxy) {
+		first, err := net.Dial("tcp", proxy.Listen)
+		if err != nil {
+			t.Fatal("Unable to dial proxy", err)
+		}
+		AssertEcho(t, first)
+
+		second, err := net.Dial("tcp", proxy.Listen)
+		if err != nil {
+			t.Fatal("Unable to dial proxy", err)
+		}
+		defer second.Close()
+		AssertClosed(t, second, syscall.ECONNRESET)
+
+		first.Close()
+		// Wait for the proxy to release the closed connection.
+		time.Sleep(100 * time.Millisecond)
+
+		third, err := net.Dial("tcp", proxy.Listen)
+		if err != nil {
+			t.Fatal("Unable to dial proxy", err)
+		}
+		defer third.Close()
+		AssertEcho(t, third)
+	})
+}
+
+func TestConnectionToxicsAreListed(t *testing.T) {
+	WithConnectionToxic(t, "refuse", new(toxics.RefuseToxic), func(proxy *toxiproxy.Proxy) {
+		toxic := proxy.Toxics.GetToxic("refuse_upstream")
+		if toxic == nil || toxic.Type != "refuse" {
+			t.Fatal("Expected to find connection toxic by name, got", toxic)
+		}
+
+		if n := len(proxy.Toxics.GetToxicArray()); n != 1 {
+			t.Fatal("Expected one toxic to be listed, got", n)
+		}
+
+		proxy.Toxics.ResetToxics(context.Background())
+		if n := len(proxy.Toxics.GetConnectionToxics()); n != 0 {
+			t.Fatal("Expected connection toxics to be reset, got", n)
+		}
+	})
+}
@@ -0,0 +1,89 @@
+package toxics
+
+import (
+	"math/rand"
+
+	"github.com/Shopify/toxiproxy/v2/stream"
+)
+
+// Ways the CorruptToxic damages data.
+const (
+	CorruptBitFlip  = "bit_flip"
+	CorruptReplace  = "replace"
+	CorruptZero     = "zero"
+	CorruptTruncate = "truncate"
+)
+
+// The CorruptToxic damages the bytes of the data passing through, to exercise
+// checksums and framing. With a unit of byte, every byte is corrupted with the
+// given probability. With a unit of chunk, every chunk is corrupted once with
+// the given probability, at a random byte.
+type CorruptToxic struct {
+	Mode        string  `json:"mode"`        // bit_flip, replace, zero or truncate
+	Unit        string  `json:"unit"`        // byte or chunk
+	Probability float64 `json:"probability"` // Between 0 and 1
+	Byte        byte    `json:"byte"`        // Replacement for the replace mode
+}
+
+// corrupt damages the data in place, and returns the part of it to send.
+func (t *CorruptToxic) corrupt(r *rand.Rand, data []byte) []byte {
+	if len(data) == 0 {
+		return data
+	}
+
+	if t.Unit == "chunk" {
+		if r.Float64() < t.Probability {
+			return t.corruptAt(r, data, r.Intn(len(data)))
+		}
+		return data
+	}
+
+	for i := 0; i < len(data); i++ {
+		if r.Float64() < t.Probability {
+			data = t.corruptAt(r, data, i)
+		}
+	}
+	return data
+}
+
+func (t *CorruptToxic) corruptAt(r *rand.Rand, data []byte, i int) []byte {
+	switch t.Mode {
+	case CorruptReplace:
+		data[i] = t.Byte
+	case CorruptZero:
+		data[i] = 0
+	case CorruptTruncate:
+		return data[:i]
+	default:
+		data[i] ^= 1 << r.Intn(8)
+	}
+	return data
+}
+
+func (t *CorruptToxic) Pipe(stub *ToxicStub) {
+	for {
+		select {
+		case <-stub.Interrupt:
+			return
+		case c := <-stub.Input:
+			if c == nil {
+				stub.Close()
+				return
+			}
+
+			data := make([]byte, len(c.Data))
+			copy(data, c.Data)
+			data = t.corrupt(stub.Rand, data)
+			if len(data) > 0 {
+				stub.Output <- &stream.StreamChunk{
+					Data:      data,
+					Timestamp: c.Timestamp,
+				}
+			}
+		}
+	}
+}
+
+func init() {
+	Register("corrupt", new(CorruptToxic))
+}
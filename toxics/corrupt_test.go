@@ -0,0 +1,100 @@
+package toxics_test
+
+import (
+	"bytes"
+	"math/bits"
+	"testing"
+
+	"github.com/Shopify/toxiproxy/v2/stream"
+	"github.com/Shopify/toxiproxy/v2/toxics"
+)
+
+// corruptChunks sends chunks through the corrupt toxic, seeded with seed, and
+// returns what came out of it.
+func corruptChunks(toxic *toxics.CorruptToxic, seed int64, chunks ...[]byte) [][]byte {
+	input := make(chan *stream.StreamChunk)
+	output := make(chan *stream.StreamChunk, len(chunks))
+	stub := toxics.NewToxicStub(input, output)
+	stub.Rand = toxics.NewRand(seed)
+
+	go toxic.Pipe(stub)
+
+	for _, data := range chunks {
+		input <- &stream.StreamChunk{Data: data}
+	}
+	close(input)
+
+	var result [][]byte
+	for c := range output {
+		result = append(result, c.Data)
+	}
+	return result
+}
+
+func TestCorruptToxicBitFlip(t *testing.T) {
+	original := []byte("hello world")
+	data := append([]byte{}, original...)
+
+	result := corruptChunks(&toxics.CorruptToxic{Probability: 1}, 1, data)
+	if len(result) != 1 || len(result[0]) != len(original) {
+		t.Fatalf("Expected one chunk of %d bytes, got %q", len(original), result)
+	}
+	for i := range original {
+		if bits.OnesCount8(original[i]^result[0][i]) != 1 {
+			t.Errorf("Expected one bit of byte %d to be flipped, got %08b", i, result[0][i])
+		}
+	}
+	if !bytes.Equal(data, original) {
+		t.Error("Expected the data of the input chunk to be left untouched")
+	}
+}
+
+func TestCorruptToxicReplace(t *testing.T) {
+	toxic := &toxics.CorruptToxic{Mode: "replace", Probability: 1, Byte: 'x'}
+	result := corruptChunks(toxic, 1, []byte("hello"))
+	if len(result) != 1 || string(result[0]) != "xxxxx" {
+		t.Errorf("Expected every byte to be replaced, got %q", result)
+	}
+}
+
+func TestCorruptToxicZeroChunk(t *testing.T) {
+	toxic := &toxics.CorruptToxic{Mode: "zero", Unit: "chunk", Probability: 1}
+	result := corruptChunks(toxic, 1, []byte("hello"), []byte("world"))
+	if len(result) != 2 {
+		t.Fatalf("Expected 2 chunks, got %q", result)
+	}
+	for _, data := range result {
+		if n := bytes.Count(data, []byte{0}); n != 1 {
+			t.Errorf("Expected one byte of each chunk to be zeroed, got %q", data)
+		}
+	}
+}
+
+func TestCorruptToxicTruncate(t *testing.T) {
+	toxic := &toxics.CorruptToxic{Mode: "truncate", Unit: "chunk", Probability: 1}
+	for i := 0; i < 20; i++ {
+		result := corruptChunks(toxic, int64(i), []byte("hello world"))
+		// A chunk truncated to nothing is dropped.
+		if len(result) == 0 {
+			continue
+		}
+		if len(result) != 1 || len(result[0]) >= len("hello world") ||
+			!bytes.HasPrefix([]byte("hello world"), result[0]) {
+			t.Fatalf("Expected the chunk to be truncated, got %q", result)
+		}
+	}
+
+	// Truncating at the first byte drops the whole chunk.
+	toxic.Unit = "byte"
+	result := corruptChunks(toxic, 1, []byte("hello"), []byte("world"))
+	if len(result) != 0 {
+		t.Errorf("Expected every chunk to be dropped, got %q", result)
+	}
+}
+
+func TestCorruptToxicProbabilityZero(t *testing.T) {
+	result := corruptChunks(&toxics.CorruptToxic{}, 1, []byte("hello"))
+	if len(result) != 1 || string(result[0]) != "hello" {
+		t.Errorf("Expected data to pass through untouched, got %q", result)
+	}
+}
@@ -0,0 +1,44 @@
+// Package dns provides toxics that parse DNS messages, to act on the queries
+// and responses for the names and types that match.
+//
+// The toxics apply to UDP proxies, where each chunk of data is a datagram
+// holding a message. Queries are read on the upstream stream, and responses on
+// the downstream stream. Data that isn't a DNS message passes through
+// untouched.
+package dns
+
+import (
+	"strings"
+)
+
+// Match selects the messages a toxic applies to, by their question. Empty
+// fields match any message.
+type Match struct {
+	Names []string `json:"names"` // Names and their subdomains, like example.com
+	Types []string `json:"types"` // Types like AAAA, or numbers
+}
+
+func (m *Match) matches(msg *message) bool {
+	return m.matchesName(msg.name) && m.matchesType(msg.qtype)
+}
+
+func (m *Match) matchesName(name string) bool {
+	for _, match := range m.Names {
+		match = strings.ToLower(strings.Trim(match, "."))
+		if name == match || strings.HasSuffix(name, "."+match) || match == "" {
+			return true
+		}
+	}
+	return len(m.Names) == 0
+}
+
+// matchesType reports whether the type of a question matches. Unknown names
+// don't match any type.
+func (m *Match) matchesType(qtype uint16) bool {
+	for _, name := range m.Types {
+		if t, ok := recordType(name); ok && t == qtype {
+			return true
+		}
+	}
+	return len(m.Types) == 0
+}
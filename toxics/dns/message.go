@@ -0,0 +1,169 @@
+package dns
+
+import (
+	"encoding/binary"
+	"strconv"
+	"strings"
+)
+
+// Flags of the header of a message.
+const (
+	flagResponse  = 0x8000
+	flagOpcode    = 0x7800
+	flagTruncated = 0x0200
+	flagRecursion = 0x0100 // Recursion desired
+	flagAvailable = 0x0080 // Recursion available
+	flagRcode     = 0x000f
+)
+
+// Types of records.
+const (
+	typeA    = 1
+	typeAAAA = 28
+)
+
+var types = map[string]uint16{
+	"a":     typeA,
+	"ns":    2,
+	"cname": 5,
+	"soa":   6,
+	"ptr":   12,
+	"mx":    15,
+	"txt":   16,
+	"aaaa":  typeAAAA,
+	"srv":   33,
+	"svcb":  64,
+	"https": 65,
+	"any":   255,
+}
+
+// recordType returns the type of a name, like AAAA, or of a number.
+func recordType(name string) (uint16, bool) {
+	if t, ok := types[strings.ToLower(name)]; ok {
+		return t, true
+	}
+	t, err := strconv.ParseUint(name, 10, 16)
+	return uint16(t), err == nil
+}
+
+// rcodes are the response codes of errors, by name.
+var rcodes = map[string]uint16{
+	"formerr":  1,
+	"servfail": 2,
+	"nxdomain": 3,
+	"notimp":   4,
+	"refused":  5,
+}
+
+// message is a DNS message, with its first question.
+type message struct {
+	raw   []byte
+	flags uint16
+	name  string // Lower case, without the final dot
+	qtype uint16
+	end   int // The end of the question
+}
+
+// parse parses a message, or returns false if the data isn't one with a single
+// question, like every query.
+func parse(data []byte) (*message, bool) {
+	if len(data) < 12 || binary.BigEndian.Uint16(data[4:6]) != 1 {
+		return nil, false
+	}
+	name, offset, ok := readName(data, 12)
+	if !ok || len(data) < offset+4 {
+		return nil, false
+	}
+	return &message{
+		raw:   data,
+		flags: binary.BigEndian.Uint16(data[2:4]),
+		name:  name,
+		qtype: binary.BigEndian.Uint16(data[offset:]),
+		end:   offset + 4,
+	}, true
+}
+
+func (m *message) response() bool {
+	return m.flags&flagResponse != 0
+}
+
+// maxPointers limits the compression pointers followed in a name, so a loop of
+// them ends.
+const maxPointers = 16
+
+// readName reads the name at an offset of a message, and returns the offset
+// after it.
+func readName(data []byte, offset int) (string, int, bool) {
+	var labels []string
+	end := -1
+	for pointers := 0; ; {
+		if offset >= len(data) {
+			return "", 0, false
+		}
+		n := int(data[offset])
+		switch {
+		case n == 0:
+			if end < 0 {
+				end = offset + 1
+			}
+			return strings.ToLower(strings.Join(labels, ".")), end, true
+		case n&0xc0 == 0xc0:
+			if offset+1 >= len(data) || pointers == maxPointers {
+				return "", 0, false
+			}
+			if end < 0 {
+				end = offset + 2
+			}
+			offset = int(binary.BigEndian.Uint16(data[offset:]) & 0x3fff)
+			pointers++
+		case n&0xc0 != 0 || offset+1+n > len(data):
+			return "", 0, false
+		default:
+			labels = append(labels, string(data[offset+1:offset+1+n]))
+			offset += 1 + n
+		}
+	}
+}
+
+// reply builds the reply to a query with a response code, and its question.
+func (m *message) reply(rcode uint16) []byte {
+	data := make([]byte, 12, m.end)
+	copy(data, m.raw[:2])
+	flags := flagResponse | m.flags&(flagOpcode|flagRecursion) | flagAvailable | rcode&flagRcode
+	binary.BigEndian.PutUint16(data[2:], flags)
+	binary.BigEndian.PutUint16(data[4:], 1)
+	return append(data, m.raw[12:m.end]...)
+}
+
+// truncate returns the response with its question only, and the truncated
+// flag set, like a response too large for a datagram.
+func (m *message) truncate() []byte {
+	data := append([]byte(nil), m.raw[:m.end]...)
+	binary.BigEndian.PutUint16(data[2:], m.flags|flagTruncated)
+	binary.BigEndian.PutUint16(data[4:], 1)
+	clear(data[6:12])
+	return data
+}
+
+// answers calls f with the type and the data of each record of the answer
+// section of the response. It returns false if the message is malformed.
+func (m *message) answers(f func(rtype uint16, rdata []byte)) bool {
+	data := m.raw
+	offset := m.end
+	for n := binary.BigEndian.Uint16(data[6:8]); n > 0; n-- {
+		var ok bool
+		_, offset, ok = readName(data, offset)
+		if !ok || len(data) < offset+10 {
+			return false
+		}
+		rtype := binary.BigEndian.Uint16(data[offset:])
+		length := int(binary.BigEndian.Uint16(data[offset+8:]))
+		offset += 10
+		if len(data) < offset+length {
+			return false
+		}
+		f(rtype, data[offset:offset+length])
+		offset += length
+	}
+	return true
+}
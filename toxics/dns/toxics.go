@@ -0,0 +1,197 @@
+package dns
+
+import (
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Shopify/toxiproxy/v2/stream"
+	"github.com/Shopify/toxiproxy/v2/toxics"
+)
+
+// rcode returns the response code of an error name, or of a number, or
+// SERVFAIL if it is unknown.
+func rcode(name string) uint16 {
+	if code, ok := rcodes[strings.ToLower(name)]; ok {
+		return code
+	}
+	code, err := strconv.ParseUint(name, 10, 4)
+	if err != nil || code == 0 {
+		return rcodes["servfail"]
+	}
+	return uint16(code)
+}
+
+// pipe passes the chunks of the stub on, calling f with the messages that
+// match. f returns the data to send in place of the message, or nil to drop it.
+func pipe(stub *toxics.ToxicStub, match func(*message) bool, f func(*message) []byte) {
+	for {
+		select {
+		case <-stub.Interrupt:
+			return
+		case c := <-stub.Input:
+			if c == nil {
+				stub.Close()
+				return
+			}
+			if m, ok := parse(c.Data); ok && match(m) {
+				data := f(m)
+				if data == nil {
+					continue
+				}
+				c = &stream.StreamChunk{Data: data, Timestamp: c.Timestamp}
+			}
+			stub.Output <- c
+		}
+	}
+}
+
+// ErrorToxic answers matching queries with an error, like NXDOMAIN, instead of
+// sending them to the server. It applies to the upstream stream.
+type ErrorToxic struct {
+	Match
+	Rcode string `json:"rcode"` // Names like nxdomain, or numbers. Defaults to servfail
+}
+
+func (t *ErrorToxic) Pipe(stub *toxics.ToxicStub) {
+	code := rcode(t.Rcode)
+	query := func(m *message) bool {
+		return !m.response() && stub.Reply != nil && t.Match.matches(m)
+	}
+
+	pipe(stub, query, func(m *message) []byte {
+		stub.WriteReply(m.reply(code))
+		return nil
+	})
+}
+
+// RewriteToxic rewrites the addresses of the A and AAAA records of matching
+// responses, like to a blackhole address. It applies to the downstream stream.
+type RewriteToxic struct {
+	Match
+	Address  string `json:"address"`  // Defaults to 0.0.0.0
+	Address6 string `json:"address6"` // Defaults to ::
+}
+
+// address parses an address of a family, or returns the unspecified one.
+func address(s string, unspecified netip.Addr) netip.Addr {
+	addr, err := netip.ParseAddr(s)
+	if err != nil || addr.Is4() != unspecified.Is4() {
+		return unspecified
+	}
+	return addr
+}
+
+func (t *RewriteToxic) Pipe(stub *toxics.ToxicStub) {
+	a := address(t.Address, netip.IPv4Unspecified()).AsSlice()
+	aaaa := address(t.Address6, netip.IPv6Unspecified()).AsSlice()
+	response := func(m *message) bool {
+		return m.response() && t.Match.matches(m)
+	}
+
+	pipe(stub, response, func(m *message) []byte {
+		m.raw = slices.Clone(m.raw)
+		m.answers(func(rtype uint16, rdata []byte) {
+			switch {
+			case rtype == typeA && len(rdata) == len(a):
+				copy(rdata, a)
+			case rtype == typeAAAA && len(rdata) == len(aaaa):
+				copy(rdata, aaaa)
+			}
+		})
+		return m.raw
+	})
+}
+
+// TruncateToxic removes the records of matching responses and sets their
+// truncated flag, so clients retry the queries over TCP. It applies to the
+// downstream stream.
+type TruncateToxic struct {
+	Match
+}
+
+func (t *TruncateToxic) Pipe(stub *toxics.ToxicStub) {
+	response := func(m *message) bool {
+		return m.response() && t.Match.matches(m)
+	}
+
+	pipe(stub, response, (*message).truncate)
+}
+
+// LatencyToxic delays matching messages, like AAAA queries. Other messages are
+// passed on right away, as each one is a datagram of its own. The delays are
+// drawn like the ones of the latency toxic.
+type LatencyToxic struct {
+	Match
+	toxics.LatencyToxic
+}
+
+// held is a message that is delayed until a time.
+type held struct {
+	chunk *stream.StreamChunk
+	until time.Time
+}
+
+func (t *LatencyToxic) Pipe(stub *toxics.ToxicStub) {
+	state, _ := stub.State.(*toxics.LatencyToxicState)
+	var queue []held // By time
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+	defer timer.Stop()
+
+	// flush passes on the messages of the queue up to a time.
+	flush := func(now time.Time) {
+		for len(queue) > 0 && !queue[0].until.After(now) {
+			stub.Output <- queue[0].chunk
+			queue = queue[1:]
+		}
+		if len(queue) > 0 {
+			timer.Reset(queue[0].until.Sub(now))
+		}
+	}
+	flushAll := func() {
+		for _, h := range queue {
+			stub.Output <- h.chunk
+		}
+	}
+
+	for {
+		select {
+		case <-stub.Interrupt:
+			flushAll()
+			return
+		case <-timer.C:
+			flush(time.Now())
+		case c := <-stub.Input:
+			if c == nil {
+				flushAll()
+				stub.Close()
+				return
+			}
+			m, ok := parse(c.Data)
+			if !ok || !t.Match.matches(m) {
+				stub.Output <- c
+				continue
+			}
+
+			h := held{chunk: c, until: time.Now().Add(t.Delay(stub.Rand, state))}
+			i, _ := slices.BinarySearchFunc(queue, h, func(a, b held) int {
+				return a.until.Compare(b.until)
+			})
+			queue = slices.Insert(queue, i, h)
+			if i == 0 {
+				timer.Stop()
+				flush(time.Now())
+			}
+		}
+	}
+}
+
+func init() {
+	toxics.Register("dns_error", new(ErrorToxic))
+	toxics.Register("dns_rewrite", new(RewriteToxic))
+	toxics.Register("dns_truncate", new(TruncateToxic))
+	toxics.Register("dns_latency", new(LatencyToxic))
+}
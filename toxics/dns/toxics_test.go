@@ -0,0 +1,223 @@
+package dns_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Shopify/toxiproxy/v2/stream"
+	"github.com/Shopify/toxiproxy/v2/toxics"
+	"github.com/Shopify/toxiproxy/v2/toxics/dns"
+)
+
+func name(s string) []byte {
+	var data []byte
+	for _, label := range strings.Split(s, ".") {
+		data = append(data, byte(len(label)))
+		data = append(data, label...)
+	}
+	return append(data, 0)
+}
+
+// query builds a query with recursion desired for a name and a type.
+func query(id uint16, host string, qtype uint16) []byte {
+	data := binary.BigEndian.AppendUint16(nil, id)
+	data = append(data, 0x01, 0x00, 0, 1, 0, 0, 0, 0, 0, 0)
+	data = append(data, name(host)...)
+	data = binary.BigEndian.AppendUint16(data, qtype)
+	return binary.BigEndian.AppendUint16(data, 1)
+}
+
+// response builds the response to a query with records of its type, whose
+// names point to the question.
+func response(q []byte, rdata ...[]byte) []byte {
+	data := append([]byte(nil), q...)
+	data[2] |= 0x80
+	data[3] |= 0x80
+	binary.BigEndian.PutUint16(data[6:], uint16(len(rdata)))
+	qtype := q[len(q)-4 : len(q)-2]
+	for _, r := range rdata {
+		data = append(data, 0xc0, 12)
+		data = append(data, qtype...)
+		data = append(data, 0, 1, 0, 0, 0x0e, 0x10)
+		data = binary.BigEndian.AppendUint16(data, uint16(len(r)))
+		data = append(data, r...)
+	}
+	return data
+}
+
+// runToxic sends each message through the toxic as a datagram, and returns the
+// ones that came out and the ones that were replied.
+func runToxic(t *testing.T, toxic toxics.Toxic, messages ...[]byte) ([][]byte, [][]byte) {
+	input := make(chan *stream.StreamChunk)
+	output := make(chan *stream.StreamChunk)
+	stub := toxics.NewToxicStub(input, output)
+	reply := new(datagrams)
+	stub.Reply = reply
+
+	go toxic.Pipe(stub)
+	go func() {
+		for _, m := range messages {
+			input <- &stream.StreamChunk{Data: m}
+		}
+		close(input)
+	}()
+
+	var out [][]byte
+	for {
+		select {
+		case c, ok := <-output:
+			if !ok {
+				return out, *reply
+			}
+			out = append(out, c.Data)
+		case <-time.After(time.Second):
+			t.Fatal("Timed out waiting for the toxic to close")
+		}
+	}
+}
+
+// datagrams keeps each write, like a UDP socket.
+type datagrams [][]byte
+
+func (d *datagrams) Write(p []byte) (int, error) {
+	*d = append(*d, append([]byte(nil), p...))
+	return len(p), nil
+}
+
+func TestErrorToxic(t *testing.T) {
+	blocked := query(1, "api.Example.com", 1)
+	other := query(2, "example.org", 1)
+
+	toxic := &dns.ErrorToxic{Match: dns.Match{Names: []string{"example.com."}}, Rcode: "nxdomain"}
+	out, reply := runToxic(t, toxic, blocked, other)
+	if len(out) != 1 || !bytes.Equal(out[0], other) {
+		t.Errorf("Expected only the other query to be forwarded, got %q", out)
+	}
+	if len(reply) != 1 {
+		t.Fatalf("Expected one reply, got %q", reply)
+	}
+	expected := append([]byte{0, 1, 0x81, 0x83}, blocked[4:]...)
+	if !bytes.Equal(reply[0], expected) {
+		t.Errorf("Expected a NXDOMAIN response %q, got %q", expected, reply[0])
+	}
+
+	// Responses aren't answered, and the default is SERVFAIL.
+	toxic = &dns.ErrorToxic{}
+	out, reply = runToxic(t, toxic, response(other), query(3, "example.org", 28))
+	if len(out) != 1 || len(reply) != 1 || reply[0][3]&0xf != 2 {
+		t.Errorf("Expected the response to pass and a SERVFAIL reply, got %q and %q", out, reply)
+	}
+}
+
+func TestRewriteToxic(t *testing.T) {
+	a := response(query(1, "example.com", 1), []byte{93, 184, 216, 34}, []byte{1, 2, 3, 4})
+	aaaa := response(query(2, "example.com", 28), bytes.Repeat([]byte{0x20}, 16))
+	other := response(query(3, "example.org", 1), []byte{93, 184, 216, 34})
+	original := bytes.Clone(a)
+
+	toxic := &dns.RewriteToxic{
+		Match:   dns.Match{Names: []string{"example.com"}},
+		Address: "192.0.2.1",
+	}
+	out, _ := runToxic(t, toxic, a, aaaa, other)
+	if len(out) != 3 {
+		t.Fatalf("Expected 3 responses, got %q", out)
+	}
+	expected := bytes.Clone(a)
+	copy(expected[len(a)-20:], []byte{192, 0, 2, 1})
+	copy(expected[len(a)-4:], []byte{192, 0, 2, 1})
+	if !bytes.Equal(out[0], expected) {
+		t.Errorf("Expected the A records to be rewritten to %q, got %q", expected, out[0])
+	}
+	if !bytes.Equal(a, original) {
+		t.Error("Expected the data read to be left untouched")
+	}
+	if !bytes.Equal(out[1][len(aaaa)-16:], make([]byte, 16)) {
+		t.Errorf("Expected the AAAA record to be rewritten to ::, got %q", out[1])
+	}
+	if !bytes.Equal(out[2], other) {
+		t.Errorf("Expected the other response to pass through, got %q", out[2])
+	}
+}
+
+func TestTruncateToxic(t *testing.T) {
+	q := query(1, "example.com", 16)
+	txt := response(q, []byte("\x05hello"))
+
+	toxic := &dns.TruncateToxic{Match: dns.Match{Types: []string{"txt"}}}
+	out, _ := runToxic(t, toxic, q, txt)
+	if len(out) != 2 || !bytes.Equal(out[0], q) {
+		t.Fatalf("Expected the query to pass through, got %q", out)
+	}
+	expected := append([]byte{0, 1, 0x83, 0x80, 0, 1, 0, 0, 0, 0, 0, 0}, q[12:]...)
+	if !bytes.Equal(out[1], expected) {
+		t.Errorf("Expected a truncated response %q, got %q", expected, out[1])
+	}
+}
+
+func TestLatencyToxicDelaysMatchingQueries(t *testing.T) {
+	input := make(chan *stream.StreamChunk)
+	output := make(chan *stream.StreamChunk)
+	stub := toxics.NewToxicStub(input, output)
+	toxic := &dns.LatencyToxic{
+		Match:        dns.Match{Types: []string{"AAAA"}},
+		LatencyToxic: toxics.LatencyToxic{Latency: 100},
+	}
+	done := make(chan struct{})
+	go func() {
+		toxic.Pipe(stub)
+		close(done)
+	}()
+
+	slow := query(1, "example.com", 28)
+	fast := query(2, "example.com", 1)
+	start := time.Now()
+	input <- &stream.StreamChunk{Data: slow}
+	input <- &stream.StreamChunk{Data: fast}
+	if c := <-output; !bytes.Equal(c.Data, fast) {
+		t.Errorf("Expected the A query right away, got %q", c.Data)
+	}
+	if c := <-output; !bytes.Equal(c.Data, slow) {
+		t.Errorf("Expected the delayed AAAA query, got %q", c.Data)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("Expected the query to be delayed, it took %s", elapsed)
+	}
+
+	// Interrupting the toxic sends the delayed queries right away.
+	toxic.Latency = 10000
+	go func() {
+		input <- &stream.StreamChunk{Data: slow}
+		stub.Interrupt <- struct{}{}
+	}()
+	select {
+	case c := <-output:
+		if !bytes.Equal(c.Data, slow) {
+			t.Errorf("Expected the delayed query after the interrupt, got %q", c.Data)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for the delayed query")
+	}
+	<-done
+}
+
+func TestOtherDataPassesThrough(t *testing.T) {
+	data := [][]byte{[]byte("hello"), bytes.Repeat([]byte{0}, 12)}
+	for _, toxic := range []toxics.Toxic{
+		&dns.ErrorToxic{},
+		&dns.RewriteToxic{},
+		&dns.TruncateToxic{},
+		&dns.LatencyToxic{LatencyToxic: toxics.LatencyToxic{Latency: 10000}},
+	} {
+		out, reply := runToxic(t, toxic, data...)
+		if len(out) != 2 || !bytes.Equal(out[0], data[0]) || !bytes.Equal(out[1], data[1]) {
+			t.Errorf("Expected the data to pass through %T, got %q", toxic, out)
+		}
+		if len(reply) != 0 {
+			t.Errorf("Expected no reply from %T, got %q", toxic, reply)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package toxics
+
+import "math/rand"
+
+// The DropToxic discards chunks of data while keeping the connection open, like
+// packet loss. Each chunk is dropped with the given probability. With
+// burst_start set, losses come in bursts following a Gilbert-Elliott model: the
+// link switches between a good state, where chunks are dropped with
+// probability, and a bad state, where only burst_keep of the chunks get through.
+type DropToxic struct {
+	// Probabilities between 0 and 1
+	Probability float64 `json:"probability"`
+	BurstStart  float64 `json:"burst_start"` // From good to bad, for every chunk
+	BurstEnd    float64 `json:"burst_end"`   // From bad to good, for every chunk
+	BurstKeep   float64 `json:"burst_keep"`  // Chunks that get through in the bad state
+}
+
+type DropToxicState struct {
+	bad bool
+}
+
+// drop decides whether a chunk is dropped, then moves to the state of the next
+// chunk.
+func (t *DropToxic) drop(r *rand.Rand, state *DropToxicState) bool {
+	var drop bool
+	if state.bad {
+		drop = r.Float64() >= t.BurstKeep
+		state.bad = r.Float64() >= t.BurstEnd
+	} else {
+		drop = r.Float64() < t.Probability
+		state.bad = r.Float64() < t.BurstStart
+	}
+	return drop
+}
+
+func (t *DropToxic) Pipe(stub *ToxicStub) {
+	state := stub.State.(*DropToxicState)
+
+	for {
+		select {
+		case <-stub.Interrupt:
+			return
+		case c := <-stub.Input:
+			if c == nil {
+				stub.Close()
+				return
+			}
+			if !t.drop(stub.Rand, state) {
+				stub.Output <- c
+			}
+		}
+	}
+}
+
+func (t *DropToxic) NewState() interface{} {
+	return new(DropToxicState)
+}
+
+func init() {
+	Register("drop", new(DropToxic))
+}
@@ -0,0 +1,95 @@
+package toxics_test
+
+import (
+	"testing"
+
+	"github.com/Shopify/toxiproxy/v2/stream"
+	"github.com/Shopify/toxiproxy/v2/toxics"
+)
+
+// dropPattern sends n chunks through the drop toxic, and returns which of them
+// were dropped.
+func dropPattern(toxic *toxics.DropToxic, seed int64, n int) []bool {
+	input := make(chan *stream.StreamChunk)
+	output := make(chan *stream.StreamChunk, n)
+	stub := toxics.NewToxicStub(input, output)
+	stub.State = toxic.NewState()
+	stub.Rand = toxics.NewRand(seed)
+
+	go toxic.Pipe(stub)
+
+	for i := 0; i < n; i++ {
+		input <- &stream.StreamChunk{Data: []byte{byte(i % 256)}}
+	}
+	close(input)
+
+	dropped := make([]bool, n)
+	for i := range dropped {
+		dropped[i] = true
+	}
+	i := 0
+	for c := range output {
+		for byte(i%256) != c.Data[0] {
+			i++
+		}
+		dropped[i] = false
+		i++
+	}
+	return dropped
+}
+
+func countDropped(dropped []bool) int {
+	count := 0
+	for _, d := range dropped {
+		if d {
+			count++
+		}
+	}
+	return count
+}
+
+func TestDropToxicProbability(t *testing.T) {
+	for _, probability := range []float64{0, 1} {
+		dropped := dropPattern(&toxics.DropToxic{Probability: probability}, 1, 100)
+		if n := countDropped(dropped); n != int(probability*100) {
+			t.Errorf("Expected %d chunks to be dropped, got %d", int(probability*100), n)
+		}
+	}
+
+	dropped := dropPattern(&toxics.DropToxic{Probability: 0.3}, 1, 1000)
+	if n := countDropped(dropped); n < 200 || n > 400 {
+		t.Error("Expected about 300 chunks to be dropped, got", n)
+	}
+}
+
+func TestDropToxicIsReproducible(t *testing.T) {
+	toxic := &toxics.DropToxic{Probability: 0.5}
+	first := dropPattern(toxic, 42, 200)
+	second := dropPattern(toxic, 42, 200)
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatal("Expected the same seed to drop the same chunks, differs at", i)
+		}
+	}
+}
+
+func TestDropToxicBursts(t *testing.T) {
+	toxic := &toxics.DropToxic{BurstStart: 0.05, BurstEnd: 0.2}
+	dropped := dropPattern(toxic, 1, 2000)
+
+	bursts := 0
+	for i, d := range dropped {
+		if d && (i == 0 || !dropped[i-1]) {
+			bursts++
+		}
+	}
+	if bursts == 0 {
+		t.Fatal("Expected chunks to be dropped in bursts")
+	}
+
+	// Bursts last 1 / burst_end chunks on average.
+	average := float64(countDropped(dropped)) / float64(bursts)
+	if average < 3 || average > 7 {
+		t.Errorf("Expected bursts of about 5 chunks, got %.1f", average)
+	}
+}
@@ -0,0 +1,49 @@
+package toxics
+
+import (
+	"errors"
+	"math/rand"
+	"time"
+)
+
+var ErrInvalidFlap = errors.New("invalid toxic flap")
+
+// A Flap turns a toxic on and off while it stays on the proxy, like a link that
+// is bad for 5 seconds every 30 seconds. The times are given either with on and
+// off, or with a period and the part of it the toxic is on. Random flaps use on
+// and off as the mean times, with every time drawn from an exponential
+// distribution.
+type Flap struct {
+	On     int64   `json:"on"`               // Time in milliseconds
+	Off    int64   `json:"off"`              // Time in milliseconds
+	Period int64   `json:"period,omitempty"` // Time in milliseconds
+	Duty   float64 `json:"duty,omitempty"`   // Between 0 and 1
+	Random bool    `json:"random,omitempty"`
+}
+
+// Normalize validates the flap and fills in on and off from the period.
+func (f *Flap) Normalize() error {
+	if f.Period > 0 {
+		if f.Duty <= 0 || f.Duty >= 1 {
+			return ErrInvalidFlap
+		}
+		f.On = int64(float64(f.Period) * f.Duty)
+		f.Off = f.Period - f.On
+	}
+	if f.On <= 0 || f.Off <= 0 {
+		return ErrInvalidFlap
+	}
+	return nil
+}
+
+// Next returns how long the toxic stays on, or off, before flapping again.
+func (f *Flap) Next(on bool, r *rand.Rand) time.Duration {
+	mean := f.Off
+	if on {
+		mean = f.On
+	}
+	if f.Random {
+		return time.Duration(r.ExpFloat64() * float64(mean) * float64(time.Millisecond))
+	}
+	return time.Duration(mean) * time.Millisecond
+}
@@ -0,0 +1,51 @@
+package toxics_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Shopify/toxiproxy/v2/toxics"
+)
+
+func TestFlapNormalize(t *testing.T) {
+	flap := &toxics.Flap{Period: 30000, Duty: 0.25}
+	if err := flap.Normalize(); err != nil {
+		t.Fatal("Expected flap to be valid, got", err)
+	}
+	if flap.On != 7500 || flap.Off != 22500 {
+		t.Errorf("Expected 7500ms on and 22500ms off, got %d and %d", flap.On, flap.Off)
+	}
+
+	for _, invalid := range []toxics.Flap{
+		{},
+		{On: 100},
+		{Off: 100},
+		{On: -1, Off: 100},
+		{Period: 100},
+		{Period: 100, Duty: 1},
+	} {
+		if err := invalid.Normalize(); err != toxics.ErrInvalidFlap {
+			t.Errorf("Expected %+v to be invalid, got %v", invalid, err)
+		}
+	}
+}
+
+func TestFlapNext(t *testing.T) {
+	flap := &toxics.Flap{On: 100, Off: 300}
+	r := toxics.NewRand(1)
+	if next := flap.Next(true, r); next != 100*time.Millisecond {
+		t.Errorf("Expected to stay on for 100ms, got %v", next)
+	}
+	if next := flap.Next(false, r); next != 300*time.Millisecond {
+		t.Errorf("Expected to stay off for 300ms, got %v", next)
+	}
+
+	flap.Random = true
+	var on, off time.Duration
+	for i := 0; i < 10000; i++ {
+		on += flap.Next(true, r)
+		off += flap.Next(false, r)
+	}
+	AssertDeltaTime(t, "Mean on", on/10000, 100*time.Millisecond, 5*time.Millisecond)
+	AssertDeltaTime(t, "Mean off", off/10000, 300*time.Millisecond, 15*time.Millisecond)
+}
@@ -0,0 +1,35 @@
+package http
+
+import (
+	"io"
+
+	"github.com/Shopify/toxiproxy/v2/toxics"
+)
+
+// HeadersToxic sets and removes headers of matching messages.
+type HeadersToxic struct {
+	Match
+	SetHeaders    map[string]string `json:"set_headers"`
+	RemoveHeaders []string          `json:"remove_headers"`
+}
+
+func (t *HeadersToxic) Pipe(stub *toxics.ToxicStub) {
+	pipe(stub, &t.Match, func(stub *toxics.ToxicStub, w io.Writer, m *message) bool {
+		for _, name := range t.RemoveHeaders {
+			m.del(name, 0)
+		}
+		for name, value := range t.SetHeaders {
+			m.set(name, value)
+		}
+		toxics.Send(w, m.bytes())
+		return true
+	})
+}
+
+func (t *HeadersToxic) NewState() interface{} {
+	return new(state)
+}
+
+func init() {
+	toxics.Register("http_headers", new(HeadersToxic))
+}
@@ -0,0 +1,205 @@
+// Package http provides toxics that parse the HTTP/1.x messages of a stream,
+// to reply with a status, delay, rewrite or truncate the messages that match.
+//
+// Requests are read on the upstream stream and responses on the downstream
+// stream. Only the heads of messages are held to be matched, and bodies pass
+// through as they arrive. Data that isn't HTTP passes through untouched, like
+// the data of a stream upgraded to a WebSocket or tunneled by CONNECT.
+package http
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/Shopify/toxiproxy/v2/stream"
+	"github.com/Shopify/toxiproxy/v2/toxics"
+)
+
+// Match selects the messages a toxic applies to. Empty fields match any message.
+// Responses don't have a method or path, so they only match on headers.
+type Match struct {
+	Method  string            `json:"method"`
+	Path    string            `json:"path"`    // Regular expression
+	Headers map[string]string `json:"headers"` // Regular expressions of the values
+}
+
+type matcher struct {
+	method  string
+	path    *regexp.Regexp
+	headers map[string]*regexp.Regexp
+	invalid bool
+
+	body *body // The rest of a message that doesn't match, for a stream
+}
+
+// compile compiles the regular expressions of the match. A match with an
+// invalid expression doesn't match any message.
+func (m *Match) compile() *matcher {
+	c := &matcher{
+		method:  m.Method,
+		headers: make(map[string]*regexp.Regexp, len(m.Headers)),
+	}
+
+	var err error
+	if m.Path != "" {
+		c.path, err = regexp.Compile(m.Path)
+		c.invalid = err != nil
+	}
+	for name, value := range m.Headers {
+		c.headers[name], err = regexp.Compile(value)
+		c.invalid = c.invalid || err != nil
+	}
+	return c
+}
+
+func (c *matcher) matches(m *message) bool {
+	if c.invalid {
+		return false
+	}
+	if c.method != "" && !strings.EqualFold(c.method, m.method) {
+		return false
+	}
+	if c.path != nil && (!m.request() || !c.path.MatchString(m.path())) {
+		return false
+	}
+	for name, value := range c.headers {
+		header, ok := m.get(name)
+		if !ok || !value.MatchString(header) {
+			return false
+		}
+	}
+	return true
+}
+
+// state is kept by the stub between runs of a toxic, for the body that was
+// being read.
+type state struct {
+	body     *body
+	requests int // Requests forwarded, which the server responds to
+	latency  toxics.LatencyToxicState
+}
+
+// stateOf returns the state the stub keeps for the toxic.
+func stateOf(stub *toxics.ToxicStub) *state {
+	st, ok := stub.State.(*state)
+	if !ok {
+		st = new(state)
+		stub.State = st
+	}
+	return st
+}
+
+// Follow counts the requests that don't match.
+func (s *state) Follow(data []byte) bool {
+	m, err := (&reader{buf: bufio.NewReader(bytes.NewReader(data))}).head()
+	if err == nil {
+		s.forward(m)
+	}
+	return true
+}
+
+// pipe reads the messages of the stub's input, and calls apply with the heads
+// of the ones that match. apply writes the head out itself, and returns false
+// if the toxic must stop, after closing the stub if it has to. Bodies pass
+// through as they arrive, unless apply dropped or cut them. Other messages pass
+// through untouched.
+func pipe(
+	stub *toxics.ToxicStub,
+	match *Match,
+	apply func(stub *toxics.ToxicStub, w io.Writer, m *message) bool,
+) {
+	st := stateOf(stub)
+	matcher := match.compile()
+	input := toxics.NewMessageReader(stub)
+	r := &reader{buf: input.Reader}
+	w := stream.NewChanWriter(stub.Output)
+	out := &bodyWriter{w, st}
+
+	var m *message
+	next := func() (bool, error) {
+		if b := st.body; b != nil && b.cut && (b.ended || b.limit <= 0) {
+			// The stream is closed, without the data after the cut.
+			b.limit = 0
+			return false, io.EOF
+		} else if b != nil && b.ended {
+			st.body = nil
+		}
+
+		var err error
+		m = nil
+		if st.body != nil {
+			err = r.pass(st.body)
+		} else {
+			m, err = r.head()
+		}
+		if err == errMalformed {
+			// Data that isn't HTTP passes through, and the next message is read
+			// after it.
+			st.body = nil
+			return false, nil
+		}
+		return m != nil, err
+	}
+
+	stopped := input.Pipe(out, next, func(data []byte) bool {
+		var err error
+		st.body, err = framing(m)
+		if err != nil || !matcher.matches(m) || input.Resumed() {
+			st.forward(m)
+			toxics.Send(out, data)
+			return true
+		}
+
+		more := apply(stub, w, m)
+		if !m.drop {
+			st.forward(m)
+		}
+		if m.drop && m.request() && m.upgrade() {
+			// The stream isn't upgraded by a request the server didn't get.
+			st.body = nil
+		} else if st.body != nil {
+			st.body.drop = m.drop
+		}
+		if m.cut {
+			if st.body == nil {
+				st.body = &body{ended: true}
+			}
+			st.body.cut, st.body.limit = true, m.limit
+		}
+		return more
+	})
+	if stopped {
+		input.Flush(out)
+	}
+}
+
+// forward counts a message forwarded, if it is a request.
+func (s *state) forward(m *message) {
+	if m.request() {
+		s.requests++
+	}
+}
+
+// bodyWriter writes the data of the body being read, unless it is dropped, and
+// only up to the limit of a cut body. Without a body, data is written as is.
+type bodyWriter struct {
+	w  io.Writer
+	st *state
+}
+
+func (b *bodyWriter) Write(data []byte) (int, error) {
+	n := len(data)
+	body := b.st.body
+	if body != nil && body.drop {
+		return n, nil
+	}
+	if body != nil && body.cut {
+		data = data[:min(int64(len(data)), body.limit)]
+		body.limit -= int64(len(data))
+	}
+	toxics.Send(b.w, data)
+	return n, nil
+}
@@ -0,0 +1,406 @@
+package http_test
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Shopify/toxiproxy/v2/stream"
+	"github.com/Shopify/toxiproxy/v2/testhelper"
+	"github.com/Shopify/toxiproxy/v2/toxics"
+	"github.com/Shopify/toxiproxy/v2/toxics/http"
+)
+
+const (
+	checkout = "POST /checkout?id=1 HTTP/1.1\r\nHost: shop\r\nContent-Length: 4\r\n\r\nbody"
+	products = "GET /products HTTP/1.1\r\nHost: shop\r\nAccept: text/html\r\n\r\n"
+	ok       = "HTTP/1.1 200 OK\r\nContent-Length: 5\r\nServer: shop\r\n\r\nhello"
+	chunked  = "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n" +
+		"5\r\nhello\r\n6\r\n world\r\n0\r\n\r\n"
+)
+
+// pipeHttp sends the data through the toxic in small chunks, so messages are
+// split between chunks, and returns what came out and what was replied.
+func pipeHttp(t *testing.T, toxic toxics.Toxic, data string) (string, string) {
+	input := make(chan *stream.StreamChunk)
+	output := make(chan *stream.StreamChunk)
+	stub := toxics.NewToxicStub(input, output)
+	reply := new(bytes.Buffer)
+	stub.Reply = reply
+
+	go toxic.Pipe(stub)
+	go func() {
+		for len(data) > 0 {
+			n := min(len(data), 7)
+			input <- &stream.StreamChunk{Data: []byte(data[:n])}
+			data = data[n:]
+		}
+		close(input)
+	}()
+
+	var out bytes.Buffer
+	for {
+		select {
+		case c, ok := <-output:
+			if !ok {
+				return out.String(), reply.String()
+			}
+			out.Write(c.Data)
+		case <-time.After(time.Second):
+			t.Fatal("Timed out waiting for the toxic to close")
+		}
+	}
+}
+
+// readHttp reads n bytes of the output, which can come in several chunks.
+func readHttp(output <-chan *stream.StreamChunk, n int) string {
+	var out bytes.Buffer
+	for out.Len() < n {
+		c, ok := <-output
+		if !ok {
+			break
+		}
+		out.Write(c.Data)
+	}
+	return out.String()
+}
+
+func TestStatusToxicRepliesToMatchingRequests(t *testing.T) {
+	toxic := &http.StatusToxic{
+		Match:      http.Match{Method: "post", Path: "^/checkout$"},
+		Status:     429,
+		Body:       "slow down",
+		SetHeaders: map[string]string{"Retry-After": "1"},
+	}
+	out, reply := pipeHttp(t, toxic, products+checkout+products)
+	if out != products+products {
+		t.Errorf("Expected only the other requests to be forwarded, got %q", out)
+	}
+
+	expected := "HTTP/1.1 429 Too Many Requests\r\nContent-Length: 9\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\nRetry-After: 1\r\n\r\nslow down"
+	if reply != expected {
+		t.Errorf("Expected reply %q, got %q", expected, reply)
+	}
+}
+
+func TestStatusToxicReplacesResponses(t *testing.T) {
+	toxic := &http.StatusToxic{
+		Match: http.Match{Headers: map[string]string{"Server": "^shop$"}},
+	}
+	out, reply := pipeHttp(t, toxic, chunked+ok)
+	expected := chunked + "HTTP/1.1 503 Service Unavailable\r\nContent-Length: 0\r\n\r\n"
+	if out != expected {
+		t.Errorf("Expected %q, got %q", expected, out)
+	}
+	if reply != "" {
+		t.Errorf("Expected no reply, got %q", reply)
+	}
+}
+
+func TestStatusToxicRejectsInterimStatuses(t *testing.T) {
+	toxic := &http.StatusToxic{Status: 100}
+	_, reply := pipeHttp(t, toxic, products)
+	expected := "HTTP/1.1 503 Service Unavailable\r\nContent-Length: 0\r\n\r\n"
+	if reply != expected {
+		t.Errorf("Expected reply %q, got %q", expected, reply)
+	}
+}
+
+func TestStatusToxicFramer(t *testing.T) {
+	responses := "HTTP/1.1 100 Continue\r\n\r\n" + ok + // Interim responses aren't counted
+		chunked +
+		"HTTP/1.1 204 No Content\r\nContent-Length: 0\r\n\r\n" +
+		"HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n" +
+		"3;ext=1\r\nabc\r\n0\r\nX-Trailer: 1\r\n\r\n"
+	expected := []int{1, 2, 3, 4}
+
+	// The end of every response is found, whether it is read at once or split.
+	framer := new(http.StatusToxic).NewFramer().(toxics.Sequencer)
+	var positions []int
+	for data := []byte(responses); len(data) > 0; {
+		data = data[framer.Next(data):]
+		if !framer.Boundary() {
+			t.Fatalf("Expected a boundary before %q", data)
+		}
+		positions = append(positions, framer.Position())
+	}
+	if !reflect.DeepEqual(positions, expected) {
+		t.Errorf("Expected the positions %v, got %v", expected, positions)
+	}
+
+	framer = new(http.StatusToxic).NewFramer().(toxics.Sequencer)
+	positions = nil
+	for _, b := range []byte(responses) {
+		framer.Next([]byte{b})
+		if framer.Boundary() {
+			positions = append(positions, framer.Position())
+		}
+	}
+	if !reflect.DeepEqual(positions, expected) {
+		t.Errorf("Expected the positions %v split, got %v", expected, positions)
+	}
+
+	// Replies aren't held back once the stream is upgraded.
+	framer.Next([]byte("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\n\r\n"))
+	if !framer.Boundary() || framer.Position() != math.MaxInt {
+		t.Errorf("Expected an upgrade to let replies through, got %d", framer.Position())
+	}
+}
+
+func TestHeadersToxic(t *testing.T) {
+	toxic := &http.HeadersToxic{
+		Match:         http.Match{Path: "^/products"},
+		SetHeaders:    map[string]string{"host": "other", "X-Toxic": "1"},
+		RemoveHeaders: []string{"Accept"},
+	}
+	out, _ := pipeHttp(t, toxic, checkout+products)
+	expected := checkout + "GET /products HTTP/1.1\r\nHost: other\r\nX-Toxic: 1\r\n\r\n"
+	if out != expected {
+		t.Errorf("Expected %q, got %q", expected, out)
+	}
+}
+
+func TestHeadersToxicKeepsData(t *testing.T) {
+	// Data that isn't HTTP passes through, and messages after it still match.
+	data := "garbage\r\nmore garbage" + "\r\n" + products + "\r\n"
+	toxic := &http.HeadersToxic{SetHeaders: map[string]string{"Accept": "*/*"}}
+	out, _ := pipeHttp(t, toxic, data)
+	expected := "garbage\r\nmore garbage\r\n" +
+		"GET /products HTTP/1.1\r\nHost: shop\r\nAccept: */*\r\n\r\n\r\n"
+	if out != expected {
+		t.Errorf("Expected %q, got %q", expected, out)
+	}
+
+	// An incomplete message is sent as is when the stream ends.
+	out, _ = pipeHttp(t, toxic, checkout[:40])
+	if out != checkout[:40] {
+		t.Errorf("Expected %q, got %q", checkout[:40], out)
+	}
+}
+
+func TestTruncateToxic(t *testing.T) {
+	toxic := &http.TruncateToxic{Bytes: 13}
+	out, _ := pipeHttp(t, toxic, chunked+ok)
+	expected := "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n6\r\n"
+	if out != expected {
+		t.Errorf("Expected %q, got %q", expected, out)
+	}
+
+	// Responses without a length are read until the end of the stream.
+	toxic = &http.TruncateToxic{Bytes: 4, Match: http.Match{Method: "GET"}}
+	response := "HTTP/1.0 200 OK\r\n\r\nuntil the end"
+	out, _ = pipeHttp(t, toxic, response)
+	if out != response {
+		t.Errorf("Expected responses not to match a method, got %q", out)
+	}
+}
+
+func TestLatencyToxicDelaysMatchingMessages(t *testing.T) {
+	input := make(chan *stream.StreamChunk)
+	output := make(chan *stream.StreamChunk)
+	stub := toxics.NewToxicStub(input, output)
+	toxic := &http.LatencyToxic{
+		Match:        http.Match{Path: "^/checkout"},
+		LatencyToxic: toxics.LatencyToxic{Latency: 100},
+	}
+	go toxic.Pipe(stub)
+
+	start := time.Now()
+	input <- &stream.StreamChunk{Data: []byte(products)}
+	if c := <-output; string(c.Data) != products || time.Since(start) > 50*time.Millisecond {
+		t.Errorf("Expected %q right away, got %q after %s", products, c.Data, time.Since(start))
+	}
+
+	start = time.Now()
+	input <- &stream.StreamChunk{Data: []byte(checkout)}
+	if out := readHttp(output, len(checkout)); out != checkout {
+		t.Errorf("Expected %q, got %q", checkout, out)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("Expected the request to be delayed, it took %s", elapsed)
+	}
+
+	// Interrupting the toxic sends a delayed message right away.
+	input = make(chan *stream.StreamChunk)
+	output = make(chan *stream.StreamChunk)
+	stub = toxics.NewToxicStub(input, output)
+	toxic.Latency = 10000
+	go toxic.Pipe(stub)
+	input <- &stream.StreamChunk{Data: []byte(checkout[:20])}
+	input <- &stream.StreamChunk{Data: []byte(checkout[20:])}
+	stub.Interrupt <- struct{}{}
+	if out := readHttp(output, len(checkout)); out != checkout {
+		t.Errorf("Expected %q after the interrupt, got %q", checkout, out)
+	}
+}
+
+// httpStub runs a toxic on a stub, to check what comes out as data goes in.
+type httpStub struct {
+	input  chan *stream.StreamChunk
+	output chan *stream.StreamChunk
+}
+
+func startHttp(toxic toxics.Toxic, match *toxics.Match) *httpStub {
+	s := &httpStub{make(chan *stream.StreamChunk), make(chan *stream.StreamChunk, 10)}
+	stub := toxics.NewToxicStub(s.input, s.output)
+	stub.State = toxic.(toxics.StatefulToxic).NewState()
+	go stub.Run(&toxics.ToxicWrapper{Toxic: toxic, Toxicity: 1, Match: match})
+	return s
+}
+
+// expect sends the data, and checks that the expected data comes out without
+// waiting for more input.
+func (s *httpStub) expect(t *testing.T, data, expected string) {
+	t.Helper()
+	s.input <- &stream.StreamChunk{Data: []byte(data)}
+	var out bytes.Buffer
+	for out.Len() < len(expected) {
+		select {
+		case c := <-s.output:
+			out.Write(c.Data)
+		case <-time.After(time.Second):
+			t.Fatalf("Expected %q to come out, got %q", expected, out.String())
+		}
+	}
+	if out.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, out.String())
+	}
+}
+
+func TestBodiesPassThroughAsTheyArrive(t *testing.T) {
+	events := "HTTP/1.1 200 OK\r\nContent-Type: text/event-stream\r\n\r\n"
+	post := testhelper.NewMatch(t, `{"protocol": "http", "method": "POST"}`)
+	for _, match := range []*toxics.Match{nil, post} {
+		s := startHttp(&http.StatusToxic{Match: http.Match{Method: "POST"}}, match)
+		s.expect(t, events, events)
+		s.expect(t, "data: 1\n\n", "data: 1\n\n")
+		s.expect(t, "data: 2\n\n", "data: 2\n\n")
+		close(s.input)
+
+		s = startHttp(&http.StatusToxic{Match: http.Match{Method: "POST"}}, match)
+		s.expect(t, chunked[:50], chunked[:50])
+		s.expect(t, chunked[50:60], chunked[50:60])
+		close(s.input)
+	}
+}
+
+func TestHugeBodiesAreNotAllocated(t *testing.T) {
+	head := "POST /upload HTTP/1.1\r\nContent-Length: 9223372036854775807\r\n\r\n"
+	toxic := &http.HeadersToxic{SetHeaders: map[string]string{"X-Toxic": "1"}}
+	s := startHttp(toxic, nil)
+	expected := "POST /upload HTTP/1.1\r\nContent-Length: 9223372036854775807\r\n" +
+		"X-Toxic: 1\r\n\r\n"
+	s.expect(t, head, expected)
+	s.expect(t, "data", "data")
+	close(s.input)
+
+	// Matching messages with bodies too large to be read with them pass through.
+	s = startHttp(&toxics.DropToxic{Probability: 1}, testhelper.NewMatch(t, `{"protocol": "http"}`))
+	s.expect(t, head, head)
+	s.expect(t, "data", "data")
+	close(s.input)
+
+	s = startHttp(&toxics.DropToxic{Probability: 1}, testhelper.NewMatch(t, `{"protocol": "http"}`))
+	size := "100001\r\n" // A chunk over 1MB
+	big := "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n" + size +
+		strings.Repeat("a", 0x100001) + "\r\n"
+	s.expect(t, big, big)
+	s.expect(t, "0\r\n\r\n"+products, "0\r\n\r\n")
+	close(s.input)
+}
+
+func TestUpgradedStreamsPassThrough(t *testing.T) {
+	upgrade := "GET /chat HTTP/1.1\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n"
+	switched := "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\n\r\n"
+	put := "PUT /cart HTTP/1.1\r\nContent-Length: 0\r\n\r\n"
+	toxic := &http.StatusToxic{Match: http.Match{Method: "PUT"}}
+	puts := testhelper.NewMatch(t, `{"protocol": "http", "method": "PUT"}`)
+	for _, match := range []*toxics.Match{nil, puts} {
+		if match != nil {
+			toxic = &http.StatusToxic{}
+		}
+
+		// Data that can't start a message isn't held.
+		s := startHttp(toxic, match)
+		s.expect(t, "\x81\x05hello", "\x81\x05hello")
+		s.expect(t, products+put, products)
+		close(s.input)
+
+		// Nothing is parsed after the stream is upgraded.
+		for _, head := range []string{upgrade, switched, "CONNECT shop:443 HTTP/1.1\r\n\r\n"} {
+			s = startHttp(toxic, match)
+			s.expect(t, head, head)
+			s.expect(t, "GET /", "GET /")
+			s.expect(t, put, put)
+			close(s.input)
+		}
+	}
+
+	// A request that is replied to doesn't upgrade the stream.
+	s := startHttp(&http.StatusToxic{Match: http.Match{Path: "^/chat$"}}, nil)
+	s.expect(t, upgrade+put+products, put+products)
+	close(s.input)
+}
+
+func TestInvalidMatchDoesNotMatch(t *testing.T) {
+	toxic := &http.StatusToxic{Match: http.Match{Path: "("}}
+	out, reply := pipeHttp(t, toxic, checkout)
+	if out != checkout || reply != "" {
+		t.Errorf("Expected the request to pass through, got %q and reply %q", out, reply)
+	}
+}
+
+func TestProtocolKeepsDataOnInterrupt(t *testing.T) {
+	match := testhelper.NewMatch(t, `{"protocol": "http", "method": "POST"}`)
+	toxic := &toxics.LatencyToxic{Latency: 10000}
+	wrapper := &toxics.ToxicWrapper{Toxic: toxic, Toxicity: 1, Match: match}
+
+	input := make(chan *stream.StreamChunk)
+	output := make(chan *stream.StreamChunk, 10)
+	stub := toxics.NewToxicStub(input, output)
+	stub.State = toxic.NewState()
+	go stub.Run(wrapper)
+
+	// The messages read after the delayed one are sent when it is interrupted.
+	input <- &stream.StreamChunk{Data: []byte(checkout + products + checkout[:10])}
+	stub.InterruptToxic()
+	go stub.Run(wrapper)
+	input <- &stream.StreamChunk{Data: []byte(checkout[10:] + products)}
+	close(input)
+
+	var out bytes.Buffer
+	for c := range output {
+		out.Write(c.Data)
+	}
+	if out.String() != checkout+products+checkout+products {
+		t.Errorf("Expected every message after the interrupt, got %q", out.String())
+	}
+}
+
+func TestProtocolMatchesRequests(t *testing.T) {
+	match := testhelper.NewMatch(t, `{"protocol": "http", "method": "POST"}`)
+	toxic := &toxics.DropToxic{Probability: 1}
+	wrapper := &toxics.ToxicWrapper{Toxic: toxic, Toxicity: 1, Match: match}
+
+	input := make(chan *stream.StreamChunk)
+	output := make(chan *stream.StreamChunk, 10)
+	stub := toxics.NewToxicStub(input, output)
+	stub.State = toxic.NewState()
+	go stub.Run(wrapper)
+	for _, data := range []string{products, checkout[:30], checkout[30:], products} {
+		input <- &stream.StreamChunk{Data: []byte(data)}
+	}
+	close(input)
+
+	var out bytes.Buffer
+	for c := range output {
+		out.Write(c.Data)
+	}
+	if out.String() != products+products {
+		t.Errorf("Expected the POST to be dropped, got %q", out.String())
+	}
+}
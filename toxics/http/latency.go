@@ -0,0 +1,41 @@
+package http
+
+import (
+	"io"
+	"time"
+
+	"github.com/Shopify/toxiproxy/v2/toxics"
+)
+
+// LatencyToxic delays matching messages, and lets the others through right
+// away. The body of a message follows its head once it is sent. The delays are
+// drawn like the ones of the latency toxic.
+type LatencyToxic struct {
+	Match
+	toxics.LatencyToxic
+}
+
+func (t *LatencyToxic) Pipe(stub *toxics.ToxicStub) {
+	st := stateOf(stub)
+	pipe(stub, &t.Match, func(stub *toxics.ToxicStub, w io.Writer, m *message) bool {
+		timer := time.NewTimer(t.Delay(stub.Rand, &st.latency))
+		defer timer.Stop()
+
+		select {
+		case <-stub.Interrupt:
+			toxics.Send(w, m.bytes())
+			return false
+		case <-timer.C:
+			toxics.Send(w, m.bytes())
+			return true
+		}
+	})
+}
+
+func (t *LatencyToxic) NewState() interface{} {
+	return new(state)
+}
+
+func init() {
+	toxics.Register("http_latency", new(LatencyToxic))
+}
@@ -0,0 +1,442 @@
+package http
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	nethttp "net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/Shopify/toxiproxy/v2/toxics"
+)
+
+var errMalformed = errors.New("malformed http message")
+
+var (
+	requestLine = regexp.MustCompile(`^([A-Za-z]+) (\S+) HTTP/\d\.\d$`)
+	statusLine  = regexp.MustCompile(`^HTTP/\d\.\d (\d{3})(?: .*)?$`)
+)
+
+// Limits of the messages read. Longer lines or heads are malformed, so they
+// aren't held.
+const (
+	maxLine   = 64 << 10
+	maxHead   = 1 << 20
+	maxMethod = 20
+)
+
+// message is the head of an HTTP/1.x request or response. Its body is read on
+// its own, a piece at a time, so it passes through as it arrives.
+type message struct {
+	line   string // The request or status line
+	header []field
+	body   []byte // The body of responses that are built, sent with the head
+
+	method string // Empty for responses
+	target string // Empty for responses
+	status int    // 0 for requests
+
+	// Set by toxics, to change how the body is sent.
+	drop  bool // The message isn't sent, and neither is its body
+	cut   bool // The stream is closed after limit bytes of the body, or at its end
+	limit int64
+}
+
+type field struct {
+	name, value string
+}
+
+func (m *message) request() bool {
+	return m.method != ""
+}
+
+// upgrade reports whether the stream switches to another protocol after the
+// message, like a WebSocket or a tunnel.
+func (m *message) upgrade() bool {
+	if m.request() {
+		_, ok := m.get("Upgrade")
+		return ok || strings.EqualFold(m.method, "CONNECT")
+	}
+	return m.status == 101
+}
+
+// path returns the path of the request target, without its query.
+func (m *message) path() string {
+	path, _, _ := strings.Cut(m.target, "?")
+	return path
+}
+
+// get returns the value of the first header with the given name.
+func (m *message) get(name string) (string, bool) {
+	for _, f := range m.header {
+		if strings.EqualFold(f.name, name) {
+			return f.value, true
+		}
+	}
+	return "", false
+}
+
+// set replaces the headers with the given name by one with the value.
+func (m *message) set(name, value string) {
+	for i, f := range m.header {
+		if strings.EqualFold(f.name, name) {
+			m.header[i].value = value
+			m.del(name, i+1)
+			return
+		}
+	}
+	m.header = append(m.header, field{name, value})
+}
+
+// del removes the headers with the given name, from index i on.
+func (m *message) del(name string, i int) {
+	header := m.header[:i]
+	for _, f := range m.header[i:] {
+		if !strings.EqualFold(f.name, name) {
+			header = append(header, f)
+		}
+	}
+	m.header = header
+}
+
+// truncate closes the stream after n bytes of the body, or at its end.
+func (m *message) truncate(n int64) {
+	m.cut = true
+	m.limit = n
+	if n < 0 {
+		m.limit = math.MaxInt64
+	}
+}
+
+func (m *message) bytes() []byte {
+	var buf bytes.Buffer
+	buf.WriteString(m.line + "\r\n")
+	for _, f := range m.header {
+		buf.WriteString(f.name + ": " + f.value + "\r\n")
+	}
+	buf.WriteString("\r\n")
+	buf.Write(m.body)
+	return buf.Bytes()
+}
+
+// response builds a response with the given status, headers and body.
+func response(status int, header map[string]string, body string) *message {
+	m := &message{
+		line:   fmt.Sprintf("HTTP/1.1 %d %s", status, nethttp.StatusText(status)),
+		body:   []byte(body),
+		status: status,
+	}
+	m.set("Content-Length", strconv.Itoa(len(body)))
+	if body != "" {
+		m.set("Content-Type", "text/plain; charset=utf-8")
+	}
+	for name, value := range header {
+		m.set(name, value)
+	}
+	return m
+}
+
+// body is the rest of a message after its head, framed as the head says. It is
+// only changed once a piece is read, so a piece read again after an interrupt
+// is read the same way.
+type body struct {
+	left    int64 // Bytes left of the body, or of the current chunk and its CRLF
+	chunked bool
+	trailer bool // The last chunk was read, and the trailer is left
+	close   bool // The body ends with the stream
+	ended   bool
+
+	drop  bool
+	cut   bool
+	limit int64 // Bytes left to send, for a cut body
+}
+
+// framing returns the body of a message, or nil if it has none. After a message
+// that upgrades the stream, the rest of the stream is read as its body, so data
+// that isn't HTTP passes through. Responses to HEAD requests can't be told
+// apart, so they are expected to have no framing.
+func framing(m *message) (*body, error) {
+	encoding, _ := m.get("Transfer-Encoding")
+	length, hasLength := m.get("Content-Length")
+	switch {
+	case m.upgrade():
+		return &body{close: true}, nil
+	case m.status >= 100 && m.status < 200, m.status == 204, m.status == 304:
+		return nil, nil
+	case strings.Contains(strings.ToLower(encoding), "chunked"):
+		return &body{chunked: true}, nil
+	case hasLength:
+		n, err := strconv.ParseInt(length, 10, 64)
+		if err != nil || n < 0 {
+			return nil, errMalformed
+		} else if n == 0 {
+			return nil, nil
+		}
+		return &body{left: n}, nil
+	case m.request():
+		return nil, nil
+	}
+	return &body{close: true}, nil
+}
+
+// reader parses the messages read by a toxics.MessageReader, and counts the
+// bytes it read.
+type reader struct {
+	buf  *bufio.Reader
+	read int64
+}
+
+// head reads the head of the next message. Data that doesn't start a message
+// returns errMalformed, so the reader can find the start of the next one. It is
+// read up to the end of its line, or as far as it is buffered if it can't be
+// the start of a request or status line, so it isn't held.
+func (r *reader) head() (*message, error) {
+	start, err := r.buf.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	start, _ = r.buf.Peek(r.buf.Buffered())
+	if !startsLine(start) {
+		n := bytes.IndexByte(start, '\n') + 1
+		if n == 0 {
+			n = len(start)
+		}
+		r.discard(n)
+		return nil, errMalformed
+	}
+
+	line, err := r.line()
+	if err != nil {
+		return nil, err
+	}
+	m := new(message)
+	m.line = line
+	if match := requestLine.FindStringSubmatch(line); match != nil {
+		m.method, m.target = match[1], match[2]
+	} else if match := statusLine.FindStringSubmatch(line); match != nil {
+		m.status, _ = strconv.Atoi(match[1])
+	} else {
+		return nil, errMalformed
+	}
+
+	size := len(line)
+	for {
+		line, err := r.line()
+		if err != nil {
+			return nil, toxics.Unexpected(err)
+		}
+		if line == "" {
+			return m, nil
+		}
+		name, value, ok := strings.Cut(line, ":")
+		size += len(line)
+		if !ok || size > maxHead {
+			return nil, errMalformed
+		}
+		m.header = append(m.header, field{name, strings.TrimSpace(value)})
+	}
+}
+
+// startsLine reports whether data can be the start of a request or status
+// line, a method followed by a space or an HTTP version.
+func startsLine(data []byte) bool {
+	for i, c := range data {
+		switch {
+		case c == ' ' && i > 0:
+			return true
+		case c == '/' && string(data[:i]) == "HTTP":
+			return true
+		case i == maxMethod || (c < 'A' || c > 'Z') && (c < 'a' || c > 'z'):
+			return false
+		}
+	}
+	return true
+}
+
+// pass reads a piece of the body, as much of it as is buffered, or a line of
+// its chunked framing. The data of a body that ends with the stream ends with
+// io.EOF.
+func (r *reader) pass(b *body) error {
+	switch {
+	case b.close || b.left > 0:
+		_, err := r.buf.Peek(1)
+		if err == io.EOF && b.close {
+			return err
+		} else if err != nil {
+			return toxics.Unexpected(err)
+		}
+		n := int64(r.buf.Buffered())
+		if !b.close {
+			n = min(n, b.left)
+			b.left -= n
+			b.ended = b.left == 0 && !b.chunked
+		}
+		r.discard(int(n))
+	case b.trailer:
+		line, err := r.line()
+		if err != nil {
+			return toxics.Unexpected(err)
+		}
+		b.ended = line == ""
+	default:
+		line, err := r.line()
+		if err != nil {
+			return toxics.Unexpected(err)
+		}
+		size, _, _ := strings.Cut(line, ";")
+		n, err := strconv.ParseInt(strings.TrimSpace(size), 16, 64)
+		if err != nil || n < 0 || n > math.MaxInt64-2 {
+			return errMalformed
+		}
+		if n == 0 {
+			b.trailer = true
+		} else {
+			b.left = n + 2 // The chunk ends with a CRLF
+		}
+	}
+	return nil
+}
+
+// line reads a line, without its line ending. Lines longer than maxLine are
+// malformed.
+func (r *reader) line() (string, error) {
+	var line []byte
+	for {
+		data, err := r.buf.ReadSlice('\n')
+		line = append(line, data...)
+		r.read += int64(len(data))
+		switch {
+		case err == bufio.ErrBufferFull && len(line) < maxLine:
+			continue
+		case err == bufio.ErrBufferFull:
+			return "", errMalformed
+		case err != nil:
+			return "", err
+		}
+		return strings.TrimRight(string(line), "\r\n"), nil
+	}
+}
+
+func (r *reader) discard(n int) {
+	r.buf.Discard(n) // #nosec G104 -- the data is buffered
+	r.read += int64(n)
+}
+
+// responseFramer follows where the responses of the server end, without
+// holding them, and counts them. Interim 1xx responses come before the final
+// response to a request, so they aren't counted. It implements
+// toxics.Sequencer, so replies are sent in the order of the requests. After a
+// response that upgrades the stream, or data that isn't HTTP, replies go
+// through right away. Responses to HEAD requests are expected to have no
+// framing, like by the reader.
+type responseFramer struct {
+	line      []byte // The head being read, or a line of a chunked body
+	body      *body
+	interim   bool // An interim response was read, and the final one is next
+	responses int
+	opaque    bool
+}
+
+func (f *responseFramer) Boundary() bool {
+	return f.opaque || (len(f.line) == 0 && f.body == nil && !f.interim)
+}
+
+func (f *responseFramer) Position() int {
+	if f.opaque {
+		return math.MaxInt
+	}
+	return f.responses
+}
+
+func (f *responseFramer) Next(data []byte) int {
+	n := 0
+	for n < len(data) && !f.opaque {
+		switch b := f.body; {
+		case b != nil && b.close:
+			return len(data)
+		case b != nil && b.left > 0:
+			k := min(int64(len(data)-n), b.left)
+			b.left -= k
+			n += int(k)
+			if b.left == 0 && !b.chunked {
+				f.done()
+			}
+		default:
+			end := bytes.IndexByte(data[n:], '\n')
+			if end < 0 {
+				f.read(data[n:])
+				return len(data)
+			}
+			f.read(data[n : n+end+1])
+			n += end + 1
+			if b == nil {
+				f.head()
+			} else {
+				f.chunk()
+			}
+		}
+		if f.Boundary() {
+			return n
+		}
+	}
+	return len(data)
+}
+
+// read adds data to the line being read. Heads longer than maxHead can't be
+// followed.
+func (f *responseFramer) read(data []byte) {
+	f.line = append(f.line, data...)
+	f.opaque = len(f.line) > maxHead
+}
+
+// head parses the head once it ended with an empty line.
+func (f *responseFramer) head() {
+	if !bytes.HasSuffix(f.line, []byte("\n\r\n")) && !bytes.HasSuffix(f.line, []byte("\n\n")) {
+		return
+	}
+	r := &reader{buf: bufio.NewReader(bytes.NewReader(f.line))}
+	m, err := r.head()
+	f.line = f.line[:0]
+	if err != nil || m.request() {
+		f.opaque = true
+		return
+	}
+
+	f.body, err = framing(m)
+	switch {
+	case err != nil:
+		f.opaque = true
+	case m.upgrade():
+		f.done()
+		f.opaque = true
+	case m.status < 200:
+		f.interim = true
+	case f.body == nil:
+		f.done()
+	}
+}
+
+// chunk parses a line of the framing of a chunked body.
+func (f *responseFramer) chunk() {
+	b := f.body
+	r := &reader{buf: bufio.NewReader(bytes.NewReader(f.line))}
+	err := r.pass(b)
+	f.line = f.line[:0]
+	if err != nil {
+		f.opaque = true
+	} else if b.ended {
+		f.done()
+	}
+}
+
+// done ends a final response.
+func (f *responseFramer) done() {
+	f.body = nil
+	f.interim = false
+	f.responses++
+}
@@ -0,0 +1,93 @@
+package http
+
+import (
+	"bufio"
+	"io"
+
+	"github.com/Shopify/toxiproxy/v2/toxics"
+)
+
+// Protocol scopes toxics to the HTTP messages that match, with the fields of a
+// Match.
+type Protocol struct{}
+
+func (Protocol) NewMatcher(decode func(interface{}) error) (toxics.Matcher, error) {
+	match := new(Match)
+	err := decode(match)
+	if err != nil {
+		return nil, err
+	}
+	matcher := match.compile()
+	if matcher.invalid {
+		return nil, toxics.ErrInvalidMatch
+	}
+	return matcher, nil
+}
+
+// maxBody is the most of the body of a matching message that is read with it.
+// Messages with larger bodies pass through untouched, like streamed responses.
+const maxBody = 1 << 20
+
+// NewStream copies the matcher, to keep the body being read on the stream.
+func (c *matcher) NewStream() toxics.Matcher {
+	stream := *c
+	return &stream
+}
+
+// Next reads the head of a message. The bodies of messages that don't match
+// are read a piece at a time after it, and pass through as they arrive, while
+// the body of a matching message is read with it.
+func (c *matcher) Next(r *bufio.Reader) (bool, error) {
+	in := &reader{buf: r}
+	if c.body != nil {
+		err := in.pass(c.body)
+		if err == errMalformed || c.body.ended {
+			c.body = nil
+		} else if err != nil {
+			return false, err
+		}
+		return false, nil
+	}
+
+	m, err := in.head()
+	if err == errMalformed {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	b, err := framing(m)
+	switch {
+	case err != nil:
+		return false, nil
+	case b == nil:
+		return c.matches(m), nil
+	case m.upgrade():
+		// The data after the message isn't HTTP, so it passes through.
+		c.body = b
+		return c.matches(m), nil
+	case !c.matches(m) || b.left > maxBody:
+		c.body = b
+		return false, nil
+	}
+
+	start := in.read
+	for !b.ended && in.read-start <= maxBody {
+		err = in.pass(b)
+		if err == io.EOF && b.close {
+			return true, nil
+		} else if err == errMalformed {
+			return false, nil
+		} else if err != nil {
+			return false, err
+		}
+	}
+	if !b.ended {
+		c.body = b
+		return false, nil
+	}
+	return true, nil
+}
+
+func init() {
+	toxics.RegisterProtocol("http", new(Protocol))
+}
@@ -0,0 +1,50 @@
+package http
+
+import (
+	"io"
+
+	"github.com/Shopify/toxiproxy/v2/toxics"
+)
+
+// StatusToxic replies to matching requests with a status instead of forwarding
+// them, like a 503 from an overloaded load balancer. The reply is sent once the
+// server responded to the requests before, so pipelined responses stay in
+// order. On the downstream stream, it replaces matching responses instead.
+// Interim 1xx statuses can't be the final status, so they reply with a 503.
+type StatusToxic struct {
+	Match
+	Status     int               `json:"status"`
+	Body       string            `json:"body"`
+	SetHeaders map[string]string `json:"set_headers"`
+}
+
+func (t *StatusToxic) Pipe(stub *toxics.ToxicStub) {
+	status := t.Status
+	if status < 200 || status > 999 {
+		status = 503
+	}
+
+	st := stateOf(stub)
+	pipe(stub, &t.Match, func(stub *toxics.ToxicStub, w io.Writer, m *message) bool {
+		reply := response(status, t.SetHeaders, t.Body).bytes()
+		m.drop = true
+		if !m.request() {
+			toxics.Send(w, reply)
+		} else {
+			stub.WriteReplyAt(reply, st.requests) // The request isn't sent
+		}
+		return true
+	})
+}
+
+func (t *StatusToxic) NewFramer() toxics.Framer {
+	return new(responseFramer)
+}
+
+func (t *StatusToxic) NewState() interface{} {
+	return new(state)
+}
+
+func init() {
+	toxics.Register("http_status", new(StatusToxic))
+}
@@ -0,0 +1,31 @@
+package http
+
+import (
+	"io"
+
+	"github.com/Shopify/toxiproxy/v2/toxics"
+)
+
+// TruncateToxic cuts the body of the first matching message after a number of
+// bytes, and closes the connection. The head of the message is unchanged, so
+// the peer sees a message that ends too early.
+type TruncateToxic struct {
+	Match
+	Bytes int64 `json:"bytes"`
+}
+
+func (t *TruncateToxic) Pipe(stub *toxics.ToxicStub) {
+	pipe(stub, &t.Match, func(stub *toxics.ToxicStub, w io.Writer, m *message) bool {
+		m.truncate(t.Bytes)
+		toxics.Send(w, m.bytes())
+		return true
+	})
+}
+
+func (t *TruncateToxic) NewState() interface{} {
+	return new(state)
+}
+
+func init() {
+	toxics.Register("http_truncate", new(TruncateToxic))
+}
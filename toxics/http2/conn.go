@@ -0,0 +1,286 @@
+package http2
+
+import (
+	"encoding/binary"
+	"math"
+	"time"
+
+	"golang.org/x/net/http2/hpack"
+
+	"github.com/Shopify/toxiproxy/v2/stream"
+	"github.com/Shopify/toxiproxy/v2/toxics"
+)
+
+// Phases of the client side of a connection.
+const (
+	phasePreface = iota // Before the preface of the client
+	phaseFrames
+	phaseOpaque // Data that isn't HTTP/2, or that isn't followed from its start
+)
+
+// conn is the state of the client side of a connection, which the toxics keep
+// between runs. Decoding header blocks needs every one of them in order, so
+// connections that were open before the toxic was added pass through untouched.
+type conn struct {
+	phase   int
+	buf     []byte         // Data read that isn't a whole frame yet
+	decoder *hpack.Decoder // nil once a header block can't be decoded
+	pass    *framer        // Follows a frame sent on as is after an interrupt
+	started bool
+	replies bool // True if replies can be written between the frames of the server
+
+	lastStream uint32          // The last stream the client started
+	dropped    map[uint32]bool // Streams whose frames are dropped
+	goneAway   bool
+
+	delays  map[uint32]time.Duration // Streams whose data is delayed
+	held    []held
+	latency toxics.LatencyToxicState
+}
+
+type held struct {
+	stream uint32
+	at     time.Time
+	raw    []byte
+}
+
+func newConn() *conn {
+	return &conn{
+		dropped: make(map[uint32]bool),
+		delays:  make(map[uint32]time.Duration),
+	}
+}
+
+// state returns the connection of the stub, setting it up the first time a
+// toxic runs on it. Replies wait for the end of the frames of the server,
+// which can't be found if the server already sent data.
+func state(stub *toxics.ToxicStub) *conn {
+	c, ok := stub.State.(*conn)
+	if !ok {
+		c = newConn()
+		stub.State = c
+	}
+	if !c.started {
+		c.started = true
+		c.decoder = hpack.NewDecoder(4096, nil)
+		c.decoder.SetAllowedMaxDynamicTableSize(math.MaxUint32)
+		c.replies = stub.Reply != nil
+		if w, ok := stub.Reply.(toxics.FramedWriter); ok {
+			c.replies = w.SetFramer(new(framer))
+		}
+	}
+	return c
+}
+
+// reply writes frames back to the client, if it can be done between the frames
+// of the server.
+func (c *conn) reply(stub *toxics.ToxicStub, frames ...[]byte) {
+	if !c.replies {
+		return
+	}
+	var data []byte
+	for _, f := range frames {
+		data = append(data, f...)
+	}
+	stub.WriteReply(data)
+}
+
+// feed adds data read from the stub's input. It returns the part of the data
+// that is sent on as is, after an interrupt in the middle of a frame.
+func (c *conn) feed(data []byte) []byte {
+	var passed []byte
+	for c.pass != nil && len(data) > 0 {
+		n := c.pass.Next(data)
+		passed = append(passed, data[:n]...)
+		data = data[n:]
+		if c.pass.Boundary() {
+			c.pass = nil
+		}
+	}
+	c.buf = append(c.buf, data...)
+	return passed
+}
+
+// interrupt returns the data read that isn't a whole frame yet, to send it on
+// as is. The rest of its frame is sent on as is too, and the header block it
+// may be part of can't be decoded.
+func (c *conn) interrupt() []byte {
+	data := c.buf
+	c.buf = nil
+	if len(data) == 0 {
+		return nil
+	}
+	if c.phase != phaseFrames {
+		c.phase = phaseOpaque
+		return data
+	}
+
+	c.pass = &framer{started: true}
+	for rest := data; len(rest) > 0; {
+		rest = rest[c.pass.Next(rest):]
+	}
+	c.decoder = nil
+	return data
+}
+
+// next returns the next whole frame read, or nil if there isn't one yet.
+func (c *conn) next() *frame {
+	switch c.phase {
+	case phasePreface:
+		n := min(len(c.buf), len(preface))
+		if string(c.buf[:n]) != preface[:n] {
+			c.phase = phaseOpaque
+			return c.next()
+		} else if n < len(preface) {
+			return nil
+		}
+		c.phase = phaseFrames
+		return &frame{kind: frameOpaque, raw: c.take(n)}
+	case phaseOpaque:
+		if len(c.buf) == 0 {
+			return nil
+		}
+		return &frame{kind: frameOpaque, raw: c.take(len(c.buf))}
+	}
+
+	end, ok := c.frameEnd(0)
+	if !ok {
+		return nil
+	}
+	f := &frame{
+		kind:   c.buf[3],
+		flags:  c.buf[4],
+		stream: binary.BigEndian.Uint32(c.buf[5:9]) & 0x7fffffff,
+	}
+	if f.kind != frameHeaders && f.kind != framePushPromise {
+		f.raw = c.take(end)
+		return f
+	}
+
+	// The frames of a header block are read together.
+	for last := 0; c.buf[last+4]&flagEndHeaders == 0; {
+		next, ok := c.frameEnd(end)
+		if !ok {
+			return nil
+		} else if c.buf[end+3] != frameContinuation {
+			c.decoder = nil
+			break
+		}
+		last, end = end, next
+	}
+	f.raw = c.take(end)
+	f.fields = c.decode(f.raw)
+	return f
+}
+
+// frameEnd returns the end of the frame starting at offset, or false if it
+// wasn't read completely.
+func (c *conn) frameEnd(offset int) (int, bool) {
+	if len(c.buf) < offset+9 {
+		return 0, false
+	}
+	header := c.buf[offset:]
+	end := offset + 9 + (int(header[0])<<16 | int(header[1])<<8 | int(header[2]))
+	return end, len(c.buf) >= end
+}
+
+func (c *conn) take(n int) []byte {
+	data := c.buf[:n:n]
+	c.buf = c.buf[n:]
+	return data
+}
+
+// decode decodes the header block of frames.
+func (c *conn) decode(raw []byte) []hpack.HeaderField {
+	if c.decoder == nil {
+		return nil
+	}
+
+	var block []byte
+	for len(raw) > 0 {
+		length := int(raw[0])<<16 | int(raw[1])<<8 | int(raw[2])
+		data, ok := fragment(raw[3], raw[4], raw[9:9+length])
+		if !ok {
+			c.decoder = nil
+			return nil
+		}
+		block = append(block, data...)
+		raw = raw[9+length:]
+	}
+
+	fields, err := c.decoder.DecodeFull(block)
+	if err != nil {
+		c.decoder = nil
+		return nil
+	}
+	return fields
+}
+
+// starts reports whether the frame starts a stream of the client, and keeps
+// track of the last one.
+func (c *conn) starts(f *frame) bool {
+	if f.kind != frameHeaders || f.stream <= c.lastStream || f.stream%2 == 0 {
+		return false
+	}
+	c.lastStream = f.stream
+	return true
+}
+
+// drop drops a stream of the client, and returns the data to send on in place
+// of the frame. Header blocks are still sent on, as they change the state of the
+// header compression of the server, which resets the stream right after the
+// first one. The flow control window the data used is returned to the client.
+func (c *conn) drop(stub *toxics.ToxicStub, f *frame) []byte {
+	first := !c.dropped[f.stream]
+	if f.endStream() || f.kind == frameRstStream {
+		delete(c.dropped, f.stream)
+	} else {
+		c.dropped[f.stream] = true
+	}
+
+	switch {
+	case f.kind == frameData && len(f.raw) > 9:
+		c.reply(stub, windowUpdate(0, uint32(len(f.raw)-9)))
+	case f.kind == frameHeaders && first:
+		return append(f.raw, rstStream(f.stream, errorCodes["cancel"])...)
+	case f.kind == frameHeaders:
+		return f.raw
+	}
+	return nil
+}
+
+// pipe reads the frames of the stub's input, and calls apply with the ones that
+// aren't part of a dropped stream. apply returns the data to send on in place
+// of the frame.
+func pipe(stub *toxics.ToxicStub, apply func(c *conn, f *frame) []byte) {
+	c := state(stub)
+	for {
+		select {
+		case <-stub.Interrupt:
+			send(stub, c.interrupt())
+			return
+		case chunk := <-stub.Input:
+			if chunk == nil {
+				send(stub, c.interrupt())
+				stub.Close()
+				return
+			}
+
+			out := c.feed(chunk.Data)
+			for f := c.next(); f != nil; f = c.next() {
+				if f.stream != 0 && c.dropped[f.stream] {
+					out = append(out, c.drop(stub, f)...)
+				} else {
+					out = append(out, apply(c, f)...)
+				}
+			}
+			send(stub, out)
+		}
+	}
+}
+
+func send(stub *toxics.ToxicStub, data []byte) {
+	if len(data) > 0 {
+		stub.Output <- &stream.StreamChunk{Data: data, Timestamp: time.Now()}
+	}
+}
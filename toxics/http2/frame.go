@@ -0,0 +1,196 @@
+package http2
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"golang.org/x/net/http2/hpack"
+)
+
+// preface starts the connections of clients.
+const preface = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+
+// Types of frames.
+const (
+	frameData         = 0x0
+	frameHeaders      = 0x1
+	framePriority     = 0x2
+	frameRstStream    = 0x3
+	frameSettings     = 0x4
+	framePushPromise  = 0x5
+	frameGoAway       = 0x7
+	frameWindowUpdate = 0x8
+	frameContinuation = 0x9
+
+	// frameOpaque isn't a frame, but the preface or data that isn't HTTP/2.
+	frameOpaque = 0xff
+)
+
+// Flags of frames.
+const (
+	flagEndStream  = 0x1
+	flagEndHeaders = 0x4
+	flagPadded     = 0x8
+	flagPriority   = 0x20
+)
+
+// Error codes of RST_STREAM and GOAWAY frames, by name.
+var errorCodes = map[string]uint32{
+	"no_error":            0x0,
+	"protocol_error":      0x1,
+	"internal_error":      0x2,
+	"flow_control_error":  0x3,
+	"settings_timeout":    0x4,
+	"stream_closed":       0x5,
+	"frame_size_error":    0x6,
+	"refused_stream":      0x7,
+	"cancel":              0x8,
+	"compression_error":   0x9,
+	"connect_error":       0xa,
+	"enhance_your_calm":   0xb,
+	"inadequate_security": 0xc,
+	"http_1_1_required":   0xd,
+}
+
+// errorCode returns the code of an error name, or the default if it is unknown.
+func errorCode(name string, defaultCode uint32) uint32 {
+	if code, ok := errorCodes[name]; ok {
+		return code
+	}
+	return defaultCode
+}
+
+// frame is a frame, with the CONTINUATION frames of its header block if it has
+// one.
+type frame struct {
+	kind   byte
+	flags  byte
+	stream uint32
+	raw    []byte              // The frames as they were sent
+	fields []hpack.HeaderField // The decoded header block
+}
+
+func (f *frame) endStream() bool {
+	return f.flags&flagEndStream != 0 && (f.kind == frameData || f.kind == frameHeaders)
+}
+
+// get returns the value of a header field of the frame.
+func (f *frame) get(name string) (string, bool) {
+	for _, field := range f.fields {
+		if field.Name == name {
+			return field.Value, true
+		}
+	}
+	return "", false
+}
+
+// newFrame builds a frame.
+func newFrame(kind, flags byte, stream uint32, payload []byte) []byte {
+	length := len(payload)
+	data := []byte{byte(length >> 16), byte(length >> 8), byte(length), kind, flags}
+	data = binary.BigEndian.AppendUint32(data, stream&0x7fffffff)
+	return append(data, payload...)
+}
+
+func rstStream(stream, code uint32) []byte {
+	return newFrame(frameRstStream, 0, stream, binary.BigEndian.AppendUint32(nil, code))
+}
+
+func goAway(lastStream, code uint32) []byte {
+	payload := binary.BigEndian.AppendUint32(nil, lastStream)
+	return newFrame(frameGoAway, 0, 0, binary.BigEndian.AppendUint32(payload, code))
+}
+
+func windowUpdate(stream, increment uint32) []byte {
+	return newFrame(frameWindowUpdate, 0, stream, binary.BigEndian.AppendUint32(nil, increment))
+}
+
+// headers builds a HEADERS frame ending its stream. The fields are never indexed,
+// so the dynamic table of the receiver doesn't change.
+func headers(stream uint32, fields ...hpack.HeaderField) []byte {
+	var block bytes.Buffer
+	encoder := hpack.NewEncoder(&block)
+	for _, field := range fields {
+		field.Sensitive = true
+		encoder.WriteField(field) // #nosec G104 -- bytes.Buffer never fails
+	}
+	return newFrame(frameHeaders, flagEndHeaders|flagEndStream, stream, block.Bytes())
+}
+
+// fragment returns the header block fragment of a HEADERS, PUSH_PROMISE or
+// CONTINUATION payload, without its padding and priority.
+func fragment(kind, flags byte, payload []byte) ([]byte, bool) {
+	if kind == frameContinuation {
+		return payload, true
+	}
+
+	var padding int
+	if flags&flagPadded != 0 {
+		if len(payload) < 1 {
+			return nil, false
+		}
+		padding = int(payload[0])
+		payload = payload[1:]
+	}
+	if kind == frameHeaders && flags&flagPriority != 0 {
+		if len(payload) < 5 {
+			return nil, false
+		}
+		payload = payload[5:]
+	} else if kind == framePushPromise {
+		if len(payload) < 4 {
+			return nil, false
+		}
+		payload = payload[4:]
+	}
+	if padding > len(payload) {
+		return nil, false
+	}
+	return payload[:len(payload)-padding], true
+}
+
+// framer follows where the frames of a stream end, without reading them. The
+// frames of a header block, and the start of the stream before its first frame,
+// aren't between messages. It implements toxics.Framer, so replies don't split
+// the frames sent by the server.
+type framer struct {
+	header  []byte
+	left    int  // Bytes left in the payload of the current frame
+	started bool // Once the first frame was read
+	block   bool // Between the frames of a header block
+}
+
+func (f *framer) Boundary() bool {
+	return f.started && f.left == 0 && len(f.header) == 0 && !f.block
+}
+
+func (f *framer) Next(data []byte) int {
+	n := 0
+	for n < len(data) {
+		if f.left > 0 {
+			k := min(f.left, len(data)-n)
+			f.left -= k
+			n += k
+		} else {
+			k := min(9-len(f.header), len(data)-n)
+			f.header = append(f.header, data[n:n+k]...)
+			n += k
+			if len(f.header) < 9 {
+				continue
+			}
+
+			kind, flags := f.header[3], f.header[4]
+			f.left = int(f.header[0])<<16 | int(f.header[1])<<8 | int(f.header[2])
+			f.header = f.header[:0]
+			f.started = true
+			switch kind {
+			case frameHeaders, framePushPromise, frameContinuation:
+				f.block = flags&flagEndHeaders == 0
+			}
+		}
+		if f.left == 0 && len(f.header) == 0 {
+			break
+		}
+	}
+	return n
+}
@@ -0,0 +1,62 @@
+// Package http2 provides toxics that parse the frames of HTTP/2 connections, to
+// act on the streams of the requests that match, like the calls to a gRPC
+// method, without breaking the other streams of the connection.
+//
+// Requests are read on the upstream stream, from the preface of the client, so
+// the toxics apply to connections opened after they were added. Connections
+// that use TLS, or upgrade from HTTP/1.1, pass through untouched. Replies to
+// the client are written between the frames of the server.
+package http2
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Match selects the requests a toxic applies to. Empty fields match any
+// request.
+type Match struct {
+	Path    string            `json:"path"`    // Regular expression, like ^/pkg.Service/Method$
+	Headers map[string]string `json:"headers"` // Regular expressions of the values
+}
+
+type matcher struct {
+	path    *regexp.Regexp
+	headers map[string]*regexp.Regexp
+	invalid bool
+}
+
+// compile compiles the regular expressions of the match. A match with an
+// invalid expression doesn't match any request.
+func (m *Match) compile() *matcher {
+	c := &matcher{headers: make(map[string]*regexp.Regexp, len(m.Headers))}
+
+	var err error
+	if m.Path != "" {
+		c.path, err = regexp.Compile(m.Path)
+		c.invalid = err != nil
+	}
+	for name, value := range m.Headers {
+		c.headers[strings.ToLower(name)], err = regexp.Compile(value)
+		c.invalid = c.invalid || err != nil
+	}
+	return c
+}
+
+// matches reports whether the frame is the headers of a request that matches.
+func (c *matcher) matches(f *frame) bool {
+	path, ok := f.get(":path")
+	if c.invalid || f.kind != frameHeaders || !ok {
+		return false
+	}
+	if c.path != nil && !c.path.MatchString(path) {
+		return false
+	}
+	for name, value := range c.headers {
+		header, ok := f.get(name)
+		if !ok || !value.MatchString(header) {
+			return false
+		}
+	}
+	return true
+}
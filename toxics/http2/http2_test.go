@@ -0,0 +1,215 @@
+package http2_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2/hpack"
+
+	"github.com/Shopify/toxiproxy/v2/stream"
+	"github.com/Shopify/toxiproxy/v2/testhelper"
+	"github.com/Shopify/toxiproxy/v2/toxics"
+	"github.com/Shopify/toxiproxy/v2/toxics/http2"
+)
+
+const preface = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+
+func frame(kind, flags byte, stream uint32, payload string) string {
+	n := len(payload)
+	header := []byte{byte(n >> 16), byte(n >> 8), byte(n), kind, flags}
+	return string(binary.BigEndian.AppendUint32(header, stream)) + payload
+}
+
+var settings = frame(0x4, 0, 0, "")
+
+func data(stream uint32, payload string, end bool) string {
+	var flags byte
+	if end {
+		flags = 0x1
+	}
+	return frame(0x0, flags, stream, payload)
+}
+
+// client encodes the header blocks of requests, indexing the fields so the
+// blocks depend on the ones before them.
+type client struct {
+	buf     bytes.Buffer
+	encoder *hpack.Encoder
+}
+
+func newClient() *client {
+	c := new(client)
+	c.encoder = hpack.NewEncoder(&c.buf)
+	return c
+}
+
+func (c *client) block(path string) string {
+	c.buf.Reset()
+	for _, field := range []hpack.HeaderField{
+		{Name: ":method", Value: "POST"},
+		{Name: ":scheme", Value: "http"},
+		{Name: ":path", Value: path},
+		{Name: "content-type", Value: "application/grpc"},
+	} {
+		c.encoder.WriteField(field)
+	}
+	return c.buf.String()
+}
+
+// cancel is the RST_STREAM sent to the server for a stream that is dropped.
+func cancel(stream uint32) string {
+	return frame(0x3, 0, stream, "\x00\x00\x00\x08")
+}
+
+func (c *client) request(stream uint32, path string) string {
+	return frame(0x1, 0x4, stream, c.block(path))
+}
+
+// decode decodes the HEADERS frames of a reply.
+func decode(t *testing.T, reply string) map[string]string {
+	if len(reply) < 9 || reply[3] != 0x1 {
+		t.Fatalf("Expected a HEADERS frame, got %q", reply)
+	}
+	fields, err := hpack.NewDecoder(4096, nil).DecodeFull([]byte(reply[9:]))
+	if err != nil {
+		t.Fatal("Failed to decode the reply:", err)
+	}
+	headers := make(map[string]string)
+	for _, field := range fields {
+		headers[field.Name] = field.Value
+	}
+	return headers
+}
+
+func TestStatusToxic(t *testing.T) {
+	c := newClient()
+	first := c.request(1, "/shop.Cart/Get") + data(1, "get", true)
+	checkout := c.request(3, "/shop.Cart/Checkout")
+	failed := checkout + data(3, "checkout", false) + data(3, "", true)
+	last := c.request(5, "/shop.Cart/Get") + data(5, "get", true)
+
+	toxic := &http2.StatusToxic{
+		Match:   http2.Match{Path: "^/shop.Cart/Checkout$"},
+		Status:  8,
+		Message: "slow down 100%",
+	}
+	out, reply := testhelper.ToxicRun{Size: 7}.Run(t, toxic, preface+settings+first+failed+last)
+	if out != preface+settings+first+checkout+cancel(3)+last {
+		t.Errorf("Expected only the headers of the failed stream to be forwarded, got %q", out)
+	}
+
+	update := frame(0x8, 0, 0, "\x00\x00\x00\x08")
+	if len(reply) < len(update) || reply[len(reply)-len(update):] != update {
+		t.Fatalf("Expected the window of the data to be returned, got %q", reply)
+	}
+	headers := decode(t, reply[:len(reply)-len(update)])
+	if headers["grpc-status"] != "8" || headers["grpc-message"] != "slow down 100%25" {
+		t.Errorf("Expected a RESOURCE_EXHAUSTED status, got %v", headers)
+	}
+	if reply[4] != 0x5 || binary.BigEndian.Uint32([]byte(reply[5:9])) != 3 {
+		t.Errorf("Expected the reply to end stream 3, got %q", reply[:9])
+	}
+}
+
+func TestResetToxicWithContinuation(t *testing.T) {
+	c := newClient()
+	block := c.block("/shop.Cart/Get")
+	split := frame(0x1, 0x1, 1, block[:3]) + frame(0x9, 0x4, 1, block[3:])
+	second := c.request(3, "/shop.Cart/Add") + data(3, "add", true)
+
+	toxic := &http2.ResetToxic{Match: http2.Match{Path: "Get$"}, ErrorCode: "REFUSED_STREAM"}
+	out, reply := testhelper.ToxicRun{Size: 7}.Run(t, toxic, preface+settings+split+second)
+	if out != preface+settings+split+cancel(1)+second {
+		t.Errorf("Expected the split request to be canceled, got %q", out)
+	}
+	if reply != frame(0x3, 0, 1, "\x00\x00\x00\x07") {
+		t.Errorf("Expected a RST_STREAM, got %q", reply)
+	}
+}
+
+func TestGoAwayToxic(t *testing.T) {
+	c := newClient()
+	first := c.request(1, "/shop.Cart/Get")
+	checkout := c.request(3, "/shop.Cart/Checkout")
+	last := c.request(5, "/shop.Cart/Get")
+	input := preface + settings + first + checkout + last + data(1, "get", true)
+
+	toxic := &http2.GoAwayToxic{Match: http2.Match{Path: "Checkout"}}
+	out, reply := testhelper.ToxicRun{Size: 7}.Run(t, toxic, input)
+	expected := preface + settings + first + checkout + cancel(3) + last + cancel(5) +
+		data(1, "get", true)
+	if out != expected {
+		t.Errorf("Expected %q, got %q", expected, out)
+	}
+	if reply != frame(0x7, 0, 0, "\x00\x00\x00\x01\x00\x00\x00\x00") {
+		t.Errorf("Expected a GOAWAY after stream 1, got %q", reply)
+	}
+}
+
+func TestLatencyToxicDelaysStreams(t *testing.T) {
+	input := make(chan *stream.StreamChunk)
+	output := make(chan *stream.StreamChunk)
+	stub := toxics.NewToxicStub(input, output)
+	toxic := &http2.LatencyToxic{
+		Match:        http2.Match{Path: "Checkout"},
+		LatencyToxic: toxics.LatencyToxic{Latency: 100},
+	}
+	stub.State = toxic.NewState()
+	done := make(chan struct{})
+	go func() {
+		toxic.Pipe(stub)
+		close(done)
+	}()
+
+	c := newClient()
+	slow := c.request(1, "/shop.Cart/Checkout")
+	fast := c.request(3, "/shop.Cart/Get")
+	start := time.Now()
+	input <- &stream.StreamChunk{Data: []byte(preface + settings + slow + fast)}
+	if c := <-output; string(c.Data) != preface+settings+slow+fast {
+		t.Errorf("Expected the headers right away, got %q", c.Data)
+	}
+
+	input <- &stream.StreamChunk{Data: []byte(data(1, "slow", true) + data(3, "fast", true))}
+	if c := <-output; string(c.Data) != data(3, "fast", true) {
+		t.Errorf("Expected the other stream right away, got %q", c.Data)
+	}
+	if c := <-output; string(c.Data) != data(1, "slow", true) {
+		t.Errorf("Expected the delayed data, got %q", c.Data)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("Expected the data to be delayed, it took %s", elapsed)
+	}
+
+	// Interrupting the toxic sends the delayed data right away.
+	stub.Interrupt <- struct{}{}
+	<-done
+	toxic.Latency = 10000
+	go toxic.Pipe(stub)
+	slow = c.request(5, "/shop.Cart/Checkout") + data(5, "slow", true)
+	input <- &stream.StreamChunk{Data: []byte(slow)}
+	<-output
+	stub.Interrupt <- struct{}{}
+	if c := <-output; string(c.Data) != data(5, "slow", true) {
+		t.Errorf("Expected the delayed data after the interrupt, got %q", c.Data)
+	}
+}
+
+func TestOtherDataPassesThrough(t *testing.T) {
+	toxic := &http2.StatusToxic{}
+	request := "GET / HTTP/1.1\r\nUpgrade: h2c\r\n\r\n"
+	out, reply := testhelper.ToxicRun{Size: 7}.Run(t, toxic, request)
+	if out != request || reply != "" {
+		t.Errorf("Expected HTTP/1.1 to pass through, got %q and reply %q", out, reply)
+	}
+
+	// Connections that weren't followed from their start pass through.
+	c := newClient()
+	frames := settings + c.request(1, "/shop.Cart/Get")
+	out, reply = testhelper.ToxicRun{Size: 7}.Run(t, toxic, frames)
+	if out != frames || reply != "" {
+		t.Errorf("Expected the frames to pass through, got %q and reply %q", out, reply)
+	}
+}
@@ -0,0 +1,220 @@
+package http2
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/http2/hpack"
+
+	"github.com/Shopify/toxiproxy/v2/toxics"
+)
+
+// StatusToxic replies to matching requests with a gRPC status instead of
+// forwarding them, like UNAVAILABLE (14), DEADLINE_EXCEEDED (4) or
+// RESOURCE_EXHAUSTED (8).
+type StatusToxic struct {
+	Match
+	Status  int    `json:"status"` // Defaults to 14, UNAVAILABLE
+	Message string `json:"message"`
+}
+
+func (t *StatusToxic) Pipe(stub *toxics.ToxicStub) {
+	status := t.Status
+	if status <= 0 || status > 16 {
+		status = 14
+	}
+	fields := []hpack.HeaderField{
+		{Name: ":status", Value: "200"},
+		{Name: "content-type", Value: "application/grpc"},
+		{Name: "grpc-status", Value: strconv.Itoa(status)},
+	}
+	if t.Message != "" {
+		fields = append(fields, hpack.HeaderField{Name: "grpc-message", Value: percent(t.Message)})
+	}
+
+	matcher := t.Match.compile()
+	pipe(stub, func(c *conn, f *frame) []byte {
+		if !c.starts(f) || !matcher.matches(f) || !c.replies {
+			return f.raw
+		}
+		c.reply(stub, headers(f.stream, fields...))
+		return c.drop(stub, f)
+	})
+}
+
+// ResetToxic resets the streams of matching requests with a RST_STREAM instead
+// of forwarding them.
+type ResetToxic struct {
+	Match
+	ErrorCode string `json:"error_code"` // Defaults to internal_error
+}
+
+func (t *ResetToxic) Pipe(stub *toxics.ToxicStub) {
+	code := errorCode(strings.ToLower(t.ErrorCode), errorCodes["internal_error"])
+	matcher := t.Match.compile()
+	pipe(stub, func(c *conn, f *frame) []byte {
+		if !c.starts(f) || !matcher.matches(f) || !c.replies {
+			return f.raw
+		}
+		c.reply(stub, rstStream(f.stream, code))
+		return c.drop(stub, f)
+	})
+}
+
+// GoAwayToxic sends a GOAWAY to the client on the first matching request, like
+// a server shutting down. The request and the ones after it aren't forwarded,
+// so the client retries them on another connection, and the streams before it
+// carry on.
+type GoAwayToxic struct {
+	Match
+	ErrorCode string `json:"error_code"` // Defaults to no_error
+}
+
+func (t *GoAwayToxic) Pipe(stub *toxics.ToxicStub) {
+	code := errorCode(strings.ToLower(t.ErrorCode), errorCodes["no_error"])
+	matcher := t.Match.compile()
+	pipe(stub, func(c *conn, f *frame) []byte {
+		last := c.lastStream
+		if !c.starts(f) || !(c.goneAway || matcher.matches(f)) || !c.replies {
+			return f.raw
+		}
+		if !c.goneAway {
+			c.goneAway = true
+			c.reply(stub, goAway(last, code))
+		}
+		return c.drop(stub, f)
+	})
+}
+
+// LatencyToxic delays the data of matching requests, and lets the other
+// streams through right away. The headers of requests are never delayed, as
+// the server must read them in order. The delays are drawn like the ones of the
+// latency toxic.
+type LatencyToxic struct {
+	Match
+	toxics.LatencyToxic
+}
+
+func (t *LatencyToxic) Pipe(stub *toxics.ToxicStub) {
+	c := state(stub)
+	matcher := t.Match.compile()
+	apply := func(f *frame) []byte {
+		if c.starts(f) {
+			if matcher.matches(f) && !f.endStream() {
+				c.delays[f.stream] = t.Delay(stub.Rand, &c.latency)
+			}
+			return f.raw
+		}
+
+		delay, ok := c.delays[f.stream]
+		if !ok || f.stream == 0 {
+			return f.raw
+		} else if f.endStream() || f.kind == frameRstStream {
+			delete(c.delays, f.stream)
+		}
+		if f.kind == frameHeaders {
+			// Trailers are decoded in order, so the data before them is sent
+			// right away.
+			return append(c.release(f.stream, time.Time{}), f.raw...)
+		}
+		c.held = append(c.held, held{f.stream, time.Now().Add(delay), f.raw})
+		return nil
+	}
+
+	for {
+		var wake <-chan time.Time
+		var timer *time.Timer
+		if len(c.held) > 0 {
+			timer = time.NewTimer(time.Until(c.nextHeld()))
+			wake = timer.C
+		}
+
+		select {
+		case <-stub.Interrupt:
+			send(stub, append(c.release(0, time.Time{}), c.interrupt()...))
+			return
+		case chunk := <-stub.Input:
+			if chunk == nil {
+				send(stub, append(c.release(0, time.Time{}), c.interrupt()...))
+				stub.Close()
+				return
+			}
+			out := c.feed(chunk.Data)
+			for f := c.next(); f != nil; f = c.next() {
+				out = append(out, apply(f)...)
+			}
+			send(stub, out)
+		case now := <-wake:
+			send(stub, c.release(0, now))
+		}
+
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// release takes the held frames of a stream, or of every stream if it is 0,
+// that are due at the given time, or every one if it is zero.
+func (c *conn) release(stream uint32, now time.Time) []byte {
+	var out []byte
+	kept := c.held[:0]
+	for _, h := range c.held {
+		if (stream == 0 || h.stream == stream) && (now.IsZero() || !h.at.After(now)) {
+			out = append(out, h.raw...)
+		} else {
+			kept = append(kept, h)
+		}
+	}
+	c.held = kept
+	return out
+}
+
+// nextHeld returns when the next held frame is due.
+func (c *conn) nextHeld() time.Time {
+	next := c.held[0].at
+	for _, h := range c.held[1:] {
+		if h.at.Before(next) {
+			next = h.at
+		}
+	}
+	return next
+}
+
+// percent encodes a grpc-message.
+func percent(message string) string {
+	var b strings.Builder
+	for i := 0; i < len(message); i++ {
+		if c := message[i]; c < 0x20 || c > 0x7e || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+func (t *StatusToxic) NewState() interface{} {
+	return newConn()
+}
+
+func (t *ResetToxic) NewState() interface{} {
+	return newConn()
+}
+
+func (t *GoAwayToxic) NewState() interface{} {
+	return newConn()
+}
+
+func (t *LatencyToxic) NewState() interface{} {
+	return newConn()
+}
+
+func init() {
+	toxics.Register("grpc_status", new(StatusToxic))
+	toxics.Register("http2_reset", new(ResetToxic))
+	toxics.Register("http2_goaway", new(GoAwayToxic))
+	toxics.Register("http2_latency", new(LatencyToxic))
+}
@@ -0,0 +1,195 @@
+// Package kafka provides toxics that parse the messages of the Kafka protocol,
+// to act on the responses to the requests that match, like every Fetch.
+//
+// Responses are read on the downstream stream. The requests they answer are
+// followed from the start of the connection, so the toxics apply to connections
+// opened after they were added. Connections that use TLS pass through
+// untouched.
+package kafka
+
+import (
+	"encoding/binary"
+	"time"
+
+	"github.com/Shopify/toxiproxy/v2/stream"
+	"github.com/Shopify/toxiproxy/v2/toxics"
+)
+
+// Match selects the responses a toxic applies to, by the API of their request.
+// An empty list matches any response.
+type Match struct {
+	APIKeys []string `json:"api_keys"` // Names like fetch, or numbers
+}
+
+// matches reports whether the response to a request matches. Unknown names
+// don't match any request.
+func (m *Match) matches(req request) bool {
+	for _, name := range m.APIKeys {
+		if key, ok := apiKey(name); ok && key == req.key {
+			return true
+		}
+	}
+	return len(m.APIKeys) == 0
+}
+
+// maxHeld is the most of a response the ErrorToxic holds to set its errors.
+// Larger responses, like big Fetch responses, pass through untouched.
+const maxHeld = 1 << 20
+
+// message is a response, or the part of one that is read so far.
+type message struct {
+	raw    []byte // The response as it is sent, starting with its size
+	req    request
+	wanted bool // The toxic wants the response
+	whole  bool // raw is the whole response, otherwise its rest follows as is
+}
+
+// responses is the state of the responses of a connection, which the toxics
+// keep between runs.
+type responses struct {
+	requests *requests // nil if the requests of the connection aren't followed
+	buf      []byte    // Data read that isn't a whole response yet
+	pass     *framer   // Follows a response that is passed on as is
+	latency  toxics.LatencyToxicState
+}
+
+func newResponses() *responses {
+	return new(responses)
+}
+
+// state returns the responses of the stub. The requests are followed by the
+// observer of the stub, which is only set for connections opened after the
+// toxic was added.
+func state(stub *toxics.ToxicStub) *responses {
+	c, ok := stub.State.(*responses)
+	if !ok {
+		c = newResponses()
+		stub.State = c
+	}
+	if c.requests == nil {
+		c.requests, _ = stub.Observer.(*requests)
+	}
+	return c
+}
+
+// feed adds data read from the stub's input. It returns the part of the data
+// that is passed on as is.
+func (c *responses) feed(data []byte) []byte {
+	var passed []byte
+	for c.pass != nil && len(data) > 0 {
+		n := c.pass.Next(data)
+		passed = append(passed, data[:n]...)
+		data = data[n:]
+		if c.pass.Boundary() {
+			c.pass = nil
+		}
+	}
+	c.buf = append(c.buf, data...)
+	return passed
+}
+
+// next returns the next response the toxic wants, the start of one that is
+// passed on as is, or nil if more data must be read first. Responses the toxic
+// wants are held whole up to hold bytes. Only the start of larger ones is
+// returned, once their header tells which request they answer.
+func (c *responses) next(wants func(req request) bool, hold int) *message {
+	if c.pass != nil || len(c.buf) < 4 {
+		return nil
+	}
+	size, ok := size(c.buf)
+	if !ok {
+		c.pass = &framer{opaque: true}
+		return &message{raw: c.take(len(c.buf))}
+	} else if len(c.buf) < 8 {
+		return nil
+	}
+
+	var req request
+	known := false
+	if c.requests != nil {
+		req, known = c.requests.get(int32(binary.BigEndian.Uint32(c.buf[4:8])))
+	}
+	if !known || !wants(req) {
+		c.pass = new(framer)
+		return &message{raw: c.take(c.pass.Next(c.buf))}
+	} else if 4+size > hold {
+		c.pass = new(framer)
+		raw := c.take(c.pass.Next(c.buf))
+		return &message{raw: raw, req: req, wanted: true, whole: c.pass == nil}
+	} else if len(c.buf) < 4+size {
+		return nil
+	}
+	return &message{raw: c.take(4 + size), req: req, wanted: true, whole: true}
+}
+
+func (c *responses) take(n int) []byte {
+	data := c.buf[:n:n]
+	c.buf = c.buf[n:]
+	if c.pass != nil && c.pass.Boundary() {
+		c.pass = nil
+	}
+	return data
+}
+
+// interrupt returns the data read that wasn't sent on yet, and passes the rest
+// of its response on as is.
+func (c *responses) interrupt() []byte {
+	data := c.buf
+	c.buf = nil
+	if len(data) == 0 {
+		return nil
+	}
+	if c.pass == nil {
+		c.pass = new(framer)
+	}
+	for rest := data; len(rest) > 0; {
+		rest = rest[c.pass.Next(rest):]
+	}
+	if c.pass.Boundary() {
+		c.pass = nil
+	}
+	return data
+}
+
+// pipe reads the responses of the stub's input, and calls apply with the ones
+// the toxic wants, held whole up to hold bytes, see next. apply sends the
+// response on itself, and returns false if the toxic was interrupted. Other
+// responses, and the rest of the ones that aren't whole, are passed on as they
+// are read.
+func pipe(
+	stub *toxics.ToxicStub,
+	wants func(req request) bool,
+	hold int,
+	apply func(m *message) bool,
+) {
+	c := state(stub)
+	for {
+		select {
+		case <-stub.Interrupt:
+			send(stub, c.interrupt())
+			return
+		case chunk := <-stub.Input:
+			if chunk == nil {
+				send(stub, c.interrupt())
+				stub.Close()
+				return
+			}
+
+			send(stub, c.feed(chunk.Data))
+			for m := c.next(wants, hold); m != nil; m = c.next(wants, hold) {
+				if !m.wanted {
+					send(stub, m.raw)
+				} else if !apply(m) {
+					send(stub, c.interrupt())
+					return
+				}
+			}
+		}
+	}
+}
+
+func send(stub *toxics.ToxicStub, data []byte) {
+	if len(data) > 0 {
+		stub.Output <- &stream.StreamChunk{Data: data, Timestamp: time.Now()}
+	}
+}
@@ -0,0 +1,177 @@
+package kafka
+
+import (
+	"encoding/binary"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// API keys of the requests the toxics know by name.
+const (
+	apiProduce  = 0
+	apiFetch    = 1
+	apiMetadata = 3
+)
+
+var apiKeys = map[string]int16{
+	"produce":          apiProduce,
+	"fetch":            apiFetch,
+	"list_offsets":     2,
+	"metadata":         apiMetadata,
+	"offset_commit":    8,
+	"offset_fetch":     9,
+	"find_coordinator": 10,
+	"join_group":       11,
+	"heartbeat":        12,
+	"leave_group":      13,
+	"sync_group":       14,
+	"api_versions":     18,
+	"create_topics":    19,
+	"init_producer_id": 22,
+}
+
+// apiKey returns the API key of a name, or of a number.
+func apiKey(name string) (int16, bool) {
+	if key, ok := apiKeys[strings.ToLower(name)]; ok {
+		return key, true
+	}
+	key, err := strconv.ParseInt(name, 10, 16)
+	return int16(key), err == nil && key >= 0
+}
+
+// maxSize is the largest message brokers accept by default. Larger sizes are
+// read from data that isn't Kafka, like TLS records.
+const maxSize = 100 << 20
+
+// size returns the size of the message starting with header, or false if it
+// isn't a valid one.
+func size(header []byte) (int, bool) {
+	n := int32(binary.BigEndian.Uint32(header))
+	return int(n), n >= 4 && n <= maxSize
+}
+
+// framer follows where the messages of a stream end. Messages start with their
+// size.
+type framer struct {
+	header []byte
+	left   int // Bytes left in the current message
+	opaque bool
+}
+
+func (f *framer) Boundary() bool {
+	return !f.opaque && f.left == 0 && len(f.header) == 0
+}
+
+func (f *framer) Next(data []byte) int {
+	if f.opaque {
+		return len(data)
+	}
+
+	n := 0
+	if f.left == 0 {
+		n = min(4-len(f.header), len(data))
+		f.header = append(f.header, data[:n]...)
+		if len(f.header) < 4 {
+			return n
+		}
+		size, ok := size(f.header)
+		if !ok {
+			f.opaque = true
+			return len(data)
+		}
+		f.header = f.header[:0]
+		f.left = size
+	}
+
+	k := min(f.left, len(data)-n)
+	f.left -= k
+	return n + k
+}
+
+// request is what the toxics need to read the response to a request.
+type request struct {
+	key     int16
+	version int16
+}
+
+// maxRequests is the number of requests kept, more than clients send before
+// reading the responses.
+const maxRequests = 1024
+
+// requests follows the requests written to the server, and keeps the last ones
+// by correlation id, so the toxics know what the responses answer. It is the
+// observer of the stubs of the toxics.
+type requests struct {
+	mu     sync.Mutex
+	header []byte // The size and the start of the header of the request being read
+	left   int    // Bytes left in the request being read
+	opaque bool
+	byID   map[int32]request
+	order  []int32
+}
+
+func newRequests() *requests {
+	return &requests{byID: make(map[int32]request)}
+}
+
+func (r *requests) Write(data []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for rest := data; len(rest) > 0 && !r.opaque; {
+		rest = rest[r.next(rest):]
+	}
+	return len(data), nil
+}
+
+// next reads data up to the end of the next request, and returns the number of
+// bytes read.
+func (r *requests) next(data []byte) int {
+
+	// The size is followed by the API key, the version and the correlation id.
+	n := 0
+	if r.left == 0 {
+		n = min(12-len(r.header), len(data))
+		r.header = append(r.header, data[:n]...)
+		if len(r.header) < 4 {
+			return n
+		}
+		size, ok := size(r.header)
+		if !ok || size < 8 {
+			r.opaque = true // Like TLS, nothing more is followed
+			return len(data)
+		} else if len(r.header) < 12 {
+			return n
+		}
+
+		r.add(int32(binary.BigEndian.Uint32(r.header[8:12])), request{
+			key:     int16(binary.BigEndian.Uint16(r.header[4:6])),
+			version: int16(binary.BigEndian.Uint16(r.header[6:8])),
+		})
+		r.header = r.header[:0]
+		r.left = size - 8
+	}
+
+	k := min(r.left, len(data)-n)
+	r.left -= k
+	return n + k
+}
+
+func (r *requests) add(id int32, req request) {
+	if _, ok := r.byID[id]; !ok {
+		r.order = append(r.order, id)
+	}
+	r.byID[id] = req
+	if len(r.order) > maxRequests {
+		delete(r.byID, r.order[0])
+		r.order = r.order[1:]
+	}
+}
+
+// get returns the request with a correlation id.
+func (r *requests) get(id int32) (request, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	req, ok := r.byID[id]
+	return req, ok
+}
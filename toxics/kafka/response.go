@@ -0,0 +1,226 @@
+package kafka
+
+import (
+	"encoding/binary"
+)
+
+// decoder skips the fields of a response body, up to the ones a toxic needs.
+// Flexible versions have compact lengths and tagged fields.
+type decoder struct {
+	data     []byte
+	offset   int
+	flexible bool
+	failed   bool
+}
+
+func (d *decoder) skip(n int) {
+	if n < 0 || n > len(d.data)-d.offset {
+		d.failed = true
+		d.offset = len(d.data)
+		return
+	}
+	d.offset += n
+}
+
+func (d *decoder) uvarint() int {
+	value, n := binary.Uvarint(d.data[d.offset:])
+	if n <= 0 || value > maxSize {
+		d.failed = true
+		d.offset = len(d.data)
+		return 0
+	}
+	d.offset += n
+	return int(value)
+}
+
+func (d *decoder) fixed(n int) int {
+	start := d.offset
+	d.skip(n)
+	if d.failed {
+		return 0
+	}
+	switch n {
+	case 2:
+		return int(int16(binary.BigEndian.Uint16(d.data[start:])))
+	default:
+		return int(int32(binary.BigEndian.Uint32(d.data[start:])))
+	}
+}
+
+// length reads the length of an array, a string or bytes, whose fixed size has
+// n bytes. Null is -1.
+func (d *decoder) length(n int) int {
+	if d.flexible {
+		return d.uvarint() - 1
+	}
+	return d.fixed(n)
+}
+
+// array returns the number of items of an array, 0 if it is null.
+func (d *decoder) array() int {
+	return max(d.length(4), 0)
+}
+
+func (d *decoder) string() {
+	d.skip(max(d.length(2), 0))
+}
+
+func (d *decoder) bytes() {
+	d.skip(max(d.length(4), 0))
+}
+
+func (d *decoder) int32s() {
+	d.skip(d.array() * 4)
+}
+
+// tags skips the tagged fields of flexible versions.
+func (d *decoder) tags() {
+	if !d.flexible {
+		return
+	}
+	for n := d.uvarint(); n > 0 && !d.failed; n-- {
+		d.uvarint()
+		d.skip(d.uvarint())
+	}
+}
+
+// flexible reports whether a version of an API has compact lengths and tagged
+// fields, or false if the version isn't supported.
+func flexible(key, version int16) (bool, bool) {
+	switch key {
+	case apiProduce:
+		return version >= 9, version >= 0 && version <= 12
+	case apiFetch:
+		return version >= 12, version >= 0 && version <= 17
+	case apiMetadata:
+		return version >= 9, version >= 0 && version <= 13
+	}
+	return false, false
+}
+
+// errorFields returns the offsets of the error codes of the partitions of a
+// Produce or Fetch response, or of the topics of a Metadata response, in its
+// payload. It returns false if the API or its version isn't supported.
+func errorFields(req request, payload []byte) ([]int, bool) {
+	flexible, ok := flexible(req.key, req.version)
+	if !ok {
+		return nil, false
+	}
+	d := &decoder{data: payload, flexible: flexible}
+	d.skip(4) // The correlation id
+	d.tags()
+
+	var fields []int
+	v := req.version
+	switch req.key {
+	case apiProduce:
+		for topics := d.array(); topics > 0 && !d.failed; topics-- {
+			d.string()
+			for partitions := d.array(); partitions > 0 && !d.failed; partitions-- {
+				d.skip(4)
+				fields = append(fields, d.offset)
+				d.skip(2 + 8)
+				if v >= 2 {
+					d.skip(8)
+				}
+				if v >= 5 {
+					d.skip(8)
+				}
+				if v >= 8 {
+					for errors := d.array(); errors > 0 && !d.failed; errors-- {
+						d.skip(4)
+						d.string()
+						d.tags()
+					}
+					d.string()
+				}
+				d.tags()
+			}
+			d.tags()
+		}
+	case apiFetch:
+		if v >= 1 {
+			d.skip(4)
+		}
+		if v >= 7 {
+			d.skip(2 + 4)
+		}
+		for topics := d.array(); topics > 0 && !d.failed; topics-- {
+			if v >= 13 {
+				d.skip(16)
+			} else {
+				d.string()
+			}
+			for partitions := d.array(); partitions > 0 && !d.failed; partitions-- {
+				d.skip(4)
+				fields = append(fields, d.offset)
+				d.skip(2 + 8)
+				if v >= 4 {
+					d.skip(8)
+				}
+				if v >= 5 {
+					d.skip(8)
+				}
+				if v >= 4 {
+					for aborted := d.array(); aborted > 0 && !d.failed; aborted-- {
+						d.skip(8 + 8)
+						d.tags()
+					}
+				}
+				if v >= 11 {
+					d.skip(4)
+				}
+				d.bytes()
+				d.tags()
+			}
+			d.tags()
+		}
+	case apiMetadata:
+		if v >= 3 {
+			d.skip(4)
+		}
+		for brokers := d.array(); brokers > 0 && !d.failed; brokers-- {
+			d.skip(4)
+			d.string()
+			d.skip(4)
+			if v >= 1 {
+				d.string()
+			}
+			d.tags()
+		}
+		if v >= 2 {
+			d.string()
+		}
+		if v >= 1 {
+			d.skip(4)
+		}
+		for topics := d.array(); topics > 0 && !d.failed; topics-- {
+			fields = append(fields, d.offset)
+			d.skip(2)
+			d.string()
+			if v >= 10 {
+				d.skip(16)
+			}
+			if v >= 1 {
+				d.skip(1)
+			}
+			for partitions := d.array(); partitions > 0 && !d.failed; partitions-- {
+				d.skip(2 + 4 + 4)
+				if v >= 7 {
+					d.skip(4)
+				}
+				d.int32s()
+				d.int32s()
+				if v >= 5 {
+					d.int32s()
+				}
+				d.tags()
+			}
+			if v >= 8 {
+				d.skip(4)
+			}
+			d.tags()
+		}
+	}
+	return fields, !d.failed
+}
@@ -0,0 +1,124 @@
+package kafka
+
+import (
+	"encoding/binary"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Shopify/toxiproxy/v2/toxics"
+)
+
+// errorCodes are the codes of the errors brokers send for the partitions of a
+// request, by name.
+var errorCodes = map[string]int16{
+	"offset_out_of_range":              1,
+	"corrupt_message":                  2,
+	"unknown_topic_or_partition":       3,
+	"leader_not_available":             5,
+	"not_leader_or_follower":           6,
+	"not_leader_for_partition":         6,
+	"request_timed_out":                7,
+	"broker_not_available":             8,
+	"replica_not_available":            9,
+	"message_too_large":                10,
+	"network_exception":                13,
+	"not_enough_replicas":              19,
+	"not_enough_replicas_after_append": 20,
+	"topic_authorization_failed":       29,
+	"kafka_storage_error":              56,
+	"fenced_leader_epoch":              74,
+	"unknown_leader_epoch":             75,
+	"throttling_quota_exceeded":        89,
+}
+
+// errorCode returns the code of an error name, or of a number, or the default
+// if it is unknown.
+func errorCode(name string, defaultCode int16) int16 {
+	if code, ok := errorCodes[strings.ToLower(name)]; ok {
+		return code
+	}
+	code, err := strconv.ParseInt(name, 10, 16)
+	if err != nil || code <= 0 {
+		return defaultCode
+	}
+	return int16(code)
+}
+
+// ErrorToxic sets an error in the responses to matching Produce, Fetch and
+// Metadata requests, for each of their partitions, or each topic of a
+// Metadata response. The broker still handled the request, like when it fails
+// after writing the records of a Produce request.
+type ErrorToxic struct {
+	Match
+	Error string `json:"error"` // Defaults to not_leader_or_follower
+}
+
+func (t *ErrorToxic) Pipe(stub *toxics.ToxicStub) {
+	code := errorCode(t.Error, errorCodes["not_leader_or_follower"])
+	wants := func(req request) bool {
+		_, supported := flexible(req.key, req.version)
+		return supported && t.Match.matches(req)
+	}
+
+	pipe(stub, wants, maxHeld, func(m *message) bool {
+		if !m.whole {
+			send(stub, m.raw)
+			return true
+		}
+		fields, ok := errorFields(m.req, m.raw[4:])
+		for i := 0; ok && i < len(fields); i++ {
+			binary.BigEndian.PutUint16(m.raw[4+fields[i]:], uint16(code))
+		}
+		send(stub, m.raw)
+		return true
+	})
+}
+
+// LatencyToxic delays matching responses, like every Fetch. Responses after
+// them wait as well, as clients read them in order. Only the start of a
+// response is held, and the rest follows as it is read. The delays are drawn
+// like the ones of the latency toxic.
+type LatencyToxic struct {
+	Match
+	toxics.LatencyToxic
+}
+
+func (t *LatencyToxic) Pipe(stub *toxics.ToxicStub) {
+	st := state(stub)
+	pipe(stub, t.Match.matches, 0, func(m *message) bool {
+		timer := time.NewTimer(t.Delay(stub.Rand, &st.latency))
+		defer timer.Stop()
+
+		select {
+		case <-stub.Interrupt:
+			send(stub, m.raw)
+			return false
+		case <-timer.C:
+			send(stub, m.raw)
+			return true
+		}
+	})
+}
+
+func (t *ErrorToxic) NewState() interface{} {
+	return newResponses()
+}
+
+func (t *LatencyToxic) NewState() interface{} {
+	return newResponses()
+}
+
+func (t *ErrorToxic) NewObserver() io.Writer {
+	return newRequests()
+}
+
+func (t *LatencyToxic) NewObserver() io.Writer {
+	return newRequests()
+}
+
+func init() {
+	toxics.Register("kafka_error", new(ErrorToxic))
+	toxics.Register("kafka_latency", new(LatencyToxic))
+}
@@ -0,0 +1,234 @@
+package kafka_test
+
+import (
+	"encoding/binary"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Shopify/toxiproxy/v2/stream"
+	"github.com/Shopify/toxiproxy/v2/testhelper"
+	"github.com/Shopify/toxiproxy/v2/toxics"
+	"github.com/Shopify/toxiproxy/v2/toxics/kafka"
+)
+
+func sized(data string) string {
+	return string(binary.BigEndian.AppendUint32(nil, uint32(len(data)))) + data
+}
+
+func int16s(values ...int16) string {
+	var data []byte
+	for _, v := range values {
+		data = binary.BigEndian.AppendUint16(data, uint16(v))
+	}
+	return string(data)
+}
+
+func int32s(values ...int32) string {
+	var data []byte
+	for _, v := range values {
+		data = binary.BigEndian.AppendUint32(data, uint32(v))
+	}
+	return string(data)
+}
+
+func int64s(values ...int64) string {
+	var data []byte
+	for _, v := range values {
+		data = binary.BigEndian.AppendUint64(data, uint64(v))
+	}
+	return string(data)
+}
+
+func request(key, version int16, id int32) string {
+	return sized(int16s(key, version) + int32s(id) + int16s(-1) + "body")
+}
+
+func response(id int32, body string) string {
+	return sized(int32s(id) + body)
+}
+
+// produce is the body of a Produce v3 response, for two partitions of topic t.
+func produce(code int16) string {
+	partition := func(index int32) string {
+		return int32s(index) + int16s(code) + int64s(42, -1)
+	}
+	return int32s(1) + int16s(1) + "t" + int32s(2) + partition(0) + partition(1) + int32s(0)
+}
+
+// fetch is the body of a flexible Fetch v12 response, for a partition of topic t.
+func fetch(code int16) string {
+	partition := int32s(0) + int16s(code) + int64s(10, 10, 0) + "\x00" + int32s(-1) +
+		"\x04abc" + "\x00"
+	return "\x00" + int32s(0) + int16s(0) + int32s(7) + "\x02\x02t\x02" + partition +
+		"\x00" + "\x00"
+}
+
+// metadata is the body of a Metadata v1 response, for a broker and topic t.
+func metadata(code int16) string {
+	broker := int32s(1) + int16s(9) + "localhost" + int32s(9092) + int16s(-1)
+	return int32s(1) + broker + int32s(1) + int32s(1) + int16s(code) + int16s(1) + "t" +
+		"\x00" + int32s(0)
+}
+
+// toxicStub is a stub of the toxic, observing the requests of its connection.
+type toxicStub struct {
+	*toxics.ToxicStub
+	input  chan *stream.StreamChunk
+	output chan *stream.StreamChunk
+}
+
+func newStub(toxic toxics.Toxic) *toxicStub {
+	input := make(chan *stream.StreamChunk)
+	output := make(chan *stream.StreamChunk, 100)
+	stub := toxics.NewToxicStub(input, output)
+	stub.State = toxic.(toxics.StatefulToxic).NewState()
+	stub.Observer = toxic.(toxics.ObservingToxic).NewObserver()
+	return &toxicStub{stub, input, output}
+}
+
+// send writes requests to the server in small pieces, like the upstream link.
+func (s *toxicStub) send(data string) {
+	for len(data) > 0 {
+		n := min(len(data), 5)
+		s.Observer.Write([]byte(data[:n]))
+		data = data[n:]
+	}
+}
+
+func TestErrorToxic(t *testing.T) {
+	requests := request(0, 3, 1) + request(1, 12, 2) + request(3, 1, 3) + request(18, 3, 4) +
+		request(0, 99, 5)
+	other := response(4, "api versions") + response(5, produce(0)) + response(6, "unknown")
+
+	toxic := &kafka.ErrorToxic{Error: "not_leader_for_partition"}
+	out, _ := testhelper.ToxicRun{Observed: requests, Size: 7}.Run(t, toxic,
+		response(1, produce(0))+response(2, fetch(0))+response(3, metadata(0))+other)
+	expected := response(1, produce(6)) + response(2, fetch(6)) + response(3, metadata(6)) +
+		other
+	if out != expected {
+		t.Errorf("Expected the errors to be set:\n%q\ngot\n%q", expected, out)
+	}
+
+	toxic = &kafka.ErrorToxic{Match: kafka.Match{APIKeys: []string{"produce"}}, Error: "7"}
+	run := testhelper.ToxicRun{Observed: requests, Size: 7}
+	out, _ = run.Run(t, toxic, response(1, produce(0))+response(2, fetch(0)))
+	if expected := response(1, produce(7)) + response(2, fetch(0)); out != expected {
+		t.Errorf("Expected only the Produce response to fail, got %q", out)
+	}
+}
+
+func TestErrorToxicChunks(t *testing.T) {
+	toxic := &kafka.ErrorToxic{Error: "not_leader_for_partition"}
+	requests := request(0, 3, 1) + request(1, 12, 2)
+	expected := response(1, produce(6)) + response(2, fetch(6))
+
+	// Responses are read across chunks, and across runs of the toxic.
+	for _, run := range []testhelper.ToxicRun{{Size: 1}, {Interrupt: true}} {
+		run.Observed = requests
+		out, _ := run.Run(t, toxic, response(1, produce(0)), response(2, fetch(0)))
+		if out != expected {
+			t.Errorf("Expected the errors to be set, got %q", out)
+		}
+	}
+
+	// A response the toxic was interrupted in passes through.
+	run := testhelper.ToxicRun{Observed: requests, Interrupt: true}
+	first := response(1, produce(0))
+	out, _ := run.Run(t, toxic, first[:9], first[9:]+response(2, fetch(0)))
+	if expected := first + response(2, fetch(6)); out != expected {
+		t.Errorf("Expected the first response to pass through, got %q", out)
+	}
+}
+
+func TestErrorToxicWithoutRequests(t *testing.T) {
+	toxic := &kafka.ErrorToxic{}
+	s := newStub(toxic)
+	s.Reply = nil
+	go func() {
+		s.input <- &stream.StreamChunk{Data: []byte(response(1, produce(0)))}
+		close(s.input)
+	}()
+	toxic.Pipe(s.ToxicStub)
+	if c := <-s.output; string(c.Data) != response(1, produce(0)) {
+		t.Errorf("Expected the response to pass through, got %q", c.Data)
+	}
+
+	// TLS records pass through.
+	record := "\x16\x03\x01\x00\x05hello"
+	run := testhelper.ToxicRun{Observed: request(0, 3, 1), Size: 7}
+	out, _ := run.Run(t, toxic, record+response(1, produce(0)))
+	if out != record+response(1, produce(0)) {
+		t.Errorf("Expected TLS to pass through, got %q", out)
+	}
+}
+
+func TestLatencyToxic(t *testing.T) {
+	toxic := &kafka.LatencyToxic{
+		Match:        kafka.Match{APIKeys: []string{"fetch"}},
+		LatencyToxic: toxics.LatencyToxic{Latency: 100},
+	}
+	s := newStub(toxic)
+	s.send(request(3, 1, 1) + request(1, 12, 2) + request(3, 1, 3))
+	done := make(chan struct{})
+	go func() {
+		toxic.Pipe(s.ToxicStub)
+		close(done)
+	}()
+
+	start := time.Now()
+	s.input <- &stream.StreamChunk{Data: []byte(response(1, metadata(0)))}
+	if c := <-s.output; string(c.Data) != response(1, metadata(0)) {
+		t.Errorf("Expected the Metadata response right away, got %q", c.Data)
+	}
+	s.input <- &stream.StreamChunk{Data: []byte(response(2, fetch(0)) + response(3, metadata(0)))}
+	if c := <-s.output; string(c.Data) != response(2, fetch(0)) {
+		t.Errorf("Expected the Fetch response, got %q", c.Data)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("Expected the Fetch response to be delayed, it took %s", elapsed)
+	}
+	if c := <-s.output; string(c.Data) != response(3, metadata(0)) {
+		t.Errorf("Expected the next response after the Fetch one, got %q", c.Data)
+	}
+
+	// Interrupting the toxic sends the delayed start of the response right away,
+	// and its rest follows as it is read.
+	toxic.Latency = 10000
+	s.Interrupt <- struct{}{}
+	<-done
+	s.send(request(1, 12, 4))
+	done = make(chan struct{})
+	go func() {
+		toxic.Pipe(s.ToxicStub)
+		close(done)
+	}()
+	delayed := response(4, fetch(0))
+	s.input <- &stream.StreamChunk{Data: []byte(delayed[:30])}
+	s.Interrupt <- struct{}{}
+	<-done
+	if c := <-s.output; string(c.Data) != delayed[:30] {
+		t.Errorf("Expected the delayed response after the interrupt, got %q", c.Data)
+	}
+	go toxic.Pipe(s.ToxicStub)
+	s.input <- &stream.StreamChunk{Data: []byte(delayed[30:])}
+	if c := <-s.output; string(c.Data) != delayed[30:] {
+		t.Errorf("Expected the rest of the response, got %q", c.Data)
+	}
+}
+
+func TestErrorToxicPassesHugeResponses(t *testing.T) {
+	toxic := &kafka.ErrorToxic{Match: kafka.Match{APIKeys: []string{"fetch"}}}
+	s := newStub(toxic)
+	s.send(request(1, 12, 1))
+	go toxic.Pipe(s.ToxicStub)
+
+	// The response isn't held, so it passes through untouched as it is read.
+	huge := response(1, fetch(0)+strings.Repeat("x", 1<<20))
+	for _, data := range []string{huge[:100], huge[100:]} {
+		s.input <- &stream.StreamChunk{Data: []byte(data)}
+		if c := <-s.output; string(c.Data) != data {
+			t.Fatalf("Expected the response to pass through, got %d bytes", len(c.Data))
+		}
+	}
+}
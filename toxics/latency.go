@@ -1,33 +1,149 @@
 package toxics
 
 import (
+	"math"
 	"math/rand"
+	"strings"
 	"time"
 )
 
+// Distributions of the delay of the LatencyToxic.
+const (
+	DistributionUniform      = "uniform"
+	DistributionNormal       = "normal"
+	DistributionPareto       = "pareto"
+	DistributionParetoNormal = "pareto_normal"
+	DistributionLogNormal    = "log_normal"
+)
+
+// The shape of the pareto distribution, the same as netem's.
+const paretoShape = 3
+
 // The LatencyToxic passes data through with the a delay of latency +/- jitter added.
+// The delay follows the given distribution, with latency as its mean and jitter
+// as its spread. With a correlation, successive delays depend on each other, so
+// the latency drifts instead of being independent for every chunk.
 type LatencyToxic struct {
 	// Times in milliseconds
-	Latency int64 `json:"latency"`
-	Jitter  int64 `json:"jitter"`
+	Latency      int64   `json:"latency"`
+	Jitter       int64   `json:"jitter"`
+	Distribution string  `json:"distribution"` // uniform, normal, pareto, pareto_normal or log_normal
+	Correlation  float64 `json:"correlation"`  // Between 0 and 1
+}
+
+type LatencyToxicState struct {
+	// The last standard normal value the delay was drawn from.
+	last    float64
+	started bool
 }
 
 func (t *LatencyToxic) GetBufferSize() int {
 	return 1024
 }
 
-func (t *LatencyToxic) delay() time.Duration {
-	// Delay = t.Latency +/- t.Jitter
-	delay := t.Latency
-	jitter := t.Jitter
-	if jitter > 0 {
-		// #nosec G404 -- was ignored before too
-		delay += rand.Int63n(jitter*2) - jitter
+// Validate checks the distribution, which can be spelled with dashes like
+// pareto-normal, and the correlation.
+func (t *LatencyToxic) Validate() error {
+	distribution := strings.ReplaceAll(strings.ToLower(t.Distribution), "-", "_")
+	switch distribution {
+	case "", DistributionUniform, DistributionNormal, DistributionPareto,
+		DistributionParetoNormal, DistributionLogNormal:
+		t.Distribution = distribution
+	default:
+		return ErrInvalidAttributes
+	}
+	if t.Correlation < 0 || t.Correlation > 1 {
+		return ErrInvalidAttributes
 	}
-	return time.Duration(delay) * time.Millisecond
+	return nil
+}
+
+func (t *LatencyToxic) NewState() interface{} {
+	return new(LatencyToxicState)
+}
+
+// Delay draws the next delay from r. The state keeps the last delay for the
+// correlation, and can be nil without one. Toxics delaying the messages of a
+// protocol embed the LatencyToxic to delay them the same way.
+func (t *LatencyToxic) Delay(r *rand.Rand, state *LatencyToxicState) time.Duration {
+	if t.Jitter <= 0 {
+		return time.Duration(t.Latency) * time.Millisecond
+	}
+	if t.uniform() && t.Correlation <= 0 {
+		// Delay = t.Latency +/- t.Jitter
+		delay := t.Latency + r.Int63n(t.Jitter*2) - t.Jitter
+		return time.Duration(delay) * time.Millisecond
+	}
+
+	delay := float64(t.Latency)
+	jitter := float64(t.Jitter)
+
+	// Every distribution is drawn from a standard normal value, which is what
+	// the correlation applies to, so it keeps its shape.
+	z := t.correlate(r, state)
+	switch t.Distribution {
+	case DistributionNormal:
+		delay += jitter * z
+	case DistributionPareto:
+		delay += jitter * pareto(z)
+	case DistributionParetoNormal:
+		// Mostly normal, with the long tail of the pareto distribution.
+		delay += jitter * (0.75*z + 0.25*pareto(z))
+	case DistributionLogNormal:
+		if delay > 0 {
+			sigma := math.Sqrt(math.Log1p(jitter * jitter / (delay * delay)))
+			delay = math.Exp(math.Log(delay) - sigma*sigma/2 + sigma*z)
+		}
+	default:
+		// A uniform value between -1 and 1.
+		delay += jitter * math.Erf(z/math.Sqrt2)
+	}
+	return time.Duration(delay * float64(time.Millisecond))
+}
+
+func (t *LatencyToxic) uniform() bool {
+	switch t.Distribution {
+	case DistributionNormal, DistributionPareto, DistributionParetoNormal,
+		DistributionLogNormal:
+		return false
+	}
+	return true
+}
+
+// correlate returns a standard normal value, correlated with the last one.
+func (t *LatencyToxic) correlate(r *rand.Rand, state *LatencyToxicState) float64 {
+	z := r.NormFloat64()
+	if state == nil {
+		return z
+	}
+
+	rho := t.Correlation
+	if state.started {
+		z = rho*state.last + math.Sqrt(1-rho*rho)*z
+	}
+	state.last = z
+	state.started = true
+	return z
+}
+
+// pareto maps a standard normal value to a pareto distribution with a mean of 0
+// and a standard deviation of 1.
+func pareto(z float64) float64 {
+	// The chance of a value above z, which is uniform between 0 and 1.
+	tail := math.Erfc(z/math.Sqrt2) / 2
+	if tail <= 0 {
+		tail = math.SmallestNonzeroFloat64
+	}
+	x := math.Pow(tail, -1.0/paretoShape)
+
+	mean := paretoShape / (paretoShape - 1.0)
+	deviation := math.Sqrt(paretoShape/(paretoShape-2.0)) / (paretoShape - 1.0)
+	return (x - mean) / deviation
 }
 
 func (t *LatencyToxic) Pipe(stub *ToxicStub) {
+	state, _ := stub.State.(*LatencyToxicState)
+
 	for {
 		select {
 		case <-stub.Interrupt:
@@ -37,7 +153,7 @@ func (t *LatencyToxic) Pipe(stub *ToxicStub) {
 				stub.Close()
 				return
 			}
-			sleep := t.delay() - time.Since(c.Timestamp)
+			sleep := t.Delay(stub.Rand, state) - time.Since(c.Timestamp)
 			select {
 			case <-time.After(sleep):
 				c.Timestamp = c.Timestamp.Add(sleep)
@@ -5,6 +5,7 @@ import (
 	"bytes"
 	"context"
 	"io"
+	"math"
 	"net"
 	"strconv"
 	"strings"
@@ -12,6 +13,7 @@ import (
 	"time"
 
 	"github.com/Shopify/toxiproxy/v2"
+	"github.com/Shopify/toxiproxy/v2/stream"
 	"github.com/Shopify/toxiproxy/v2/testhelper"
 	"github.com/Shopify/toxiproxy/v2/toxics"
 )
@@ -270,3 +272,149 @@ func TestLatencyToxicBandwidth(t *testing.T) {
 		t.Error("Failed to close TCP connection", err)
 	}
 }
+
+// latencyDelays sends n chunks through the latency toxic, and returns the delay
+// it drew for each of them. The chunks are timestamped in the past, so they
+// are passed on without waiting for the delay.
+func latencyDelays(t *testing.T, toxic *toxics.LatencyToxic, seed int64, n int) []float64 {
+	input := make(chan *stream.StreamChunk)
+	output := make(chan *stream.StreamChunk)
+	stub := toxics.NewToxicStub(input, output)
+	stub.State = toxic.NewState()
+	stub.Rand = toxics.NewRand(seed)
+
+	go toxic.Pipe(stub)
+	defer close(input)
+
+	delays := make([]float64, n)
+	for i := range delays {
+		past := time.Now().Add(-time.Hour)
+		before := time.Since(past)
+		input <- &stream.StreamChunk{Timestamp: past}
+		c := <-output
+
+		// The toxic subtracts the time since the timestamp from the delay, which
+		// is only a little longer than the time measured before sending it.
+		delay := c.Timestamp.Sub(past) + before
+		delays[i] = float64(delay) / float64(time.Millisecond)
+		if delay > time.Hour {
+			t.Fatalf("Delay %v is too long to test", delay)
+		}
+	}
+	return delays
+}
+
+func meanDeviation(values []float64) (float64, float64) {
+	var sum, squares float64
+	for _, v := range values {
+		sum += v
+	}
+	mean := sum / float64(len(values))
+	for _, v := range values {
+		squares += (v - mean) * (v - mean)
+	}
+	return mean, math.Sqrt(squares / float64(len(values)))
+}
+
+func TestLatencyToxicDistributions(t *testing.T) {
+	for _, distribution := range []string{
+		"",
+		toxics.DistributionUniform,
+		toxics.DistributionNormal,
+		toxics.DistributionPareto,
+		toxics.DistributionParetoNormal,
+		toxics.DistributionLogNormal,
+	} {
+		toxic := &toxics.LatencyToxic{Latency: 1000, Jitter: 200, Distribution: distribution}
+		delays := latencyDelays(t, toxic, 1, 5000)
+
+		mean, deviation := meanDeviation(delays)
+		if mean < 980 || mean > 1020 {
+			t.Errorf("[%s] Expected a mean delay of 1000ms, got %.1fms", distribution, mean)
+		}
+		if distribution == "" || distribution == toxics.DistributionUniform {
+			// A uniform distribution between -200 and 200 spreads by 200/sqrt(3).
+			if deviation < 105 || deviation > 125 {
+				t.Errorf("[%s] Expected a deviation of 115ms, got %.1fms", distribution, deviation)
+			}
+			for _, delay := range delays {
+				if delay < 799 || delay > 1201 {
+					t.Errorf("[%s] Expected delay within the jitter, got %.1fms", distribution, delay)
+				}
+			}
+		} else if distribution != toxics.DistributionPareto {
+			// The tail of the pareto distribution makes its deviation vary a lot.
+			if deviation < 180 || deviation > 220 {
+				t.Errorf("[%s] Expected a deviation of 200ms, got %.1fms", distribution, deviation)
+			}
+		}
+	}
+}
+
+func TestLatencyToxicParetoHasLongTail(t *testing.T) {
+	toxic := &toxics.LatencyToxic{
+		Latency:      1000,
+		Jitter:       200,
+		Distribution: toxics.DistributionPareto,
+	}
+	delays := latencyDelays(t, toxic, 1, 5000)
+
+	min, max := delays[0], delays[0]
+	for _, delay := range delays {
+		min = math.Min(min, delay)
+		max = math.Max(max, delay)
+	}
+	// Pareto delays are bounded below the mean, but not above it.
+	if min < 1000-2*200 {
+		t.Errorf("Expected no delay far below the mean, got %.1fms", min)
+	}
+	if max < 1000+5*200 {
+		t.Errorf("Expected delays far above the mean, got at most %.1fms", max)
+	}
+}
+
+func TestLatencyToxicCorrelation(t *testing.T) {
+	for _, correlation := range []float64{0, 0.5, 0.9} {
+		toxic := &toxics.LatencyToxic{
+			Latency:      1000,
+			Jitter:       200,
+			Distribution: toxics.DistributionNormal,
+			Correlation:  correlation,
+		}
+		delays := latencyDelays(t, toxic, 1, 5000)
+
+		mean, deviation := meanDeviation(delays)
+		var covariance float64
+		for i := 1; i < len(delays); i++ {
+			covariance += (delays[i] - mean) * (delays[i-1] - mean)
+		}
+		actual := covariance / float64(len(delays)-1) / (deviation * deviation)
+		if math.Abs(actual-correlation) > 0.05 {
+			t.Errorf("Expected a correlation of %.1f, got %.2f", correlation, actual)
+		}
+		// The correlation doesn't change the distribution of the delays.
+		if deviation < 180 || deviation > 220 {
+			t.Errorf("Expected a deviation of 200ms, got %.1fms", deviation)
+		}
+	}
+}
+
+func TestLatencyToxicValidate(t *testing.T) {
+	toxic := &toxics.LatencyToxic{Distribution: "Pareto-Normal", Correlation: 1}
+	if err := toxic.Validate(); err != nil {
+		t.Fatal("Expected the toxic to be valid, got", err)
+	}
+	if toxic.Distribution != toxics.DistributionParetoNormal {
+		t.Errorf("Expected the distribution to be normalized, got %q", toxic.Distribution)
+	}
+
+	for _, toxic := range []*toxics.LatencyToxic{
+		{Distribution: "gaussian"},
+		{Correlation: -0.1},
+		{Correlation: 1.5},
+	} {
+		if toxic.Validate() != toxics.ErrInvalidAttributes {
+			t.Errorf("Expected %+v to be invalid", toxic)
+		}
+	}
+}
@@ -0,0 +1,319 @@
+package toxics
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/Shopify/toxiproxy/v2/stream"
+)
+
+var ErrInvalidMatch = errors.New("invalid toxic match")
+
+// A Protocol splits the data of a stream into messages, so toxics can be scoped
+// to the messages that match.
+type Protocol interface {
+	// NewMatcher decodes the fields of a match, other than the protocol.
+	NewMatcher(decode func(fields interface{}) error) (Matcher, error)
+}
+
+// A Matcher is shared by every link of a proxy, so it doesn't keep any state
+// between messages.
+type Matcher interface {
+	// Next reads the next message, and reports whether the toxic applies to it.
+	// Data that isn't a message of the protocol is read as a message that
+	// doesn't match. Errors are those of the reader.
+	Next(r *bufio.Reader) (bool, error)
+}
+
+// A StatefulMatcher keeps state about the stream it reads, like the phase of a
+// protocol. Each stream is read by its own copy, made with NewStream.
+type StatefulMatcher interface {
+	Matcher
+	NewStream() Matcher
+}
+
+var (
+	protocols     map[string]Protocol
+	protocolMutex sync.RWMutex
+)
+
+func RegisterProtocol(name string, protocol Protocol) {
+	protocolMutex.Lock()
+	defer protocolMutex.Unlock()
+
+	if protocols == nil {
+		protocols = make(map[string]Protocol)
+	}
+	protocols[name] = protocol
+}
+
+// Match scopes a toxic to the messages of a protocol that match, like the HTTP
+// requests to a path. Its other fields depend on the protocol.
+type Match struct {
+	Protocol string
+
+	fields  json.RawMessage
+	matcher Matcher
+}
+
+func (m *Match) UnmarshalJSON(data []byte) error {
+	fields := &struct {
+		Protocol string `json:"protocol"`
+	}{}
+	err := json.Unmarshal(data, fields)
+	if err != nil {
+		return err
+	}
+	m.Protocol = fields.Protocol
+	m.fields = append(json.RawMessage(nil), data...)
+	return nil
+}
+
+func (m *Match) MarshalJSON() ([]byte, error) {
+	return m.fields, nil
+}
+
+// Compile validates the fields of the match for its protocol. Unknown fields
+// are invalid.
+func (m *Match) Compile() error {
+	protocolMutex.RLock()
+	protocol, ok := protocols[m.Protocol]
+	protocolMutex.RUnlock()
+	if !ok {
+		return ErrInvalidMatch
+	}
+
+	fields := make(map[string]json.RawMessage)
+	err := json.Unmarshal(m.fields, &fields)
+	if err != nil {
+		return ErrInvalidMatch
+	}
+	delete(fields, "protocol")
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return ErrInvalidMatch
+	}
+
+	m.matcher, err = protocol.NewMatcher(func(v interface{}) error {
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		return decoder.Decode(v)
+	})
+	if err != nil || m.matcher == nil {
+		return ErrInvalidMatch
+	}
+	return nil
+}
+
+// MessageReader reads the messages of the input of a stub. The bytes read are
+// kept until they are taken as a message, so none are lost if reading stops in
+// the middle of one.
+//
+// When reading stops, like when the stub is interrupted, the start of the
+// message being read is sent as is, and kept by the stub. The next reader of
+// the stub reads the message again, so it picks up at the next message, and
+// leaves out the bytes that were sent.
+type MessageReader struct {
+	*bufio.Reader
+	stub    *ToxicStub
+	raw     *bytes.Buffer
+	sent    int    // Bytes at the start of raw that were sent by the last reader
+	resumed bool   // The start of the last message was sent by the last reader
+	whole   []byte // The last message, with the start sent by the last reader
+}
+
+func NewMessageReader(stub *ToxicStub) *MessageReader {
+	input := stream.NewChanReader(stub.Input)
+	input.SetInterrupt(stub.Interrupt)
+	flushed := stub.flushed
+	stub.flushed = nil
+
+	r := &MessageReader{
+		stub: stub,
+		raw:  bytes.NewBuffer(bytes.Clone(flushed)),
+		sent: len(flushed),
+	}
+	r.Reader = bufio.NewReader(io.MultiReader(
+		bytes.NewReader(flushed),
+		io.TeeReader(input, r.raw),
+	))
+	return r
+}
+
+// Message returns the bytes of the messages read since it was last called,
+// without the ones the last reader sent.
+func (r *MessageReader) Message() []byte {
+	data := bytes.Clone(r.raw.Next(r.raw.Len() - r.Buffered()))
+	skip := min(r.sent, len(data))
+	r.sent -= skip
+	r.resumed = skip > 0
+	r.whole = data
+	return data[skip:]
+}
+
+// Resumed reports whether the start of the last message was sent by the last
+// reader, so the rest of it must pass through untouched.
+func (r *MessageReader) Resumed() bool {
+	return r.resumed
+}
+
+// Flush writes every byte read to w, including the start of a message that
+// wasn't read completely, and the stub keeps them for its next reader. The
+// reader can't be used afterwards.
+func (r *MessageReader) Flush(w io.Writer) {
+	data := r.raw.Bytes()
+	if len(data) > r.sent {
+		Send(w, data[r.sent:])
+	}
+	r.stub.flushed = bytes.Clone(data)
+}
+
+// Pipe reads the messages of the input with next, which reports whether it read
+// a message for apply, and calls apply with the data of each one. apply sends
+// the data itself, and returns false to stop, leaving the data after the
+// message in the reader. Other data is sent to w untouched. Pipe returns true
+// if apply stopped it. Otherwise reading failed, the data read is flushed to w,
+// and the stub is closed unless it was interrupted.
+func (r *MessageReader) Pipe(
+	w io.Writer,
+	next func() (bool, error),
+	apply func(data []byte) bool,
+) bool {
+	for {
+		message, err := next()
+		switch {
+		case err == stream.ErrInterrupted:
+			r.Flush(w)
+			return false
+		case err != nil:
+			r.Flush(w)
+			r.stub.Close()
+			return false
+		}
+
+		data := r.Message()
+		if !message {
+			Send(w, data)
+		} else if !apply(data) {
+			return true
+		}
+	}
+}
+
+// Send writes data to w, a writer of the output of a stub like a
+// stream.ChanWriter. Those never fail, so there is no error to handle. Empty
+// data isn't sent.
+func Send(w io.Writer, data []byte) {
+	if len(data) > 0 {
+		w.Write(data) // #nosec G104 -- writers of the output never fail
+	}
+}
+
+// Unexpected turns the end of the input in the middle of a message into an
+// error.
+func Unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// A MessageFollower is the state of a toxic scoped by a match, told about the
+// messages the toxic doesn't get before they are passed on. Follow returns
+// false to drop the message, like the rest of a pipeline after a failed
+// statement.
+type MessageFollower interface {
+	Follow(data []byte) bool
+}
+
+// follow tells the state of the toxic about a message passed on, and returns
+// whether to pass it.
+func (s *ToxicStub) follow(data []byte) bool {
+	follower, ok := s.State.(MessageFollower)
+	return !ok || follower.Follow(data)
+}
+
+// pipeMessages runs the toxic on every message that matches and is selected by
+// the toxicity, one message at a time so the data stays in order.
+func (s *ToxicStub) pipeMessages(toxic *ToxicWrapper, toxicity float32) {
+	if s.matcher == nil {
+		s.matcher = toxic.Match.matcher
+		if stateful, ok := s.matcher.(StatefulMatcher); ok {
+			s.matcher = stateful.NewStream()
+		}
+	}
+
+	r := NewMessageReader(s)
+	w := stream.NewChanWriter(s.Output)
+	var matches bool
+	next := func() (bool, error) {
+		var err error
+		matches, err = s.matcher.Next(r.Reader)
+		return true, err
+	}
+	stopped := r.Pipe(w, next, func(data []byte) bool {
+		if r.Resumed() {
+			s.follow(r.whole)
+		}
+		if len(data) == 0 {
+			return true
+		}
+		c := &stream.StreamChunk{Data: data, Timestamp: time.Now()}
+		if r.Resumed() {
+			s.Output <- c
+		} else if !matches || !s.roll(toxicity) {
+			if s.follow(data) {
+				s.Output <- c
+			}
+		} else if !s.pipeChunk(toxic, c) {
+			return false
+		}
+		return true
+	})
+	if stopped && !s.Closed() {
+		r.Flush(w) // The data read after the message
+	}
+}
+
+// BytesProtocol matches chunks of data against a regular expression. A pattern
+// split between chunks doesn't match.
+type BytesProtocol struct{}
+
+type bytesMatcher struct {
+	pattern *regexp.Regexp
+}
+
+func (BytesProtocol) NewMatcher(decode func(interface{}) error) (Matcher, error) {
+	fields := &struct {
+		Pattern string `json:"pattern"`
+	}{}
+	err := decode(fields)
+	if err != nil {
+		return nil, err
+	}
+	pattern, err := regexp.Compile(fields.Pattern)
+	if err != nil {
+		return nil, err
+	}
+	return &bytesMatcher{pattern}, nil
+}
+
+func (m *bytesMatcher) Next(r *bufio.Reader) (bool, error) {
+	_, err := r.Peek(1)
+	if err != nil {
+		return false, err
+	}
+	data, _ := r.Peek(r.Buffered())
+	r.Discard(len(data)) // #nosec G104 -- the data is buffered
+	return m.pattern.Match(data), nil
+}
+
+func init() {
+	RegisterProtocol("bytes", new(BytesProtocol))
+}
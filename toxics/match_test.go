@@ -0,0 +1,121 @@
+package toxics_test
+
+import (
+	"encoding/json"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/Shopify/toxiproxy/v2/stream"
+	"github.com/Shopify/toxiproxy/v2/testhelper"
+	"github.com/Shopify/toxiproxy/v2/toxics"
+)
+
+func TestMatchBytes(t *testing.T) {
+	run := testhelper.ToxicRun{Match: `{"protocol": "bytes", "pattern": "^dro+p"}`}
+	out := run.DropMatching(t, "keep", "drooop this", "keep drop", "drop")
+	if out != "keepkeep drop" {
+		t.Errorf("Expected matching chunks to be dropped, got %q", out)
+	}
+}
+
+// observingToxic passes data on if its stub has an observer, and closes it
+// otherwise.
+type observingToxic struct{}
+
+func (t *observingToxic) NewObserver() io.Writer {
+	return io.Discard
+}
+
+func (t *observingToxic) Pipe(stub *toxics.ToxicStub) {
+	if stub.Observer == nil {
+		stub.Close()
+		return
+	}
+	new(toxics.NoopToxic).Pipe(stub)
+}
+
+func TestMatchKeepsObserver(t *testing.T) {
+	run := testhelper.ToxicRun{Match: `{"protocol": "bytes", "pattern": "^a"}`}
+	out, _ := run.Run(t, new(observingToxic), "abc")
+	if out != "abc" {
+		t.Errorf("Expected the toxic to run with the observer of the stub, got %q", out)
+	}
+}
+
+func TestMatchInvalid(t *testing.T) {
+	for _, fields := range []string{
+		`{"protocol": "unknown"}`,
+		`{"pattern": "a"}`,
+		`{"protocol": "bytes", "pattern": "("}`,
+		`{"protocol": "bytes", "path": "/"}`,
+		`{"protocol": "bytes", "pattern": 1}`,
+	} {
+		match := new(toxics.Match)
+		err := json.Unmarshal([]byte(fields), match)
+		if err != nil {
+			t.Fatal("Failed to decode match:", err)
+		}
+		if match.Compile() != toxics.ErrInvalidMatch {
+			t.Errorf("Expected %s to be invalid", fields)
+		}
+	}
+}
+
+func TestMatchSamplesMessages(t *testing.T) {
+	toxic := &toxics.DropToxic{Probability: 1}
+	wrapper := &toxics.ToxicWrapper{
+		Toxic:    toxic,
+		Toxicity: 0.5,
+		Sampling: toxics.SamplingChunk,
+		Match:    testhelper.NewMatch(t, `{"protocol": "bytes", "pattern": "x"}`),
+	}
+
+	input := make(chan *stream.StreamChunk)
+	output := make(chan *stream.StreamChunk, 200)
+	stub := toxics.NewToxicStub(input, output)
+	stub.State = toxic.NewState()
+	stub.Rand = toxics.NewRand(1)
+	go stub.Run(wrapper)
+	for i := 0; i < 100; i++ {
+		input <- &stream.StreamChunk{Data: []byte("x")}
+		input <- &stream.StreamChunk{Data: []byte("y")}
+	}
+	close(input)
+
+	counts := map[string]int{}
+	for c := range output {
+		counts[string(c.Data)]++
+	}
+	if counts["y"] != 100 {
+		t.Errorf("Expected every chunk that doesn't match to pass, got %d", counts["y"])
+	}
+	if counts["x"] < 30 || counts["x"] > 70 {
+		t.Errorf("Expected about half of the matching chunks to pass, got %d", counts["x"])
+	}
+}
+
+func TestMatchClosesConnection(t *testing.T) {
+	match := testhelper.NewMatch(t, `{"protocol": "bytes", "pattern": "^hello"}`)
+	limit := &toxics.ToxicWrapper{
+		Toxic:    &toxics.LimitDataToxic{Bytes: 3},
+		Toxicity: 1,
+		Match:    match,
+	}
+	if out := pipeUntilClosed(t, limit, "keep", "hello", "after"); out != "keephel" {
+		t.Errorf("Expected the data after the limit to be cut, got %q", out)
+	}
+
+	timeout := &toxics.ToxicWrapper{
+		Toxic:    &toxics.TimeoutToxic{Timeout: 50},
+		Toxicity: 1,
+		Match:    match,
+	}
+	start := time.Now()
+	if out := pipeUntilClosed(t, timeout, "keep", "hello", "after"); out != "keep" {
+		t.Errorf("Expected the data to stop at the matching message, got %q", out)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("Expected the connection to be closed after the timeout, it took %s", elapsed)
+	}
+}
@@ -0,0 +1,43 @@
+package toxics
+
+import (
+	"strings"
+	"sync"
+)
+
+// The MaxConnectionsToxic limits the number of concurrent connections through
+// the proxy. New clients over the limit are closed, or reset with a TCP RST when
+// the mode is "reset". Connections opened before the toxic was added are not
+// counted.
+type MaxConnectionsToxic struct {
+	NoopToxic
+	Limit int64  `json:"limit"`
+	Mode  string `json:"mode"`
+
+	lock   sync.Mutex
+	active int64
+}
+
+func (t *MaxConnectionsToxic) Accept(stub *ConnectionStub) ConnectionAction {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.active >= t.Limit {
+		if strings.ToLower(t.Mode) == string(ConnectionReset) {
+			return ConnectionReset
+		}
+		return ConnectionClose
+	}
+
+	t.active++
+	stub.OnClose(func() {
+		t.lock.Lock()
+		defer t.lock.Unlock()
+		t.active--
+	})
+	return ConnectionContinue
+}
+
+func init() {
+	Register("max_connections", new(MaxConnectionsToxic))
+}
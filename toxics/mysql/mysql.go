@@ -0,0 +1,67 @@
+// Package mysql provides toxics that parse the packets of the MySQL protocol,
+// to act on the statements that match.
+//
+// Statements are read on the upstream stream. Connections that switch to TLS
+// pass through untouched from then on.
+package mysql
+
+import (
+	"bufio"
+	"strings"
+
+	"github.com/Shopify/toxiproxy/v2/toxics"
+)
+
+// Match selects the packets a toxic applies to. An empty statement matches any
+// packet, otherwise only queries and prepared statements that start with it
+// match, ignoring case.
+type Match struct {
+	Statement string `json:"statement"` // Like COMMIT
+}
+
+func (m *Match) matches(p *packet) bool {
+	if m.Statement == "" {
+		return true
+	}
+	statement, ok := p.statement()
+	if !ok {
+		return false
+	}
+	statement = strings.TrimSpace(statement)
+	return len(statement) >= len(m.Statement) &&
+		strings.EqualFold(statement[:len(m.Statement)], m.Statement)
+}
+
+// Protocol scopes toxics to the MySQL packets that match, with the fields of a
+// Match.
+type Protocol struct{}
+
+func (Protocol) NewMatcher(decode func(interface{}) error) (toxics.Matcher, error) {
+	match := new(Match)
+	err := decode(match)
+	if err != nil {
+		return nil, err
+	}
+	return &matcher{match: match}, nil
+}
+
+type matcher struct {
+	match   *Match
+	decoder decoder
+}
+
+func (c *matcher) NewStream() toxics.Matcher {
+	return &matcher{match: c.match}
+}
+
+func (c *matcher) Next(r *bufio.Reader) (bool, error) {
+	p, err := c.decoder.read(r)
+	if err != nil || p == nil {
+		return false, err
+	}
+	return c.match.matches(p), nil
+}
+
+func init() {
+	toxics.RegisterProtocol("mysql", new(Protocol))
+}
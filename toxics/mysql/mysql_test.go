@@ -0,0 +1,69 @@
+package mysql_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Shopify/toxiproxy/v2/testhelper"
+	_ "github.com/Shopify/toxiproxy/v2/toxics/mysql"
+)
+
+func packet(seq byte, payload string) string {
+	n := len(payload)
+	return string([]byte{byte(n), byte(n >> 8), byte(n >> 16), seq}) + payload
+}
+
+func query(sql string) string {
+	return packet(0, "\x03"+sql)
+}
+
+func TestMatchStatements(t *testing.T) {
+	data := query("SELECT 1") + query("  commit") + packet(0, "\x16COMMIT") +
+		packet(0, "\x0e") + query("COMMITTED") + query("COM")
+	run := testhelper.ToxicRun{Match: `{"protocol": "mysql", "statement": "COMMIT"}`, Size: 3}
+	out := run.DropMatching(t, data)
+	if out != query("SELECT 1")+packet(0, "\x0e")+query("COM") {
+		t.Errorf("Expected commits to be dropped, got %q", out)
+	}
+
+	run = testhelper.ToxicRun{Match: `{"protocol": "mysql"}`, Size: 3}
+	out = run.DropMatching(t, data+"\x01\x00")
+	if out != "\x01\x00" {
+		t.Errorf("Expected every packet to match, and the end to be kept, got %q", out)
+	}
+}
+
+func TestMatchSkipsTls(t *testing.T) {
+	ssl := packet(1, "\x00\x08\x00\x00"+strings.Repeat("\x00", 28))
+	hello := "\x16\x03\x01\x00\x05hello" + query("COMMIT")
+	run := testhelper.ToxicRun{Match: `{"protocol": "mysql", "statement": "COMMIT"}`, Size: 3}
+	out := run.DropMatching(t, ssl+hello)
+	if out != ssl+hello {
+		t.Errorf("Expected TLS data to pass through, got %q", out)
+	}
+
+	// The server answers a greeting offering TLS with the TLS handshake.
+	greeting := packet(0, "\x0a8.0.36\x00\x01\x00\x00\x00abcdefgh\x00\x00\x08rest")
+	run = testhelper.ToxicRun{Match: `{"protocol": "mysql"}`, Size: 3}
+	out = run.DropMatching(t, greeting+hello)
+	if out != hello {
+		t.Errorf("Expected TLS data to pass through after the greeting, got %q", out)
+	}
+	ok := packet(2, "\x00\x00\x00\x02\x00\x00\x00")
+	out = run.DropMatching(t, greeting+ok+ok)
+	if out != "" {
+		t.Errorf("Expected the packets after the greeting to match, got %q", out)
+	}
+}
+
+func TestMatchPacketsLikeTls(t *testing.T) {
+	// The headers of packets of 788 to 791 bytes look like TLS records.
+	run := testhelper.ToxicRun{Match: `{"protocol": "mysql", "statement": "COMMIT"}`}
+	for n := 788; n <= 791; n++ {
+		large := query("SELECT '" + strings.Repeat("x", n-10) + "'")
+		out := run.DropMatching(t, large+query("COMMIT"))
+		if out != large {
+			t.Errorf("Expected COMMIT after a %d byte packet to be dropped, got %q", len(large)-4, out)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package mysql
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+)
+
+// Commands of the client, the first byte of their packets.
+const (
+	comQuit        = 0x01
+	comQuery       = 0x03
+	comStmtPrepare = 0x16
+)
+
+// greeting is the protocol version the handshake of the server starts with.
+const greeting = 0x0a
+
+// clientSSL is the capability of a client asking for TLS, or of a server
+// offering it.
+const clientSSL = 0x0800
+
+// sslRequestLength is the length of an SSLRequest, the handshake response of a
+// client cut after its capabilities.
+const sslRequestLength = 32
+
+// tlsHandshake is the content type of the records of a TLS handshake.
+const tlsHandshake = 0x16
+
+// packet is a packet of the MySQL client/server protocol.
+type packet struct {
+	seq     byte
+	payload []byte
+}
+
+// statement returns the SQL of a query or prepared statement, or false if the
+// packet isn't one. Commands start a sequence, unlike the packets of the
+// handshake.
+func (p *packet) statement() (string, bool) {
+	if p.seq != 0 || len(p.payload) == 0 ||
+		(p.payload[0] != comQuery && p.payload[0] != comStmtPrepare) {
+		return "", false
+	}
+	return string(p.payload[1:]), true
+}
+
+// offersTLS reports whether the packet is the greeting of a server offering
+// TLS.
+func (p *packet) offersTLS() bool {
+	if p.seq != 0 || len(p.payload) == 0 || p.payload[0] != greeting {
+		return false
+	}
+	// The capabilities follow the server version, the connection id, the
+	// start of the auth data and a filler.
+	end := bytes.IndexByte(p.payload[1:], 0)
+	offset := 1 + end + 1 + 4 + 8 + 1
+	if end < 0 || len(p.payload) < offset+2 {
+		return false
+	}
+	return binary.LittleEndian.Uint16(p.payload[offset:])&clientSSL != 0
+}
+
+// requestsTLS reports whether the packet is the SSLRequest of a client.
+func (p *packet) requestsTLS() bool {
+	return p.seq == 1 && len(p.payload) == sslRequestLength &&
+		binary.LittleEndian.Uint32(p.payload)&clientSSL != 0
+}
+
+// bytes returns the packet as it is sent.
+func (p *packet) bytes() []byte {
+	data := make([]byte, 4, 4+len(p.payload))
+	data[0] = byte(len(p.payload))
+	data[1] = byte(len(p.payload) >> 8)
+	data[2] = byte(len(p.payload) >> 16)
+	data[3] = p.seq
+	return append(data, p.payload...)
+}
+
+// read reads a packet.
+func read(r *bufio.Reader) (*packet, error) {
+	header := make([]byte, 4)
+	_, err := io.ReadFull(r, header)
+	if err != nil {
+		return nil, err
+	}
+
+	n := binary.LittleEndian.Uint32(append(header[:3:3], 0))
+	p := &packet{seq: header[3], payload: make([]byte, n)}
+	_, err = io.ReadFull(r, p.payload)
+	if err == io.EOF {
+		return nil, io.ErrUnexpectedEOF
+	} else if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Phases of a stream, see decoder.
+const (
+	phaseStart   = iota // Before the first packet
+	phaseGreeted        // After the greeting of a server offering TLS
+	phasePackets        // Packets
+	phaseTLS            // Encrypted
+)
+
+// decoder reads the packets of one direction of a connection. A client asking
+// for TLS sends an SSLRequest, and starts the TLS handshake after it. The
+// server answers with the TLS handshake right after its greeting, so only the
+// data after the greeting is checked for it: packets that follow are small,
+// unlike a TLS record. Every read returns nil once the connection is
+// encrypted.
+type decoder struct {
+	phase int
+}
+
+func (d *decoder) read(r *bufio.Reader) (*packet, error) {
+	if d.phase == phaseGreeted {
+		header, err := r.Peek(3)
+		if err != nil {
+			return nil, err
+		}
+		// A TLS record has a version of 3.x, while the length of a packet
+		// under 64KB ends with a zero byte.
+		d.phase = phasePackets
+		if header[0] == tlsHandshake && header[1] == 0x03 && header[2] != 0 {
+			d.phase = phaseTLS
+		}
+	}
+	if d.phase == phaseTLS {
+		_, err := r.Discard(max(r.Buffered(), 1))
+		return nil, err
+	}
+
+	p, err := read(r)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case d.phase == phaseStart && p.offersTLS():
+		d.phase = phaseGreeted
+	case p.requestsTLS():
+		d.phase = phaseTLS
+	default:
+		d.phase = phasePackets
+	}
+	return p, nil
+}
+
+// errPacket builds an ERR packet, with the SQLSTATE of the error code.
+func errPacket(seq byte, code uint16, state, message string) []byte {
+	payload := []byte{0xff, byte(code), byte(code >> 8), '#'}
+	payload = append(payload, state...)
+	payload = append(payload, message...)
+	return (&packet{seq: seq, payload: payload}).bytes()
+}
@@ -0,0 +1,161 @@
+package mysql
+
+import (
+	"io"
+	"strings"
+
+	"github.com/Shopify/toxiproxy/v2/stream"
+	"github.com/Shopify/toxiproxy/v2/toxics"
+)
+
+// serverErrors are the SQLSTATE and message of the errors a server sends with
+// a code.
+var serverErrors = map[int]struct{ state, message string }{
+	1040: {"08004", "Too many connections"},
+	1045: {"28000", "Access denied for user"},
+	1205: {"HY000", "Lock wait timeout exceeded; try restarting transaction"},
+	1213: {"40001", "Deadlock found when trying to get lock; try restarting transaction"},
+	1317: {"70100", "Query execution was interrupted"},
+}
+
+// newError builds the ERR packet for a code and a message, or their defaults
+// if they are empty or invalid.
+func newError(seq byte, code, defaultCode int, message string) []byte {
+	if code <= 0 || code > 0xffff {
+		code = defaultCode
+	}
+	known, ok := serverErrors[code]
+	if !ok {
+		known.state = "HY000"
+		known.message = "Error injected by toxiproxy"
+	}
+	if message == "" {
+		message = known.message
+	}
+	return errPacket(seq, uint16(code), known.state, message)
+}
+
+// ErrorToxic replies to matching queries and prepared statements with an ERR
+// packet instead of forwarding them, like a deadlock. The transaction of the
+// server carries on, it isn't rolled back like after a real deadlock.
+type ErrorToxic struct {
+	Match
+	Code    int    `json:"code"` // Defaults to 1213, a deadlock
+	Message string `json:"message"`
+}
+
+type ErrorToxicState struct {
+	decoder decoder
+}
+
+func (t *ErrorToxic) NewState() interface{} {
+	return new(ErrorToxicState)
+}
+
+func (t *ErrorToxic) Pipe(stub *toxics.ToxicStub) {
+	state, ok := stub.State.(*ErrorToxicState)
+	if !ok {
+		state = new(ErrorToxicState)
+		stub.State = state
+	}
+
+	pipe(stub, &state.decoder, func(w io.Writer, data []byte, p *packet) bool {
+		_, ok := p.statement()
+		if !ok || !t.Match.matches(p) {
+			toxics.Send(w, data)
+		} else {
+			stub.WriteReply(newError(p.seq+1, t.Code, 1213, t.Message))
+		}
+		return true
+	})
+}
+
+// Phases of the handshake the HandshakeToxic fails.
+const (
+	PhaseGreeting = "greeting"
+	PhaseAuth     = "auth"
+)
+
+// HandshakeToxic fails new connections with an ERR packet, either instead of
+// the greeting of the server, like when it has too many connections, or
+// instead of the result of the authentication. The connection is closed
+// afterwards. It applies to the downstream stream, and connections that switch
+// to TLS can't fail their authentication.
+type HandshakeToxic struct {
+	Phase   string `json:"phase"` // greeting or auth
+	Code    int    `json:"code"`  // Defaults to 1040 for the greeting, 1045 for auth
+	Message string `json:"message"`
+}
+
+type HandshakeToxicState struct {
+	decoder decoder
+	greeted bool
+}
+
+func (t *HandshakeToxic) NewState() interface{} {
+	return new(HandshakeToxicState)
+}
+
+func (t *HandshakeToxic) Pipe(stub *toxics.ToxicStub) {
+	state, ok := stub.State.(*HandshakeToxicState)
+	if !ok {
+		state = new(HandshakeToxicState)
+		stub.State = state
+	}
+	auth := strings.EqualFold(t.Phase, PhaseAuth)
+
+	pipe(stub, &state.decoder, func(w io.Writer, data []byte, p *packet) bool {
+		var fail bool
+		if p.seq == 0 && len(p.payload) > 0 && p.payload[0] == greeting {
+			state.greeted = true
+			fail = !auth
+		} else if auth && state.greeted && len(p.payload) > 0 {
+			// The first OK or ERR packet after the greeting is the result.
+			// Connections greeted before the toxic was added are left alone.
+			fail = p.payload[0] == 0x00 || p.payload[0] == 0xff
+		}
+		if !fail {
+			toxics.Send(w, data)
+			return true
+		}
+
+		code := 1040
+		if auth {
+			code = 1045
+		}
+		toxics.Send(w, newError(p.seq, t.Code, code, t.Message))
+		stub.Close()
+		return false
+	})
+}
+
+// pipe reads the packets of the stub's input, and calls apply with each one and
+// the data it was read from. apply writes the packet out itself, and returns
+// false if the toxic must stop, after closing the stub if it has to. Data after
+// the connection switches to TLS passes through untouched.
+func pipe(
+	stub *toxics.ToxicStub,
+	d *decoder,
+	apply func(w io.Writer, data []byte, p *packet) bool,
+) {
+	input := toxics.NewMessageReader(stub)
+	w := stream.NewChanWriter(stub.Output)
+	var p *packet
+	next := func() (bool, error) {
+		var err error
+		p, err = d.read(input.Reader)
+		return p != nil, err
+	}
+	input.Pipe(w, next, func(data []byte) bool {
+		if input.Resumed() {
+			toxics.Send(w, data)
+			return true
+		}
+		return apply(w, data, p)
+	})
+}
+
+func init() {
+	toxics.Register("mysql_error", new(ErrorToxic))
+	toxics.Register("mysql_handshake", new(HandshakeToxic))
+}
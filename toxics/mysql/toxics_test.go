@@ -0,0 +1,106 @@
+package mysql_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Shopify/toxiproxy/v2/testhelper"
+	"github.com/Shopify/toxiproxy/v2/toxics/mysql"
+)
+
+func errPacket(seq byte, code uint16, state, message string) string {
+	return packet(seq, string([]byte{0xff, byte(code), byte(code >> 8)})+"#"+state+message)
+}
+
+var (
+	greeting = packet(0, "\x0a8.0.36\x00rest of the greeting")
+	login    = packet(1, "\x03\x00\x00\x00login of the client")
+	ok       = packet(2, "\x00\x00\x00\x02\x00\x00\x00")
+)
+
+func TestErrorToxic(t *testing.T) {
+	toxic := &mysql.ErrorToxic{Match: mysql.Match{Statement: "UPDATE"}}
+	data := login + query("SELECT 1") + query("update t set a = 1") + query("COMMIT")
+	out, reply := testhelper.ToxicRun{}.Run(t, toxic, data)
+	if out != login+query("SELECT 1")+query("COMMIT") {
+		t.Errorf("Expected only the other packets to be forwarded, got %q", out)
+	}
+	expected := errPacket(1, 1213, "40001",
+		"Deadlock found when trying to get lock; try restarting transaction")
+	if reply != expected {
+		t.Errorf("Expected %q, got %q", expected, reply)
+	}
+
+	toxic = &mysql.ErrorToxic{Code: 1205}
+	_, reply = testhelper.ToxicRun{}.Run(t, toxic, packet(0, "\x16SELECT ?"))
+	expected = errPacket(1, 1205, "HY000", "Lock wait timeout exceeded; try restarting transaction")
+	if reply != expected {
+		t.Errorf("Expected %q, got %q", expected, reply)
+	}
+
+	toxic = &mysql.ErrorToxic{Code: 4000, Message: "custom"}
+	_, reply = testhelper.ToxicRun{}.Run(t, toxic, query("SELECT 1"))
+	if reply != errPacket(1, 4000, "HY000", "custom") {
+		t.Errorf("Expected the custom error, got %q", reply)
+	}
+}
+
+func TestErrorToxicAfterPacketsLikeTls(t *testing.T) {
+	// The headers of packets of 788 to 791 bytes look like TLS records.
+	toxic := &mysql.ErrorToxic{Match: mysql.Match{Statement: "UPDATE"}}
+	expected := errPacket(1, 1213, "40001",
+		"Deadlock found when trying to get lock; try restarting transaction")
+	for n := 788; n <= 791; n++ {
+		large := query("UPDATE t SET a = '" + strings.Repeat("x", n-20) + "'")
+		out, reply := testhelper.ToxicRun{}.Run(t, toxic, login, large, query("UPDATE t SET a = 1"))
+		if out != login || reply != expected+expected {
+			t.Errorf("Expected errors after a %d byte query, got reply %q", len(large)-4, reply)
+		}
+	}
+}
+
+func TestErrorToxicChunks(t *testing.T) {
+	toxic := &mysql.ErrorToxic{}
+	update, commit := query("UPDATE t SET a = 1"), query("COMMIT")
+	expected := errPacket(1, 1213, "40001",
+		"Deadlock found when trying to get lock; try restarting transaction")
+
+	// Packets are read across chunks, and across runs of the toxic.
+	for _, run := range []testhelper.ToxicRun{{Size: 1}, {Interrupt: true}} {
+		out, reply := run.Run(t, toxic, login, update, commit)
+		if out != login || reply != expected+expected {
+			t.Errorf("Expected errors, got %q and reply %q", out, reply)
+		}
+	}
+
+	// A packet the toxic was interrupted in passes through.
+	run := testhelper.ToxicRun{Interrupt: true}
+	out, reply := run.Run(t, toxic, login+update[:3], update[3:]+commit)
+	if out != login+update || reply != expected {
+		t.Errorf("Expected UPDATE to pass through, got %q and reply %q", out, reply)
+	}
+}
+
+func TestHandshakeToxicGreeting(t *testing.T) {
+	toxic := &mysql.HandshakeToxic{}
+	out, _ := testhelper.ToxicRun{}.Run(t, toxic, greeting+ok)
+	if out != errPacket(0, 1040, "08004", "Too many connections") {
+		t.Errorf("Expected the greeting to be replaced, got %q", out)
+	}
+}
+
+func TestHandshakeToxicAuth(t *testing.T) {
+	toxic := &mysql.HandshakeToxic{Phase: "auth", Message: "denied"}
+	switchAuth := packet(2, "\xfemysql_native_password\x00")
+	data := greeting + switchAuth + packet(4, "\x00\x00\x00\x02\x00\x00\x00") + ok
+	out, _ := testhelper.ToxicRun{}.Run(t, toxic, data)
+	if out != greeting+switchAuth+errPacket(4, 1045, "28000", "denied") {
+		t.Errorf("Expected the result of the authentication to be replaced, got %q", out)
+	}
+
+	// Connections that were already greeted pass through.
+	out, _ = testhelper.ToxicRun{}.Run(t, toxic, ok+ok)
+	if out != ok+ok {
+		t.Errorf("Expected %q, got %q", ok+ok, out)
+	}
+}
@@ -0,0 +1,150 @@
+package postgres
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+
+	"github.com/Shopify/toxiproxy/v2/toxics"
+)
+
+// Codes of the untyped messages a client starts with.
+const (
+	sslRequest    = 80877103
+	gssencRequest = 80877104
+)
+
+// maxLength is the length over which a message is taken as garbage.
+const maxLength = 1 << 30
+
+// Phases of a stream, see decoder.
+const (
+	phaseStartup  = iota // Before the startup message, or the reply to an SSLRequest
+	phaseMessages        // Typed messages
+	phaseOpaque          // Encrypted or unknown data
+)
+
+// message is a message of the frontend/backend protocol. The startup messages
+// of clients and the replies to SSLRequests don't have a type.
+type message struct {
+	kind    byte
+	payload []byte
+}
+
+// statement returns the SQL of a simple query or a parsed statement, or false
+// if the message isn't one.
+func (m *message) statement() (string, bool) {
+	switch m.kind {
+	case 'Q':
+		sql, _, _ := bytes.Cut(m.payload, []byte{0})
+		return string(sql), true
+	case 'P':
+		_, rest, _ := bytes.Cut(m.payload, []byte{0}) // The name of the statement
+		sql, _, _ := bytes.Cut(rest, []byte{0})
+		return string(sql), true
+	}
+	return "", false
+}
+
+// newMessage builds a typed message.
+func newMessage(kind byte, payload []byte) []byte {
+	data := make([]byte, 5, 5+len(payload))
+	data[0] = kind
+	binary.BigEndian.PutUint32(data[1:], uint32(4+len(payload)))
+	return append(data, payload...)
+}
+
+// errorResponse builds an ErrorResponse with a severity, an SQLSTATE code and a
+// message.
+func errorResponse(severity, code, text string) []byte {
+	var payload bytes.Buffer
+	for _, field := range []struct {
+		kind  byte
+		value string
+	}{{'S', severity}, {'V', severity}, {'C', code}, {'M', text}} {
+		payload.WriteByte(field.kind)
+		payload.WriteString(field.value)
+		payload.WriteByte(0)
+	}
+	payload.WriteByte(0)
+	return newMessage('E', payload.Bytes())
+}
+
+// readyForQuery tells the client the server is idle, and waits for a query.
+var readyForQuery = newMessage('Z', []byte{'I'})
+
+// decoder reads the messages of one direction of a connection. Clients start
+// with untyped messages, and servers can reply to an SSLRequest with a single
+// byte. Connections that switch to encryption are opaque from then on, and
+// every read returns nil.
+type decoder struct {
+	phase int
+}
+
+func (d *decoder) read(r *bufio.Reader) (*message, error) {
+	if d.phase == phaseStartup {
+		first, err := r.Peek(1)
+		if err != nil {
+			return nil, err
+		}
+		switch first[0] {
+		case 0: // The length of an untyped message
+			return d.readStartup(r)
+		case 'N': // SSL or GSSAPI encryption refused
+			_, err = r.ReadByte()
+			return &message{kind: 'N'}, err
+		case 'S', 'G', 0x16: // Encryption accepted, or a TLS handshake
+			d.phase = phaseOpaque
+		default:
+			d.phase = phaseMessages
+		}
+	}
+
+	if d.phase == phaseOpaque {
+		_, err := r.Discard(max(r.Buffered(), 1))
+		return nil, err
+	}
+
+	header := make([]byte, 5)
+	_, err := io.ReadFull(r, header)
+	if err != nil {
+		return nil, err
+	}
+	length := binary.BigEndian.Uint32(header[1:])
+	if length < 4 || length > maxLength {
+		d.phase = phaseOpaque
+		return nil, nil
+	}
+	m := &message{kind: header[0], payload: make([]byte, length-4)}
+	_, err = io.ReadFull(r, m.payload)
+	return m, toxics.Unexpected(err)
+}
+
+func (d *decoder) readStartup(r *bufio.Reader) (*message, error) {
+	header := make([]byte, 8)
+	_, err := io.ReadFull(r, header)
+	if err != nil {
+		return nil, toxics.Unexpected(err)
+	}
+	length := binary.BigEndian.Uint32(header)
+	if length < 8 || length > maxLength {
+		d.phase = phaseOpaque
+		return nil, nil
+	}
+
+	payload := make([]byte, length-4)
+	copy(payload, header[4:])
+	_, err = io.ReadFull(r, payload[4:])
+	if err != nil {
+		return nil, toxics.Unexpected(err)
+	}
+
+	// The phase changes once the message is read, so a message read again after
+	// an interrupt is read the same way.
+	code := binary.BigEndian.Uint32(header[4:])
+	if code != sslRequest && code != gssencRequest {
+		d.phase = phaseMessages
+	}
+	return &message{payload: payload}, nil
+}
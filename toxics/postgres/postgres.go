@@ -0,0 +1,69 @@
+// Package postgres provides toxics that parse the messages of the PostgreSQL
+// frontend/backend protocol, to act on the queries that match.
+//
+// Queries are read on the upstream stream, and the replies of the server on the
+// downstream stream. Connections that switch to SSL or GSSAPI encryption pass
+// through untouched from then on.
+package postgres
+
+import (
+	"bufio"
+	"strings"
+
+	"github.com/Shopify/toxiproxy/v2/toxics"
+)
+
+// Match selects the messages a toxic applies to. An empty statement matches any
+// message, otherwise only simple queries and parsed statements that start with
+// it match, ignoring case. Prepared statements only match when they are parsed,
+// not when they are executed.
+type Match struct {
+	Statement string `json:"statement"` // Like SELECT
+}
+
+func (m *Match) matches(msg *message) bool {
+	if m.Statement == "" {
+		return true
+	}
+	statement, ok := msg.statement()
+	if !ok {
+		return false
+	}
+	statement = strings.TrimSpace(statement)
+	return len(statement) >= len(m.Statement) &&
+		strings.EqualFold(statement[:len(m.Statement)], m.Statement)
+}
+
+// Protocol scopes toxics to the PostgreSQL messages that match, with the fields
+// of a Match.
+type Protocol struct{}
+
+func (Protocol) NewMatcher(decode func(interface{}) error) (toxics.Matcher, error) {
+	match := new(Match)
+	err := decode(match)
+	if err != nil {
+		return nil, err
+	}
+	return &matcher{match: match}, nil
+}
+
+type matcher struct {
+	match   *Match
+	decoder decoder
+}
+
+func (c *matcher) NewStream() toxics.Matcher {
+	return &matcher{match: c.match}
+}
+
+func (c *matcher) Next(r *bufio.Reader) (bool, error) {
+	m, err := c.decoder.read(r)
+	if err != nil || m == nil {
+		return false, err
+	}
+	return c.match.matches(m), nil
+}
+
+func init() {
+	toxics.RegisterProtocol("postgres", new(Protocol))
+}
@@ -0,0 +1,100 @@
+package postgres_test
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"testing"
+
+	"github.com/Shopify/toxiproxy/v2/testhelper"
+	"github.com/Shopify/toxiproxy/v2/toxics"
+	_ "github.com/Shopify/toxiproxy/v2/toxics/postgres"
+)
+
+func message(kind byte, payload string) string {
+	length := binary.BigEndian.AppendUint32(nil, uint32(4+len(payload)))
+	return string(kind) + string(length) + payload
+}
+
+func untyped(code uint32, payload string) string {
+	data := binary.BigEndian.AppendUint32(nil, uint32(8+len(payload)))
+	data = binary.BigEndian.AppendUint32(data, code)
+	return string(data) + payload
+}
+
+var (
+	startup    = untyped(196608, "user\x00app\x00\x00")
+	sslRequest = untyped(80877103, "")
+	sync       = message('S', "")
+	ready      = message('Z', "I")
+)
+
+func query(sql string) string {
+	return message('Q', sql+"\x00")
+}
+
+func parse(sql string) string {
+	return message('P', "stmt\x00"+sql+"\x00\x00\x00")
+}
+
+func TestMatchStatements(t *testing.T) {
+	bind := message('B', "\x00stmt\x00\x00\x00\x00\x00\x00\x00")
+	data := sslRequest + "N" + startup + query("SELECT 1") + query("  update t") +
+		parse("UPDATE t SET a = 1") + bind + sync + query("UPD")
+	run := testhelper.ToxicRun{Match: `{"protocol": "postgres", "statement": "update"}`, Size: 3}
+	out := run.DropMatching(t, data)
+	expected := sslRequest + "N" + startup + query("SELECT 1") + bind + sync + query("UPD")
+	if out != expected {
+		t.Errorf("Expected updates to be dropped, got %q", out)
+	}
+
+	run = testhelper.ToxicRun{Match: `{"protocol": "postgres"}`, Size: 3}
+	out = run.DropMatching(t, data+"Q\x00")
+	if out != "Q\x00" {
+		t.Errorf("Expected every message to match, and the end to be kept, got %q", out)
+	}
+}
+
+func TestMatchServerMessages(t *testing.T) {
+	// Server messages start with types that are only single bytes at first.
+	data := "N" + message('R', "\x00\x00\x00\x00") + message('S', "a\x00b\x00") + ready
+	run := testhelper.ToxicRun{Match: `{"protocol": "postgres", "statement": "SELECT"}`, Size: 3}
+	out := run.DropMatching(t, data)
+	if out != data {
+		t.Errorf("Expected server messages to pass through, got %q", out)
+	}
+}
+
+func TestMatchSkipsEncryption(t *testing.T) {
+	hello := "\x16\x03\x01\x00\x05hello" + query("SELECT 1")
+	run := testhelper.ToxicRun{Match: `{"protocol": "postgres"}`, Size: 3}
+	out := run.DropMatching(t, sslRequest+hello)
+	if out != hello {
+		t.Errorf("Expected TLS data to pass through, got %q", out)
+	}
+
+	data := "S" + query("SELECT 1")
+	out = testhelper.ToxicRun{Match: `{"protocol": "postgres"}`, Size: 3}.DropMatching(t, data)
+	if out != data {
+		t.Errorf("Expected data after SSL is accepted to pass through, got %q", out)
+	}
+}
+
+func TestMatchResumesAfterInterrupt(t *testing.T) {
+	// The message interrupted halfway passes through, and the ones after it are
+	// still read.
+	run := testhelper.ToxicRun{Match: `{"protocol": "postgres", "statement": "UPDATE"}`}
+	run.Interrupt = true
+	update := query("UPDATE a")
+	out := run.DropMatching(t, startup+update[:3], update[3:]+query("UPDATE b")+query("SELECT 1"))
+	if out != startup+update+query("SELECT 1") {
+		t.Errorf("Expected the interrupted message to pass through, got %q", out)
+	}
+}
+
+func TestMatchInvalid(t *testing.T) {
+	match := new(toxics.Match)
+	err := json.Unmarshal([]byte(`{"protocol": "postgres", "query": "SELECT"}`), match)
+	if err != nil || match.Compile() != toxics.ErrInvalidMatch {
+		t.Errorf("Expected an unknown field to be invalid, got %v", err)
+	}
+}
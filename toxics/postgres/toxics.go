@@ -0,0 +1,360 @@
+package postgres
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/Shopify/toxiproxy/v2/stream"
+	"github.com/Shopify/toxiproxy/v2/toxics"
+)
+
+// messages are the messages of the errors a server sends with an SQLSTATE.
+var messages = map[string]string{
+	"40001": "could not serialize access due to concurrent update",
+	"40P01": "deadlock detected",
+	"53300": "sorry, too many clients already",
+	"55P03": "could not obtain lock on row",
+	"57014": "canceling statement due to statement timeout",
+	"57P01": "terminating connection due to administrator command",
+}
+
+// newError builds the ErrorResponse for a code and a message, or their
+// defaults if they are empty or invalid.
+func newError(severity, code, defaultCode, text string) []byte {
+	if len(code) != 5 {
+		code = defaultCode
+	}
+	code = strings.ToUpper(code)
+	if text == "" {
+		text = messages[code]
+	}
+	if text == "" {
+		text = "error injected by toxiproxy"
+	}
+	return errorResponse(severity, code, text)
+}
+
+// ErrorToxic replies to matching queries with an ErrorResponse instead of
+// forwarding them, like a serialization failure. The client is told the server
+// is idle afterwards. Parsed statements fail with the messages after them, up
+// to the next Sync. Errors wait for the results of the queries before, so
+// pipelined results stay in order. With a FATAL severity, the error is sent
+// right away, and the connection is closed after it.
+type ErrorToxic struct {
+	Match
+	Code     string `json:"code"` // SQLSTATE, defaults to 40001
+	Message  string `json:"message"`
+	Severity string `json:"severity"` // ERROR or FATAL
+}
+
+type ErrorToxicState struct {
+	decoder  decoder
+	skipping bool // Until the next Sync, after a parsed statement failed
+	syncs    int  // Messages forwarded the server ends with a ReadyForQuery
+}
+
+// pass follows a message the toxic doesn't fail, and returns whether it is
+// forwarded. The messages after a failed parsed statement are dropped up to
+// the next Sync, which the server replies to.
+func (s *ErrorToxicState) pass(m *message) bool {
+	if s.skipping && m.kind != 'S' && m.kind != 'X' {
+		return false
+	}
+	s.skipping = false
+	switch m.kind {
+	case 'Q', 'S', 'F': // Queries, Syncs and function calls
+		s.syncs++
+	case 0: // The startup message, not the requests for encryption
+		if len(m.payload) >= 4 && binary.BigEndian.Uint32(m.payload)>>16 == 3 {
+			s.syncs++
+		}
+	}
+	return true
+}
+
+// Follow follows the messages that don't match.
+func (s *ErrorToxicState) Follow(data []byte) bool {
+	m, err := s.decoder.read(bufio.NewReader(bytes.NewReader(data)))
+	return err != nil || m == nil || s.pass(m)
+}
+
+func (t *ErrorToxic) NewState() interface{} {
+	return new(ErrorToxicState)
+}
+
+func (t *ErrorToxic) NewFramer() toxics.Framer {
+	return new(replyFramer)
+}
+
+func (t *ErrorToxic) Pipe(stub *toxics.ToxicStub) {
+	state, ok := stub.State.(*ErrorToxicState)
+	if !ok {
+		state = new(ErrorToxicState)
+		stub.State = state
+	}
+	severity := strings.ToUpper(t.Severity)
+	if severity != "FATAL" {
+		severity = "ERROR"
+	}
+
+	input := toxics.NewMessageReader(stub)
+	pipe(stub, input, &state.decoder, func(w io.Writer, data []byte, m *message, resumed bool) bool {
+		_, ok := m.statement()
+		if resumed || state.skipping || !ok || !t.Match.matches(m) {
+			if state.pass(m) || resumed {
+				toxics.Send(w, data)
+			}
+			return true
+		}
+
+		// The results of the queries before are replied first, up to the
+		// ReadyForQuery of the Sync after a parsed statement.
+		response := newError(severity, t.Code, "40001", t.Message)
+		switch {
+		case severity == "FATAL":
+			stub.WriteReply(response)
+			stub.Close()
+			return false
+		case m.kind == 'Q':
+			stub.WriteReplyAt(append(response, readyForQuery...), 2*state.syncs)
+		default:
+			stub.WriteReplyAt(response, 2*state.syncs+1)
+			state.skipping = true
+		}
+		return true
+	})
+}
+
+// TerminateToxic ends connections once the startup handshake is done, like a
+// server shutting down. After the first ReadyForQuery of the server and the
+// delay, in milliseconds, the client gets a FATAL error and the connection is
+// closed. It applies to the downstream stream.
+type TerminateToxic struct {
+	Code    string `json:"code"` // SQLSTATE, defaults to 57P01
+	Message string `json:"message"`
+	Delay   int64  `json:"delay"`
+}
+
+type TerminateToxicState struct {
+	decoder  decoder
+	framer   framer
+	ready    bool
+	deadline time.Time
+}
+
+func (t *TerminateToxic) NewState() interface{} {
+	return new(TerminateToxicState)
+}
+
+func (t *TerminateToxic) Pipe(stub *toxics.ToxicStub) {
+	state, ok := stub.State.(*TerminateToxicState)
+	if !ok {
+		state = new(TerminateToxicState)
+		stub.State = state
+	}
+
+	var pending []byte
+	if !state.ready {
+		input := toxics.NewMessageReader(stub)
+		ready := pipe(stub, input, &state.decoder, func(
+			w io.Writer, data []byte, m *message, resumed bool,
+		) bool {
+			toxics.Send(w, data)
+			return m.kind != 'Z'
+		})
+		if !ready {
+			return
+		}
+		state.ready = true
+		state.deadline = time.Now().Add(time.Duration(t.Delay) * time.Millisecond)
+
+		rest := new(bytes.Buffer)
+		input.Flush(rest)
+		pending = rest.Bytes()
+	}
+
+	timer := time.NewTimer(time.Until(state.deadline))
+	defer timer.Stop()
+	due := !time.Now().Before(state.deadline)
+	for {
+		// The error is sent between the messages of the server.
+		if due && state.framer.boundary() {
+			stub.Output <- &stream.StreamChunk{
+				Data:      newError("FATAL", t.Code, "57P01", t.Message),
+				Timestamp: time.Now(),
+			}
+			stub.Close()
+			return
+		}
+		if len(pending) > 0 {
+			n := state.framer.feed(pending, due)
+			stub.Output <- &stream.StreamChunk{Data: pending[:n], Timestamp: time.Now()}
+			pending = pending[n:]
+			continue
+		}
+
+		select {
+		case <-stub.Interrupt:
+			return
+		case c := <-stub.Input:
+			if c == nil {
+				stub.Close()
+				return
+			}
+			pending = c.Data
+		case <-timer.C:
+			due = true
+		}
+	}
+}
+
+// framer follows where the typed messages of a stream end, without reading
+// them.
+type framer struct {
+	header []byte
+	left   int
+}
+
+func (f *framer) boundary() bool {
+	return f.left == 0 && len(f.header) == 0
+}
+
+// feed follows the messages of data, and returns the number of bytes read. If
+// stop is true, it stops at the end of the first message.
+func (f *framer) feed(data []byte, stop bool) int {
+	n := 0
+	for n < len(data) {
+		if f.left > 0 {
+			k := min(f.left, len(data)-n)
+			f.left -= k
+			n += k
+		} else {
+			k := min(5-len(f.header), len(data)-n)
+			f.header = append(f.header, data[n:n+k]...)
+			n += k
+			if len(f.header) == 5 {
+				f.left = max(int(binary.BigEndian.Uint32(f.header[1:]))-4, 0)
+				f.header = f.header[:0]
+			}
+		}
+		if stop && f.boundary() {
+			break
+		}
+	}
+	return n
+}
+
+// replyFramer follows where the messages of the server end, without reading
+// them, and numbers the places around its ReadyForQuery messages: the place
+// before the nth is 2n-1, and the place after it 2n. It implements
+// toxics.Sequencer, so errors are replied in the order of the queries.
+// Encrypted connections can't be followed, and let errors through right away.
+type replyFramer struct {
+	header []byte
+	left   int  // Bytes left in the payload of the current message
+	kind   byte // The type of the current message
+	typed  bool // Once the first typed message was read
+	ready  int  // The ReadyForQuery messages read
+	before bool // Stopped before a ReadyForQuery
+	opaque bool
+}
+
+func (f *replyFramer) Boundary() bool {
+	return f.opaque || (f.left == 0 && len(f.header) == 0)
+}
+
+func (f *replyFramer) Position() int {
+	if f.opaque {
+		return math.MaxInt
+	}
+	if f.before {
+		return 2*f.ready + 1
+	}
+	return 2 * f.ready
+}
+
+func (f *replyFramer) Next(data []byte) int {
+	if !f.typed && !f.opaque {
+		switch data[0] {
+		case 'N': // SSL or GSSAPI encryption refused
+			return 1
+		case 'S', 'G': // Encryption accepted
+			f.opaque = true
+		}
+		f.typed = true
+	}
+	if f.opaque {
+		return len(data)
+	}
+	if f.Boundary() && data[0] == 'Z' && !f.before {
+		// Stop before the ReadyForQuery, so errors can be placed before it.
+		f.before = true
+		return 0
+	}
+
+	n := 0
+	for n < len(data) {
+		if f.left > 0 {
+			k := min(f.left, len(data)-n)
+			f.left -= k
+			n += k
+		} else {
+			k := min(5-len(f.header), len(data)-n)
+			f.header = append(f.header, data[n:n+k]...)
+			n += k
+			if len(f.header) < 5 {
+				continue
+			}
+			length := binary.BigEndian.Uint32(f.header[1:])
+			if length < 4 || length > maxLength {
+				f.opaque = true
+				return len(data)
+			}
+			f.kind = f.header[0]
+			f.left = int(length) - 4
+			f.header = f.header[:0]
+		}
+		if f.Boundary() {
+			if f.kind == 'Z' {
+				f.ready++
+				f.before = false
+			}
+			break
+		}
+	}
+	return n
+}
+
+// pipe reads the messages of the input, and calls apply with each one and the
+// data it was read from. apply writes the message out itself, and returns false
+// to stop reading. A message whose start was sent by an earlier reader is
+// resumed, and must be written out untouched. Other data passes through
+// untouched. pipe returns true if apply stopped it, and false if the toxic must
+// stop.
+func pipe(
+	stub *toxics.ToxicStub,
+	input *toxics.MessageReader,
+	d *decoder,
+	apply func(w io.Writer, data []byte, m *message, resumed bool) bool,
+) bool {
+	w := stream.NewChanWriter(stub.Output)
+	var m *message
+	next := func() (bool, error) {
+		var err error
+		m, err = d.read(input.Reader)
+		return m != nil, err
+	}
+	return input.Pipe(w, next, func(data []byte) bool {
+		return apply(w, data, m, input.Resumed())
+	})
+}
+
+func init() {
+	toxics.Register("postgres_error", new(ErrorToxic))
+	toxics.Register("postgres_terminate", new(TerminateToxic))
+}
@@ -0,0 +1,178 @@
+package postgres_test
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Shopify/toxiproxy/v2/stream"
+	"github.com/Shopify/toxiproxy/v2/testhelper"
+	"github.com/Shopify/toxiproxy/v2/toxics"
+	"github.com/Shopify/toxiproxy/v2/toxics/postgres"
+)
+
+func errorResponse(severity, code, text string) string {
+	return message('E', "S"+severity+"\x00V"+severity+"\x00C"+code+"\x00M"+text+"\x00\x00")
+}
+
+func TestErrorToxicSimpleQueries(t *testing.T) {
+	toxic := &postgres.ErrorToxic{Match: postgres.Match{Statement: "UPDATE"}}
+	data := startup + query("SELECT 1") + query("UPDATE t SET a = 1") + query("SELECT 2")
+	out, reply := testhelper.ToxicRun{}.Run(t, toxic, data)
+	if out != startup+query("SELECT 1")+query("SELECT 2") {
+		t.Errorf("Expected only the other messages to be forwarded, got %q", out)
+	}
+	expected := errorResponse("ERROR", "40001",
+		"could not serialize access due to concurrent update") + ready
+	if reply != expected {
+		t.Errorf("Expected %q, got %q", expected, reply)
+	}
+}
+
+func TestErrorToxicParsedStatements(t *testing.T) {
+	toxic := &postgres.ErrorToxic{Code: "40p01"}
+	bind := message('B', "\x00stmt\x00\x00\x00\x00\x00\x00\x00")
+	execute := message('E', "\x00\x00\x00\x00\x00")
+	data := startup + parse("SELECT 1") + bind + execute + sync + message('X', "")
+	out, reply := testhelper.ToxicRun{}.Run(t, toxic, data)
+	if out != startup+sync+message('X', "") {
+		t.Errorf("Expected the statement to be skipped up to the sync, got %q", out)
+	}
+	expected := errorResponse("ERROR", "40P01", "deadlock detected")
+	if reply != expected {
+		t.Errorf("Expected %q, got %q", expected, reply)
+	}
+}
+
+func TestErrorToxicMatchSkipsToSync(t *testing.T) {
+	toxic := &postgres.ErrorToxic{}
+	bind := message('B', "\x00stmt\x00\x00\x00\x00\x00\x00\x00")
+	execute := message('E', "\x00\x00\x00\x00\x00")
+	first := parse("SELECT 1") + bind + execute
+	data := startup + first + parse("UPDATE t SET a = 1") + bind + execute + sync
+	run := testhelper.ToxicRun{Match: `{"protocol": "postgres", "statement": "UPDATE"}`}
+	out, reply := run.Run(t, toxic, data)
+	if out != startup+first+sync {
+		t.Errorf("Expected the messages after UPDATE to be skipped up to the sync, got %q", out)
+	}
+	expected := errorResponse("ERROR", "40001",
+		"could not serialize access due to concurrent update")
+	if reply != expected {
+		t.Errorf("Expected %q, got %q", expected, reply)
+	}
+}
+
+func TestErrorToxicFramer(t *testing.T) {
+	complete := message('C', "SELECT 1\x00")
+	replies := "N" + message('R', "\x00\x00\x00\x00") + ready + complete + ready
+	// The framer stops before every ReadyForQuery.
+	expected := []int{0, 0, 1, 2, 2, 3, 4}
+
+	// The end of every message is found, whether it is read at once or split.
+	framer := new(postgres.ErrorToxic).NewFramer().(toxics.Sequencer)
+	var positions []int
+	for data := []byte(replies); len(data) > 0; {
+		data = data[framer.Next(data):]
+		if !framer.Boundary() {
+			t.Fatalf("Expected a boundary before %q", data)
+		}
+		positions = append(positions, framer.Position())
+	}
+	if !reflect.DeepEqual(positions, expected) {
+		t.Errorf("Expected the positions %v, got %v", expected, positions)
+	}
+
+	framer = new(postgres.ErrorToxic).NewFramer().(toxics.Sequencer)
+	positions = nil
+	for _, b := range []byte(replies) {
+		for n := 0; n == 0; {
+			n = framer.Next([]byte{b})
+			if framer.Boundary() {
+				positions = append(positions, framer.Position())
+			}
+		}
+	}
+	if !reflect.DeepEqual(positions, expected) {
+		t.Errorf("Expected the positions %v split, got %v", expected, positions)
+	}
+
+	// Errors aren't held back by encrypted connections.
+	framer = new(postgres.ErrorToxic).NewFramer().(toxics.Sequencer)
+	framer.Next([]byte("S\x16\x03\x01"))
+	if !framer.Boundary() || framer.Position() != math.MaxInt {
+		t.Errorf("Expected encryption to let errors through, got %d", framer.Position())
+	}
+}
+
+func TestErrorToxicChunks(t *testing.T) {
+	toxic := &postgres.ErrorToxic{}
+	update, commit := query("UPDATE t SET a = 1"), query("COMMIT")
+	expected := errorResponse("ERROR", "40001",
+		"could not serialize access due to concurrent update") + ready
+
+	// Messages are read across chunks, and across runs of the toxic.
+	for _, run := range []testhelper.ToxicRun{{Size: 1}, {Interrupt: true}} {
+		out, reply := run.Run(t, toxic, startup, update, commit)
+		if out != startup || reply != expected+expected {
+			t.Errorf("Expected errors, got %q and reply %q", out, reply)
+		}
+	}
+
+	// A message the toxic was interrupted in passes through.
+	run := testhelper.ToxicRun{Interrupt: true}
+	out, reply := run.Run(t, toxic, startup+update[:3], update[3:]+commit)
+	if out != startup+update || reply != expected {
+		t.Errorf("Expected UPDATE to pass through, got %q and reply %q", out, reply)
+	}
+}
+
+func TestErrorToxicFatal(t *testing.T) {
+	toxic := &postgres.ErrorToxic{Code: "57P01", Message: "bye", Severity: "fatal"}
+	out, reply := testhelper.ToxicRun{}.Run(t, toxic, startup+query("SELECT 1")+query("SELECT 2"))
+	if out != startup {
+		t.Errorf("Expected the connection to be closed, got %q", out)
+	}
+	if reply != errorResponse("FATAL", "57P01", "bye") {
+		t.Errorf("Expected a fatal error, got %q", reply)
+	}
+}
+
+func TestTerminateToxic(t *testing.T) {
+	auth := "N" + message('R', "\x00\x00\x00\x00") + message('S', "a\x00b\x00")
+	toxic := &postgres.TerminateToxic{}
+	out, _ := testhelper.ToxicRun{}.Run(t, toxic, auth+ready+message('C', "SELECT 1\x00"))
+	expected := auth + ready + errorResponse("FATAL", "57P01",
+		"terminating connection due to administrator command")
+	if out != expected {
+		t.Errorf("Expected %q, got %q", expected, out)
+	}
+}
+
+func TestTerminateToxicWaitsForMessages(t *testing.T) {
+	input := make(chan *stream.StreamChunk)
+	output := make(chan *stream.StreamChunk, 10)
+	stub := toxics.NewToxicStub(input, output)
+	toxic := &postgres.TerminateToxic{Delay: 50, Code: "08006", Message: "lost"}
+	stub.State = toxic.NewState()
+	go toxic.Pipe(stub)
+
+	complete := message('C', "SELECT 1\x00")
+	input <- &stream.StreamChunk{Data: []byte(ready + complete[:3])}
+	time.Sleep(100 * time.Millisecond)
+	// The interrupt keeps the deadline, and the error waits for the end of the
+	// message.
+	stub.Interrupt <- struct{}{}
+	go toxic.Pipe(stub)
+	input <- &stream.StreamChunk{Data: []byte(complete[3:] + ready)}
+
+	var out bytes.Buffer
+	for c := range output {
+		out.Write(c.Data)
+	}
+	expected := ready + complete + errorResponse("FATAL", "08006", "lost") + ready[:0]
+	if out.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, out.String())
+	}
+}
@@ -0,0 +1,161 @@
+package toxics
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Curves of a Ramp.
+const (
+	CurveLinear      = "linear"
+	CurveExponential = "exponential"
+)
+
+var ErrInvalidRamp = errors.New("invalid toxic ramp")
+
+// A Ramp changes numeric attributes of a toxic gradually, from their values when
+// it starts to the target attributes, like a link that slowly degrades. The
+// attributes are updated every interval. With an exponential curve, every update
+// multiplies them by the same factor, so they change slowly at first.
+type Ramp struct {
+	Attributes map[string]float64 `json:"attributes"`
+	Duration   int64              `json:"duration"` // Time in milliseconds
+	Interval   int64              `json:"interval"` // Time in milliseconds
+	Curve      string             `json:"curve"`    // linear or exponential
+
+	From   map[string]float64 `json:"from"`
+	EndsAt time.Time          `json:"ends_at"`
+}
+
+// Start validates the ramp of a toxic, and sets the values it starts from.
+func (r *Ramp) Start(toxic Toxic, now time.Time) error {
+	switch strings.ToLower(r.Curve) {
+	case "", CurveLinear:
+		r.Curve = CurveLinear
+	case CurveExponential:
+		r.Curve = CurveExponential
+	default:
+		return ErrInvalidRamp
+	}
+	if r.Duration <= 0 || r.Interval < 0 || len(r.Attributes) == 0 {
+		return ErrInvalidRamp
+	}
+	if r.Interval == 0 {
+		r.Interval = 1000
+	}
+
+	data, err := json.Marshal(toxic)
+	if err != nil {
+		return err
+	}
+	var current map[string]interface{}
+	err = json.Unmarshal(data, &current)
+	if err != nil {
+		return err
+	}
+
+	r.From = make(map[string]float64, len(r.Attributes))
+	for name := range r.Attributes {
+		value, ok := current[name].(float64)
+		if !ok {
+			return ErrInvalidRamp
+		}
+		r.From[name] = value
+	}
+
+	// The attributes the ramp ends at must be valid for the toxic.
+	if _, ok := toxic.(ValidatedToxic); ok {
+		target := reflect.New(reflect.TypeOf(toxic).Elem()).Interface().(ValidatedToxic)
+		end, err := r.Step(toxic, 1)
+		if err == nil {
+			err = json.Unmarshal(data, target)
+		}
+		if err == nil {
+			err = json.Unmarshal(end, target)
+		}
+		if err != nil || target.Validate() != nil {
+			return ErrInvalidRamp
+		}
+	}
+	r.EndsAt = now.Add(time.Duration(r.Duration) * time.Millisecond)
+	return nil
+}
+
+// Progress returns how far along the ramp is at the given time, from 0 to 1.
+func (r *Ramp) Progress(now time.Time) float64 {
+	left := r.EndsAt.Sub(now)
+	return math.Min(math.Max(1-float64(left)/float64(r.duration()), 0), 1)
+}
+
+// Next returns when the attributes are updated next.
+func (r *Ramp) Next(now time.Time) time.Time {
+	next := now.Add(time.Duration(r.Interval) * time.Millisecond)
+	if next.After(r.EndsAt) {
+		return r.EndsAt
+	}
+	return next
+}
+
+func (r *Ramp) duration() time.Duration {
+	return time.Duration(r.Duration) * time.Millisecond
+}
+
+// Step returns the attributes of the toxic at the given progress, encoded so
+// they can be decoded into the toxic. Integer attributes are rounded.
+func (r *Ramp) Step(toxic Toxic, progress float64) ([]byte, error) {
+	integers := integerAttributes(toxic)
+	values := make(map[string]interface{}, len(r.Attributes))
+	for name, to := range r.Attributes {
+		value := r.interpolate(r.From[name], to, progress)
+		if integers[name] {
+			values[name] = int64(math.Round(value))
+		} else {
+			values[name] = value
+		}
+	}
+	return json.Marshal(values)
+}
+
+func (r *Ramp) interpolate(from, to, progress float64) float64 {
+	if progress >= 1 {
+		return to
+	}
+	if r.Curve != CurveExponential || from == to {
+		return from + (to-from)*progress
+	}
+
+	// Values are multiplied by the same factor over time. When ramping from or
+	// to 0, the curve has the shape of one that grows a hundredfold.
+	growth := math.Log(100)
+	if from != 0 && to/from > 0 {
+		growth = math.Log(to / from)
+	}
+	return from + (to-from)*math.Expm1(growth*progress)/math.Expm1(growth)
+}
+
+// integerAttributes returns the attributes of a toxic that are integers, by
+// their names in JSON.
+func integerAttributes(toxic Toxic) map[string]bool {
+	integers := map[string]bool{}
+	t := reflect.TypeOf(toxic)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return integers
+	}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		switch field.Type.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			integers[name] = true
+		}
+	}
+	return integers
+}
@@ -0,0 +1,107 @@
+package toxics_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/Shopify/toxiproxy/v2/toxics"
+)
+
+func rampStep(t *testing.T, ramp *toxics.Ramp, toxic toxics.Toxic, progress float64) {
+	data, err := ramp.Step(toxic, progress)
+	if err != nil {
+		t.Fatal("Failed to step ramp", err)
+	}
+	err = json.Unmarshal(data, toxic)
+	if err != nil {
+		t.Fatal("Failed to decode ramp step", err)
+	}
+}
+
+func TestRampLinear(t *testing.T) {
+	toxic := &toxics.LatencyToxic{Latency: 100, Correlation: 0.5}
+	ramp := &toxics.Ramp{
+		Attributes: map[string]float64{"latency": 201, "correlation": 0},
+		Duration:   1000,
+	}
+	now := time.Now()
+	err := ramp.Start(toxic, now)
+	if err != nil {
+		t.Fatal("Expected ramp to be valid, got", err)
+	}
+	if ramp.Curve != toxics.CurveLinear || ramp.Interval != 1000 {
+		t.Errorf("Expected a linear ramp every second, got %+v", ramp)
+	}
+	if progress := ramp.Progress(now.Add(250 * time.Millisecond)); progress != 0.25 {
+		t.Errorf("Expected a progress of 0.25, got %v", progress)
+	}
+
+	rampStep(t, ramp, toxic, 0.5)
+	// Integer attributes are rounded.
+	if toxic.Latency != 151 || toxic.Correlation != 0.25 {
+		t.Errorf("Expected halfway attributes, got %+v", toxic)
+	}
+	rampStep(t, ramp, toxic, 1)
+	if toxic.Latency != 201 || toxic.Correlation != 0 {
+		t.Errorf("Expected target attributes, got %+v", toxic)
+	}
+}
+
+func TestRampExponential(t *testing.T) {
+	toxic := &toxics.BandwidthToxic{Rate: 1000}
+	ramp := &toxics.Ramp{
+		Attributes: map[string]float64{"rate": 10},
+		Duration:   1000,
+		Curve:      toxics.CurveExponential,
+	}
+	err := ramp.Start(toxic, time.Now())
+	if err != nil {
+		t.Fatal("Expected ramp to be valid, got", err)
+	}
+
+	// Every step divides the rate by the same factor.
+	rampStep(t, ramp, toxic, 0.5)
+	if toxic.Rate != 100 {
+		t.Errorf("Expected a rate of 100, got %d", toxic.Rate)
+	}
+
+	toxic = &toxics.BandwidthToxic{Rate: 0}
+	ramp.Attributes["rate"] = 1000
+	err = ramp.Start(toxic, time.Now())
+	if err != nil {
+		t.Fatal("Expected ramp to be valid, got", err)
+	}
+	rampStep(t, ramp, toxic, 0.5)
+	if toxic.Rate <= 0 || toxic.Rate >= 500 {
+		t.Errorf("Expected a rate that grows slowly from 0, got %d", toxic.Rate)
+	}
+}
+
+func TestRampInvalid(t *testing.T) {
+	for _, ramp := range []toxics.Ramp{
+		{Attributes: map[string]float64{"latency": 100}},
+		{Attributes: map[string]float64{"latency": 100}, Duration: -1},
+		{Attributes: map[string]float64{"latency": 100}, Duration: 1, Interval: -1},
+		{Attributes: map[string]float64{"latency": 100}, Duration: 1, Curve: "sine"},
+		{Attributes: map[string]float64{"distribution": 1}, Duration: 1},
+		{Attributes: map[string]float64{"unknown": 1}, Duration: 1},
+		{Duration: 1},
+	} {
+		err := ramp.Start(&toxics.LatencyToxic{}, time.Now())
+		if err != toxics.ErrInvalidRamp {
+			t.Errorf("Expected %+v to be invalid, got %v", ramp, err)
+		}
+	}
+}
+
+func TestRampInvalidTarget(t *testing.T) {
+	toxic := &toxics.LatencyToxic{Latency: 100}
+	ramp := &toxics.Ramp{
+		Attributes: map[string]float64{"correlation": 2},
+		Duration:   1000,
+	}
+	if ramp.Start(toxic, time.Now()) != toxics.ErrInvalidRamp {
+		t.Error("Expected a ramp to invalid attributes to be rejected")
+	}
+}
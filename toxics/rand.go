@@ -0,0 +1,29 @@
+package toxics
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"math/rand"
+	"time"
+)
+
+// DeriveSeed mixes a seed with the given keys, so every proxy, toxic and
+// connection gets its own random numbers, reproducible from the same seed.
+func DeriveSeed(seed int64, keys ...string) int64 {
+	hash := fnv.New64a()
+	binary.Write(hash, binary.LittleEndian, seed) // #nosec G104 -- hashes never fail
+	for _, key := range keys {
+		hash.Write([]byte(key)) // #nosec G104
+		hash.Write([]byte{0})   // #nosec G104
+	}
+	return int64(hash.Sum64())
+}
+
+// NewRand returns a source of random numbers for a single goroutine.
+func NewRand(seed int64) *rand.Rand {
+	return rand.New(rand.NewSource(seed)) // #nosec G404 -- not used for security
+}
+
+func newTimeRand() *rand.Rand {
+	return NewRand(time.Now().UnixNano())
+}
@@ -0,0 +1,89 @@
+// Package redis provides toxics that parse the RESP protocol of Redis, to
+// act on the commands that match.
+//
+// Commands are read on the upstream stream and replies on the downstream
+// stream. Data that isn't RESP passes through untouched.
+package redis
+
+import (
+	"bufio"
+	"regexp"
+	"strings"
+
+	"github.com/Shopify/toxiproxy/v2/toxics"
+)
+
+// Match selects the commands a toxic applies to. Empty fields match any command.
+// Replies aren't commands, so they only match if every field is empty.
+type Match struct {
+	Commands []string `json:"commands"` // Names of the commands, like GET
+	Key      string   `json:"key"`      // Regular expression of the first argument
+}
+
+type matcher struct {
+	commands map[string]bool
+	key      *regexp.Regexp
+	invalid  bool
+}
+
+// compile compiles the regular expression of the match. A match with an
+// invalid expression doesn't match any command.
+func (m *Match) compile() *matcher {
+	c := &matcher{commands: make(map[string]bool, len(m.Commands))}
+	for _, name := range m.Commands {
+		c.commands[strings.ToUpper(name)] = true
+	}
+
+	var err error
+	if m.Key != "" {
+		c.key, err = regexp.Compile(m.Key)
+		c.invalid = err != nil
+	}
+	return c
+}
+
+func (c *matcher) matches(v *value) bool {
+	if c.invalid {
+		return false
+	}
+	if len(c.commands) == 0 && c.key == nil {
+		return true
+	}
+
+	args := v.command()
+	if args == nil || (len(c.commands) > 0 && !c.commands[args[0]]) {
+		return false
+	}
+	return c.key == nil || (len(args) > 1 && c.key.MatchString(args[1]))
+}
+
+// Protocol scopes toxics to the Redis commands that match, with the fields of a
+// Match.
+type Protocol struct{}
+
+func (Protocol) NewMatcher(decode func(interface{}) error) (toxics.Matcher, error) {
+	match := new(Match)
+	err := decode(match)
+	if err != nil {
+		return nil, err
+	}
+	matcher := match.compile()
+	if matcher.invalid {
+		return nil, toxics.ErrInvalidMatch
+	}
+	return matcher, nil
+}
+
+func (c *matcher) Next(r *bufio.Reader) (bool, error) {
+	v, err := read(r)
+	if err == errMalformed {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return c.matches(v), nil
+}
+
+func init() {
+	toxics.RegisterProtocol("redis", new(Protocol))
+}
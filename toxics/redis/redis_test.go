@@ -0,0 +1,83 @@
+package redis_test
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+
+	"github.com/Shopify/toxiproxy/v2/testhelper"
+	"github.com/Shopify/toxiproxy/v2/toxics"
+	_ "github.com/Shopify/toxiproxy/v2/toxics/redis"
+)
+
+func command(args ...string) string {
+	resp := "*" + strconv.Itoa(len(args)) + "\r\n"
+	for _, arg := range args {
+		resp += "$" + strconv.Itoa(len(arg)) + "\r\n" + arg + "\r\n"
+	}
+	return resp
+}
+
+func TestMatchCommands(t *testing.T) {
+	get := command("GET", "user:1")
+	set := command("set", "user:1", "x")
+	other := command("GET", "session:1")
+	data := get + set + "PING\r\n" + other
+
+	run := testhelper.ToxicRun{Size: 5}
+	run.Match = `{"protocol": "redis", "commands": ["SET", "ping"]}`
+	out := run.DropMatching(t, data)
+	if out != get+other {
+		t.Errorf("Expected SET and PING to be dropped, got %q", out)
+	}
+
+	run.Match = `{"protocol": "redis", "commands": ["get"], "key": "^user:"}`
+	out = run.DropMatching(t, data)
+	if out != set+"PING\r\n"+other {
+		t.Errorf("Expected GET of users to be dropped, got %q", out)
+	}
+}
+
+func TestMatchReplies(t *testing.T) {
+	replies := "+OK\r\n-ERR no\r\n:1\r\n$5\r\nhello\r\n$-1\r\n*2\r\n$1\r\na\r\n:2\r\n" +
+		"%1\r\n+key\r\n#t\r\n|1\r\n+ttl\r\n:3\r\n$3\r\nGET\r\n"
+	run := testhelper.ToxicRun{Match: `{"protocol": "redis", "commands": ["GET"]}`, Size: 5}
+	out := run.DropMatching(t, replies)
+	if out != replies {
+		t.Errorf("Expected replies not to match commands, got %q", out)
+	}
+
+	run.Match = `{"protocol": "redis"}`
+	out = run.DropMatching(t, replies+"*3\r\n$1\r\n")
+	if out != "*3\r\n$1\r\n" {
+		t.Errorf("Expected every reply to match, and the end to be kept, got %q", out)
+	}
+}
+
+func TestMatchOversizedLengths(t *testing.T) {
+	// Lengths past the limits of the server pass through, instead of being
+	// allocated or waited for.
+	get := command("GET", "user:1")
+	for _, length := range []string{
+		"$9223372036854775807\r\n", "$536870913\r\n", "*9223372036854775807\r\n", "*1048577\r\n",
+	} {
+		run := testhelper.ToxicRun{Match: `{"protocol": "redis", "commands": ["GET"]}`, Size: 5}
+		out := run.DropMatching(t, length+get)
+		if out != length {
+			t.Errorf("Expected %q to pass through and GET to be dropped, got %q", length, out)
+		}
+	}
+}
+
+func TestMatchInvalid(t *testing.T) {
+	for _, fields := range []string{
+		`{"protocol": "redis", "key": "("}`,
+		`{"protocol": "redis", "command": "GET"}`,
+	} {
+		match := new(toxics.Match)
+		err := json.Unmarshal([]byte(fields), match)
+		if err != nil || match.Compile() != toxics.ErrInvalidMatch {
+			t.Errorf("Expected %s to be invalid", fields)
+		}
+	}
+}
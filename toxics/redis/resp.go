@@ -0,0 +1,265 @@
+package redis
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/Shopify/toxiproxy/v2/toxics"
+)
+
+var errMalformed = errors.New("malformed resp value")
+
+// Lengths past the limits of the server are malformed, so they aren't
+// allocated or waited for.
+const (
+	maxBulk  = 512 << 20 // The default proto-max-bulk-len of the server
+	maxItems = 1 << 20   // Items of an aggregate
+)
+
+// value is a RESP value, either a command or a reply. Inline commands are read
+// as arrays of bulk strings.
+type value struct {
+	kind  byte    // The RESP type of the value
+	text  string  // Simple strings, errors, numbers and bulk strings
+	items []value // Arrays, maps, sets and pushes
+}
+
+// command returns the name of the command in upper case and its arguments,
+// or nil if the value isn't a command.
+func (v *value) command() []string {
+	if v.kind != '*' || len(v.items) == 0 {
+		return nil
+	}
+	args := make([]string, len(v.items))
+	for i, item := range v.items {
+		if item.kind != '$' {
+			return nil
+		}
+		args[i] = item.text
+	}
+	args[0] = strings.ToUpper(args[0])
+	return args
+}
+
+// read reads a RESP2 or RESP3 value.
+func read(r *bufio.Reader) (*value, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if line == "" {
+		return nil, errMalformed
+	}
+
+	v := &value{kind: line[0], text: line[1:]}
+	switch v.kind {
+	case '+', '-', ':', '_', ',', '#', '(':
+		return v, nil
+	case '$', '!', '=':
+		return v, v.readBulk(r)
+	case '*', '~', '>':
+		return v, v.readItems(r, 1)
+	case '%', '|':
+		err = v.readItems(r, 2)
+		if err == nil && v.kind == '|' {
+			// Attributes come before the value they describe.
+			return read(r)
+		}
+		return v, err
+	}
+
+	// Inline commands are words separated by spaces.
+	v = &value{kind: '*'}
+	for _, word := range strings.Fields(line) {
+		v.items = append(v.items, value{kind: '$', text: word})
+	}
+	return v, nil
+}
+
+func (v *value) readBulk(r *bufio.Reader) error {
+	n, err := strconv.ParseInt(v.text, 10, 64)
+	if err != nil || n < -1 || n > maxBulk {
+		return errMalformed
+	}
+	if n == -1 {
+		v.text = ""
+		return nil
+	}
+
+	// The buffer grows with the data read, instead of the length sent.
+	var data bytes.Buffer
+	_, err = io.CopyN(&data, r, n+2) // The string ends with a CRLF
+	if err != nil {
+		return toxics.Unexpected(err)
+	}
+	v.text = string(data.Bytes()[:n])
+	return nil
+}
+
+// readItems reads the items of an aggregate value, with per values per item.
+func (v *value) readItems(r *bufio.Reader, per int) error {
+	n, err := strconv.Atoi(v.text)
+	if err != nil || n < -1 || n > maxItems {
+		return errMalformed
+	}
+	v.text = ""
+	for i := 0; i < n*per; i++ {
+		item, err := read(r)
+		if err != nil {
+			return toxics.Unexpected(err)
+		}
+		v.items = append(v.items, *item)
+	}
+	return nil
+}
+
+// readLine reads a line, without its line ending.
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// replyFramer follows where the replies of the server end, without reading
+// them, and counts them. Pushes aren't replies to commands, and attributes
+// belong to the value after them. It implements toxics.Sequencer, so errors
+// are replied in the order of the commands. Data that isn't RESP can't be
+// followed, and lets errors through right away.
+type replyFramer struct {
+	line    []byte  // The start of the line being read
+	bulk    int     // Bytes left in the bulk string being read
+	items   []items // The aggregates being read, innermost last
+	replies int     // The replies read
+	push    bool    // The reply being read is a push
+	prefix  bool    // An attribute was read, and its value is next
+	opaque  bool
+}
+
+// items are the items left in an aggregate.
+type items struct {
+	left      int
+	attribute bool
+}
+
+func (f *replyFramer) Boundary() bool {
+	return f.opaque ||
+		(len(f.line) == 0 && f.bulk == 0 && len(f.items) == 0 && !f.prefix)
+}
+
+func (f *replyFramer) Position() int {
+	if f.opaque {
+		return math.MaxInt
+	}
+	return f.replies
+}
+
+func (f *replyFramer) Next(data []byte) int {
+	n := 0
+	for n < len(data) && !f.opaque {
+		if f.bulk > 0 {
+			k := min(f.bulk, len(data)-n)
+			f.bulk -= k
+			n += k
+			if f.bulk == 0 {
+				f.done()
+			}
+		} else {
+			end := bytes.IndexByte(data[n:], '\n')
+			if end < 0 {
+				f.read(data[n:])
+				return len(data)
+			}
+			f.read(data[n : n+end])
+			n += end + 1
+			f.parse()
+		}
+		if f.Boundary() {
+			return n
+		}
+	}
+	return len(data)
+}
+
+// read adds data to the line being read. Only its start is kept, lines
+// carrying a length are short.
+func (f *replyFramer) read(data []byte) {
+	if len(f.line) == 0 && len(data) == 0 {
+		data = []byte{'\r'} // An empty line is still a line
+	}
+	f.line = append(f.line, data[:min(len(data), 32)]...)
+}
+
+// parse reads the line once it ended.
+func (f *replyFramer) parse() {
+	line := strings.TrimRight(string(f.line), "\r")
+	f.line = f.line[:0]
+	if line == "" {
+		f.opaque = true
+		return
+	}
+
+	kind := line[0]
+	n, err := strconv.Atoi(line[1:])
+	switch kind {
+	case '+', '-', ':', '_', ',', '#', '(':
+		f.done()
+		return
+	case '$', '!', '=':
+		if err == nil && n >= 0 {
+			f.bulk = n + 2 // The string ends with a CRLF
+		} else if err == nil && n == -1 {
+			f.done()
+		} else {
+			f.opaque = true
+		}
+		return
+	case '*', '~', '>':
+		f.push = f.push || (kind == '>' && len(f.items) == 0)
+	case '%', '|':
+		n *= 2
+	default:
+		f.opaque = true
+		return
+	}
+
+	if err != nil {
+		f.opaque = true
+	} else if n > 0 {
+		f.items = append(f.items, items{n, kind == '|'})
+	} else if kind == '|' {
+		f.prefix = len(f.items) == 0
+	} else {
+		f.done()
+	}
+}
+
+// done ends a value, and the aggregates it ends.
+func (f *replyFramer) done() {
+	for len(f.items) > 0 {
+		last := &f.items[len(f.items)-1]
+		last.left--
+		if last.left > 0 {
+			return
+		}
+		attribute := last.attribute
+		f.items = f.items[:len(f.items)-1]
+		if attribute {
+			// The value the attribute describes is next.
+			f.prefix = len(f.items) == 0
+			return
+		}
+	}
+
+	if !f.push {
+		f.replies++
+	}
+	f.push = false
+	f.prefix = false
+}
@@ -0,0 +1,213 @@
+package redis
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/Shopify/toxiproxy/v2/stream"
+	"github.com/Shopify/toxiproxy/v2/toxics"
+)
+
+// Errors the ErrorToxic replies with, like a server in the middle of a failover.
+const (
+	ErrorLoading  = "loading"
+	ErrorReadonly = "readonly"
+	ErrorMoved    = "moved"
+	ErrorAsk      = "ask"
+	ErrorOom      = "oom"
+)
+
+// ErrorToxic replies to commands with an error instead of forwarding them. Use
+// a match to only reply to some commands. Redirects point to the Address,
+// with the slot of the key of the command.
+type ErrorToxic struct {
+	Error   string `json:"error"`
+	Message string `json:"message"` // Replaces the message of the error
+	Address string `json:"address"` // Defaults to 127.0.0.1:6379
+}
+
+func (t *ErrorToxic) reply(args []string) string {
+	if t.Message != "" {
+		return "-" + strings.ReplaceAll(t.Message, "\r\n", " ") + "\r\n"
+	}
+
+	var key string
+	if len(args) > 1 {
+		key = args[1]
+	}
+	address := t.Address
+	if address == "" {
+		address = "127.0.0.1:6379"
+	}
+
+	switch strings.ToLower(t.Error) {
+	case ErrorReadonly:
+		return "-READONLY You can't write against a read only replica.\r\n"
+	case ErrorMoved:
+		return fmt.Sprintf("-MOVED %d %s\r\n", slot(key), address)
+	case ErrorAsk:
+		return fmt.Sprintf("-ASK %d %s\r\n", slot(key), address)
+	case ErrorOom:
+		return "-OOM command not allowed when used memory > 'maxmemory'.\r\n"
+	}
+	return "-LOADING Redis is loading the dataset in memory\r\n"
+}
+
+// Pipe replies with the error once the server replied to the commands before,
+// so pipelined replies stay in order.
+func (t *ErrorToxic) Pipe(stub *toxics.ToxicStub) {
+	state, ok := stub.State.(*errorState)
+	if !ok {
+		state = new(errorState)
+		stub.State = state
+	}
+	pipe(stub, func(w io.Writer, data []byte, args []string) {
+		if args == nil {
+			state.forward(nil)
+			toxics.Send(w, data)
+			return
+		}
+		stub.WriteReplyAt([]byte(t.reply(args)), state.forwarded)
+	})
+}
+
+func (t *ErrorToxic) NewFramer() toxics.Framer {
+	return new(replyFramer)
+}
+
+// skipReply makes the server skip the reply to the command after it.
+var skipReply = []byte("*3\r\n$6\r\nCLIENT\r\n$5\r\nREPLY\r\n$4\r\nSKIP\r\n")
+
+// DropReplyToxic forwards commands, but makes the server skip their replies
+// with CLIENT REPLY SKIP. The commands run, like when a reply is lost. Use a
+// match to only drop the replies to some commands. Errors of other toxics
+// can't wait for the replies to the commands before them anymore, so they are
+// sent right away.
+type DropReplyToxic struct{}
+
+func (t *DropReplyToxic) Pipe(stub *toxics.ToxicStub) {
+	pipe(stub, func(w io.Writer, data []byte, args []string) {
+		if args == nil {
+			toxics.Send(w, data)
+			return
+		}
+		if replies, ok := stub.Reply.(toxics.FramedWriter); ok {
+			replies.Release()
+		}
+		toxics.Send(w, append(append([]byte{}, skipReply...), data...))
+	})
+}
+
+// pipe reads the values of the stub's input, and calls apply with each value
+// and the data it was read from. The arguments are nil if the value isn't a
+// command, or its start was sent by an earlier reader, so it must be written
+// out untouched. apply writes the data out itself. Malformed data passes
+// through untouched.
+func pipe(stub *toxics.ToxicStub, apply func(w io.Writer, data []byte, args []string)) {
+	input := toxics.NewMessageReader(stub)
+	w := stream.NewChanWriter(stub.Output)
+	var v *value
+	next := func() (bool, error) {
+		var err error
+		v, err = read(input.Reader)
+		if err == errMalformed {
+			return false, nil
+		}
+		return err == nil, err
+	}
+	input.Pipe(w, next, func(data []byte) bool {
+		args := v.command()
+		if input.Resumed() {
+			args = nil
+		}
+		apply(w, data, args)
+		return true
+	})
+}
+
+// slot returns the cluster slot of a key. Only the part of the key between
+// braces is hashed, if there is one, so related keys share a slot.
+func slot(key string) uint16 {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+	return crc16(key) % 16384
+}
+
+// crc16 is the CRC-16/XMODEM checksum Redis Cluster uses.
+func crc16(data string) uint16 {
+	var crc uint16
+	for i := 0; i < len(data); i++ {
+		crc ^= uint16(data[i]) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// Reply modes of a connection, set with CLIENT REPLY.
+const (
+	replyOn = iota
+	replyOff
+	replySkip // The next command isn't replied to
+)
+
+// errorState counts the replies the server sends to the commands forwarded on
+// a connection, which come before the error.
+type errorState struct {
+	forwarded int
+	mode      int
+}
+
+// forward counts the reply to a command forwarded, unless CLIENT REPLY turned
+// it off. args are nil for values that aren't commands.
+func (s *errorState) forward(args []string) {
+	skipped := s.mode != replyOn
+	if s.mode == replySkip {
+		s.mode = replyOn
+	}
+	if len(args) == 3 && args[0] == "CLIENT" && strings.EqualFold(args[1], "REPLY") {
+		switch strings.ToUpper(args[2]) {
+		case "ON":
+			s.mode, skipped = replyOn, false
+		case "OFF":
+			s.mode, skipped = replyOff, true
+		case "SKIP":
+			if s.mode != replyOff {
+				s.mode = replySkip
+			}
+			skipped = true
+		}
+	}
+	if !skipped {
+		s.forwarded++
+	}
+}
+
+// Follow counts the replies to the commands that don't match.
+func (s *errorState) Follow(data []byte) bool {
+	v, err := read(bufio.NewReader(bytes.NewReader(data)))
+	if err == nil {
+		s.forward(v.command())
+	}
+	return true
+}
+
+func (t *ErrorToxic) NewState() interface{} {
+	return new(errorState)
+}
+
+func init() {
+	toxics.Register("redis_error", new(ErrorToxic))
+	toxics.Register("redis_drop_reply", new(DropReplyToxic))
+}
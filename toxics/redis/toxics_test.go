@@ -0,0 +1,135 @@
+package redis_test
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/Shopify/toxiproxy/v2/testhelper"
+	"github.com/Shopify/toxiproxy/v2/toxics"
+	"github.com/Shopify/toxiproxy/v2/toxics/redis"
+)
+
+func TestErrorToxic(t *testing.T) {
+	toxic := &redis.ErrorToxic{}
+	data := command("GET", "foo") + "PING\r\n+OK\r\n"
+	out, reply := testhelper.ToxicRun{}.Run(t, toxic, data)
+	if out != "+OK\r\n" {
+		t.Errorf("Expected only data that isn't a command to be forwarded, got %q", out)
+	}
+	loading := "-LOADING Redis is loading the dataset in memory\r\n"
+	if reply != loading+loading {
+		t.Errorf("Expected LOADING errors, got %q", reply)
+	}
+
+	toxic = &redis.ErrorToxic{Error: "readonly"}
+	data = command("GET", "foo") + command("SET", "foo", "bar")
+	run := testhelper.ToxicRun{Match: `{"protocol": "redis", "commands": ["SET"]}`}
+	out, reply = run.Run(t, toxic, data)
+	if out != command("GET", "foo") {
+		t.Errorf("Expected GET to be forwarded, got %q", out)
+	}
+	if reply != "-READONLY You can't write against a read only replica.\r\n" {
+		t.Errorf("Expected a READONLY error, got %q", reply)
+	}
+
+	toxic = &redis.ErrorToxic{Error: "oom", Message: "OOM\r\ncustom"}
+	_, reply = testhelper.ToxicRun{}.Run(t, toxic, command("SET", "foo", "bar"))
+	if reply != "-OOM custom\r\n" {
+		t.Errorf("Expected the custom error, got %q", reply)
+	}
+}
+
+func TestErrorToxicChunks(t *testing.T) {
+	toxic := &redis.ErrorToxic{}
+	get, set := command("GET", "foo"), command("SET", "foo", "bar")
+	loading := "-LOADING Redis is loading the dataset in memory\r\n"
+
+	// Commands are read across chunks, and across runs of the toxic.
+	for _, run := range []testhelper.ToxicRun{{Size: 1}, {Interrupt: true}} {
+		out, reply := run.Run(t, toxic, get, set)
+		if out != "" || reply != loading+loading {
+			t.Errorf("Expected LOADING errors, got %q and reply %q", out, reply)
+		}
+	}
+
+	// A command the toxic was interrupted in passes through.
+	out, reply := testhelper.ToxicRun{Interrupt: true}.Run(t, toxic, get[:5], get[5:]+set)
+	if out != get || reply != loading {
+		t.Errorf("Expected GET to pass through, got %q and reply %q", out, reply)
+	}
+}
+
+func TestErrorToxicFramer(t *testing.T) {
+	replies := "+OK\r\n" +
+		">2\r\n$7\r\nmessage\r\n$1\r\nx\r\n" + // A push isn't a reply
+		"*2\r\n$4\r\na\r\nb\r\n*-1\r\n" +
+		"|1\r\n+key\r\n+val\r\n:5\r\n" + // The attribute is part of the number
+		"%1\r\n$1\r\nk\r\n*0\r\n" +
+		"$-1\r\n"
+	expected := []int{1, 1, 2, 3, 4, 5}
+
+	// The end of every reply is found, whether it is read at once or split.
+	framer := new(redis.ErrorToxic).NewFramer().(toxics.Sequencer)
+	var positions []int
+	for data := []byte(replies); len(data) > 0; {
+		data = data[framer.Next(data):]
+		if !framer.Boundary() {
+			t.Fatalf("Expected a boundary before %q", data)
+		}
+		positions = append(positions, framer.Position())
+	}
+	if !reflect.DeepEqual(positions, expected) {
+		t.Errorf("Expected the positions %v, got %v", expected, positions)
+	}
+
+	framer = new(redis.ErrorToxic).NewFramer().(toxics.Sequencer)
+	positions = nil
+	for _, b := range []byte(replies) {
+		framer.Next([]byte{b})
+		if framer.Boundary() {
+			positions = append(positions, framer.Position())
+		}
+	}
+	if !reflect.DeepEqual(positions, expected) {
+		t.Errorf("Expected the positions %v split, got %v", expected, positions)
+	}
+
+	// Errors aren't held back by data that isn't RESP.
+	framer.Next([]byte("garbage\r\n+OK"))
+	if !framer.Boundary() || framer.Position() != math.MaxInt {
+		t.Errorf("Expected data that isn't RESP to let errors through, got %d", framer.Position())
+	}
+}
+
+func TestErrorToxicRedirects(t *testing.T) {
+	toxic := &redis.ErrorToxic{Error: "moved", Address: "10.0.0.1:7000"}
+	for key, expected := range map[string]string{
+		"foo":                  "-MOVED 12182 10.0.0.1:7000\r\n",
+		"123456789":            "-MOVED 12739 10.0.0.1:7000\r\n",
+		"{123456789}.follower": "-MOVED 12739 10.0.0.1:7000\r\n",
+		"foo{}{123456789}":     "-MOVED 4273 10.0.0.1:7000\r\n",
+	} {
+		_, reply := testhelper.ToxicRun{}.Run(t, toxic, command("GET", key))
+		if reply != expected {
+			t.Errorf("Expected %q for %s, got %q", expected, key, reply)
+		}
+	}
+
+	toxic = &redis.ErrorToxic{Error: "ASK"}
+	_, reply := testhelper.ToxicRun{}.Run(t, toxic, "PING\r\n")
+	if reply != "-ASK 0 127.0.0.1:6379\r\n" {
+		t.Errorf("Expected an ASK redirect, got %q", reply)
+	}
+}
+
+func TestDropReplyToxic(t *testing.T) {
+	toxic := &redis.DropReplyToxic{}
+	data := command("GET", "foo") + command("INCR", "foo")
+	run := testhelper.ToxicRun{Match: `{"protocol": "redis", "commands": ["INCR"]}`}
+	out, reply := run.Run(t, toxic, data)
+	expected := command("GET", "foo") + command("CLIENT", "REPLY", "SKIP") + command("INCR", "foo")
+	if out != expected || reply != "" {
+		t.Errorf("Expected %q, got %q and reply %q", expected, out, reply)
+	}
+}
@@ -0,0 +1,16 @@
+package toxics
+
+// The RefuseToxic resets new connections with a TCP RST before the upstream
+// is dialed, like an upstream that refuses connections. Clients of a Unix
+// socket are closed gracefully instead.
+type RefuseToxic struct {
+	NoopToxic
+}
+
+func (t *RefuseToxic) Accept(stub *ConnectionStub) ConnectionAction {
+	return ConnectionReset
+}
+
+func init() {
+	Register("refuse", new(RefuseToxic))
+}
@@ -0,0 +1,77 @@
+package toxics
+
+import (
+	"time"
+
+	"github.com/Shopify/toxiproxy/v2/stream"
+)
+
+// The ReorderToxic holds a window of chunks and passes them on in a random
+// order, and can duplicate chunks. A chunk is passed on when the window is full,
+// and a partial window is flushed after the delay, so slow traffic isn't held
+// back forever.
+type ReorderToxic struct {
+	Window    int     `json:"window"`    // Number of chunks held
+	Delay     int64   `json:"delay"`     // Time in milliseconds
+	Duplicate float64 `json:"duplicate"` // Probability between 0 and 1
+}
+
+// emit passes on a random chunk of the window, and returns the rest of it.
+func (t *ReorderToxic) emit(stub *ToxicStub, window []*stream.StreamChunk) []*stream.StreamChunk {
+	i := stub.Rand.Intn(len(window))
+	c := window[i]
+	window = append(window[:i], window[i+1:]...)
+
+	stub.Output <- c
+	if stub.Rand.Float64() < t.Duplicate {
+		data := make([]byte, len(c.Data))
+		copy(data, c.Data)
+		stub.Output <- &stream.StreamChunk{Data: data, Timestamp: c.Timestamp}
+	}
+	return window
+}
+
+func (t *ReorderToxic) flush(stub *ToxicStub, window []*stream.StreamChunk) {
+	for len(window) > 0 {
+		window = t.emit(stub, window)
+	}
+}
+
+func (t *ReorderToxic) Pipe(stub *ToxicStub) {
+	var window []*stream.StreamChunk
+	var timeout <-chan time.Time
+
+	for {
+		select {
+		case <-stub.Interrupt:
+			// Don't drop any data on the floor
+			t.flush(stub, window)
+			return
+		case c := <-stub.Input:
+			if c == nil {
+				t.flush(stub, window)
+				stub.Close()
+				return
+			}
+
+			window = append(window, c)
+			if len(window) >= t.Window {
+				window = t.emit(stub, window)
+			}
+
+			if len(window) == 0 {
+				timeout = nil
+			} else if timeout == nil {
+				timeout = time.After(time.Duration(t.Delay) * time.Millisecond)
+			}
+		case <-timeout:
+			t.flush(stub, window)
+			window = nil
+			timeout = nil
+		}
+	}
+}
+
+func init() {
+	Register("reorder", new(ReorderToxic))
+}
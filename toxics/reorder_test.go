@@ -0,0 +1,122 @@
+package toxics_test
+
+import (
+	"testing"
+
+	"github.com/Shopify/toxiproxy/v2/stream"
+	"github.com/Shopify/toxiproxy/v2/toxics"
+)
+
+// reorderPattern sends n numbered chunks through the reorder toxic, and returns
+// the numbers of the chunks in the order they came out.
+func reorderPattern(toxic *toxics.ReorderToxic, seed int64, n int) []byte {
+	input := make(chan *stream.StreamChunk)
+	output := make(chan *stream.StreamChunk, 2*n)
+	stub := toxics.NewToxicStub(input, output)
+	stub.Rand = toxics.NewRand(seed)
+
+	go toxic.Pipe(stub)
+
+	for i := 0; i < n; i++ {
+		input <- &stream.StreamChunk{Data: []byte{byte(i)}}
+	}
+	close(input)
+
+	var order []byte
+	for c := range output {
+		order = append(order, c.Data[0])
+	}
+	return order
+}
+
+func TestReorderToxicShufflesChunks(t *testing.T) {
+	toxic := &toxics.ReorderToxic{Window: 5, Delay: 10000}
+	order := reorderPattern(toxic, 1, 100)
+	if len(order) != 100 {
+		t.Fatalf("Expected 100 chunks, got %d", len(order))
+	}
+
+	seen := make([]bool, 100)
+	reordered := 0
+	for i, n := range order {
+		if seen[n] {
+			t.Fatalf("Chunk %d was sent twice", n)
+		}
+		seen[n] = true
+		if int(n) != i {
+			reordered++
+		}
+		// A chunk can't be held back longer than the window.
+		if int(n) > i+4 {
+			t.Errorf("Chunk %d came out at %d, before it was read", n, i)
+		}
+	}
+	if reordered == 0 {
+		t.Error("Expected chunks to be reordered")
+	}
+}
+
+func TestReorderToxicIsReproducible(t *testing.T) {
+	toxic := &toxics.ReorderToxic{Window: 8, Delay: 10000, Duplicate: 0.2}
+	first := reorderPattern(toxic, 42, 100)
+	second := reorderPattern(toxic, 42, 100)
+	if string(first) != string(second) {
+		t.Errorf("Expected the same order from the same seed:\n%v\n%v", first, second)
+	}
+}
+
+func TestReorderToxicDuplicatesChunks(t *testing.T) {
+	toxic := &toxics.ReorderToxic{Window: 1, Duplicate: 1}
+	order := reorderPattern(toxic, 1, 50)
+	if len(order) != 100 {
+		t.Fatalf("Expected 100 chunks, got %d", len(order))
+	}
+	for i, n := range order {
+		if int(n) != i/2 {
+			t.Fatalf("Expected chunk %d at %d, got %d", i/2, i, n)
+		}
+	}
+}
+
+func TestReorderToxicFlushesAfterDelay(t *testing.T) {
+	input := make(chan *stream.StreamChunk)
+	output := make(chan *stream.StreamChunk)
+	stub := toxics.NewToxicStub(input, output)
+	stub.Rand = toxics.NewRand(1)
+
+	go (&toxics.ReorderToxic{Window: 10, Delay: 10}).Pipe(stub)
+
+	input <- &stream.StreamChunk{Data: []byte("hello")}
+	checkOutgoingChunk(t, output, []byte("hello"))
+	close(input)
+}
+
+func TestReorderToxicFlushesOnInterrupt(t *testing.T) {
+	input := make(chan *stream.StreamChunk)
+	output := make(chan *stream.StreamChunk, 3)
+	stub := toxics.NewToxicStub(input, output)
+	stub.Rand = toxics.NewRand(1)
+
+	done := make(chan struct{})
+	go func() {
+		(&toxics.ReorderToxic{Window: 10, Delay: 10000}).Pipe(stub)
+		close(done)
+	}()
+
+	for i := 0; i < 3; i++ {
+		input <- &stream.StreamChunk{Data: []byte{byte(i)}}
+	}
+	stub.Interrupt <- struct{}{}
+	<-done
+
+	if len(output) != 3 {
+		t.Fatalf("Expected 3 chunks to be flushed, got %d", len(output))
+	}
+	seen := map[byte]bool{}
+	for i := 0; i < 3; i++ {
+		seen[(<-output).Data[0]] = true
+	}
+	if len(seen) != 3 {
+		t.Errorf("Expected every chunk to be flushed once, got %v", seen)
+	}
+}
@@ -0,0 +1,56 @@
+package toxics
+
+import "io"
+
+// A Framer finds the ends of the messages of a stream, so replies can be
+// written between them.
+type Framer interface {
+	// Next reads data up to the end of the next message, and returns the number
+	// of bytes read.
+	Next(data []byte) int
+	// Boundary reports whether the data read so far ends between messages.
+	Boundary() bool
+}
+
+// A Sequencer is a Framer that numbers the places between messages it reaches,
+// like by the replies the server sent, so replies can wait for their turn.
+type Sequencer interface {
+	Framer
+	// Position returns the number of the place the data read so far ends at.
+	Position() int
+}
+
+// A FramedWriter writes replies between the messages sent the same way, once it
+// knows how to find them. Replies wait for the end of the message being sent.
+type FramedWriter interface {
+	io.Writer
+	// SetFramer returns false if data was already sent, so the messages can't be
+	// found. Replies are framed once a framer is set.
+	SetFramer(framer Framer) bool
+	// WriteAt writes a reply once the framer, a Sequencer, reaches a position,
+	// after the replies with lower positions. Positions count from the start of
+	// the connection. Without a Sequencer, the reply is written like by Write.
+	WriteAt(p []byte, position int)
+	// Release lets the replies waiting for their turn through, and the ones
+	// written at a position from then on, once the message being sent ends. A
+	// toxic making the other way skip messages calls it, as their positions
+	// may never be reached.
+	Release()
+}
+
+// A FramingToxic places its replies between the messages sent the other way.
+// Its framer is set on the reply writer of the stub when the toxic starts on a
+// connection. A toxic added once the connection carried data can't count the
+// messages, so its Reply isn't a FramedWriter.
+type FramingToxic interface {
+	NewFramer() Framer
+}
+
+// An ObservingToxic follows the data sent the other way on its connection, like
+// the requests a server responds to. Its observer gets a copy of that data from
+// the start of the connection, and is set as the Observer of the stub. Data
+// sent before the toxic was added can't be followed, so a toxic added once the
+// other way carried data gets no observer.
+type ObservingToxic interface {
+	NewObserver() io.Writer
+}
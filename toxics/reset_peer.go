@@ -11,6 +11,9 @@ The behavior of Close is set to discard any unsent/unacknowledged data by settin
 If the timeout is set to 0, then the connection will be reset immediately.
 
 Drop data since it will initiate a graceful close by sending the FIN/ACK. (io.EOF)
+
+Connections without SO_LINGER, like Unix sockets, cannot be reset and are closed
+gracefully instead.
 */
 
 type ResetToxic struct {
@@ -8,7 +8,7 @@ import (
 )
 
 // The SlicerToxic slices data into multiple smaller packets
-// to simulate real-world TCP behavior.
+// to simulate real-world TCP behavior. Datagrams are passed on whole.
 type SlicerToxic struct {
 	// Average number of bytes to slice at
 	AverageSize int `json:"average_size"`
@@ -28,7 +28,7 @@ type SlicerToxic struct {
 //
 // This tries to get fairly evenly-varying chunks (no tendency
 // to have a small/large chunk at the start/end).
-func (t *SlicerToxic) chunk(start int, end int) []int {
+func (t *SlicerToxic) chunk(r *rand.Rand, start int, end int) []int {
 	// Base case:
 	// If the size is within the random varation, _or already
 	// less than the average size_, just return it.
@@ -40,10 +40,10 @@ func (t *SlicerToxic) chunk(start int, end int) []int {
 	mid := start + (end-start)/2
 
 	if t.SizeVariation > 0 {
-		mid += rand.Intn(t.SizeVariation*2) - t.SizeVariation // #nosec G404 -- was ignored before too
+		mid += r.Intn(t.SizeVariation*2) - t.SizeVariation
 	}
-	left := t.chunk(start, mid)
-	right := t.chunk(mid, end)
+	left := t.chunk(r, start, mid)
+	right := t.chunk(r, mid, end)
 
 	return append(left, right...)
 }
@@ -58,8 +58,12 @@ func (t *SlicerToxic) Pipe(stub *ToxicStub) {
 				stub.Close()
 				return
 			}
+			if stub.Datagrams {
+				stub.Output <- c
+				continue
+			}
 
-			chunks := t.chunk(0, len(c.Data))
+			chunks := t.chunk(stub.Rand, 0, len(c.Data))
 			for i := 1; i < len(chunks); i += 2 {
 				stub.Output <- &stream.StreamChunk{
 					Data:      c.Data[chunks[i-1]:chunks[i]],
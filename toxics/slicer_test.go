@@ -57,6 +57,24 @@ func TestSlicerToxic(t *testing.T) {
 	}
 }
 
+func TestSlicerToxicKeepsDatagrams(t *testing.T) {
+	slicer := &toxics.SlicerToxic{AverageSize: 10}
+
+	input := make(chan *stream.StreamChunk)
+	output := make(chan *stream.StreamChunk)
+	stub := toxics.NewToxicStub(input, output)
+	stub.Datagrams = true
+	go slicer.Pipe(stub)
+
+	data := []byte(strings.Repeat("hello world ", 10))
+	input <- &stream.StreamChunk{Data: data}
+	if c := <-output; !bytes.Equal(c.Data, data) {
+		t.Errorf("Expected the datagram to be passed on whole, got %q", c.Data)
+	}
+	close(input)
+	<-output
+}
+
 func TestSlicerToxicZeroSizeVariation(t *testing.T) {
 	data := []byte(strings.Repeat("hello world ", 2)) // 24 bytes
 	// SizeVariation: 0 by default
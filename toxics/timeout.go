@@ -5,6 +5,8 @@ import "time"
 // The TimeoutToxic stops any data from flowing through,
 // and will close the connection after a timeout.
 // If the timeout is set to 0, then the connection will not be closed.
+// Applied to a chunk or a message, the toxic drops it, and with a timeout the
+// rest of the stream waits until the connection is closed.
 type TimeoutToxic struct {
 	// Times in milliseconds
 	Timeout int64 `json:"timeout"`
@@ -13,6 +15,7 @@ type TimeoutToxic struct {
 func (t *TimeoutToxic) Pipe(stub *ToxicStub) {
 	timeout := time.Duration(t.Timeout) * time.Millisecond
 	if timeout > 0 {
+		input := stub.Input
 		for {
 			select {
 			case <-time.After(timeout):
@@ -20,12 +23,15 @@ func (t *TimeoutToxic) Pipe(stub *ToxicStub) {
 				return
 			case <-stub.Interrupt:
 				return
-			case c := <-stub.Input:
+			case c := <-input:
 				if c == nil {
 					stub.Close()
 					return
 				}
 				// Drop the data on the ground.
+				if stub.partial {
+					input = nil // The end of the part isn't the end of the stream
+				}
 			}
 		}
 	} else {
@@ -1,10 +1,16 @@
 package toxics
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"math"
 	"math/rand"
 	"reflect"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/Shopify/toxiproxy/v2/stream"
@@ -39,6 +45,21 @@ type BufferedToxic interface {
 	GetBufferSize() int
 }
 
+// A ValidatedToxic checks its attributes once they are decoded, when the toxic
+// is created, updated or ramped. It can normalize them, like the case of names.
+type ValidatedToxic interface {
+	Validate() error
+}
+
+var ErrInvalidAttributes = errors.New("invalid toxic attributes")
+
+// A SharedToxic shares state with the toxics of other proxies, like a bucket of
+// the bandwidth toxic. Release is called once the toxic is removed, or its proxy
+// is, so the state is dropped when no toxic uses it anymore.
+type SharedToxic interface {
+	Release()
+}
+
 // Stateful toxics store a per-connection state object on the ToxicStub.
 // The state is created once when the toxic is added and persists until the
 // toxic is removed or the connection is closed.
@@ -47,24 +68,147 @@ type StatefulToxic interface {
 	NewState() interface{}
 }
 
+// Sampling modes decide how often the toxicity of a toxic is rolled.
+const (
+	// SamplingRestart rolls every time the toxic is started on a link, which
+	// also happens when other toxics of the link are added or removed.
+	SamplingRestart = "restart"
+	// SamplingConnection rolls once, for the lifetime of the connection.
+	SamplingConnection = "connection"
+	// SamplingChunk rolls for every chunk of data. Chunks that aren't selected
+	// pass through untouched.
+	SamplingChunk = "chunk"
+)
+
+var ErrInvalidSampling = errors.New("invalid sampling mode")
+
+// ParseSampling validates a sampling mode, defaulting to SamplingRestart.
+func ParseSampling(mode string) (string, error) {
+	switch strings.ToLower(mode) {
+	case "", SamplingRestart:
+		return SamplingRestart, nil
+	case SamplingConnection, SamplingChunk:
+		return strings.ToLower(mode), nil
+	}
+	return "", ErrInvalidSampling
+}
+
+var ErrInvalidSchedule = errors.New("invalid toxic schedule")
+
 type ToxicWrapper struct {
 	Toxic      `json:"attributes"`
 	Name       string           `json:"name"`
 	Type       string           `json:"type"`
 	Stream     string           `json:"stream"`
 	Toxicity   float32          `json:"toxicity"`
+	Sampling   string           `json:"sampling"`
+	Seed       *int64           `json:"seed,omitempty"`
+	StartAfter int64            `json:"start_after,omitempty"` // Time in milliseconds
+	Duration   int64            `json:"duration,omitempty"`    // Time in milliseconds
+	StartsAt   *time.Time       `json:"starts_at,omitempty"`
+	ExpiresAt  *time.Time       `json:"expires_at,omitempty"`
+	Flap       *Flap            `json:"flap,omitempty"`
+	Ramp       *Ramp            `json:"ramp,omitempty"` // Set while the toxic is ramping
+	Match      *Match           `json:"match,omitempty"`
 	Direction  stream.Direction `json:"-"`
 	Index      int              `json:"-"`
 	BufferSize int              `json:"-"`
+
+	flappedOff atomic.Bool
+	fields     sync.RWMutex // Held to change fields, so they aren't encoded meanwhile
+}
+
+// Update runs f to change the fields of the toxic, like its attributes, while it
+// isn't being encoded.
+func (t *ToxicWrapper) Update(f func() error) error {
+	t.fields.Lock()
+	defer t.fields.Unlock()
+
+	return f()
+}
+
+// FlappedOff reports whether the toxic is flapped off, so it lets data through
+// untouched.
+func (t *ToxicWrapper) FlappedOff() bool {
+	return t.flappedOff.Load()
+}
+
+// SetFlappedOff flaps the toxic off or back on. Stubs running the toxic must be
+// restarted for it to take effect.
+func (t *ToxicWrapper) SetFlappedOff(off bool) {
+	t.flappedOff.Store(off)
+}
+
+// Schedule validates the schedule of a toxic added at the given time, and sets
+// when it starts and expires. A toxic expires after its duration, or at
+// ExpiresAt if that comes first.
+func (t *ToxicWrapper) Schedule(now time.Time) error {
+	if t.StartAfter < 0 || t.Duration < 0 {
+		return ErrInvalidSchedule
+	}
+
+	start := now
+	t.StartsAt = nil
+	if t.StartAfter > 0 {
+		start = now.Add(time.Duration(t.StartAfter) * time.Millisecond)
+		t.StartsAt = &start
+	}
+
+	if t.Duration > 0 {
+		expires := start.Add(time.Duration(t.Duration) * time.Millisecond)
+		if t.ExpiresAt == nil || expires.Before(*t.ExpiresAt) {
+			t.ExpiresAt = &expires
+		}
+	}
+	if t.ExpiresAt != nil && !t.ExpiresAt.After(start) {
+		return ErrInvalidSchedule
+	}
+	return nil
+}
+
+// MarshalJSON adds the time left until the toxic starts and expires, and whether
+// it is flapped off.
+func (t *ToxicWrapper) MarshalJSON() ([]byte, error) {
+	// The wrapper is embedded by reference, so fields that aren't encoded like
+	// the index aren't read. Its name must be exported for them to be encoded.
+	type Wrapper ToxicWrapper
+	t.fields.RLock()
+	defer t.fields.RUnlock()
+
+	now := time.Now()
+	return json.Marshal(struct {
+		*Wrapper
+		StartsIn   int64 `json:"starts_in,omitempty"`  // Time in milliseconds
+		ExpiresIn  int64 `json:"expires_in,omitempty"` // Time in milliseconds
+		FlappedOff bool  `json:"flapped_off,omitempty"`
+	}{(*Wrapper)(t), until(now, t.StartsAt), until(now, t.ExpiresAt), t.FlappedOff()})
+}
+
+// until returns the milliseconds from now until the given time, or 0 if it
+// has passed.
+func until(now time.Time, at *time.Time) int64 {
+	if at == nil || !at.After(now) {
+		return 0
+	}
+	return int64(math.Ceil(float64(at.Sub(now)) / float64(time.Millisecond)))
 }
 
 type ToxicStub struct {
 	Input     <-chan *stream.StreamChunk
 	Output    chan<- *stream.StreamChunk
 	State     interface{}
+	Rand      *rand.Rand // Only used by the goroutine running the toxic
+	Reply     io.Writer  // Writes back to the sender of the input, if there is one
+	Datagrams bool       // Each chunk is a datagram, which toxics don't split
+	Observer  io.Writer  // Gets the data sent the other way, see ObservingToxic
 	Interrupt chan struct{}
 	running   chan struct{}
 	closed    chan struct{}
+	active    atomic.Bool
+	sampled   bool    // True once the toxicity was rolled for the connection
+	partial   bool    // The input is a part of the stream, like a chunk the toxic applies to
+	matcher   Matcher // Reads the messages of the stream, for toxics with a match
+	flushed   []byte  // The start of a message sent as is when the toxic was interrupted
 }
 
 func NewToxicStub(input <-chan *stream.StreamChunk, output chan<- *stream.StreamChunk) *ToxicStub {
@@ -73,22 +217,147 @@ func NewToxicStub(input <-chan *stream.StreamChunk, output chan<- *stream.Stream
 		closed:    make(chan struct{}),
 		Input:     input,
 		Output:    output,
+		Rand:      newTimeRand(),
 	}
 }
 
 // Begin running a toxic on this stub, can be interrupted.
-// Runs a noop toxic randomly depending on toxicity.
+// Runs a noop toxic randomly depending on toxicity and sampling, and while the
+// toxic is flapped off. A toxic with a match only runs on the messages that
+// match.
 func (s *ToxicStub) Run(toxic *ToxicWrapper) {
 	s.running = make(chan struct{})
 	defer close(s.running)
-	randomToxicity := rand.Float32() // #nosec G404 -- was ignored before too
-	if randomToxicity < toxic.Toxicity {
+
+	if toxic.FlappedOff() {
+		s.active.Store(false)
+		s.flushed = nil // The messages aren't followed meanwhile
+		new(NoopToxic).Pipe(s)
+		return
+	}
+
+	// Updates of the toxic restart the stub, so its fields are read once.
+	toxic.fields.RLock()
+	sampling, toxicity := toxic.Sampling, toxic.Toxicity
+	toxic.fields.RUnlock()
+
+	switch sampling {
+	case SamplingConnection:
+		if !s.sampled {
+			s.sampled = true
+			s.active.Store(s.roll(toxicity))
+		}
+	case SamplingChunk:
+		s.active.Store(toxicity > 0)
+		if s.active.Load() && toxic.Match != nil {
+			s.pipeMessages(toxic, toxicity)
+			return
+		} else if s.active.Load() {
+			s.pipeChunks(toxic, toxicity)
+			return
+		}
+	default:
+		s.active.Store(s.roll(toxicity))
+	}
+
+	if s.active.Load() && toxic.Match != nil {
+		s.pipeMessages(toxic, 1)
+	} else if s.active.Load() {
 		toxic.Pipe(s)
 	} else {
+		s.flushed = nil
 		new(NoopToxic).Pipe(s)
 	}
 }
 
+func (s *ToxicStub) roll(toxicity float32) bool {
+	return s.Rand.Float32() < toxicity
+}
+
+// pipeChunks runs the toxic on every chunk selected by its toxicity, one chunk
+// at a time so the data stays in order.
+func (s *ToxicStub) pipeChunks(toxic *ToxicWrapper, toxicity float32) {
+	for {
+		select {
+		case <-s.Interrupt:
+			return
+		case c := <-s.Input:
+			if c == nil {
+				s.Close()
+				return
+			}
+			if !s.roll(toxicity) {
+				s.Output <- c
+			} else if !s.pipeChunk(toxic, c) {
+				return
+			}
+		}
+	}
+}
+
+// pipeChunk runs the toxic on a single chunk, through a stub whose input ends
+// after the chunk. If the toxic closes that stub before reading the end of its
+// input, like limit_data, this stub is closed as well. Returns false if this
+// stub was interrupted or closed meanwhile.
+func (s *ToxicStub) pipeChunk(toxic *ToxicWrapper, c *stream.StreamChunk) bool {
+	// The end of the input is sent as a nil chunk, so it is still buffered if the
+	// toxic didn't read it.
+	input := make(chan *stream.StreamChunk, 2)
+	output := make(chan *stream.StreamChunk)
+	input <- c
+	input <- nil
+	close(input)
+
+	chunk := NewToxicStub(input, output)
+	chunk.State = s.State
+	chunk.Rand = s.Rand
+	chunk.Reply = s.Reply
+	chunk.Observer = s.Observer
+	chunk.Datagrams = s.Datagrams
+	chunk.partial = true
+	chunk.running = make(chan struct{})
+	go func() {
+		defer close(chunk.running)
+		toxic.Pipe(chunk)
+	}()
+
+	interrupt := s.Interrupt
+	var interrupted chan bool
+	for {
+		select {
+		case c, ok := <-output:
+			if !ok && len(input) > 0 {
+				s.Close()
+				return false
+			} else if !ok {
+				return true
+			}
+			s.Output <- c
+		case <-interrupt:
+			// Keep forwarding the output while the toxic stops, it may still
+			// flush data.
+			interrupt = nil
+			interrupted = make(chan bool, 1)
+			go func() {
+				interrupted <- chunk.InterruptToxic()
+			}()
+		case <-interrupted:
+			// Don't drop the chunk if the toxic didn't get to it.
+			if c := <-input; c != nil {
+				s.Output <- c
+			}
+			return false
+		}
+	}
+}
+
+// Active reports whether the toxic running on this stub is applied, or if it
+// passes data through because of its toxicity. Toxics sampling chunks are
+// active as long as their toxicity isn't 0.
+func (s *ToxicStub) Active() bool {
+	return s.active.Load()
+}
+
 // WriteOutput allows to write to Output with timeout to avoid deadlocks.
 // If duration is 0, then wait until other goroutines finish reading from Output.
 func (s *ToxicStub) WriteOutput(p *stream.StreamChunk, d time.Duration) error {
@@ -105,6 +374,24 @@ func (s *ToxicStub) WriteOutput(p *stream.StreamChunk, d time.Duration) error {
 	}
 }
 
+// WriteReply writes data back to the sender of the input. Replies are dropped
+// if there is no sender, or its connection is closed.
+func (s *ToxicStub) WriteReply(data []byte) {
+	if s.Reply != nil {
+		s.Reply.Write(data) // #nosec G104
+	}
+}
+
+// WriteReplyAt writes a reply once the sender got the replies up to a position,
+// see FramedWriter. The reply is written right away if Reply can't place it.
+func (s *ToxicStub) WriteReplyAt(data []byte, position int) {
+	if w, ok := s.Reply.(FramedWriter); ok {
+		w.WriteAt(data, position)
+	} else {
+		s.WriteReply(data)
+	}
+}
+
 // Interrupt the flow of data so that the toxic controlling the stub can be replaced.
 // Returns true if the stream was successfully interrupted, or false if the stream is closed.
 func (s *ToxicStub) InterruptToxic() bool {
@@ -399,3 +399,149 @@ func TestToxicStub_WriteOutput(t *testing.T) {
 		}
 	})
 }
+
+func TestToxicStubConnectionSampling(t *testing.T) {
+	for i := 0; i < 10; i++ {
+		input := make(chan *stream.StreamChunk)
+		output := make(chan *stream.StreamChunk)
+		stub := toxics.NewToxicStub(input, output)
+		toxic := &toxics.ToxicWrapper{
+			Toxic:    new(toxics.NoopToxic),
+			Toxicity: 0.5,
+			Sampling: toxics.SamplingConnection,
+		}
+
+		go stub.Run(toxic)
+		stub.InterruptToxic()
+		active := stub.Active()
+
+		// Restarting the toxic, like when other toxics are added or removed,
+		// doesn't roll its toxicity again.
+		for j := 0; j < 20; j++ {
+			go stub.Run(toxic)
+			stub.InterruptToxic()
+			if stub.Active() != active {
+				t.Fatal("Expected toxicity to be rolled once per connection")
+			}
+		}
+	}
+}
+
+func TestToxicStubChunkSampling(t *testing.T) {
+	input := make(chan *stream.StreamChunk)
+	output := make(chan *stream.StreamChunk)
+	stub := toxics.NewToxicStub(input, output)
+	toxic := &toxics.ToxicWrapper{
+		Toxic:    new(toxics.TimeoutToxic),
+		Toxicity: 0.5,
+		Sampling: toxics.SamplingChunk,
+	}
+	go stub.Run(toxic)
+
+	go func() {
+		for i := 0; i < 200; i++ {
+			input <- &stream.StreamChunk{Data: []byte{byte(i)}}
+		}
+		close(input)
+	}()
+
+	// The timeout toxic drops the chunks it is applied to.
+	received := 0
+	last := -1
+	for c := range output {
+		if int(c.Data[0]) <= last {
+			t.Fatalf("Expected chunks to stay in order, got %d after %d", c.Data[0], last)
+		}
+		last = int(c.Data[0])
+		received++
+	}
+
+	if received < 50 || received > 150 {
+		t.Errorf("Expected about half of the chunks to pass through, got %d", received)
+	}
+	if !stub.Active() {
+		t.Error("Expected toxic sampling chunks to be active")
+	}
+}
+
+func TestToxicStubChunkSamplingInterrupt(t *testing.T) {
+	input := make(chan *stream.StreamChunk)
+	output := make(chan *stream.StreamChunk)
+	stub := toxics.NewToxicStub(input, output)
+	toxic := &toxics.ToxicWrapper{
+		Toxic:    &toxics.LatencyToxic{Latency: 10000},
+		Toxicity: 1,
+		Sampling: toxics.SamplingChunk,
+	}
+	go stub.Run(toxic)
+
+	input <- &stream.StreamChunk{Data: []byte("hello"), Timestamp: time.Now()}
+
+	received := make(chan []byte)
+	go func() {
+		c := <-output
+		received <- c.Data
+	}()
+
+	stub.InterruptToxic()
+	select {
+	case data := <-received:
+		if string(data) != "hello" {
+			t.Errorf("Expected hello, got %q", data)
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected the chunk to be flushed when the toxic is interrupted")
+	}
+}
+
+// pipeUntilClosed sends the chunks through a stub running the toxic, and
+// returns what came out before the stub was closed.
+func pipeUntilClosed(t *testing.T, toxic *toxics.ToxicWrapper, chunks ...string) string {
+	input := make(chan *stream.StreamChunk, len(chunks))
+	output := make(chan *stream.StreamChunk)
+	stub := toxics.NewToxicStub(input, output)
+	if stateful, ok := toxic.Toxic.(toxics.StatefulToxic); ok {
+		stub.State = stateful.NewState()
+	}
+	for _, c := range chunks {
+		input <- &stream.StreamChunk{Data: []byte(c), Timestamp: time.Now()}
+	}
+	go stub.Run(toxic)
+
+	var out strings.Builder
+	for {
+		select {
+		case c, ok := <-output:
+			if !ok {
+				return out.String()
+			}
+			out.Write(c.Data)
+		case <-time.After(time.Second):
+			t.Fatalf("Expected the stub to be closed, got %q", out.String())
+		}
+	}
+}
+
+func TestToxicStubChunkSamplingClosesConnection(t *testing.T) {
+	limit := &toxics.ToxicWrapper{
+		Toxic:    &toxics.LimitDataToxic{Bytes: 3},
+		Toxicity: 1,
+		Sampling: toxics.SamplingChunk,
+	}
+	if out := pipeUntilClosed(t, limit, "hello", "after"); out != "hel" {
+		t.Errorf("Expected the data after the limit to be cut, got %q", out)
+	}
+
+	timeout := &toxics.ToxicWrapper{
+		Toxic:    &toxics.TimeoutToxic{Timeout: 50},
+		Toxicity: 1,
+		Sampling: toxics.SamplingChunk,
+	}
+	start := time.Now()
+	if out := pipeUntilClosed(t, timeout, "hello", "after"); out != "" {
+		t.Errorf("Expected the data to stop after the timeout chunk, got %q", out)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("Expected the connection to be closed after the timeout, it took %s", elapsed)
+	}
+}
@@ -0,0 +1,297 @@
+package toxiproxy
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	tomb "gopkg.in/tomb.v1"
+)
+
+// UDPSessionTimeout is how long a UDP session may stay idle in both directions
+// before it is expired and its upstream socket is closed.
+var UDPSessionTimeout = 60 * time.Second
+
+// maxDatagramSize is the largest payload a UDP datagram can carry. Buffers of
+// this size guarantee that every read returns exactly one datagram.
+const maxDatagramSize = 65535
+
+// udpSessionBacklog is the number of datagrams queued for a session before new
+// datagrams from the same client are dropped.
+const udpSessionBacklog = 64
+
+// udpSession represents a UDP client of a proxy. UDP has no connections, so a
+// session is created for each client source address and lives until it has
+// been idle for UDPSessionTimeout. It implements net.Conn so it can be used as
+// the client side of a pair of ToxicLinks like a TCP connection.
+type udpSession struct {
+	conn       net.PacketConn
+	addr       net.Addr
+	in         chan []byte
+	closed     chan struct{}
+	closeOnce  sync.Once
+	lastActive int64
+	onClose    func()
+}
+
+func newUDPSession(conn net.PacketConn, addr net.Addr, onClose func()) *udpSession {
+	session := &udpSession{
+		conn:    conn,
+		addr:    addr,
+		in:      make(chan []byte, udpSessionBacklog),
+		closed:  make(chan struct{}),
+		onClose: onClose,
+	}
+	session.touch()
+	return session
+}
+
+func (s *udpSession) touch() {
+	atomic.StoreInt64(&s.lastActive, time.Now().UnixNano())
+}
+
+func (s *udpSession) idle() time.Duration {
+	return time.Since(time.Unix(0, atomic.LoadInt64(&s.lastActive)))
+}
+
+// deliver queues a datagram received from the client. It never blocks, so a
+// slow session does not stall the other clients of the proxy. Returns false
+// if the datagram was dropped.
+func (s *udpSession) deliver(datagram []byte) bool {
+	select {
+	case <-s.closed:
+		return false
+	default:
+	}
+
+	select {
+	case s.in <- datagram:
+		s.touch()
+		return true
+	default:
+		return false
+	}
+}
+
+// expire closes the session and its upstream once it has been idle for the
+// given timeout.
+func (s *udpSession) expire(timeout time.Duration, upstream io.Closer) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-s.closed:
+			return
+		case <-timer.C:
+			idle := s.idle()
+			if idle >= timeout {
+				s.Close()
+				upstream.Close()
+				return
+			}
+			timer.Reset(timeout - idle)
+		}
+	}
+}
+
+func (s *udpSession) Read(b []byte) (int, error) {
+	select {
+	case datagram := <-s.in:
+		return copy(b, datagram), nil
+	case <-s.closed:
+		return 0, io.EOF
+	}
+}
+
+// WriteTo writes every datagram received from the client to w, one datagram
+// per write.
+func (s *udpSession) WriteTo(w io.Writer) (int64, error) {
+	var total int64
+	for {
+		select {
+		case datagram := <-s.in:
+			n, err := w.Write(datagram)
+			total += int64(n)
+			if err != nil {
+				return total, err
+			}
+		case <-s.closed:
+			return total, nil
+		}
+	}
+}
+
+func (s *udpSession) Write(b []byte) (int, error) {
+	select {
+	case <-s.closed:
+		return 0, net.ErrClosed
+	default:
+	}
+
+	s.touch()
+	return s.conn.WriteTo(b, s.addr)
+}
+
+// ReadFrom sends everything read from r to the client, one datagram per read.
+func (s *udpSession) ReadFrom(r io.Reader) (int64, error) {
+	return copyDatagrams(s, r)
+}
+
+func (s *udpSession) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.closed)
+		if s.onClose != nil {
+			s.onClose()
+		}
+	})
+	return nil
+}
+
+func (s *udpSession) LocalAddr() net.Addr {
+	return s.conn.LocalAddr()
+}
+
+func (s *udpSession) RemoteAddr() net.Addr {
+	return s.addr
+}
+
+func (s *udpSession) SetDeadline(t time.Time) error {
+	return nil
+}
+
+func (s *udpSession) SetReadDeadline(t time.Time) error {
+	return nil
+}
+
+func (s *udpSession) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+// datagramConn wraps a connected UDP socket so that io.Copy preserves datagram
+// boundaries instead of using a buffer that could truncate large datagrams.
+type datagramConn struct {
+	net.Conn
+}
+
+func (c *datagramConn) WriteTo(w io.Writer) (int64, error) {
+	return copyDatagrams(w, c.Conn)
+}
+
+func (c *datagramConn) ReadFrom(r io.Reader) (int64, error) {
+	return copyDatagrams(c.Conn, r)
+}
+
+// copyDatagrams copies from r to w with a buffer large enough to hold any
+// datagram, so each stream.StreamChunk is written as a single datagram.
+func copyDatagrams(w io.Writer, r io.Reader) (int64, error) {
+	var total int64
+	buf := make([]byte, maxDatagramSize)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			written, werr := w.Write(buf[:n])
+			total += int64(written)
+			if werr != nil {
+				return total, werr
+			}
+		}
+		if err == io.EOF {
+			return total, nil
+		} else if err != nil {
+			return total, err
+		}
+	}
+}
+
+// serveUDP reads datagrams from the proxy socket and dispatches them to the
+// session of their source address, creating the session and the Links to the
+// upstream on the first datagram.
+func (proxy *Proxy) serveUDP(acceptTomb *tomb.Tomb, pool *UpstreamPool) {
+	var lock sync.Mutex
+	sessions := make(map[string]*udpSession)
+
+	buf := make([]byte, maxDatagramSize)
+	for {
+		n, addr, err := proxy.packetConn.ReadFrom(buf)
+		if err != nil {
+			select {
+			case <-acceptTomb.Dying():
+			default:
+				proxy.Logger.
+					Warn().
+					Err(err).
+					Msg("Error while reading datagram")
+			}
+			return
+		}
+
+		datagram := make([]byte, n)
+		copy(datagram, buf[:n])
+
+		name := addr.String()
+		lock.Lock()
+		session, exists := sessions[name]
+		if !exists {
+			session = newUDPSession(proxy.packetConn, addr, nil)
+			session.onClose = func(session *udpSession) func() {
+				return func() {
+					lock.Lock()
+					defer lock.Unlock()
+					if sessions[name] == session {
+						delete(sessions, name)
+					}
+				}
+			}(session)
+			sessions[name] = session
+		}
+		lock.Unlock()
+
+		if !exists {
+			err = proxy.startUDPSession(session, pool)
+			if err != nil {
+				session.Close()
+				continue
+			}
+		}
+
+		if !session.deliver(datagram) {
+			proxy.Logger.
+				Debug().
+				Str("client", name).
+				Msg("Dropped datagram for busy session")
+		}
+	}
+}
+
+func (proxy *Proxy) startUDPSession(client *udpSession, pool *UpstreamPool) error {
+	name := client.RemoteAddr().String()
+
+	proxy.Logger.
+		Info().
+		Str("client", name).
+		Msg("Accepted client")
+
+	// Dialing a datagram socket doesn't wait for the upstream, so UDP sessions
+	// make a single attempt without the retries of TCP proxies.
+	conn, err := proxy.dialPool(context.Background(), pool, proxy.dialOptions.Load())
+	if err != nil {
+		proxy.Logger.
+			Err(err).
+			Str("client", name).
+			Msg("Unable to open connection to upstream")
+		return err
+	}
+	upstream := &datagramConn{conn}
+
+	// Datagrams are written whole, so replies can't split them, and UDP
+	// sessions aren't wrapped to place them.
+	proxy.startConnection(proxy.nextConnectionID(), name, client, upstream)
+
+	go client.expire(UDPSessionTimeout, upstream)
+
+	return nil
+}
@@ -0,0 +1,226 @@
+package toxiproxy
+
+import (
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Shopify/toxiproxy/v2/toxics"
+)
+
+// Strategies to pick the upstream of a new connection in an UpstreamPool.
+const (
+	BalanceRoundRobin       = "round_robin"
+	BalanceRandom           = "random"
+	BalanceLeastConnections = "least_connections"
+	BalanceFailover         = "failover"
+)
+
+// parseBalance normalizes the balancing strategy of a proxy, defaulting to
+// round robin.
+func parseBalance(balance string) (string, error) {
+	switch strings.ToLower(balance) {
+	case "", BalanceRoundRobin:
+		return BalanceRoundRobin, nil
+	case BalanceRandom, BalanceLeastConnections, BalanceFailover:
+		return strings.ToLower(balance), nil
+	}
+	return "", ErrInvalidBalance
+}
+
+// PoolMember is the state of a single upstream of a proxy.
+type PoolMember struct {
+	Address     string `json:"address"`
+	Enabled     bool   `json:"enabled"`
+	Connections int    `json:"connections"`
+
+	conns map[*pooledConn]struct{}
+}
+
+// UpstreamPool holds the upstreams of a proxy and picks one for every new
+// connection. Upstreams can be disabled to simulate losing a replica, which
+// closes their connections and stops sending new connections to them.
+type UpstreamPool struct {
+	sync.Mutex
+
+	balance string
+	members []*PoolMember
+	next    int
+	random  *rand.Rand
+}
+
+func NewUpstreamPool(balance string, addresses []string) *UpstreamPool {
+	pool := &UpstreamPool{
+		balance: balance,
+		members: make([]*PoolMember, len(addresses)),
+		random:  toxics.NewRand(time.Now().UnixNano()),
+	}
+	for i, address := range addresses {
+		pool.members[i] = &PoolMember{
+			Address: address,
+			Enabled: true,
+			conns:   make(map[*pooledConn]struct{}),
+		}
+	}
+	return pool
+}
+
+// matches reports whether the pool was created with the given configuration.
+func (pool *UpstreamPool) matches(balance string, addresses []string) bool {
+	pool.Lock()
+	defer pool.Unlock()
+
+	if pool.balance != balance || len(pool.members) != len(addresses) {
+		return false
+	}
+	for i, member := range pool.members {
+		if member.Address != addresses[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Members returns a copy of the state of every upstream in the pool.
+func (pool *UpstreamPool) Members() []PoolMember {
+	pool.Lock()
+	defer pool.Unlock()
+
+	result := make([]PoolMember, len(pool.members))
+	for i, member := range pool.members {
+		result[i] = PoolMember{
+			Address:     member.Address,
+			Enabled:     member.Enabled,
+			Connections: member.Connections,
+		}
+	}
+	return result
+}
+
+// SetEnabled enables or disables an upstream. Disabling an upstream closes all
+// of its connections.
+func (pool *UpstreamPool) SetEnabled(address string, enabled bool) (*PoolMember, error) {
+	pool.Lock()
+	var member *PoolMember
+	for _, m := range pool.members {
+		if m.Address == address {
+			member = m
+		}
+	}
+	if member == nil {
+		pool.Unlock()
+		return nil, ErrUpstreamNotFound
+	}
+
+	member.Enabled = enabled
+	var conns []*pooledConn
+	if !enabled {
+		for conn := range member.conns {
+			conns = append(conns, conn)
+		}
+	}
+	result := &PoolMember{
+		Address:     member.Address,
+		Enabled:     member.Enabled,
+		Connections: member.Connections,
+	}
+	pool.Unlock()
+
+	// Close outside of the lock, closing releases the connection from the pool.
+	for _, conn := range conns {
+		conn.Close()
+	}
+	return result, nil
+}
+
+// attempts returns the number of upstreams a new connection tries: all of them
+// with the failover strategy, otherwise only the one picked.
+func (pool *UpstreamPool) attempts() int {
+	pool.Lock()
+	defer pool.Unlock()
+
+	if pool.balance == BalanceFailover {
+		return len(pool.members)
+	}
+	return 1
+}
+
+// pick returns the upstream for a new connection, or nil if every upstream is
+// disabled. Attempt is the number of previous failed attempts to connect, so
+// failover moves on to the next upstream when the primary can't be dialed.
+func (pool *UpstreamPool) pick(attempt int) *PoolMember {
+	pool.Lock()
+	defer pool.Unlock()
+
+	enabled := make([]*PoolMember, 0, len(pool.members))
+	for _, member := range pool.members {
+		if member.Enabled {
+			enabled = append(enabled, member)
+		}
+	}
+	if len(enabled) == 0 {
+		return nil
+	}
+
+	switch pool.balance {
+	case BalanceFailover:
+		return enabled[attempt%len(enabled)]
+	case BalanceRandom:
+		return enabled[pool.random.Intn(len(enabled))]
+	case BalanceLeastConnections:
+		least := enabled[0]
+		for _, member := range enabled[1:] {
+			if member.Connections < least.Connections {
+				least = member
+			}
+		}
+		return least
+	default:
+		member := enabled[pool.next%len(enabled)]
+		pool.next++
+		return member
+	}
+}
+
+// track counts a connection against the upstream it was dialed to, until the
+// connection is closed.
+func (pool *UpstreamPool) track(member *PoolMember, conn net.Conn) net.Conn {
+	pooled := &pooledConn{Conn: conn, pool: pool, member: member}
+
+	pool.Lock()
+	defer pool.Unlock()
+	member.Connections++
+	member.conns[pooled] = struct{}{}
+	return pooled
+}
+
+func (pool *UpstreamPool) release(conn *pooledConn) {
+	pool.Lock()
+	defer pool.Unlock()
+	if _, ok := conn.member.conns[conn]; ok {
+		delete(conn.member.conns, conn)
+		conn.member.Connections--
+	}
+}
+
+// pooledConn releases its upstream in the pool when it is closed.
+type pooledConn struct {
+	net.Conn
+	pool   *UpstreamPool
+	member *PoolMember
+	once   sync.Once
+}
+
+func (c *pooledConn) Close() error {
+	c.once.Do(func() {
+		c.pool.release(c)
+	})
+	return c.Conn.Close()
+}
+
+// NetConn returns the underlying connection, see setLinger.
+func (c *pooledConn) NetConn() net.Conn {
+	return c.Conn
+}
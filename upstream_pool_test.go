@@ -0,0 +1,179 @@
+package toxiproxy_test
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Shopify/toxiproxy/v2"
+)
+
+// WithNamedServers runs a TCP server for every name. Each server writes its name
+// to new connections and keeps them open until the client closes them.
+func WithNamedServers(t *testing.T, names []string, f func(addresses []string)) {
+	addresses := make([]string, len(names))
+	for i, name := range names {
+		ln, err := net.Listen("tcp", "localhost:0")
+		if err != nil {
+			t.Fatal("Failed to create TCP server", err)
+		}
+		defer ln.Close()
+		addresses[i] = ln.Addr().String()
+		go ServeName(ln, name)
+	}
+
+	f(addresses)
+}
+
+// ServeName writes the name to every connection accepted by the listener.
+func ServeName(ln net.Listener, name string) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			conn.Write([]byte(name))
+			io.Copy(io.Discard, conn)
+		}()
+	}
+}
+
+// AssertUpstreamName connects to the proxy and checks which server answered.
+func AssertUpstreamName(t *testing.T, addr, expected string) net.Conn {
+	conn := AssertProxyUp(t, addr, true)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, len(expected))
+	_, err := io.ReadFull(conn, buf)
+	if err != nil {
+		t.Fatal("Failed to read from proxy", err)
+	}
+	if string(buf) != expected {
+		t.Fatalf("Expected connection to %s, got %s", expected, buf)
+	}
+	return conn
+}
+
+func TestUpstreamPoolRoundRobin(t *testing.T) {
+	WithNamedServers(t, []string{"a", "b", "c"}, func(addresses []string) {
+		proxy := NewTestProxy("test", "")
+		proxy.Upstreams = addresses
+		err := proxy.Start()
+		if err != nil {
+			t.Fatal("Proxy failed to start", err)
+		}
+		defer proxy.Stop()
+
+		for _, name := range []string{"a", "b", "c", "a"} {
+			conn := AssertUpstreamName(t, proxy.Listen, name)
+			conn.Close()
+		}
+	})
+}
+
+func TestUpstreamPoolLeastConnections(t *testing.T) {
+	WithNamedServers(t, []string{"a", "b"}, func(addresses []string) {
+		proxy := NewTestProxy("test", "")
+		proxy.Upstreams = addresses
+		proxy.Balance = toxiproxy.BalanceLeastConnections
+		err := proxy.Start()
+		if err != nil {
+			t.Fatal("Proxy failed to start", err)
+		}
+		defer proxy.Stop()
+
+		conn := AssertUpstreamName(t, proxy.Listen, "a")
+		defer conn.Close()
+		for i := 0; i < 3; i++ {
+			conn := AssertUpstreamName(t, proxy.Listen, "b")
+			conn.Close()
+			// Wait for the proxy to release the closed connection.
+			time.Sleep(50 * time.Millisecond)
+		}
+	})
+}
+
+func TestUpstreamPoolFailover(t *testing.T) {
+	WithNamedServers(t, []string{"b"}, func(addresses []string) {
+		proxy := NewTestProxy("test", "")
+		proxy.Upstreams = []string{ReserveAddress(t), addresses[0]}
+		proxy.Balance = toxiproxy.BalanceFailover
+		err := proxy.Start()
+		if err != nil {
+			t.Fatal("Proxy failed to start", err)
+		}
+		defer proxy.Stop()
+
+		conn := AssertUpstreamName(t, proxy.Listen, "b")
+		conn.Close()
+	})
+}
+
+func TestUpstreamPoolDisableClosesConnections(t *testing.T) {
+	WithNamedServers(t, []string{"a", "b"}, func(addresses []string) {
+		proxy := NewTestProxy("test", "")
+		proxy.Upstreams = addresses
+		proxy.Balance = toxiproxy.BalanceFailover
+		err := proxy.Start()
+		if err != nil {
+			t.Fatal("Proxy failed to start", err)
+		}
+		defer proxy.Stop()
+
+		conn := AssertUpstreamName(t, proxy.Listen, "a")
+		defer conn.Close()
+
+		member, err := proxy.UpstreamPool().SetEnabled(addresses[0], false)
+		if err != nil {
+			t.Fatal("Failed to disable upstream", err)
+		}
+		if member.Enabled {
+			t.Error("Expected upstream to be disabled")
+		}
+
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		_, err = conn.Read(make([]byte, 1))
+		if err != io.EOF {
+			t.Error("Expected connection to the disabled upstream to close", err)
+		}
+
+		conn = AssertUpstreamName(t, proxy.Listen, "b")
+		conn.Close()
+
+		members := proxy.UpstreamPool().Members()
+		if len(members) != 2 || members[0].Enabled || !members[1].Enabled {
+			t.Error("Unexpected pool members", members)
+		}
+	})
+}
+
+func TestUpstreamPoolAllDisabled(t *testing.T) {
+	WithNamedServers(t, []string{"a"}, func(addresses []string) {
+		proxy := NewTestProxy("test", addresses[0])
+		err := proxy.Start()
+		if err != nil {
+			t.Fatal("Proxy failed to start", err)
+		}
+		defer proxy.Stop()
+
+		_, err = proxy.UpstreamPool().SetEnabled(addresses[0], false)
+		if err != nil {
+			t.Fatal("Failed to disable upstream", err)
+		}
+
+		conn := AssertProxyUp(t, proxy.Listen, true)
+		defer conn.Close()
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		_, err = conn.Read(make([]byte, 1))
+		if err != io.EOF {
+			t.Error("Expected connection to close without upstreams", err)
+		}
+
+		_, err = proxy.UpstreamPool().SetEnabled("localhost:1", false)
+		if err != toxiproxy.ErrUpstreamNotFound {
+			t.Error("Expected upstream not found error, got", err)
+		}
+	})
+}